@@ -0,0 +1,164 @@
+package aead
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// Algorithm is an AEAD algorithm. Its value is written in the header of each sealed message,
+// so it must not change.
+type Algorithm byte
+
+const (
+	// AESGCM is AES in Galois/Counter Mode with a 16, 24 or 32-byte key and a 12-byte random nonce.
+	AESGCM Algorithm = iota + 1
+	// ChaCha20Poly1305 is ChaCha20-Poly1305 (RFC 8439) with a 32-byte key and a 12-byte random nonce.
+	ChaCha20Poly1305
+	// XChaCha20Poly1305 is XChaCha20-Poly1305 with a 32-byte key and a 24-byte random nonce,
+	// which is safe for an unlimited number of messages per key.
+	XChaCha20Poly1305
+)
+
+func (a Algorithm) String() string {
+	switch a {
+	case AESGCM:
+		return "AES-GCM"
+	case ChaCha20Poly1305:
+		return "ChaCha20-Poly1305"
+	case XChaCha20Poly1305:
+		return "XChaCha20-Poly1305"
+	default:
+		return fmt.Sprintf("Algorithm(%d)", byte(a))
+	}
+}
+
+// maxMessages is the count of messages that can be sealed with random 12-byte nonces under a
+// single key before the probability of a nonce collision becomes unacceptable (NIST SP 800-38D).
+const maxMessages = 1 << 32
+
+// MaxKeyIDLen is the maximum length of a key ID.
+const MaxKeyIDLen = 255
+
+type key struct {
+	id    string
+	alg   Algorithm
+	aead  cipher.AEAD
+	count uint64 // messages sealed, accessed atomically
+}
+
+// reserve reserves a nonce for a new message, failing once the key should be rotated.
+func (k *key) reserve() error {
+	if k.alg == XChaCha20Poly1305 {
+		return nil
+	}
+	if atomic.AddUint64(&k.count, 1) > maxMessages {
+		return fmt.Errorf("%w: %q", ErrKeyExhausted, k.id)
+	}
+	return nil
+}
+
+func newAEAD(alg Algorithm, secret []byte) (cipher.AEAD, error) {
+	switch alg {
+	case AESGCM:
+		b, err := aes.NewCipher(secret)
+		if err != nil {
+			return nil, err
+		}
+		return cipher.NewGCM(b)
+	case ChaCha20Poly1305:
+		return chacha20poly1305.New(secret)
+	case XChaCha20Poly1305:
+		return chacha20poly1305.NewX(secret)
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnknownAlgorithm, alg)
+	}
+}
+
+// Keyring holds the keys a Marsha seals and opens messages with.
+// Messages are sealed with the primary key and opened with the key whose ID is in their header,
+// so keys can be rotated by adding a new key, making it primary and removing the old key once no
+// message sealed with it is left.
+// It is safe for concurrent use by multiple goroutines.
+type Keyring struct {
+	mu      sync.RWMutex
+	keys    map[string]*key
+	primary *key
+}
+
+// NewKeyring creates an empty Keyring.
+func NewKeyring() *Keyring {
+	return &Keyring{keys: make(map[string]*key)}
+}
+
+// Add adds a key with ID `id`, which becomes the primary key if it is the first one added.
+func (kr *Keyring) Add(id string, alg Algorithm, secret []byte) error {
+	if len(id) > MaxKeyIDLen {
+		return fmt.Errorf("%w: key ID longer than %d bytes", ErrMalformed, MaxKeyIDLen)
+	}
+	a, err := newAEAD(alg, secret)
+	if err != nil {
+		return err
+	}
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+	if _, ok := kr.keys[id]; ok {
+		return fmt.Errorf("%w: %q", ErrKeyExists, id)
+	}
+	k := &key{id: id, alg: alg, aead: a}
+	kr.keys[id] = k
+	if kr.primary == nil {
+		kr.primary = k
+	}
+	return nil
+}
+
+// SetPrimary makes the key with ID `id` the primary key.
+func (kr *Keyring) SetPrimary(id string) error {
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+	k, ok := kr.keys[id]
+	if !ok {
+		return fmt.Errorf("%w: %q", ErrUnknownKey, id)
+	}
+	kr.primary = k
+	return nil
+}
+
+// Remove removes the key with ID `id`. The primary key cannot be removed.
+func (kr *Keyring) Remove(id string) error {
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+	k, ok := kr.keys[id]
+	if !ok {
+		return fmt.Errorf("%w: %q", ErrUnknownKey, id)
+	}
+	if k == kr.primary {
+		return fmt.Errorf("%w: %q is the primary key", ErrKeyInUse, id)
+	}
+	delete(kr.keys, id)
+	return nil
+}
+
+func (kr *Keyring) getPrimary() (*key, error) {
+	kr.mu.RLock()
+	defer kr.mu.RUnlock()
+	if kr.primary == nil {
+		return nil, ErrNoKey
+	}
+	return kr.primary, nil
+}
+
+func (kr *Keyring) get(id string) (*key, error) {
+	kr.mu.RLock()
+	defer kr.mu.RUnlock()
+	k, ok := kr.keys[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownKey, id)
+	}
+	return k, nil
+}
@@ -0,0 +1,411 @@
+// Package aead provides a Marsha middleware which seals the bytes and streams produced by any
+// other Marsha with authenticated encryption (AES-GCM or ChaCha20-Poly1305).
+package aead
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"sync"
+
+	"github.com/daotl/go-marsha"
+	"github.com/daotl/go-marsha/internal/frame"
+)
+
+var (
+	ErrNoKey            = errors.New("keyring has no key")
+	ErrUnknownKey       = errors.New("unknown key ID")
+	ErrKeyExists        = errors.New("key ID already exists")
+	ErrKeyInUse         = errors.New("key is in use")
+	ErrKeyExhausted     = errors.New("key has sealed too many messages and must be rotated")
+	ErrUnknownAlgorithm = errors.New("unknown AEAD algorithm")
+	ErrMalformed        = errors.New("malformed sealed message")
+	ErrOpen             = errors.New("message authentication failed: tampered, wrong key or wrong type")
+	ErrStreamClosed     = errors.New("stream is closed")
+)
+
+// version is the version of the sealed message format.
+const version = 1
+
+const (
+	// streamIDSize is the size of the random ID which starts each stream.
+	streamIDSize = 16
+
+	flagData  = 0
+	flagFinal = 1
+)
+
+type options struct {
+	rand io.Reader
+}
+
+// Option configures a Marsha.
+type Option func(*options)
+
+// WithRand sets the source of nonces, `crypto/rand.Reader` by default.
+func WithRand(r io.Reader) Option {
+	return func(o *options) {
+		o.rand = r
+	}
+}
+
+// Marsha is a marsha.Marsha middleware which seals the bytes produced by the wrapped Marsha with
+// the primary key of a Keyring.
+//
+// A sealed message is laid out as:
+//
+//   version (1 byte) | algorithm (1 byte) | key ID length (1 byte) | key ID | nonce | ciphertext
+//
+// The associated data is the header (everything before the nonce) followed by the fully qualified
+// name of the Go type of the value marshaled, so opening a message into a value of another type
+// fails with ErrOpen just like a tampered message does.
+//
+// A stream transmitted by an Encoder starts with a random stream ID of 16 bytes. Each message is
+// then a flag byte telling whether it is the final one, followed by the uvarint length of the
+// sealed message and the sealed message. The associated data of messages in a stream is extended
+// with the stream ID, the index of the message in the stream and the flag, so messages reordered,
+// dropped or spliced from another stream fail with ErrOpen. Closing the Encoder transmits an
+// empty final message, without which a Decoder reports the stream as truncated with
+// io.ErrUnexpectedEOF.
+type Marsha struct {
+	inner marsha.Marsha
+	keys  *Keyring
+	opts  options
+}
+
+var _ marsha.Marsha = (*Marsha)(nil)
+
+// New creates a Marsha wrapping `m` which seals and opens messages with `keys`.
+func New(m marsha.Marsha, keys *Keyring, opts ...Option) *Marsha {
+	o := options{rand: rand.Reader}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return &Marsha{inner: m, keys: keys, opts: o}
+}
+
+func (m *Marsha) MarshalPrimitive(p interface{}) ([]byte, error) {
+	bin, err := m.inner.MarshalPrimitive(p)
+	if err != nil {
+		return nil, err
+	}
+	return m.seal(p, bin, nil)
+}
+
+func (m *Marsha) UnmarshalPrimitive(bin []byte, p interface{}) (int, error) {
+	return m.open(bin, p, func(bin []byte) (int, error) { return m.inner.UnmarshalPrimitive(bin, p) })
+}
+
+func (m *Marsha) MarshalStruct(p marsha.StructPtr) ([]byte, error) {
+	bin, err := m.inner.MarshalStruct(p)
+	if err != nil {
+		return nil, err
+	}
+	return m.seal(p, bin, nil)
+}
+
+func (m *Marsha) UnmarshalStruct(bin []byte, p marsha.StructPtr) (int, error) {
+	return m.open(bin, p, func(bin []byte) (int, error) { return m.inner.UnmarshalStruct(bin, p) })
+}
+
+func (m *Marsha) MarshalStructSlice(p marsha.StructSlicePtr) ([]byte, error) {
+	bin, err := m.inner.MarshalStructSlice(p)
+	if err != nil {
+		return nil, err
+	}
+	return m.seal(p, bin, nil)
+}
+
+func (m *Marsha) UnmarshalStructSlice(bin []byte, p marsha.StructSlicePtr) (int, error) {
+	return m.open(bin, p, func(bin []byte) (int, error) { return m.inner.UnmarshalStructSlice(bin, p) })
+}
+
+// NewEncoder returns an Encoder which also implements io.Closer. It must be closed to transmit the
+// final message of the stream.
+func (m *Marsha) NewEncoder(w io.Writer) marsha.Encoder {
+	e := &encoder{m: m, w: w}
+	e.inner = m.inner.NewEncoder(&e.buf)
+	return e
+}
+
+func (m *Marsha) NewDecoder(r io.Reader) marsha.Decoder {
+	d := &decoder{m: m, r: r}
+	d.inner = m.inner.NewDecoder(&d.buf)
+	return d
+}
+
+// seal seals `plaintext` marshaled from `p` with the primary key. `stream` is appended to the
+// associated data of messages in a stream.
+func (m *Marsha) seal(p interface{}, plaintext, stream []byte) ([]byte, error) {
+	k, err := m.keys.getPrimary()
+	if err != nil {
+		return nil, err
+	}
+	if err = k.reserve(); err != nil {
+		return nil, err
+	}
+	hl := 3 + len(k.id)
+	ns := k.aead.NonceSize()
+	out := make([]byte, hl+ns, hl+ns+len(plaintext)+k.aead.Overhead())
+	out[0] = version
+	out[1] = byte(k.alg)
+	out[2] = byte(len(k.id))
+	copy(out[3:], k.id)
+	nonce := out[hl:]
+	if _, err = io.ReadFull(m.opts.rand, nonce); err != nil {
+		return nil, err
+	}
+	return k.aead.Seal(out, nonce, plaintext, associatedData(out[:hl], p, stream)), nil
+}
+
+// open opens `bin` with the key whose ID is in its header and passes the plaintext to `unmarshal`.
+// It returns the count of bytes of `bin` read, which is all of it if the wrapped Marsha read all
+// of the plaintext, or -1 otherwise.
+func (m *Marsha) open(bin []byte, p interface{}, unmarshal func([]byte) (int, error)) (int, error) {
+	plaintext, err := m.openBytes(bin, p, nil)
+	if err != nil {
+		return 0, err
+	}
+	read, err := unmarshal(plaintext)
+	if err != nil {
+		return read, err
+	}
+	if read == len(plaintext) {
+		return len(bin), nil
+	}
+	return -1, nil
+}
+
+func (m *Marsha) openBytes(bin []byte, p interface{}, stream []byte) ([]byte, error) {
+	if len(bin) < 3 {
+		return nil, ErrMalformed
+	}
+	if bin[0] != version {
+		return nil, fmt.Errorf("%w: unknown version %d", ErrMalformed, bin[0])
+	}
+	hl := 3 + int(bin[2])
+	if len(bin) < hl {
+		return nil, ErrMalformed
+	}
+	k, err := m.keys.get(string(bin[3:hl]))
+	if err != nil {
+		return nil, err
+	}
+	if Algorithm(bin[1]) != k.alg {
+		return nil, fmt.Errorf("%w: key %q is for %s, not %s", ErrMalformed, k.id, k.alg, Algorithm(bin[1]))
+	}
+	ns := k.aead.NonceSize()
+	if len(bin) < hl+ns+k.aead.Overhead() {
+		return nil, ErrMalformed
+	}
+	plaintext, err := k.aead.Open(nil, bin[hl:hl+ns], bin[hl+ns:], associatedData(bin[:hl], p, stream))
+	if err != nil {
+		return nil, ErrOpen
+	}
+	return plaintext, nil
+}
+
+// associatedData binds a message to its header, the Go type of `p` and its position in a stream.
+func associatedData(header []byte, p interface{}, stream []byte) []byte {
+	name := TypeName(p)
+	ad := make([]byte, 0, len(header)+1+len(name)+len(stream))
+	ad = append(ad, header...)
+	ad = append(ad, 0)
+	ad = append(ad, name...)
+	return append(ad, stream...)
+}
+
+// streamData returns the associated data binding the message with index `n` and flag `flag` to
+// the stream `id`.
+func streamData(id []byte, n uint64, flag byte) []byte {
+	ad := make([]byte, len(id)+9)
+	copy(ad, id)
+	binary.BigEndian.PutUint64(ad[len(id):], n)
+	ad[len(id)+8] = flag
+	return ad
+}
+
+// TypeName returns the fully qualified name of the type `p` points to, such as
+// "github.com/daotl/go-marsha/test.TestStruct", or its literal form for unnamed types such as
+// "[]int".
+func TypeName(p interface{}) string {
+	t := reflect.TypeOf(p)
+	if t == nil {
+		return "nil"
+	}
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Name() == "" || t.PkgPath() == "" {
+		return t.String()
+	}
+	return t.PkgPath() + "." + t.Name()
+}
+
+type encoder struct {
+	sync.Mutex // each item must be sent atomically
+	m          *Marsha
+	inner      marsha.Encoder
+	buf        bytes.Buffer
+	w          io.Writer
+	id         []byte // stream ID, nil until the first message is transmitted
+	n          uint64 // count of messages transmitted
+	closed     bool
+}
+
+var _ io.Closer = (*encoder)(nil)
+
+func (e *encoder) EncodePrimitive(p interface{}) (int, error) {
+	return e.encode(p, func() (int, error) { return e.inner.EncodePrimitive(p) })
+}
+
+func (e *encoder) EncodeStruct(p marsha.StructPtr) (int, error) {
+	return e.encode(p, func() (int, error) { return e.inner.EncodeStruct(p) })
+}
+
+func (e *encoder) EncodeStructSlice(p marsha.StructSlicePtr) (int, error) {
+	return e.encode(p, func() (int, error) { return e.inner.EncodeStructSlice(p) })
+}
+
+// encode encodes an item with the wrapped Encoder into the buffer and transmits it sealed.
+func (e *encoder) encode(p interface{}, enc func() (int, error)) (int, error) {
+	// Make sure we're single-threaded through here, so multiple
+	// goroutines can share an encoder.
+	e.Lock()
+	defer e.Unlock()
+	if e.closed {
+		return 0, ErrStreamClosed
+	}
+	e.buf.Reset()
+	if _, err := enc(); err != nil {
+		return 0, err
+	}
+	return e.write(p, e.buf.Bytes(), flagData)
+}
+
+// Close transmits the final message of the stream. Encoding after Close fails with
+// ErrStreamClosed, closing again does nothing.
+func (e *encoder) Close() error {
+	e.Lock()
+	defer e.Unlock()
+	if e.closed {
+		return nil
+	}
+	if _, err := e.write(nil, nil, flagFinal); err != nil {
+		return err
+	}
+	e.closed = true
+	return nil
+}
+
+// write seals `plaintext` marshaled from `p` as the next message of the stream and transmits it,
+// preceded by the stream ID if it is the first one.
+func (e *encoder) write(p interface{}, plaintext []byte, flag byte) (int, error) {
+	var prefix []byte
+	if e.id == nil {
+		id := make([]byte, streamIDSize)
+		if _, err := io.ReadFull(e.m.opts.rand, id); err != nil {
+			return 0, err
+		}
+		e.id = id
+		prefix = id
+	}
+	sealed, err := e.m.seal(p, plaintext, streamData(e.id, e.n, flag))
+	if err != nil {
+		return 0, err
+	}
+	n, err := frame.Write(e.w, append(prefix, flag), sealed)
+	if err != nil {
+		return n, err
+	}
+	e.n++
+	return n, nil
+}
+
+type decoder struct {
+	sync.Mutex // each item must be sent atomically
+	m          *Marsha
+	inner      marsha.Decoder
+	buf        bytes.Buffer
+	r          io.Reader
+	id         []byte // stream ID, nil until the first message is received
+	n          uint64 // count of messages received
+	done       bool   // whether the final message was received
+}
+
+func (d *decoder) DecodePrimitive(p interface{}) (int, error) {
+	return d.decode(p, func() (int, error) { return d.inner.DecodePrimitive(p) })
+}
+
+func (d *decoder) DecodeStruct(p marsha.StructPtr) (int, error) {
+	return d.decode(p, func() (int, error) { return d.inner.DecodeStruct(p) })
+}
+
+func (d *decoder) DecodeStructSlice(p marsha.StructSlicePtr) (int, error) {
+	return d.decode(p, func() (int, error) { return d.inner.DecodeStructSlice(p) })
+}
+
+// decode reads a sealed frame, opens it into the buffer and decodes an item from it with the
+// wrapped Decoder. It returns the count of bytes of the frame read.
+func (d *decoder) decode(p interface{}, dec func() (int, error)) (int, error) {
+	// Make sure we're single-threaded through here, so multiple
+	// goroutines can share a decoder.
+	d.Lock()
+	defer d.Unlock()
+
+	if d.done {
+		return 0, io.EOF
+	}
+	fr := &frame.Reader{R: d.r}
+	if d.id == nil {
+		id := make([]byte, streamIDSize)
+		if _, err := io.ReadFull(fr, id); err != nil {
+			return fr.N, truncated(err)
+		}
+		d.id = id
+	}
+	flag, err := fr.ReadByte()
+	if err != nil {
+		return fr.N, truncated(err)
+	}
+	if flag != flagData && flag != flagFinal {
+		return fr.N, fmt.Errorf("%w: unknown flag %d", ErrMalformed, flag)
+	}
+	sealed, err := fr.ReadBody()
+	if err != nil {
+		return fr.N, frame.NoEOF(err)
+	}
+	if flag == flagFinal {
+		p = nil
+	}
+	plaintext, err := d.m.openBytes(sealed, p, streamData(d.id, d.n, flag))
+	if err != nil {
+		return fr.N, err
+	}
+	d.n++
+	if flag == flagFinal {
+		if len(plaintext) != 0 {
+			return fr.N, fmt.Errorf("%w: final message is not empty", ErrMalformed)
+		}
+		d.done = true
+		return fr.N, io.EOF
+	}
+	d.buf.Reset()
+	d.buf.Write(plaintext)
+	if _, err = dec(); err != nil {
+		return fr.N, err
+	}
+	return fr.N, nil
+}
+
+// truncated converts io.EOF before the final message to io.ErrUnexpectedEOF.
+func truncated(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
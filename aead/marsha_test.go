@@ -0,0 +1,181 @@
+package aead_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/daotl/go-marsha/aead"
+	"github.com/daotl/go-marsha/cborgen"
+	"github.com/daotl/go-marsha/test"
+)
+
+func newKeyring(t *testing.T, alg aead.Algorithm, ids ...string) *aead.Keyring {
+	kr := aead.NewKeyring()
+	for i, id := range ids {
+		require.NoError(t, kr.Add(id, alg, bytes.Repeat([]byte{byte(i + 1)}, 32)))
+	}
+	return kr
+}
+
+func TestSuite(t *testing.T) {
+	for _, alg := range []aead.Algorithm{aead.AESGCM, aead.ChaCha20Poly1305, aead.XChaCha20Poly1305} {
+		t.Run(alg.String(), func(t *testing.T) {
+			test.SubTestAll(t, aead.New(cborgen.New(), newKeyring(t, alg, "k1")))
+		})
+	}
+}
+
+func TestSpecial(t *testing.T) {
+	req := require.New(t)
+	asrt := assert.New(t)
+	kr := newKeyring(t, aead.AESGCM, "k1", "k2")
+	mrsh := aead.New(cborgen.New(), kr)
+	s := &test.TestStruct{Data: "secret"}
+
+	bin, err := mrsh.MarshalStruct(s)
+	req.NoError(err)
+	asrt.False(bytes.Contains(bin, []byte(s.Data)))
+
+	t.Run("UnmarshalStruct error: tampered", func(t *testing.T) {
+		tampered := append([]byte(nil), bin...)
+		tampered[len(tampered)-1] ^= 1
+		_, err := mrsh.UnmarshalStruct(tampered, &test.TestStruct{})
+		asrt.True(errors.Is(err, aead.ErrOpen))
+	})
+
+	t.Run("UnmarshalStruct error: type confusion", func(t *testing.T) {
+		_, err := mrsh.UnmarshalStruct(bin, &test.TestStruct2{})
+		asrt.True(errors.Is(err, aead.ErrOpen))
+	})
+
+	t.Run("Key rotation", func(t *testing.T) {
+		req.NoError(kr.SetPrimary("k2"))
+		bin2, err := mrsh.MarshalStruct(s)
+		req.NoError(err)
+		req.NoError(kr.Remove("k1"))
+
+		s2 := &test.TestStruct{}
+		read, err := mrsh.UnmarshalStruct(bin2, s2)
+		req.NoError(err)
+		asrt.Equal(s.Data, s2.Data)
+		asrt.Equal(len(bin2), read)
+
+		_, err = mrsh.UnmarshalStruct(bin, s2)
+		asrt.True(errors.Is(err, aead.ErrUnknownKey))
+		asrt.True(errors.Is(kr.Remove("k2"), aead.ErrKeyInUse))
+	})
+
+	t.Run("DecodeStruct error: type confusion", func(t *testing.T) {
+		var buf bytes.Buffer
+		_, err := mrsh.NewEncoder(&buf).EncodeStruct(s)
+		req.NoError(err)
+		_, err = mrsh.NewDecoder(&buf).DecodeStruct(&test.TestStruct2{})
+		asrt.True(errors.Is(err, aead.ErrOpen))
+	})
+}
+
+// encodeStream encodes `items` into a closed stream and returns its stream ID and frames.
+func encodeStream(t *testing.T, mrsh *aead.Marsha, items ...string) ([]byte, [][]byte) {
+	var buf bytes.Buffer
+	enc := mrsh.NewEncoder(&buf)
+	for _, data := range items {
+		_, err := enc.EncodeStruct(&test.TestStruct{Data: data})
+		require.NoError(t, err)
+	}
+	require.NoError(t, enc.(io.Closer).Close())
+	_, err := enc.EncodeStruct(&test.TestStruct{})
+	require.True(t, errors.Is(err, aead.ErrStreamClosed))
+
+	bin := buf.Bytes()
+	id, bin := bin[:16], bin[16:]
+	var frames [][]byte
+	for len(bin) > 0 {
+		l, n := binary.Uvarint(bin[1:])
+		require.Greater(t, n, 0)
+		end := 1 + n + int(l)
+		frames = append(frames, bin[:end])
+		bin = bin[end:]
+	}
+	return id, frames
+}
+
+// decodeStream decodes the stream `id` made of `frames` until an error and returns the items
+// decoded and the error.
+func decodeStream(mrsh *aead.Marsha, id []byte, frames ...[]byte) ([]string, error) {
+	dec := mrsh.NewDecoder(bytes.NewReader(bytes.Join(append([][]byte{id}, frames...), nil)))
+	var items []string
+	for {
+		s := &test.TestStruct{}
+		if _, err := dec.DecodeStruct(s); err != nil {
+			return items, err
+		}
+		items = append(items, s.Data)
+	}
+}
+
+func TestStream(t *testing.T) {
+	asrt := assert.New(t)
+	mrsh := aead.New(cborgen.New(), newKeyring(t, aead.ChaCha20Poly1305, "k1"))
+	id, frames := encodeStream(t, mrsh, "a", "b", "c")
+	asrt.Len(frames, 4)
+
+	items, err := decodeStream(mrsh, id, frames...)
+	asrt.Equal(io.EOF, err)
+	asrt.Equal([]string{"a", "b", "c"}, items)
+
+	t.Run("Empty stream", func(t *testing.T) {
+		id, frames := encodeStream(t, mrsh)
+		items, err := decodeStream(mrsh, id, frames...)
+		asrt.Equal(io.EOF, err)
+		asrt.Empty(items)
+	})
+
+	t.Run("Error: swapped", func(t *testing.T) {
+		items, err := decodeStream(mrsh, id, frames[0], frames[2], frames[1], frames[3])
+		asrt.True(errors.Is(err, aead.ErrOpen))
+		asrt.Equal([]string{"a"}, items)
+	})
+
+	t.Run("Error: dropped", func(t *testing.T) {
+		items, err := decodeStream(mrsh, id, frames[0], frames[2], frames[3])
+		asrt.True(errors.Is(err, aead.ErrOpen))
+		asrt.Equal([]string{"a"}, items)
+	})
+
+	t.Run("Error: truncated", func(t *testing.T) {
+		items, err := decodeStream(mrsh, id, frames[:3]...)
+		asrt.Equal(io.ErrUnexpectedEOF, err)
+		asrt.Equal([]string{"a", "b", "c"}, items)
+
+		items, err = decodeStream(mrsh, id, frames[0], frames[1], frames[3][:len(frames[3])-1])
+		asrt.Equal(io.ErrUnexpectedEOF, err)
+		asrt.Equal([]string{"a", "b"}, items)
+
+		_, err = decodeStream(mrsh, nil)
+		asrt.Equal(io.ErrUnexpectedEOF, err)
+	})
+
+	t.Run("Error: final flag flipped", func(t *testing.T) {
+		final := append([]byte{0}, frames[3][1:]...)
+		items, err := decodeStream(mrsh, id, frames[0], frames[1], frames[2], final)
+		asrt.True(errors.Is(err, aead.ErrOpen))
+		asrt.Equal([]string{"a", "b", "c"}, items)
+	})
+
+	t.Run("Error: spliced from another stream", func(t *testing.T) {
+		id2, frames2 := encodeStream(t, mrsh, "x", "y")
+		items, err := decodeStream(mrsh, id, frames[0], frames2[1], frames[2], frames[3])
+		asrt.True(errors.Is(err, aead.ErrOpen))
+		asrt.Equal([]string{"a"}, items)
+
+		items, err = decodeStream(mrsh, id2, frames[:2]...)
+		asrt.True(errors.Is(err, aead.ErrOpen))
+		asrt.Empty(items)
+	})
+}
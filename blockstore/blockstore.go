@@ -0,0 +1,99 @@
+// Package blockstore computes content identifiers (CIDs) of marshaled structs and stores them by
+// CID in a pluggable BlockStore, verifying their hashes on read.
+package blockstore
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/ipfs/go-cid"
+	mh "github.com/multiformats/go-multihash"
+
+	"github.com/daotl/go-marsha"
+)
+
+var (
+	ErrNotFound     = errors.New("block not found")
+	ErrHashMismatch = errors.New("block does not match its CID")
+)
+
+// DefaultPrefix is the prefix of CIDs computed by default: CIDv1 with the DAG-CBOR codec and a
+// SHA2-256 multihash.
+var DefaultPrefix = cid.Prefix{
+	Version:  1,
+	Codec:    cid.DagCBOR,
+	MhType:   mh.SHA2_256,
+	MhLength: -1,
+}
+
+// BlockStore stores blocks by CID.
+// Implementations must be safe for concurrent use by multiple goroutines.
+type BlockStore interface {
+	// Put stores the block `data` under CID `c`. Storing a block already stored is a no-op.
+	Put(c cid.Cid, data []byte) error
+
+	// Get returns the block stored under CID `c`, or an error wrapping ErrNotFound.
+	Get(c cid.Cid) ([]byte, error)
+
+	// Has returns whether a block is stored under CID `c`.
+	Has(c cid.Cid) (bool, error)
+
+	// Delete deletes the block stored under CID `c`. Deleting a missing block is a no-op.
+	Delete(c cid.Cid) error
+
+	// ForEach calls `f` with the CID of each stored block until it returns an error.
+	ForEach(f func(c cid.Cid) error) error
+}
+
+// Verify returns an error wrapping ErrHashMismatch if `data` does not hash to CID `c`.
+func Verify(c cid.Cid, data []byte) error {
+	c2, err := c.Prefix().Sum(data)
+	if err != nil {
+		return err
+	}
+	if !c2.Equals(c) {
+		return fmt.Errorf("%w: %s", ErrHashMismatch, c)
+	}
+	return nil
+}
+
+// CID marshals the struct `p` points to with `m` and returns its CID with DefaultPrefix along with
+// the marshaled bytes. `m` must marshal deterministically, such as the `cborgen` implementation
+// or the `cbor_refmt` implementation.
+func CID(m marsha.Marsha, p marsha.StructPtr) (cid.Cid, []byte, error) {
+	return CIDWithPrefix(m, p, DefaultPrefix)
+}
+
+// CIDWithPrefix is like CID but computes the CID with `prefix`.
+func CIDWithPrefix(m marsha.Marsha, p marsha.StructPtr, prefix cid.Prefix) (cid.Cid, []byte, error) {
+	bin, err := m.MarshalStruct(p)
+	if err != nil {
+		return cid.Undef, nil, err
+	}
+	c, err := prefix.Sum(bin)
+	if err != nil {
+		return cid.Undef, nil, err
+	}
+	return c, bin, nil
+}
+
+// PutStruct marshals the struct `p` points to with `m`, stores it in `bs` and returns its CID with
+// DefaultPrefix.
+func PutStruct(bs BlockStore, m marsha.Marsha, p marsha.StructPtr) (cid.Cid, error) {
+	c, bin, err := CID(m, p)
+	if err != nil {
+		return cid.Undef, err
+	}
+	return c, bs.Put(c, bin)
+}
+
+// GetStruct gets the block stored under CID `c` from `bs` and unmarshals it with `m` into the
+// struct `p` points to.
+func GetStruct(bs BlockStore, m marsha.Marsha, c cid.Cid, p marsha.StructPtr) error {
+	bin, err := bs.Get(c)
+	if err != nil {
+		return err
+	}
+	_, err = m.UnmarshalStruct(bin, p)
+	return err
+}
@@ -0,0 +1,94 @@
+package blockstore_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	mh "github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/daotl/go-marsha/blockstore"
+	"github.com/daotl/go-marsha/cborgen"
+	"github.com/daotl/go-marsha/test"
+)
+
+func TestCID(t *testing.T) {
+	req := require.New(t)
+	asrt := assert.New(t)
+	mrsh := cborgen.New()
+
+	c1, bin, err := blockstore.CID(mrsh, &test.TestStruct{Data: "test"})
+	req.NoError(err)
+	c2, _, err := blockstore.CID(mrsh, &test.TestStruct{Data: "test"})
+	req.NoError(err)
+	asrt.True(c1.Equals(c2))
+	asrt.Equal(uint64(cid.DagCBOR), c1.Type())
+	asrt.NoError(blockstore.Verify(c1, bin))
+
+	c3, _, err := blockstore.CIDWithPrefix(mrsh, &test.TestStruct{Data: "test"}, cid.Prefix{
+		Version: 1, Codec: cid.DagCBOR, MhType: mh.SHA2_512, MhLength: -1,
+	})
+	req.NoError(err)
+	asrt.False(c1.Equals(c3))
+	asrt.NoError(blockstore.Verify(c3, bin))
+}
+
+func TestBlockStores(t *testing.T) {
+	fs, err := blockstore.NewFS(t.TempDir())
+	require.NoError(t, err)
+
+	for name, bs := range map[string]blockstore.BlockStore{"Memory": blockstore.NewMemory(), "FS": fs} {
+		t.Run(name, func(t *testing.T) {
+			req := require.New(t)
+			asrt := assert.New(t)
+			mrsh := cborgen.New()
+
+			c, err := blockstore.PutStruct(bs, mrsh, &test.TestStruct{Data: "test"})
+			req.NoError(err)
+			c2, err := blockstore.PutStruct(bs, mrsh, &test.TestStruct{Data: "test2"})
+			req.NoError(err)
+			has, err := bs.Has(c)
+			req.NoError(err)
+			asrt.True(has)
+
+			s := &test.TestStruct{}
+			req.NoError(blockstore.GetStruct(bs, mrsh, c, s))
+			asrt.Equal("test", s.Data)
+
+			count := 0
+			req.NoError(bs.ForEach(func(cid.Cid) error { count++; return nil }))
+			asrt.Equal(2, count)
+
+			err = bs.Put(c, []byte("not the block"))
+			asrt.True(errors.Is(err, blockstore.ErrHashMismatch))
+
+			req.NoError(bs.Delete(c2))
+			_, err = bs.Get(c2)
+			asrt.True(errors.Is(err, blockstore.ErrNotFound))
+		})
+	}
+}
+
+func TestFSVerifiesOnRead(t *testing.T) {
+	req := require.New(t)
+	dir := t.TempDir()
+	bs, err := blockstore.NewFS(dir)
+	req.NoError(err)
+	c, err := blockstore.PutStruct(bs, cborgen.New(), &test.TestStruct{Data: "test"})
+	req.NoError(err)
+
+	name := c.String()
+	p := filepath.Join(dir, name[len(name)-2:], name)
+	data, err := ioutil.ReadFile(p)
+	req.NoError(err)
+	data[len(data)-1] ^= 1
+	req.NoError(ioutil.WriteFile(p, data, os.ModePerm))
+
+	_, err = bs.Get(c)
+	assert.True(t, errors.Is(err, blockstore.ErrHashMismatch))
+}
@@ -0,0 +1,119 @@
+package blockstore
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/ipfs/go-cid"
+)
+
+// FS is a BlockStore keeping each block in a file under a directory. Blocks are sharded into
+// subdirectories named after the last 2 characters of their CID strings.
+type FS struct {
+	dir string
+}
+
+var _ BlockStore = (*FS)(nil)
+
+// NewFS creates an FS storing blocks under directory `dir`, creating it if needed.
+func NewFS(dir string) (*FS, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &FS{dir: dir}, nil
+}
+
+func (s *FS) path(c cid.Cid) string {
+	name := c.String()
+	return filepath.Join(s.dir, name[len(name)-2:], name)
+}
+
+// Put writes the block to a temporary file and renames it, so a crash never leaves a partial block.
+func (s *FS) Put(c cid.Cid, data []byte) error {
+	if err := Verify(c, data); err != nil {
+		return err
+	}
+	p := s.path(c)
+	if _, err := os.Stat(p); err == nil {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(filepath.Dir(p), ".tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err = f.Write(data); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err = f.Sync(); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), p)
+}
+
+// Get reads the block and verifies it against CID `c`.
+func (s *FS) Get(c cid.Cid) ([]byte, error) {
+	data, err := ioutil.ReadFile(s.path(c))
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, c)
+	} else if err != nil {
+		return nil, err
+	}
+	if err = Verify(c, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+func (s *FS) Has(c cid.Cid) (bool, error) {
+	_, err := os.Stat(s.path(c))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (s *FS) Delete(c cid.Cid) error {
+	err := os.Remove(s.path(c))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// ForEach calls `f` with the CID of each block file. Files not named after a CID are ignored.
+func (s *FS) ForEach(f func(c cid.Cid) error) error {
+	shards, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return err
+	}
+	for _, shard := range shards {
+		if !shard.IsDir() {
+			continue
+		}
+		files, err := ioutil.ReadDir(filepath.Join(s.dir, shard.Name()))
+		if err != nil {
+			return err
+		}
+		for _, file := range files {
+			c, err := cid.Decode(file.Name())
+			if err != nil {
+				continue
+			}
+			if err = f(c); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package blockstore
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/ipfs/go-cid"
+)
+
+// Memory is an in-memory BlockStore.
+type Memory struct {
+	mu     sync.RWMutex
+	blocks map[string][]byte // keyed by cid.Cid.KeyString()
+}
+
+var _ BlockStore = (*Memory)(nil)
+
+// NewMemory creates an empty Memory.
+func NewMemory() *Memory {
+	return &Memory{blocks: make(map[string][]byte)}
+}
+
+func (s *Memory) Put(c cid.Cid, data []byte) error {
+	if err := Verify(c, data); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.blocks[c.KeyString()] = append([]byte(nil), data...)
+	return nil
+}
+
+// Get returns a copy of the block, verified against CID `c`.
+func (s *Memory) Get(c cid.Cid) ([]byte, error) {
+	s.mu.RLock()
+	data, ok := s.blocks[c.KeyString()]
+	s.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, c)
+	}
+	if err := Verify(c, data); err != nil {
+		return nil, err
+	}
+	return append([]byte(nil), data...), nil
+}
+
+func (s *Memory) Has(c cid.Cid) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	_, ok := s.blocks[c.KeyString()]
+	return ok, nil
+}
+
+func (s *Memory) Delete(c cid.Cid) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.blocks, c.KeyString())
+	return nil
+}
+
+// ForEach calls `f` with a snapshot of the stored CIDs, so `f` may modify the Memory.
+func (s *Memory) ForEach(f func(c cid.Cid) error) error {
+	s.mu.RLock()
+	keys := make([]string, 0, len(s.blocks))
+	for k := range s.blocks {
+		keys = append(keys, k)
+	}
+	s.mu.RUnlock()
+	for _, k := range keys {
+		c, err := cid.Cast([]byte(k))
+		if err != nil {
+			return err
+		}
+		if err = f(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
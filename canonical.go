@@ -0,0 +1,50 @@
+package marsha
+
+import (
+	"github.com/daotl/go-marsha/internal/cbor"
+)
+
+var (
+	// ErrNotCanonical is wrapped by errors reporting input not in deterministic encoding, such as
+	// CBOR not in core deterministic encoding or Protocol Buffers messages which differ from their
+	// deterministic encoding.
+	ErrNotCanonical = cbor.ErrNotCanonical
+	// ErrInvalidCBOR is wrapped by errors reporting malformed CBOR.
+	ErrInvalidCBOR = cbor.ErrSyntax
+)
+
+// CheckCanonical returns nil if `bin` is exactly one CBOR data item in core deterministic encoding
+// as defined by RFC 8949 section 4.2.1, or otherwise an error wrapping ErrNotCanonical or
+// ErrInvalidCBOR which reports the offset of the first violation:
+//   - integers, lengths and tags are encoded in the fewest bytes possible,
+//   - no item has indefinite length,
+//   - floats are encoded in the shortest form preserving their value, and NaN as 0xf97e00,
+//   - map keys are sorted in bytewise lexicographic order of their encodings with no duplicates.
+func CheckCanonical(bin []byte) error {
+	end, err := cbor.CheckCanonical(bin, 0)
+	if err != nil {
+		return err
+	}
+	if end != len(bin) {
+		return &cbor.NonCanonicalError{Offset: end, Msg: "trailing bytes"}
+	}
+	return nil
+}
+
+// IsCanonical returns whether `bin` is exactly one CBOR data item in core deterministic encoding.
+func IsCanonical(bin []byte) bool {
+	return CheckCanonical(bin) == nil
+}
+
+// Canonicalize re-encodes the CBOR data item `bin` in core deterministic encoding. It fails if
+// `bin` is not exactly one well-formed data item or contains a map with duplicate keys.
+func Canonicalize(bin []byte) ([]byte, error) {
+	out, end, err := cbor.Canonicalize(make([]byte, 0, len(bin)), bin, 0)
+	if err != nil {
+		return nil, err
+	}
+	if end != len(bin) {
+		return nil, &cbor.SyntaxError{Offset: end, Msg: "trailing bytes"}
+	}
+	return out, nil
+}
@@ -0,0 +1,71 @@
+package marsha_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/daotl/go-marsha"
+)
+
+func TestCanonical(t *testing.T) {
+	asrt := assert.New(t)
+
+	for _, c := range []struct {
+		name      string
+		bin       []byte
+		canonical []byte // nil if `bin` is canonical
+	}{
+		{"small integer", []byte{0x17}, nil},
+		{"non-minimal integer", []byte{0x18, 0x17}, []byte{0x17}},
+		{"non-minimal negative integer", []byte{0x39, 0x00, 0x01}, []byte{0x21}},
+		{"non-minimal string length", []byte{0x79, 0x00, 0x01, 'a'}, []byte{0x61, 'a'}},
+		{"indefinite string", []byte{0x7f, 0x61, 'a', 0x61, 'b', 0xff}, []byte{0x62, 'a', 'b'}},
+		{"indefinite array", []byte{0x9f, 0x01, 0x02, 0xff}, []byte{0x82, 0x01, 0x02}},
+		{"half float", []byte{0xf9, 0x3e, 0x00}, nil},
+		{"double float", []byte{0xfb, 0x3f, 0xf8, 0, 0, 0, 0, 0, 0}, []byte{0xf9, 0x3e, 0x00}},
+		{"single float", []byte{0xfa, 0x47, 0xc3, 0x50, 0x00}, nil},
+		{"double float as single", []byte{0xfb, 0x40, 0xf8, 0x6a, 0, 0, 0, 0, 0},
+			[]byte{0xfa, 0x47, 0xc3, 0x50, 0x00}},
+		{"subnormal half float", []byte{0xfa, 0x33, 0x80, 0, 0}, []byte{0xf9, 0x00, 0x01}},
+		{"NaN", []byte{0xfb, 0x7f, 0xf8, 0, 0, 0, 0, 0, 0}, []byte{0xf9, 0x7e, 0x00}},
+		{"sorted map", []byte{0xa2, 0x01, 0x00, 0x61, 'a', 0x00}, nil},
+		{"unsorted map", []byte{0xa2, 0x61, 'a', 0x00, 0x01, 0x00},
+			[]byte{0xa2, 0x01, 0x00, 0x61, 'a', 0x00}},
+		{"length-first map keys", []byte{0xa2, 0x62, 'a', 'a', 0x00, 0x61, 'b', 0x00},
+			[]byte{0xa2, 0x61, 'b', 0x00, 0x62, 'a', 'a', 0x00}},
+		{"nested", []byte{0xd8, 0x2a, 0x81, 0xa1, 0x18, 0x01, 0x00},
+			[]byte{0xd8, 0x2a, 0x81, 0xa1, 0x01, 0x00}},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			err := marsha.CheckCanonical(c.bin)
+			asrt.Equal(c.canonical == nil, marsha.IsCanonical(c.bin))
+			if c.canonical != nil {
+				asrt.True(errors.Is(err, marsha.ErrNotCanonical))
+			}
+			canonical, err := marsha.Canonicalize(c.bin)
+			asrt.NoError(err)
+			if c.canonical == nil {
+				asrt.Equal(c.bin, canonical)
+			} else {
+				asrt.Equal(c.canonical, canonical)
+			}
+		})
+	}
+
+	t.Run("Error: duplicate map key", func(t *testing.T) {
+		bin := []byte{0xa2, 0x01, 0x00, 0x01, 0x01}
+		err := marsha.CheckCanonical(bin)
+		asrt.True(errors.Is(err, marsha.ErrNotCanonical))
+		asrt.Contains(err.Error(), "offset 3")
+		_, err = marsha.Canonicalize(bin)
+		asrt.True(errors.Is(err, marsha.ErrInvalidCBOR))
+	})
+
+	t.Run("Error: trailing bytes", func(t *testing.T) {
+		asrt.False(marsha.IsCanonical([]byte{0x01, 0x02}))
+		_, err := marsha.Canonicalize([]byte{0x01, 0x02})
+		asrt.True(errors.Is(err, marsha.ErrInvalidCBOR))
+	})
+}
@@ -3,13 +3,23 @@
 package cbor_refmt
 
 import (
+	"bytes"
+	"errors"
 	"io"
 	"sync"
 
+	cbg "github.com/daotl/cbor-gen"
+
 	"github.com/daotl/go-marsha"
+	"github.com/daotl/go-marsha/internal/cborutil"
 	"github.com/daotl/go-marsha/internal/refmt"
 )
 
+var (
+	ErrNotCBORArrayBytes = errors.New("bytes does not represent a CBOR array")
+	ErrArrayTooLong      = errors.New("CBOR array too long")
+)
+
 // Marsha is a marsha.Marsha implementation for CBOR backed by `go-ipld-cbor` and `refmt` packages.
 //
 // A Struct must first be registered by calling Marsha.Register(Struct{}) before being able to be
@@ -21,16 +31,29 @@ import (
 //   	Foo string `refmt:"bar,omitempty"`
 //   }
 //
+// A marsha.Union is marshaled as its value preceded by the CBOR tag or name registered for the
+// value's type in the marsha.Registry passed by marsha.WithRegistry, and *marsha.Unions can be
+// used as a heterogeneous struct slice. The concrete types must be registered with both.
+//
+// refmt always encodes floats in 64 bits, so with marsha.WithDeterministic or marsha.WithStrict
+// the output is re-encoded when it contains floats representable in fewer bits. With
+// marsha.WithStrict, Decoder reads each data item in full before unmarshaling it.
 type Marsha struct {
 	refmt *refmt.Refmt
+	opts  marsha.Options
 }
 
-var _ marsha.Marsha = (*Marsha)(nil)
+var (
+	_ marsha.ValueMarsha  = (*Marsha)(nil)
+	_ marsha.ValueDecoder = (*Marsha)(nil)
+	_ marsha.AppendMarsha = (*Marsha)(nil)
+)
 
 // New creates a Marsha.
-func New() *Marsha {
+func New(opts ...marsha.Option) *Marsha {
 	return &Marsha{
 		refmt: refmt.New(),
+		opts:  marsha.NewOptions(opts...),
 	}
 }
 
@@ -40,35 +63,95 @@ func (m *Marsha) Register(i interface{}) {
 }
 
 func (m *Marsha) MarshalPrimitive(p interface{}) ([]byte, error) {
-	return m.refmt.Marshaller.Marshal(p)
+	return m.marshal(p)
 }
 
 func (m *Marsha) MarshalStruct(p marsha.StructPtr) ([]byte, error) {
-	return m.refmt.Marshaller.Marshal(p)
+	return m.marshal(p)
 }
 
 func (m *Marsha) MarshalStructSlice(p marsha.StructSlicePtr) ([]byte, error) {
-	return m.refmt.Marshaller.Marshal(p)
+	return m.marshal(p)
+}
+
+// MarshalStructAppend appends the encoding of the struct `p` points to to `dst`.
+func (m *Marsha) MarshalStructAppend(dst []byte, p marsha.StructPtr) ([]byte, error) {
+	return m.marshalAppend(dst, p)
+}
+
+// MarshalStructSliceAppend appends the encoding of the struct slice `p` points to to `dst`.
+func (m *Marsha) MarshalStructSliceAppend(dst []byte, p marsha.StructSlicePtr) ([]byte, error) {
+	return m.marshalAppend(dst, p)
 }
 
 // This implementation does not support returning the count of bytes read.
 func (m *Marsha) UnmarshalStruct(bin []byte, p marsha.StructPtr) (int, error) {
-	return -1, m.refmt.Unmarshaller.Unmarshal(bin, p)
+	return -1, m.unmarshal(bin, p)
 }
 
 // This implementation does not support returning the count of bytes read.
 func (m *Marsha) UnmarshalPrimitive(bin []byte, p interface{}) (int, error) {
-	return -1, m.refmt.Unmarshaller.Unmarshal(bin, p)
+	return -1, m.unmarshal(bin, p)
 }
 
 // This implementation does not support returning the count of bytes read.
 func (m *Marsha) UnmarshalStructSlice(bin []byte, p marsha.StructSlicePtr) (int, error) {
-	return -1, m.refmt.Unmarshaller.Unmarshal(bin, p)
+	return -1, m.unmarshal(bin, p)
+}
+
+func (m *Marsha) marshal(p interface{}) ([]byte, error) {
+	bin, err := m.marshalAppend(nil, p)
+	if err != nil {
+		return nil, err
+	}
+	return bin, nil
+}
+
+func (m *Marsha) marshalAppend(dst []byte, p interface{}) ([]byte, error) {
+	return appendEncoding(dst, p, m.refmt, m.opts)
+}
+
+func (m *Marsha) unmarshal(bin []byte, p interface{}) error {
+	in, done, err := cborutil.Input(m.opts, bin, p, m.refmt)
+	if err != nil {
+		return err
+	}
+	switch p.(type) {
+	case *marsha.Union, *marsha.Unions:
+		err = decode(bytes.NewReader(in), p, m.refmt, m.opts.Registry)
+	default:
+		err = m.refmt.Unmarshaller.Unmarshal(in, p)
+	}
+	if err == nil {
+		done(-1)
+	}
+	return err
+}
+
+// DecodeValue decodes the data item at the start of `bin` into a Value tree without a Go type, and
+// returns it along with the count of bytes read. With marsha.WithStrict, `bin` must be exactly one
+// data item in core deterministic encoding.
+func (m *Marsha) DecodeValue(bin []byte) (marsha.Value, int, error) {
+	return cborutil.DecodeValue(m.opts, bin)
+}
+
+// EncodeValue encodes the Value tree `v` in core deterministic encoding.
+func (m *Marsha) EncodeValue(v marsha.Value) ([]byte, error) {
+	return marsha.EncodeCBORValue(v)
+}
+
+func (m *Marsha) StructToValue(p marsha.StructPtr) (marsha.Value, error) {
+	return cborutil.StructToValue(m, p)
+}
+
+func (m *Marsha) ValueToStruct(v marsha.Value, p marsha.StructPtr) error {
+	return cborutil.ValueToStruct(m, v, p)
 }
 
 func (m *Marsha) NewEncoder(w io.Writer) marsha.Encoder {
 	return &encoder{
 		refmt: m.refmt,
+		opts:  m.opts,
 		w:     w,
 	}
 }
@@ -76,6 +159,7 @@ func (m *Marsha) NewEncoder(w io.Writer) marsha.Encoder {
 func (m *Marsha) NewDecoder(r io.Reader) marsha.Decoder {
 	return &decoder{
 		refmt: m.refmt,
+		opts:  m.opts,
 		r:     r,
 	}
 }
@@ -83,6 +167,7 @@ func (m *Marsha) NewDecoder(r io.Reader) marsha.Decoder {
 type encoder struct {
 	sync.Mutex // each item must be sent atomically
 	refmt      *refmt.Refmt
+	opts       marsha.Options
 	w          io.Writer
 }
 
@@ -106,12 +191,19 @@ func (e *encoder) encode(p interface{}) error {
 	// goroutines can share an encoder.
 	e.Lock()
 	defer e.Unlock()
-	return e.refmt.Marshaller.Encode(p, e.w)
+	if !cborutil.Buffered(e.opts) {
+		return encode(e.w, p, e.refmt, e.opts.Registry)
+	}
+	_, err := cborutil.WriteAppended(e.w, func(dst []byte) ([]byte, error) {
+		return appendEncoding(dst, p, e.refmt, e.opts)
+	})
+	return err
 }
 
 type decoder struct {
 	sync.Mutex // each item must be sent atomically
 	refmt      *refmt.Refmt
+	opts       marsha.Options
 	r          io.Reader
 }
 
@@ -135,5 +227,84 @@ func (d *decoder) decode(p interface{}) error {
 	// goroutines can share a decoder.
 	d.Lock()
 	defer d.Unlock()
-	return d.refmt.Unmarshaller.Decode(d.r, p)
+	r, done, err := cborutil.Reader(d.opts, d.r, p, d.refmt)
+	if err != nil {
+		return err
+	}
+	if err = decode(r, p, d.refmt, d.opts.Registry); err == nil {
+		done(-1)
+	}
+	return err
+}
+
+// encode writes `p` to `w`, marshaling a marsha.Union or marsha.Unions by their registered tags.
+func encode(w io.Writer, p interface{}, r *refmt.Refmt, reg *marsha.Registry) error {
+	switch u := p.(type) {
+	case *marsha.Union:
+		h, _, err := cborutil.UnionValue(reg, u)
+		if err != nil {
+			return err
+		}
+		if _, err = w.Write(h); err != nil {
+			return err
+		}
+		return r.Marshaller.Encode(u.Value, w)
+	case *marsha.Unions:
+		if _, err := cbg.WriteMajorTypeHeader(w, cbg.MajArray, uint64(len(*u))); err != nil {
+			return err
+		}
+		for i := range *u {
+			if err := encode(w, &(*u)[i], r, reg); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		return r.Marshaller.Encode(p, w)
+	}
+}
+
+// appendEncoding appends the encoding of `p` to `dst` according to `opts`.
+func appendEncoding(dst []byte, p interface{}, r *refmt.Refmt, opts marsha.Options) ([]byte, error) {
+	return cborutil.Append(opts, dst, p, r, func(w io.Writer) error {
+		return encode(w, p, r, opts.Registry)
+	})
+}
+
+// decode reads `p` from `rd`, unmarshaling a marsha.Union or marsha.Unions by their registered tags.
+func decode(rd io.Reader, p interface{}, r *refmt.Refmt, reg *marsha.Registry) error {
+	switch u := p.(type) {
+	case *marsha.Union:
+		ti, _, err := cborutil.ReadUnionHeader(rd, reg)
+		if err != nil {
+			return err
+		}
+		v := ti.New()
+		if err = r.Unmarshaller.Decode(rd, v); err != nil {
+			return err
+		}
+		u.Value = v
+		return nil
+	case *marsha.Unions:
+		maj, l, _, err := cbg.CborReadHeader(rd)
+		if err != nil {
+			return err
+		}
+		if maj != cbg.MajArray {
+			return ErrNotCBORArrayBytes
+		}
+		if l > cbg.MaxLength {
+			return ErrArrayTooLong
+		}
+		us := make(marsha.Unions, l)
+		for i := range us {
+			if err = decode(rd, &us[i], r, reg); err != nil {
+				return err
+			}
+		}
+		*u = append(*u, us...)
+		return nil
+	default:
+		return r.Unmarshaller.Decode(rd, p)
+	}
 }
@@ -42,6 +42,78 @@ func TestSuite(t *testing.T) {
 	test.SubTestAll(t, mrsh)
 }
 
+func TestAppend(t *testing.T) {
+	mrsh := cbor_refmt.New()
+	mrsh.Register(test.TestStruct{})
+	test.SubTestAppend(t, mrsh)
+}
+
+func TestUnion(t *testing.T) {
+	mrsh := cbor_refmt.New(marsha.WithRegistry(test.NewRegistry()))
+	mrsh.Register(test.TestStruct{})
+	mrsh.Register(test.TestStruct2{})
+	test.SubTestUnion(t, mrsh)
+}
+
+func TestValue(t *testing.T) {
+	mrsh := cbor_refmt.New()
+	mrsh.Register(test.TestStructV2{})
+	mrsh.Register(test.TestChildV2{})
+	test.SubTestValue(t, mrsh)
+
+	// Data of unregistered types can still be decoded.
+	bin, err := mrsh.MarshalPrimitive(&map[string]interface{}{"a": []interface{}{1.5, "b"}})
+	require.NoError(t, err)
+	v, _, err := mrsh.DecodeValue(bin)
+	require.NoError(t, err)
+	elems, ok := v.Get("a")
+	require.True(t, ok)
+	assert.True(t, marsha.NewArray(marsha.NewFloat(1.5), marsha.NewString("b")).Equal(elems))
+}
+
+func TestGet(t *testing.T) {
+	mrsh := cbor_refmt.New()
+	mrsh.Register(TestStructNoGen{})
+
+	// Fields of the map layout are keyed by their lowercased names.
+	bin, err := mrsh.MarshalStruct(&TestStructNoGen{Data: "test"})
+	require.NoError(t, err)
+	v, err := marsha.Get(bin, "/data")
+	require.NoError(t, err)
+	assert.True(t, marsha.NewString("test").Equal(v))
+
+	bin, err = mrsh.MarshalPrimitive(&map[string]interface{}{
+		"items": []interface{}{map[string]interface{}{"name": "a"}, map[string]interface{}{"name": "b"}},
+	})
+	require.NoError(t, err)
+	v, err = marsha.Get(bin, "/items/1/name")
+	require.NoError(t, err)
+	assert.True(t, marsha.NewString("b").Equal(v))
+}
+
+func TestDeterministic(t *testing.T) {
+	mrsh := cbor_refmt.New(marsha.WithDeterministic(), marsha.WithStrict())
+	mrsh.Register(test.TestStruct{})
+	test.SubTestDeterministic(t, mrsh)
+}
+
+func TestStrict(t *testing.T) {
+	mrsh := cbor_refmt.New(marsha.WithStrict())
+	mrsh.Register(test.TestStruct{})
+	test.SubTestStrict(t, mrsh)
+}
+
+func TestUnknown(t *testing.T) {
+	test.SubTestUnknown(t, func(p marsha.UnknownPolicy) marsha.Marsha {
+		mrsh := cbor_refmt.New(marsha.WithUnknownPolicy(p))
+		mrsh.Register(test.TestStructV1{})
+		mrsh.Register(test.TestChildV1{})
+		mrsh.Register(test.TestStructV2{})
+		mrsh.Register(test.TestChildV2{})
+		return mrsh
+	})
+}
+
 func TestNoGenBasic(t *testing.T) {
 	req := require.New(t)
 	asrt := assert.New(t)
@@ -0,0 +1,200 @@
+package cbordiag_test
+
+import (
+	"encoding/hex"
+	"errors"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/daotl/go-marsha"
+	"github.com/daotl/go-marsha/cbordiag"
+	"github.com/daotl/go-marsha/cborgen"
+	"github.com/daotl/go-marsha/test"
+)
+
+const link = "bafyreidykglsfhoixmivffc5uwhcgshx4j465xwqntbmu43nb2dzqwfvae"
+
+// Items in their diagnostic notation and encoding.
+var items = []struct {
+	diag string
+	hex  string
+}{
+	{"0", "00"},
+	{"23", "17"},
+	{"24", "1818"},
+	{"1_0", "1801"},
+	{"1_3", "1b0000000000000001"},
+	{"18446744073709551615", "1bffffffffffffffff"},
+	{"-1", "20"},
+	{"-18446744073709551616", "3bffffffffffffffff"},
+	{"0.0", "f90000"},
+	{"-0.0", "f98000"},
+	{"1.5", "f93e00"},
+	{"100000.0", "fa47c35000"},
+	{"1.0009765625", "f93c01"},
+	{"5.960464477539063e-08", "f90001"},
+	{"1.0009765625_2", "fa3f802000"},
+	{"0.10000000149011612", "fa3dcccccd"},
+	{"1.401298464324817e-45", "fa00000001"},
+	{"1.1", "fb3ff199999999999a"},
+	{"1.5_3", "fb3ff8000000000000"},
+	{"1e-05", "fb3ee4f8b588e368f1"},
+	{"1e+300", "fb7e37e43c8800759c"},
+	{"Infinity", "f97c00"},
+	{"-Infinity", "f9fc00"},
+	{"NaN", "f97e00"},
+	{"false", "f4"},
+	{"true", "f5"},
+	{"null", "f6"},
+	{"undefined", "f7"},
+	{"simple(16)", "f0"},
+	{"simple(255)", "f8ff"},
+	{"h''", "40"},
+	{"h'01ff'", "4201ff"},
+	{"h'01'_1", "59000101"},
+	{`""`, "60"},
+	{`"a\"\\\n\u0001ü水"`, "6a61225c0a01c3bce6b0b4"},
+	{`(_ h'01', h'02')`, "5f41014102ff"},
+	{`(_ "a", "b")`, "7f61616162ff"},
+	{"[]", "80"},
+	{"[1, [2, 3]]", "8201820203"},
+	{"[_ 1, 2]", "9f0102ff"},
+	{"[_ ]", "9fff"},
+	{"[_0 1]", "980101"},
+	{"{}", "a0"},
+	{`{1: 2, "a": [true]}`, "a20102616181f5"},
+	{`{_ "a": 1}`, "bf616101ff"},
+	{"1(1363896240)", "c11a514b67b0"},
+	{"2(h'010000000000000000')", "c249010000000000000000"},
+	{"42_1(h'01')", "d9002a4101"},
+	{`42("` + link + `")`, "d82a58250001711220785197229dc8bb1152945da58e2348f7e279eeded06cc2ca736d0e879858b501"},
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, c := range items {
+		want, err := hex.DecodeString(stripSpaces(c.hex))
+		require.NoError(t, err, c.diag)
+		got, err := cbordiag.Parse(c.diag)
+		if assert.NoError(t, err, c.diag) {
+			assert.Equal(t, hex.EncodeToString(want), hex.EncodeToString(got), c.diag)
+		}
+		diag, err := cbordiag.Format(want)
+		assert.NoError(t, err, c.diag)
+		assert.Equal(t, c.diag, diag)
+	}
+}
+
+func stripSpaces(s string) string {
+	out := make([]byte, 0, len(s))
+	for i := 0; i < len(s); i++ {
+		if s[i] != ' ' {
+			out = append(out, s[i])
+		}
+	}
+	return string(out)
+}
+
+func TestParseExtensions(t *testing.T) {
+	for diag, want := range map[string]string{
+		"0x2a":                   "182a",
+		"-0b11":                  "22",
+		"0o17":                   "0f",
+		"+1":                     "01",
+		"-0":                     "00",
+		"1.0_2":                  "fa3f800000",
+		"'a\\'b'":                "4361 27 62",
+		"b64'AQL_'":              "4301 02ff",
+		"b64'AQL/'":              "4301 02ff",
+		"h'01 02\n 03'":          "43010203",
+		"<<1, \"a\">>":           "43016161",
+		"[1, 2,]":                "820102",
+		"/ array / [1, # one\n]": "8101",
+		"\"\\ud83d\\ude00\"":     "64f09f9880",
+		"(_ )":                   "5fff",
+	} {
+		got, err := cbordiag.Parse(diag)
+		if assert.NoError(t, err, diag) {
+			assert.Equal(t, stripSpaces(want), hex.EncodeToString(got), diag)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for diag, want := range map[string]string{
+		"":                     "invalid diagnostic notation at 1:1: unexpected end of input",
+		"[1,\n 2":              `invalid diagnostic notation at 2:3: expected ","`,
+		"1 2":                  `invalid diagnostic notation at 1:3: unexpected '2' after the item`,
+		"foo":                  `invalid diagnostic notation at 1:1: unknown word "foo"`,
+		"256_0":                "invalid diagnostic notation at 1:6: 256 doesn't fit in the 1-byte argument of encoding indicator _0",
+		"18446744073709551616": "invalid diagnostic notation at 1:1: integer 18446744073709551616 out of range, use a bignum such as 2(h'...')",
+		"1.1_1":                "invalid diagnostic notation at 1:1: 1.1 is not a half-precision float",
+		`42("nope")`:           `invalid diagnostic notation at 1:4: invalid CID: selected encoding not supported`,
+		`(_ "a", h'01')`:       "invalid diagnostic notation at 1:9: chunks of different string types",
+		`"abc`:                 "invalid diagnostic notation at 1:1: unterminated string",
+		"h'0'":                 "invalid diagnostic notation at 1:1: invalid hex: encoding/hex: odd length hex string",
+		"simple(24)":           "invalid diagnostic notation at 1:8: invalid simple value",
+		"/ unterminated":       "invalid diagnostic notation at 1:1: unterminated comment",
+		`"\x"`:                 `invalid diagnostic notation at 1:2: invalid escape \x`,
+	} {
+		_, err := cbordiag.Parse(diag)
+		if assert.Error(t, err, diag) {
+			assert.True(t, errors.Is(err, cbordiag.ErrSyntax))
+			assert.Equal(t, want, err.Error(), diag)
+		}
+	}
+	assert.Panics(t, func() { cbordiag.MustParse("[") })
+}
+
+func TestFormatOptions(t *testing.T) {
+	c, err := cid.Decode(link)
+	require.NoError(t, err)
+	bin, err := cborgen.New().MarshalStruct(&test.TestLinked{Name: "a", Left: &c})
+	require.NoError(t, err)
+
+	diag, err := cbordiag.Format(bin, cbordiag.WithIndent("  "))
+	require.NoError(t, err)
+	assert.Equal(t, `[
+  "a",
+  42("`+link+`"),
+  null
+]`, diag)
+
+	diag, err = cbordiag.Format(bin, cbordiag.WithOffsets(), cbordiag.WithRawLinks())
+	require.NoError(t, err)
+	assert.Equal(t, `/0/ [/1/ "a", /3/ 42(/5/ h'0001711220785197229dc8bb1152945da58e2348f7e279eeded06cc2ca736d0e879858b501'), /44/ null]`, diag)
+
+	// Offsets are comments and indented notation parses back.
+	for _, opts := range [][]cbordiag.Option{
+		{cbordiag.WithIndent("\t"), cbordiag.WithOffsets()},
+		{cbordiag.WithRawLinks()},
+	} {
+		diag, err = cbordiag.Format(bin, opts...)
+		require.NoError(t, err)
+		assert.Equal(t, bin, cbordiag.MustParse(diag), diag)
+	}
+
+	diag, err = cbordiag.Format(cbordiag.MustParse(`{"a": [], "b": {_ }}`), cbordiag.WithIndent("  "))
+	require.NoError(t, err)
+	assert.Equal(t, "{\n  \"a\": [],\n  \"b\": {_}\n}", diag)
+}
+
+func TestFormatErrors(t *testing.T) {
+	// What could be read is returned along with the error.
+	diag, err := cbordiag.Format([]byte{0x83, 0x01, 0x62, 'a'})
+	assert.Equal(t, "[1, ", diag)
+	assert.True(t, errors.Is(err, marsha.ErrInvalidCBOR))
+
+	diag, err = cbordiag.Format([]byte{0x01, 0x02})
+	assert.Equal(t, "1", diag)
+	var serr *marsha.StrictError
+	require.True(t, errors.As(err, &serr))
+	assert.Equal(t, marsha.ErrTrailingData, serr.Err)
+	assert.Equal(t, 1, serr.Offset)
+
+	diag, err = cbordiag.Format([]byte{0x62, 'a', 0xff})
+	assert.NoError(t, err)
+	assert.Equal(t, `"a�" /invalid UTF-8: h'61ff'/`, diag)
+}
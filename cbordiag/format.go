@@ -0,0 +1,323 @@
+// Package cbordiag converts between CBOR and its diagnostic notation (RFC 8949 section 8 and the
+// extended notation of RFC 8610 appendix G), to inspect raw CBOR and to write readable fixtures.
+//
+// IPLD links, byte strings tagged 42, are shown as tag 42 of the text form of their CID, such as
+// `42("bafy...")`, which Parse turns back into links. Encoding indicators show how items were
+// encoded when it isn't the preferred encoding, such as `1_0` for the integer 1 with a 1-byte
+// argument or `[_ 1, 2]` for an indefinite-length array, so that Parse reproduces the original
+// bytes of any well-formed CBOR, except for the payloads of NaNs and invalid UTF-8 in text
+// strings.
+package cbordiag
+
+import (
+	"encoding/hex"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/daotl/go-marsha"
+	"github.com/daotl/go-marsha/internal/cbor"
+)
+
+type options struct {
+	indent   string
+	offsets  bool
+	rawLinks bool
+}
+
+// Option configures Format.
+type Option func(*options)
+
+// WithIndent puts each element of arrays and maps on its own line, indented by `indent` per
+// nesting level.
+func WithIndent(indent string) Option {
+	return func(o *options) {
+		o.indent = indent
+	}
+}
+
+// WithOffsets precedes each item with its byte offset in a comment, such as `/12/ "abc"`.
+func WithOffsets() Option {
+	return func(o *options) {
+		o.offsets = true
+	}
+}
+
+// WithRawLinks shows IPLD links as tagged byte strings rather than text CIDs.
+func WithRawLinks() Option {
+	return func(o *options) {
+		o.rawLinks = true
+	}
+}
+
+// Format returns the diagnostic notation of the single CBOR data item `b`. If `b` is malformed
+// or has trailing data, it returns the notation of what could be read along with the error,
+// which is a *marsha.StrictError wrapping marsha.ErrTrailingData for trailing data.
+func Format(b []byte, opts ...Option) (string, error) {
+	p := &printer{b: b}
+	for _, opt := range opts {
+		opt(&p.opts)
+	}
+	end, err := p.item(0, 0)
+	if err == nil && end != len(b) {
+		err = &marsha.StrictError{Offset: end, Err: marsha.ErrTrailingData}
+	}
+	return p.sb.String(), err
+}
+
+type printer struct {
+	b    []byte
+	opts options
+	sb   strings.Builder
+}
+
+func syntaxError(off int, msg string) error {
+	return &cbor.SyntaxError{Offset: off, Msg: msg}
+}
+
+// indicator returns the encoding indicator of the argument of `h`, if it isn't minimal.
+func indicator(h cbor.Head) string {
+	if h.Minimal() {
+		return ""
+	}
+	return "_" + strconv.Itoa(int(h.Info-24))
+}
+
+// item writes the item at `off` nested `depth` levels deep and returns the offset after it.
+func (p *printer) item(off, depth int) (int, error) {
+	if depth > cbor.MaxDepth {
+		return 0, syntaxError(off, fmt.Sprintf("nesting deeper than %d", cbor.MaxDepth))
+	}
+	h, err := cbor.ReadHead(p.b, off)
+	if err != nil {
+		return 0, err
+	}
+	if p.opts.offsets {
+		fmt.Fprintf(&p.sb, "/%d/ ", off)
+	}
+	switch h.Major {
+	case cbor.MajUnsignedInt:
+		p.sb.WriteString(strconv.FormatUint(h.Arg, 10) + indicator(h))
+		return h.End(), nil
+	case cbor.MajNegativeInt:
+		n := new(big.Int).SetUint64(h.Arg)
+		p.sb.WriteString(n.Neg(n.Add(n, big.NewInt(1))).String() + indicator(h))
+		return h.End(), nil
+	case cbor.MajByteString, cbor.MajTextString:
+		if h.Indefinite() {
+			return p.chunks(h)
+		}
+		return p.str(h)
+	case cbor.MajArray, cbor.MajMap:
+		return p.container(h, depth)
+	case cbor.MajTag:
+		if h.Arg == cbor.TagLink && !p.opts.rawLinks {
+			if c, end, err := cbor.ReadLink(p.b, h.End()); err == nil {
+				fmt.Fprintf(&p.sb, "42%s(%q)", indicator(h), c.String())
+				return end, nil
+			}
+		}
+		fmt.Fprintf(&p.sb, "%d%s(", h.Arg, indicator(h))
+		end, err := p.item(h.End(), depth+1)
+		if err != nil {
+			return 0, err
+		}
+		p.sb.WriteByte(')')
+		return end, nil
+	default:
+		p.other(h)
+		return h.End(), nil
+	}
+}
+
+// str writes the definite-length string of head `h`.
+func (p *printer) str(h cbor.Head) (int, error) {
+	end := h.End() + int(h.Arg)
+	if h.Arg > uint64(len(p.b)) || end > len(p.b) {
+		return 0, syntaxError(h.Offset, "unexpected end of input")
+	}
+	s := p.b[h.End():end]
+	if h.Major == cbor.MajByteString {
+		p.sb.WriteString("h'" + hex.EncodeToString(s) + "'" + indicator(h))
+		return end, nil
+	}
+	p.sb.WriteString(quote(s) + indicator(h))
+	if !utf8.Valid(s) {
+		fmt.Fprintf(&p.sb, " /invalid UTF-8: h'%x'/", s)
+	}
+	return end, nil
+}
+
+// quote returns the text string `s` in double quotes, escaping quotes, backslashes and control
+// characters like JSON and replacing invalid UTF-8 with U+FFFD.
+func quote(s []byte) string {
+	var sb strings.Builder
+	sb.WriteByte('"')
+	for len(s) > 0 {
+		r, n := utf8.DecodeRune(s)
+		s = s[n:]
+		switch r {
+		case '"', '\\':
+			sb.WriteByte('\\')
+			sb.WriteRune(r)
+		case '\n':
+			sb.WriteString(`\n`)
+		case '\r':
+			sb.WriteString(`\r`)
+		case '\t':
+			sb.WriteString(`\t`)
+		case '\b':
+			sb.WriteString(`\b`)
+		case '\f':
+			sb.WriteString(`\f`)
+		default:
+			if r < 0x20 || r == 0x7f {
+				fmt.Fprintf(&sb, `\u%04x`, r)
+			} else {
+				sb.WriteRune(r)
+			}
+		}
+	}
+	sb.WriteByte('"')
+	return sb.String()
+}
+
+// chunks writes the indefinite-length string of head `h` as `(_ chunk, ...)`.
+func (p *printer) chunks(h cbor.Head) (int, error) {
+	p.sb.WriteString("(_ ")
+	off := h.End()
+	for i := 0; ; i++ {
+		if off >= len(p.b) {
+			return 0, syntaxError(off, "unexpected end of input")
+		}
+		if p.b[off] == cbor.Break {
+			p.sb.WriteByte(')')
+			return off + 1, nil
+		}
+		c, err := cbor.ReadHead(p.b, off)
+		if err != nil {
+			return 0, err
+		}
+		if c.Major != h.Major || c.Indefinite() {
+			return 0, syntaxError(off, "invalid chunk of indefinite-length string")
+		}
+		if i > 0 {
+			p.sb.WriteString(", ")
+		}
+		if p.opts.offsets {
+			fmt.Fprintf(&p.sb, "/%d/ ", off)
+		}
+		if off, err = p.str(c); err != nil {
+			return 0, err
+		}
+	}
+}
+
+// container writes the array or map of head `h`.
+func (p *printer) container(h cbor.Head, depth int) (int, error) {
+	open, close := "[", "]"
+	if h.Major == cbor.MajMap {
+		open, close = "{", "}"
+	}
+	p.sb.WriteString(open)
+	if ind := indicator(h); h.Indefinite() || ind != "" {
+		if h.Indefinite() {
+			ind = "_"
+		}
+		p.sb.WriteString(ind)
+		if p.opts.indent == "" {
+			p.sb.WriteByte(' ')
+		}
+	}
+	off := h.End()
+	var n uint64
+	for ; h.Indefinite() || n < h.Arg; n++ {
+		if h.Indefinite() {
+			if off >= len(p.b) {
+				return 0, syntaxError(off, "unexpected end of input")
+			}
+			if p.b[off] == cbor.Break {
+				off++
+				break
+			}
+		}
+		if n > 0 {
+			p.sb.WriteByte(',')
+			if p.opts.indent == "" {
+				p.sb.WriteByte(' ')
+			}
+		}
+		p.newline(depth + 1)
+		var err error
+		if off, err = p.item(off, depth+1); err != nil {
+			return 0, err
+		}
+		if h.Major == cbor.MajMap {
+			p.sb.WriteString(": ")
+			if off, err = p.item(off, depth+1); err != nil {
+				return 0, err
+			}
+		}
+	}
+	if n > 0 {
+		p.newline(depth)
+	}
+	p.sb.WriteString(close)
+	return off, nil
+}
+
+// newline starts a line indented `depth` times, if indenting.
+func (p *printer) newline(depth int) {
+	if p.opts.indent == "" {
+		return
+	}
+	p.sb.WriteByte('\n')
+	p.sb.WriteString(strings.Repeat(p.opts.indent, depth))
+}
+
+// other writes the float or simple value of head `h`.
+func (p *printer) other(h cbor.Head) {
+	if h.IsFloat() {
+		f := h.Float()
+		p.sb.WriteString(formatFloat(f))
+		// Indicate floats not in their shortest form.
+		if len(cbor.AppendFloat(nil, f)) != h.Size {
+			p.sb.WriteString("_" + strconv.Itoa(int(h.Info-24)))
+		}
+		return
+	}
+	switch h.Arg {
+	case 20:
+		p.sb.WriteString("false")
+	case 21:
+		p.sb.WriteString("true")
+	case 22:
+		p.sb.WriteString("null")
+	case 23:
+		p.sb.WriteString("undefined")
+	default:
+		fmt.Fprintf(&p.sb, "simple(%d)", h.Arg)
+	}
+}
+
+// formatFloat returns the shortest decimal form of `f`, always with a decimal point or an
+// exponent. Half and single precision floats are formatted as the doubles they widen to, since
+// the shortest decimal of a narrower float may parse back to a different double.
+func formatFloat(f float64) string {
+	switch {
+	case math.IsNaN(f):
+		return "NaN"
+	case math.IsInf(f, 1):
+		return "Infinity"
+	case math.IsInf(f, -1):
+		return "-Infinity"
+	}
+	s := strconv.FormatFloat(f, 'g', -1, 64)
+	if !strings.ContainsAny(s, ".e") {
+		s += ".0"
+	}
+	return s
+}
@@ -0,0 +1,591 @@
+package cbordiag
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"github.com/ipfs/go-cid"
+
+	"github.com/daotl/go-marsha/internal/cbor"
+)
+
+var ErrSyntax = errors.New("invalid diagnostic notation")
+
+// SyntaxError reports invalid diagnostic notation at a line and column, both starting at 1.
+type SyntaxError struct {
+	Line, Col int
+	Msg       string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("%s at %d:%d: %s", ErrSyntax, e.Line, e.Col, e.Msg)
+}
+
+func (e *SyntaxError) Unwrap() error { return ErrSyntax }
+
+// Parse returns the CBOR encoding of the single data item written in diagnostic notation in `s`.
+//
+// Items are encoded in the preferred encoding unless encoding indicators say otherwise: `_0` to
+// `_3` after integers, floats, strings, tag numbers and opening brackets for arguments of 1 to 8
+// bytes (half, single and double precision for floats), `[_ ...]` and `{_ ...}` for
+// indefinite-length arrays and maps and `(_ "a", "b")` for indefinite-length strings.
+//
+// Besides the notation of RFC 8949, Parse accepts the following extensions of RFC 8610
+// appendix G: byte strings in single quotes, `b64'...'` base64 byte strings, `<<item, ...>>` byte
+// strings holding encoded items, integers in hexadecimal, octal or binary such as `0x2a`,
+// comments between slashes such as `/ comment /` and `#` comments running to the end of the line.
+// The text form of a CID tagged 42, such as `42("bafy...")`, encodes an IPLD link.
+func Parse(s string) ([]byte, error) {
+	p := &parser{src: s}
+	b, err := p.item(nil, 0)
+	if err != nil {
+		return nil, err
+	}
+	if err := p.space(); err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.src) {
+		return nil, p.errorf("unexpected %q after the item", p.src[p.pos])
+	}
+	return b, nil
+}
+
+// MustParse is like Parse but panics on error, for fixtures.
+func MustParse(s string) []byte {
+	b, err := Parse(s)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+type parser struct {
+	src string
+	pos int
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	return p.errorAt(p.pos, format, args...)
+}
+
+func (p *parser) errorAt(pos int, format string, args ...interface{}) error {
+	line := 1 + strings.Count(p.src[:pos], "\n")
+	col := 1 + utf8.RuneCountInString(p.src[strings.LastIndexByte(p.src[:pos], '\n')+1:pos])
+	return &SyntaxError{Line: line, Col: col, Msg: fmt.Sprintf(format, args...)}
+}
+
+// space skips whitespace and comments.
+func (p *parser) space() error {
+	for p.pos < len(p.src) {
+		switch c := p.src[p.pos]; {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			p.pos++
+		case c == '/':
+			end := strings.IndexByte(p.src[p.pos+1:], '/')
+			if end < 0 {
+				return p.errorf("unterminated comment")
+			}
+			p.pos += end + 2
+		case c == '#':
+			end := strings.IndexByte(p.src[p.pos:], '\n')
+			if end < 0 {
+				end = len(p.src) - p.pos
+			}
+			p.pos += end
+		default:
+			return nil
+		}
+	}
+	return nil
+}
+
+func (p *parser) peek() byte {
+	if p.pos < len(p.src) {
+		return p.src[p.pos]
+	}
+	return 0
+}
+
+// consume skips whitespace and comments, and then `s` if it is next.
+func (p *parser) consume(s string) (bool, error) {
+	if err := p.space(); err != nil {
+		return false, err
+	}
+	if strings.HasPrefix(p.src[p.pos:], s) {
+		p.pos += len(s)
+		return true, nil
+	}
+	return false, nil
+}
+
+func (p *parser) expect(s string) error {
+	ok, err := p.consume(s)
+	if err == nil && !ok {
+		err = p.errorf("expected %q", s)
+	}
+	return err
+}
+
+// indicator reads an optional encoding indicator `_0` to `_3`, returning -1 if there is none.
+func (p *parser) indicator() int {
+	if p.pos+1 < len(p.src) && p.src[p.pos] == '_' && p.src[p.pos+1] >= '0' && p.src[p.pos+1] <= '3' {
+		p.pos += 2
+		return int(p.src[p.pos-1] - '0')
+	}
+	return -1
+}
+
+// appendHead appends a head with the argument `arg` encoded as the indicator `ind` says.
+func (p *parser) appendHead(b []byte, major byte, arg uint64, ind int) ([]byte, error) {
+	if ind < 0 {
+		return cbor.AppendHead(b, major, arg), nil
+	}
+	n := 1 << ind
+	if n < 8 && arg >= 1<<(8*n) {
+		return nil, p.errorf("%d doesn't fit in the %d-byte argument of encoding indicator _%d",
+			arg, n, ind)
+	}
+	b = append(b, major<<5|byte(24+ind))
+	for i := n - 1; i >= 0; i-- {
+		b = append(b, byte(arg>>(8*i)))
+	}
+	return b, nil
+}
+
+// item appends the encoding of the item next in the input to `b`.
+func (p *parser) item(b []byte, depth int) ([]byte, error) {
+	if depth > cbor.MaxDepth {
+		return nil, p.errorf("nesting deeper than %d", cbor.MaxDepth)
+	}
+	if err := p.space(); err != nil {
+		return nil, err
+	}
+	start := p.pos
+	switch c := p.peek(); {
+	case c == '[' || c == '{':
+		return p.container(b, depth)
+	case c == '(':
+		return p.chunks(b)
+	case c == '"' || c == '\'' || strings.HasPrefix(p.src[p.pos:], "h'") ||
+		strings.HasPrefix(p.src[p.pos:], "b64'") || strings.HasPrefix(p.src[p.pos:], "<<"):
+		major, s, err := p.str(depth)
+		if err != nil {
+			return nil, err
+		}
+		b, err = p.appendHead(b, major, uint64(len(s)), p.indicator())
+		return append(b, s...), err
+	case c == '-' || c == '+' || c >= '0' && c <= '9' || strings.HasPrefix(p.src[p.pos:], "NaN") ||
+		strings.HasPrefix(p.src[p.pos:], "Infinity"):
+		return p.number(b, depth)
+	}
+	word := p.word()
+	switch word {
+	case "false":
+		return append(b, cbor.MajOther<<5|20), nil
+	case "true":
+		return append(b, cbor.MajOther<<5|21), nil
+	case "null":
+		return append(b, cbor.MajOther<<5|22), nil
+	case "undefined":
+		return append(b, cbor.MajOther<<5|23), nil
+	case "simple":
+		if err := p.expect("("); err != nil {
+			return nil, err
+		}
+		if err := p.space(); err != nil {
+			return nil, err
+		}
+		numStart := p.pos
+		n, err := strconv.ParseUint(p.word(), 10, 8)
+		if err != nil || n >= 24 && n < 32 {
+			return nil, p.errorAt(numStart, "invalid simple value")
+		}
+		if err := p.expect(")"); err != nil {
+			return nil, err
+		}
+		return cbor.AppendHead(b, cbor.MajOther, n), nil
+	case "":
+		if p.pos == len(p.src) {
+			return nil, p.errorf("unexpected end of input")
+		}
+		return nil, p.errorf("unexpected %q", p.src[p.pos])
+	}
+	return nil, p.errorAt(start, "unknown word %q", word)
+}
+
+// word reads the letters, digits, dots and exponent signs next in the input.
+func (p *parser) word() string {
+	start := p.pos
+	for p.pos < len(p.src) {
+		c := p.src[p.pos]
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '.' ||
+			c == '+' || c == '-') {
+			break
+		}
+		// Signs only follow exponents.
+		if (c == '+' || c == '-') && p.pos > start && p.src[p.pos-1] != 'e' && p.src[p.pos-1] != 'E' {
+			break
+		}
+		p.pos++
+	}
+	return p.src[start:p.pos]
+}
+
+// number appends an integer, a float or a tag.
+func (p *parser) number(b []byte, depth int) ([]byte, error) {
+	start := p.pos
+	lit := p.word()
+	ind := p.indicator()
+	neg := strings.HasPrefix(lit, "-")
+	digits := strings.TrimLeft(lit, "+-")
+
+	isFloat := digits == "NaN" || digits == "Infinity" ||
+		!strings.HasPrefix(digits, "0x") && strings.ContainsAny(digits, ".eE")
+	if isFloat {
+		f, err := strconv.ParseFloat(lit, 64)
+		if err != nil && !errors.Is(err, strconv.ErrRange) {
+			return nil, p.errorAt(start, "invalid number %q", lit)
+		}
+		return p.appendFloat(b, f, ind, start)
+	}
+
+	n, ok := new(big.Int).SetString(digits, 0)
+	if !ok {
+		return nil, p.errorAt(start, "invalid number %q", lit)
+	}
+	major := byte(cbor.MajUnsignedInt)
+	if neg {
+		// -1-n is encoded as n.
+		major = cbor.MajNegativeInt
+		n.Sub(n, big.NewInt(1))
+		if n.Sign() < 0 {
+			// -0 is 0.
+			n.SetInt64(0)
+			major = cbor.MajUnsignedInt
+		}
+	}
+	if !n.IsUint64() {
+		return nil, p.errorAt(start, "integer %s out of range, use a bignum such as 2(h'...')", lit)
+	}
+	if ok, err := p.consume("("); err != nil {
+		return nil, err
+	} else if ok {
+		if neg {
+			return nil, p.errorAt(start, "negative tag number")
+		}
+		return p.tag(b, n.Uint64(), ind, depth)
+	}
+	return p.appendHead(b, major, n.Uint64(), ind)
+}
+
+// appendFloat appends `f` in the width of the indicator `ind`, or in the shortest width
+// preserving its value.
+func (p *parser) appendFloat(b []byte, f float64, ind, start int) ([]byte, error) {
+	switch ind {
+	case -1:
+		return cbor.AppendFloat(b, f), nil
+	case 1:
+		enc := cbor.AppendFloat(nil, f)
+		if len(enc) != 3 {
+			return nil, p.errorAt(start, "%v is not a half-precision float", f)
+		}
+		return append(b, enc...), nil
+	case 2:
+		if f32 := float32(f); float64(f32) != f && !math.IsNaN(f) {
+			return nil, p.errorAt(start, "%v is not a single-precision float", f)
+		}
+		var buf [5]byte
+		buf[0] = cbor.MajOther<<5 | 26
+		binary.BigEndian.PutUint32(buf[1:], math.Float32bits(float32(f)))
+		return append(b, buf[:]...), nil
+	case 3:
+		var buf [9]byte
+		buf[0] = cbor.MajOther<<5 | 27
+		binary.BigEndian.PutUint64(buf[1:], math.Float64bits(f))
+		return append(b, buf[:]...), nil
+	}
+	return nil, p.errorAt(start, "invalid encoding indicator _0 for a float")
+}
+
+// tag appends tag `n` of the item in parentheses, whose opening parenthesis was read. The text
+// form of a CID in tag 42 becomes a link.
+func (p *parser) tag(b []byte, n uint64, ind, depth int) ([]byte, error) {
+	b, err := p.appendHead(b, cbor.MajTag, n, ind)
+	if err != nil {
+		return nil, err
+	}
+	if err := p.space(); err != nil {
+		return nil, err
+	}
+	if n == cbor.TagLink && p.peek() == '"' {
+		start := p.pos
+		_, s, err := p.str(depth)
+		if err != nil {
+			return nil, err
+		}
+		c, err := cid.Decode(string(s))
+		if err != nil {
+			return nil, p.errorAt(start, "invalid CID: %v", err)
+		}
+		link := append([]byte{0}, c.Bytes()...)
+		b = cbor.AppendHead(b, cbor.MajByteString, uint64(len(link)))
+		b = append(b, link...)
+	} else if b, err = p.item(b, depth+1); err != nil {
+		return nil, err
+	}
+	return b, p.expect(")")
+}
+
+// container appends an array or a map.
+func (p *parser) container(b []byte, depth int) ([]byte, error) {
+	open := p.src[p.pos]
+	p.pos++
+	major, close := byte(cbor.MajArray), "]"
+	if open == '{' {
+		major, close = cbor.MajMap, "}"
+	}
+	indefinite, ind := false, -1
+	if p.peek() == '_' {
+		if ind = p.indicator(); ind < 0 {
+			indefinite = true
+			p.pos++
+		}
+	}
+
+	var items []byte
+	var n uint64
+	for {
+		if ok, err := p.consume(close); err != nil {
+			return nil, err
+		} else if ok {
+			break
+		}
+		if n > 0 {
+			if err := p.expect(","); err != nil {
+				return nil, err
+			}
+			// Allow a trailing comma.
+			if ok, err := p.consume(close); err != nil {
+				return nil, err
+			} else if ok {
+				break
+			}
+		}
+		var err error
+		if items, err = p.item(items, depth+1); err != nil {
+			return nil, err
+		}
+		if major == cbor.MajMap {
+			if err := p.expect(":"); err != nil {
+				return nil, err
+			}
+			if items, err = p.item(items, depth+1); err != nil {
+				return nil, err
+			}
+		}
+		n++
+	}
+	if indefinite {
+		b = append(b, major<<5|cbor.InfoIndefinite)
+		return append(append(b, items...), cbor.Break), nil
+	}
+	b, err := p.appendHead(b, major, n, ind)
+	return append(b, items...), err
+}
+
+// chunks appends an indefinite-length string `(_ chunk, ...)`.
+func (p *parser) chunks(b []byte) ([]byte, error) {
+	start := p.pos
+	p.pos++
+	if p.peek() != '_' {
+		return nil, p.errorAt(start, `expected "(_" starting an indefinite-length string`)
+	}
+	p.pos++
+	var major byte
+	var chunks []byte
+	for n := 0; ; n++ {
+		if ok, err := p.consume(")"); err != nil {
+			return nil, err
+		} else if ok {
+			break
+		}
+		if n > 0 {
+			if err := p.expect(","); err != nil {
+				return nil, err
+			}
+		}
+		if err := p.space(); err != nil {
+			return nil, err
+		}
+		chunkStart := p.pos
+		m, s, err := p.str(0)
+		if err != nil {
+			return nil, err
+		}
+		if n > 0 && m != major {
+			return nil, p.errorAt(chunkStart, "chunks of different string types")
+		}
+		major = m
+		if chunks, err = p.appendHead(chunks, m, uint64(len(s)), p.indicator()); err != nil {
+			return nil, err
+		}
+		chunks = append(chunks, s...)
+	}
+	if len(chunks) == 0 {
+		// Empty indefinite-length strings are byte strings, "" chunks aside.
+		major = cbor.MajByteString
+	}
+	b = append(b, major<<5|cbor.InfoIndefinite)
+	return append(append(b, chunks...), cbor.Break), nil
+}
+
+// str reads a text or byte string, returning its major type and content.
+func (p *parser) str(depth int) (byte, []byte, error) {
+	start := p.pos
+	switch {
+	case p.peek() == '"':
+		s, err := p.quoted('"')
+		return cbor.MajTextString, s, err
+	case p.peek() == '\'':
+		s, err := p.quoted('\'')
+		return cbor.MajByteString, s, err
+	case strings.HasPrefix(p.src[p.pos:], "h'"):
+		p.pos += 2
+		end := strings.IndexByte(p.src[p.pos:], '\'')
+		if end < 0 {
+			return 0, nil, p.errorAt(start, "unterminated byte string")
+		}
+		digits := strings.Map(func(r rune) rune {
+			if r == ' ' || r == '\t' || r == '\n' || r == '\r' {
+				return -1
+			}
+			return r
+		}, p.src[p.pos:p.pos+end])
+		p.pos += end + 1
+		s, err := hex.DecodeString(digits)
+		if err != nil {
+			return 0, nil, p.errorAt(start, "invalid hex: %v", err)
+		}
+		return cbor.MajByteString, s, nil
+	case strings.HasPrefix(p.src[p.pos:], "b64'"):
+		p.pos += 4
+		end := strings.IndexByte(p.src[p.pos:], '\'')
+		if end < 0 {
+			return 0, nil, p.errorAt(start, "unterminated byte string")
+		}
+		text := strings.TrimRight(p.src[p.pos:p.pos+end], "=")
+		p.pos += end + 1
+		enc := base64.RawStdEncoding
+		if strings.ContainsAny(text, "-_") {
+			enc = base64.RawURLEncoding
+		}
+		s, err := enc.DecodeString(text)
+		if err != nil {
+			return 0, nil, p.errorAt(start, "invalid base64: %v", err)
+		}
+		return cbor.MajByteString, s, nil
+	case strings.HasPrefix(p.src[p.pos:], "<<"):
+		p.pos += 2
+		var s []byte
+		for n := 0; ; n++ {
+			if ok, err := p.consume(">>"); err != nil {
+				return 0, nil, err
+			} else if ok {
+				return cbor.MajByteString, s, nil
+			}
+			if n > 0 {
+				if err := p.expect(","); err != nil {
+					return 0, nil, err
+				}
+			}
+			var err error
+			if s, err = p.item(s, depth+1); err != nil {
+				return 0, nil, err
+			}
+		}
+	}
+	return 0, nil, p.errorf("expected a string")
+}
+
+// quoted reads a string in `quote`s with JSON escapes.
+func (p *parser) quoted(quote byte) ([]byte, error) {
+	start := p.pos
+	p.pos++
+	var s []byte
+	for {
+		if p.pos >= len(p.src) {
+			return nil, p.errorAt(start, "unterminated string")
+		}
+		c := p.src[p.pos]
+		p.pos++
+		if c == quote {
+			return s, nil
+		}
+		if c != '\\' {
+			s = append(s, c)
+			continue
+		}
+		if p.pos >= len(p.src) {
+			return nil, p.errorAt(start, "unterminated string")
+		}
+		e := p.src[p.pos]
+		p.pos++
+		switch e {
+		case '"', '\'', '\\', '/':
+			s = append(s, e)
+		case 'n':
+			s = append(s, '\n')
+		case 'r':
+			s = append(s, '\r')
+		case 't':
+			s = append(s, '\t')
+		case 'b':
+			s = append(s, '\b')
+		case 'f':
+			s = append(s, '\f')
+		case 'u':
+			r, err := p.hex4()
+			if err != nil {
+				return nil, err
+			}
+			if utf16.IsSurrogate(r) {
+				if !strings.HasPrefix(p.src[p.pos:], `\u`) {
+					return nil, p.errorf("unpaired surrogate")
+				}
+				p.pos += 2
+				r2, err := p.hex4()
+				if err != nil {
+					return nil, err
+				}
+				if r = utf16.DecodeRune(r, r2); r == utf8.RuneError {
+					return nil, p.errorf("invalid surrogate pair")
+				}
+			}
+			var buf [utf8.UTFMax]byte
+			s = append(s, buf[:utf8.EncodeRune(buf[:], r)]...)
+		default:
+			return nil, p.errorAt(p.pos-2, "invalid escape \\%c", e)
+		}
+	}
+}
+
+func (p *parser) hex4() (rune, error) {
+	if p.pos+4 > len(p.src) {
+		return 0, p.errorf("invalid \\u escape")
+	}
+	n, err := strconv.ParseUint(p.src[p.pos:p.pos+4], 16, 16)
+	if err != nil {
+		return 0, p.errorf("invalid \\u escape")
+	}
+	p.pos += 4
+	return rune(n), nil
+}
@@ -0,0 +1,159 @@
+package cborgen
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sync"
+
+	cbg "github.com/daotl/cbor-gen"
+	"github.com/ipfs/go-cid"
+
+	"github.com/daotl/go-marsha/internal/cbor"
+)
+
+// LazyStructPtr is implemented by the lazy structs generated by package `lazygen`, which
+// UnmarshalStruct and DecodeStruct only index: the fields of the tuple are located by skipping
+// them by their lengths and decoded by the generated accessors on first access, which cuts the
+// cost of unmarshaling records of which few fields are read.
+//
+// The indexed struct keeps the bytes it was unmarshaled from, so they must not be modified while
+// it is used. Unknown fields are handled as by other structs, except that UnknownPreserve drops
+// them like UnknownIgnore.
+type LazyStructPtr interface {
+	StructPtr
+
+	// IndexCBOR indexes the fields of the struct encoded at the start of `bin`, keeping `bin`,
+	// and returns the count of bytes read.
+	IndexCBOR(bin []byte) (int, error)
+
+	// Model returns a pointer to a new struct of the type the lazy struct is generated from.
+	Model() interface{}
+}
+
+// Lazy holds the encoding of a struct in the tuple layout, the offsets of its fields and the
+// fields decoded so far. It is embedded in lazy structs and used by their generated methods.
+//
+// The zero Lazy has no encoding, and the accessors of a lazy struct return the zero values of
+// its fields. Copies of a Lazy share the decoded fields, which the accessors decode once even if
+// called concurrently, but unmarshaling into a Lazy must not be concurrent with using it.
+type Lazy struct {
+	s *lazyState
+}
+
+type lazyState struct {
+	raw    []byte
+	offs   []int
+	fields []lazyField
+}
+
+// lazyField is a field decoded on first access.
+type lazyField struct {
+	once sync.Once
+	v    interface{}
+	err  error
+}
+
+// Raw returns the encoding the struct was unmarshaled from, or nil.
+func (l *Lazy) Raw() []byte {
+	if l.s == nil {
+		return nil
+	}
+	return l.s.raw
+}
+
+// Index indexes the `n` fields of the tuple at the start of `bin` and returns the count of bytes
+// read.
+func (l *Lazy) Index(bin []byte, n int) (int, error) {
+	h, err := cbor.ReadHead(bin, 0)
+	if err != nil {
+		return 0, err
+	}
+	if h.Major != cbor.MajArray {
+		return 0, fmt.Errorf("cbor input should be of type array")
+	}
+	if h.Indefinite() || h.Arg != uint64(n) {
+		return 0, fmt.Errorf("cbor input had wrong number of fields")
+	}
+	offs := make([]int, n+1)
+	offs[0] = h.End()
+	for i := 0; i < n; i++ {
+		if offs[i+1], err = cbor.Skip(bin, offs[i]); err != nil {
+			return 0, err
+		}
+	}
+	l.s = &lazyState{raw: bin[:offs[n]], offs: offs, fields: make([]lazyField, n)}
+	return offs[n], nil
+}
+
+// ReadCBOR reads the tuple of `n` fields from `r` and indexes it.
+func (l *Lazy) ReadCBOR(r io.Reader, n int) (int, error) {
+	item, err := cbor.ReadItem(r)
+	if err != nil {
+		return 0, err
+	}
+	return l.Index(item, n)
+}
+
+// Field returns field `i` decoded from its encoding by `decode` on first access, or nil if the
+// struct has no encoding. The result of `decode`, including its error, is kept for later accesses.
+func (l *Lazy) Field(i int, decode func(b []byte) (interface{}, error)) (interface{}, error) {
+	if l.s == nil {
+		return nil, nil
+	}
+	f := &l.s.fields[i]
+	f.once.Do(func() {
+		f.v, f.err = decode(l.s.raw[l.s.offs[i]:l.s.offs[i+1]])
+	})
+	return f.v, f.err
+}
+
+// IsNull returns whether the encoded field `b` is null.
+func IsNull(b []byte) bool {
+	return len(b) == 1 && b[0] == cbg.CborNull[0]
+}
+
+// DecodeBool decodes the encoded field `b` as a bool.
+func DecodeBool(b []byte) (bool, error) {
+	v, _, err := readBool(b, 0)
+	return v, err
+}
+
+// DecodeInt64 decodes the encoded field `b` as an int64.
+func DecodeInt64(b []byte) (int64, error) {
+	v, _, err := readInt64(b, 0)
+	return v, err
+}
+
+// DecodeUint64 decodes the encoded field `b` as a uint64.
+func DecodeUint64(b []byte) (uint64, error) {
+	v, _, err := readUint64(b, 0)
+	return v, err
+}
+
+// DecodeString decodes the encoded field `b` as a string.
+func DecodeString(b []byte) (string, error) {
+	s, _, err := readDefinite(b, 0, cbor.MajTextString)
+	return string(s), err
+}
+
+// DecodeBytes decodes the encoded field `b` as a byte slice, copying it.
+func DecodeBytes(b []byte) ([]byte, error) {
+	s, _, err := readDefinite(b, 0, cbor.MajByteString)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(nil), s...), nil
+}
+
+// DecodeCid decodes the encoded field `b` as a CID.
+func DecodeCid(b []byte) (cid.Cid, error) {
+	c, _, err := readCid(b, 0)
+	return c, err
+}
+
+// DecodeStruct decodes the encoded field `b` into the struct `p` points to.
+func DecodeStruct(b []byte, p cbg.CBORUnmarshaler) error {
+	_, err := p.UnmarshalCBOR(bytes.NewReader(b))
+	return err
+}
@@ -0,0 +1,238 @@
+// Package lazygen generates lazy structs of Go models for the `cborgen` implementation, next to
+// the encoders generated by `cbg.WriteTupleEncodersToFile`: for a model `Order`, `LazyOrder`
+// keeps the encoding it is unmarshaled from, and its accessors, such as `Name() (string, error)`,
+// decode single fields on first access. See cborgen.LazyStructPtr.
+package lazygen
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"io"
+	"os"
+	"reflect"
+
+	"github.com/daotl/go-marsha/internal/fields"
+	"github.com/daotl/go-marsha/internal/model"
+)
+
+var (
+	ErrNotStruct   = model.ErrNotStruct
+	ErrUnsupported = model.ErrUnsupported
+)
+
+// reserved are the names of the methods of lazy structs other than the accessors.
+var reserved = map[string]bool{
+	"Ptr": true, "Val": true, "IndexCBOR": true, "Model": true, "Struct": true,
+	"MarshalCBOR": true, "UnmarshalCBOR": true,
+	"Raw": true, "Index": true, "ReadCBOR": true, "Field": true,
+}
+
+// Generator generates the lazy structs of Go models of a package.
+type Generator struct {
+	pkg   string
+	buf   bytes.Buffer
+	names *model.Namer
+	cid   bool // Whether the cid package is imported
+}
+
+// NewGenerator creates a Generator of lazy structs in the package `pkg`, which is the package of
+// the models.
+func NewGenerator(pkg string) *Generator {
+	return &Generator{pkg: pkg, names: model.NewNamer(nil)}
+}
+
+// Add generates the lazy structs of the models, which are struct values or pointers to them.
+// Fields can be booleans, integers, strings, byte slices, CIDs, pointers to CIDs, and structs of
+// the same package, or pointers to them, with code generated by cbor-gen.
+func (g *Generator) Add(models ...interface{}) error {
+	for _, m := range models {
+		t, err := model.Struct(m)
+		if err != nil {
+			return err
+		}
+		if _, ok := g.names.Lookup(t); ok {
+			continue
+		}
+		fs, ok := fields.CBORGen.Fields(t)
+		if !ok {
+			return fmt.Errorf("%w: %s has no code generated by cbor-gen", ErrNotStruct, t)
+		}
+		if _, err := g.names.Name(t); err != nil {
+			return err
+		}
+		if err := g.add(t, fs); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (g *Generator) add(t reflect.Type, fs []fields.Field) error {
+	name := t.Name()
+	lazy := "Lazy" + name
+	var accessors bytes.Buffer
+	fmt.Fprintf(&g.buf, `
+// %[1]s is a %[2]s unmarshaled by indexing its fields, decoded on first access.
+type %[1]s struct {
+	cborgen.Lazy
+}`, lazy, name)
+	for i, f := range fs {
+		sf := t.FieldByIndex(f.Index)
+		if reserved[sf.Name] {
+			return fmt.Errorf("%w: field %s.%s has the name of a method", ErrUnsupported, name, sf.Name)
+		}
+		typ, decode, err := g.decoder(t, sf.Type)
+		if err != nil {
+			return fmt.Errorf("field %s.%s: %w", name, sf.Name, err)
+		}
+		fmt.Fprintf(&accessors, `
+// %[2]s returns field %[2]s, decoding it on first access.
+func (t *%[1]s) %[2]s() (%[3]s, error) {
+	f, err := t.Field(%[4]d, func(b []byte) (interface{}, error) {
+%[5]s		if err != nil {
+			return nil, err
+		}
+		return v, nil
+	})
+	v, _ := f.(%[3]s)
+	return v, err
+}
+`, lazy, sf.Name, typ, i, decode)
+	}
+	fmt.Fprintf(&g.buf, `
+
+func (t %[1]s) Ptr() marsha.StructPtr { return &t }
+func (t *%[1]s) Val() marsha.Struct   { return *t }
+
+// IndexCBOR implements cborgen.LazyStructPtr.
+func (t *%[1]s) IndexCBOR(bin []byte) (int, error) {
+	*t = %[1]s{}
+	return t.Index(bin, %[3]d)
+}
+
+// Model implements cborgen.LazyStructPtr.
+func (t *%[1]s) Model() interface{} { return &%[2]s{} }
+
+// Struct decodes all the fields into a %[2]s.
+func (t *%[1]s) Struct() (*%[2]s, error) {
+	s := &%[2]s{}
+	if t.Raw() == nil {
+		return s, nil
+	}
+	if _, err := s.UnmarshalCBOR(bytes.NewReader(t.Raw())); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// MarshalCBOR writes the encoding the struct was unmarshaled from.
+func (t *%[1]s) MarshalCBOR(w io.Writer) (int, error) {
+	if t.Raw() == nil {
+		return (&%[2]s{}).MarshalCBOR(w)
+	}
+	return w.Write(t.Raw())
+}
+
+// UnmarshalCBOR reads the struct from r and indexes it.
+func (t *%[1]s) UnmarshalCBOR(r io.Reader) (int, error) {
+	*t = %[1]s{}
+	return t.ReadCBOR(r, %[3]d)
+}
+`, lazy, name, len(fs))
+	accessors.WriteTo(&g.buf)
+	return nil
+}
+
+// decoder returns the Go type of a field of type `ft` of the struct type `t` in generated code,
+// and the statements decoding it from `b` into `v` and `err`.
+func (g *Generator) decoder(t, ft reflect.Type) (string, string, error) {
+	typ := ft.String()
+	if ft.PkgPath() == t.PkgPath() {
+		typ = ft.Name()
+	}
+	conv := func(fn string) string {
+		if ft.Name() == ft.Kind().String() {
+			return fmt.Sprintf("\t\tv, err := cborgen.%s(b)\n", fn)
+		}
+		return fmt.Sprintf("\t\tu, err := cborgen.%s(b)\n\t\tv := %s(u)\n", fn, typ)
+	}
+	switch {
+	case ft == model.CidType:
+		g.cid = true
+		return typ, "\t\tv, err := cborgen.DecodeCid(b)\n", nil
+	case ft.Kind() == reflect.Ptr && ft.Elem() == model.CidType:
+		g.cid = true
+		return typ, `		var v *cid.Cid
+		var err error
+		if !cborgen.IsNull(b) {
+			var c cid.Cid
+			c, err = cborgen.DecodeCid(b)
+			v = &c
+		}
+`, nil
+	case ft.Kind() == reflect.Bool:
+		return typ, conv("DecodeBool"), nil
+	case ft.Kind() >= reflect.Int && ft.Kind() <= reflect.Int64:
+		return typ, conv("DecodeInt64"), nil
+	case ft.Kind() >= reflect.Uint && ft.Kind() <= reflect.Uint64:
+		return typ, conv("DecodeUint64"), nil
+	case ft.Kind() == reflect.String:
+		return typ, conv("DecodeString"), nil
+	case ft.Kind() == reflect.Slice && ft.Elem().Kind() == reflect.Uint8 && ft.Name() == "":
+		return typ, "\t\tv, err := cborgen.DecodeBytes(b)\n", nil
+	}
+
+	st := ft
+	if st.Kind() == reflect.Ptr {
+		st = st.Elem()
+	}
+	if st.Kind() != reflect.Struct || st.PkgPath() != t.PkgPath() {
+		return "", "", fmt.Errorf("%w: %s", ErrUnsupported, ft)
+	}
+	if _, ok := fields.CBORGen.Fields(st); !ok {
+		return "", "", fmt.Errorf("%w: %s has no code generated by cbor-gen", ErrNotStruct, ft)
+	}
+	if ft.Kind() == reflect.Ptr {
+		return "*" + st.Name(), fmt.Sprintf(`		var v *%s
+		var err error
+		if !cborgen.IsNull(b) {
+			v = new(%[1]s)
+			err = cborgen.DecodeStruct(b, v)
+		}
+`, st.Name()), nil
+	}
+	return typ, fmt.Sprintf("\t\tvar v %s\n\t\terr := cborgen.DecodeStruct(b, &v)\n", typ), nil
+}
+
+// WriteTo writes the source file of the lazy structs generated so far to `w`.
+func (g *Generator) WriteTo(w io.Writer) (int64, error) {
+	var src bytes.Buffer
+	fmt.Fprintf(&src, "// Code generated by github.com/daotl/go-marsha/cborgen/lazygen. DO NOT EDIT.\n\npackage %s\n\nimport (\n", g.pkg)
+	src.WriteString("\t\"bytes\"\n\t\"io\"\n\n")
+	if g.cid {
+		src.WriteString("\t\"github.com/ipfs/go-cid\"\n\n")
+	}
+	src.WriteString("\t\"github.com/daotl/go-marsha\"\n\t\"github.com/daotl/go-marsha/cborgen\"\n)\n")
+	src.Write(g.buf.Bytes())
+	out, err := format.Source(src.Bytes())
+	if err != nil {
+		return 0, err
+	}
+	n, err := w.Write(out)
+	return int64(n), err
+}
+
+// WriteFile writes the source file of the lazy structs of `models` in the package `pkg` to the
+// file `path`.
+func WriteFile(path, pkg string, models ...interface{}) error {
+	g := NewGenerator(pkg)
+	if err := g.Add(models...); err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	if _, err := g.WriteTo(&buf); err != nil {
+		return err
+	}
+	return os.WriteFile(path, buf.Bytes(), 0o644)
+}
@@ -0,0 +1,50 @@
+package lazygen_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/daotl/go-marsha/cborgen/lazygen"
+	"github.com/daotl/go-marsha/test"
+)
+
+func TestModels(t *testing.T) {
+	want, err := os.ReadFile("../../test/models_lazy.go")
+	require.NoError(t, err)
+
+	g := lazygen.NewGenerator("test")
+	require.NoError(t, g.Add(test.TestStruct{}, test.TestStruct2{}, test.TestLinked{},
+		test.TestStructV2{}, test.TestChildV2{}, test.TestPerson{}))
+	var got bytes.Buffer
+	_, err = g.WriteTo(&got)
+	require.NoError(t, err)
+	assert.Equal(t, string(want), got.String(), "test/models_lazy.go is outdated, run go generate")
+}
+
+// Tags has code generated by cbor-gen, but a field of a type lazy structs don't support.
+type Tags struct {
+	Tags []string
+}
+
+func (t *Tags) UnmarshalCBOR(io.Reader) (int, error) { return 0, nil }
+
+// Reserved has a field with the name of a method of lazy structs.
+type Reserved struct {
+	Raw []byte
+}
+
+func (t *Reserved) UnmarshalCBOR(io.Reader) (int, error) { return 0, nil }
+
+func TestErrors(t *testing.T) {
+	g := lazygen.NewGenerator("test")
+	assert.True(t, errors.Is(g.Add(42), lazygen.ErrNotStruct))
+	assert.True(t, errors.Is(g.Add(struct{ A int }{}), lazygen.ErrNotStruct))
+	assert.True(t, errors.Is(g.Add(Tags{}), lazygen.ErrUnsupported))
+	assert.True(t, errors.Is(g.Add(&Reserved{}), lazygen.ErrUnsupported))
+}
@@ -7,11 +7,15 @@ import (
 	"bytes"
 	"errors"
 	"io"
+	"reflect"
 	"strings"
 	"sync"
 
 	cbg "github.com/daotl/cbor-gen"
 	"github.com/daotl/go-marsha"
+	"github.com/daotl/go-marsha/internal/cbor"
+	"github.com/daotl/go-marsha/internal/cborutil"
+	"github.com/daotl/go-marsha/internal/fields"
 	"github.com/daotl/go-marsha/internal/refmt"
 )
 
@@ -49,16 +53,36 @@ type StructSlicePtr interface {
 
 // Marsha is a fast Marsha implementation for CBOR backed by `go-ipld-cbor` package
 // and marshaling/unmarshaling code generated by github.com/daotl/cbor-gen package.
+//
+// A marsha.Union is marshaled as its value preceded by the CBOR tag or name registered for the
+// value's type in the marsha.Registry passed by marsha.WithRegistry, and *marsha.Unions can be
+// used as a heterogeneous struct slice.
+//
+// With marsha.WithDeterministic or marsha.WithStrict, output not already in core deterministic
+// encoding, such as maps with keys out of order, is re-encoded. With marsha.WithStrict, Decoder
+// reads each data item in full before unmarshaling it.
+//
+// With marsha.WithZeroCopy, UnmarshalStruct and UnmarshalStructSlice decode structs in the tuple
+// layout whose fields are booleans, integers, strings, byte slices, CIDs, structs with code
+// generated by cbor-gen, pointers to them or slices of these without the code generated by
+// cbor-gen, so that strings and byte slices share memory with the input. Structs with fields of
+// other types are decoded by their generated code and copy as usual.
 type Marsha struct {
 	refmt *refmt.Refmt
+	opts  marsha.Options
 }
 
-var _ marsha.Marsha = (*Marsha)(nil)
+var (
+	_ marsha.ValueMarsha  = (*Marsha)(nil)
+	_ marsha.ValueDecoder = (*Marsha)(nil)
+	_ marsha.AppendMarsha = (*Marsha)(nil)
+)
 
 // New creates a Marsha.
-func New() *Marsha {
+func New(opts ...marsha.Option) *Marsha {
 	return &Marsha{
 		refmt: refmt.New(),
+		opts:  marsha.NewOptions(opts...),
 	}
 }
 
@@ -68,67 +92,91 @@ func (m *Marsha) Register(i interface{}) {
 }
 
 func (m *Marsha) MarshalPrimitive(p interface{}) ([]byte, error) {
-	return m.refmt.Marshaller.Marshal(p)
+	bin, err := m.refmt.Marshaller.Marshal(p)
+	if err != nil {
+		return nil, err
+	}
+	return cborutil.Output(m.opts, bin, p, fields.CBORGen)
 }
 
 // This implementation does not support returning the count of bytes read.
 func (m *Marsha) UnmarshalPrimitive(bin []byte, p interface{}) (int, error) {
-	return -1, m.refmt.Unmarshaller.Unmarshal(bin, p)
+	in, done, err := cborutil.Input(m.opts, bin, p, fields.CBORGen)
+	if err != nil {
+		return 0, err
+	}
+	if err = m.refmt.Unmarshaller.Unmarshal(in, p); err != nil {
+		return -1, err
+	}
+	return done(-1), nil
 }
 
 func (m *Marsha) MarshalStruct(p marsha.StructPtr) ([]byte, error) {
-	cbp, ok := p.(StructPtr)
-	if !ok {
-		return nil, ErrNotCBORStructPtr
-	}
-	var buf bytes.Buffer
-	if _, err := cbp.MarshalCBOR(&buf); err != nil {
+	bin, err := m.MarshalStructAppend(nil, p)
+	if err != nil {
 		return nil, err
 	}
-	return buf.Bytes(), nil
+	return bin, nil
+}
+
+// MarshalStructAppend appends the encoding of the struct `p` points to to `dst`. The code
+// generated by cbor-gen allocates a scratch buffer per struct marshaled, nested ones included,
+// which are the only allocations if `dst` has enough capacity and the output isn't buffered.
+func (m *Marsha) MarshalStructAppend(dst []byte, p marsha.StructPtr) ([]byte, error) {
+	return appendStruct(m.opts, dst, p)
 }
 
 func (m *Marsha) UnmarshalStruct(bin []byte, p marsha.StructPtr) (int, error) {
-	cbp, ok := p.(StructPtr)
-	if !ok {
-		return 0, ErrNotCBORStructPtr
+	if lp, ok := p.(LazyStructPtr); ok {
+		return index(m.opts, bin, lp)
 	}
-	return unmarshal(bytes.NewReader(bin), cbp)
+	in, done, err := cborutil.Input(m.opts, bin, p, fields.CBORGen)
+	if err != nil {
+		return 0, err
+	}
+	read, err := m.unmarshalBytes(bytes.NewReader(in), in, p)
+	if err != nil {
+		return read, err
+	}
+	return done(read), nil
 }
 
-func (m *Marsha) MarshalStructSlice(p marsha.StructSlicePtr) (bin []byte, err error) {
-	cbp, ok := p.(StructSlicePtr)
-	if !ok {
-		return nil, ErrNotCBORStructSlicePtr
+func (m *Marsha) MarshalStructSlice(p marsha.StructSlicePtr) ([]byte, error) {
+	bin, err := m.MarshalStructSliceAppend(nil, p)
+	if err != nil {
+		return nil, err
 	}
+	return bin, nil
+}
 
-	ptrs := cbp.Val()
-	bins := make([][]byte, len(ptrs))
-	l := 0
-	for i, s := range ptrs {
-		if bins[i], err = m.MarshalStruct(s); err != nil {
-			return nil, err
-		}
-		l += len(bins[i])
+// MarshalStructSliceAppend appends the encoding of the struct slice `p` points to to `dst`.
+func (m *Marsha) MarshalStructSliceAppend(dst []byte, p marsha.StructSlicePtr) ([]byte, error) {
+	if _, _, err := sliceFuncs(p); err != nil {
+		return dst, err
 	}
-
-	binH := cbg.CborEncodeMajorType(cbg.MajArray, uint64(len(p.Val())))
-	bin = make([]byte, len(binH), len(binH)+l)
-	copy(bin, binH)
-	for _, b := range bins {
-		bin = append(bin, b...)
+	ptrs := p.Val()
+	b := cbor.AppendHead(dst, cbor.MajArray, uint64(len(ptrs)))
+	for _, s := range ptrs {
+		var err error
+		if b, err = m.MarshalStructAppend(b, s); err != nil {
+			return dst, err
+		}
 	}
-	return bin, nil
+	return b, nil
 }
 
 func (m *Marsha) UnmarshalStructSlice(bin []byte, p marsha.StructSlicePtr) (int, error) {
-	cbp, ok := p.(StructSlicePtr)
-	if !ok {
-		return 0, ErrNotCBORStructSlicePtr
+	newPtr, appendPtr, err := sliceFuncs(p)
+	if err != nil {
+		return 0, err
+	}
+	in, done, err := cborutil.Input(m.opts, bin, p, fields.CBORGen)
+	if err != nil {
+		return 0, err
 	}
 
 	bytesRead := 0
-	r := bytes.NewReader(bin)
+	r := bytes.NewReader(in)
 	if majorType, _, read, err := cbg.CborReadHeader(r); err != nil {
 		return read, err
 	} else if majorType != cbg.MajArray {
@@ -138,11 +186,8 @@ func (m *Marsha) UnmarshalStructSlice(bin []byte, p marsha.StructSlicePtr) (int,
 	}
 
 	for {
-		s, ok := cbp.NewStructPtr().(StructPtr)
-		if !ok {
-			return 0, ErrNotCBORStructPtr
-		}
-		if read, err := unmarshal(r, s); err != nil {
+		s := newPtr()
+		if read, err := m.unmarshalBytes(r, in, s); err != nil {
 			if err.Error() == "EOF" {
 				break
 			}
@@ -150,14 +195,35 @@ func (m *Marsha) UnmarshalStructSlice(bin []byte, p marsha.StructSlicePtr) (int,
 		} else {
 			bytesRead += read
 		}
-		cbp.Append(s)
+		appendPtr(s)
 	}
-	return bytesRead, nil
+	return done(bytesRead), nil
+}
+
+// DecodeValue decodes the data item at the start of `bin` into a Value tree without a Go type, and
+// returns it along with the count of bytes read. With marsha.WithStrict, `bin` must be exactly one
+// data item in core deterministic encoding.
+func (m *Marsha) DecodeValue(bin []byte) (marsha.Value, int, error) {
+	return cborutil.DecodeValue(m.opts, bin)
+}
+
+// EncodeValue encodes the Value tree `v` in core deterministic encoding.
+func (m *Marsha) EncodeValue(v marsha.Value) ([]byte, error) {
+	return marsha.EncodeCBORValue(v)
+}
+
+func (m *Marsha) StructToValue(p marsha.StructPtr) (marsha.Value, error) {
+	return cborutil.StructToValue(m, p)
+}
+
+func (m *Marsha) ValueToStruct(v marsha.Value, p marsha.StructPtr) error {
+	return cborutil.ValueToStruct(m, v, p)
 }
 
 func (m *Marsha) NewEncoder(w io.Writer) marsha.Encoder {
 	return &encoder{
 		refmt:         m.refmt,
+		opts:          m.opts,
 		w:             w,
 		cborHeaderBuf: make([]byte, maxCBORHeaderSize),
 	}
@@ -166,6 +232,7 @@ func (m *Marsha) NewEncoder(w io.Writer) marsha.Encoder {
 func (m *Marsha) NewDecoder(r io.Reader) marsha.Decoder {
 	return &decoder{
 		refmt: m.refmt,
+		opts:  m.opts,
 		r:     r,
 	}
 }
@@ -173,6 +240,7 @@ func (m *Marsha) NewDecoder(r io.Reader) marsha.Decoder {
 type encoder struct {
 	sync.Mutex    // each item must be sent atomically
 	refmt         *refmt.Refmt
+	opts          marsha.Options
 	w             io.Writer
 	cborHeaderBuf []byte
 }
@@ -185,25 +253,22 @@ func (e *encoder) EncodePrimitive(p interface{}) (int, error) {
 	//return e.refmt.Marshaller.Encode(p, e.w)
 	if bin, err := e.refmt.Marshaller.Marshal(p); err != nil {
 		return 0, err
+	} else if cborutil.Buffered(e.opts) {
+		return cborutil.WriteOutput(e.w, e.opts, bin, p, fields.CBORGen)
 	} else {
 		return len(bin), e.refmt.Marshaller.Encode(p, e.w)
 	}
 }
 
 func (e *encoder) EncodeStruct(p marsha.StructPtr) (int, error) {
-	cbp, ok := p.(StructPtr)
-	if !ok {
-		return 0, ErrNotCBORStructPtr
-	}
 	e.Lock()
 	defer e.Unlock()
-	return cbp.MarshalCBOR(e.w)
+	return e.marshal(p)
 }
 
 func (e *encoder) EncodeStructSlice(p marsha.StructSlicePtr) (n int, err error) {
-	cbp, ok := p.(StructSlicePtr)
-	if !ok {
-		return 0, ErrNotCBORStructSlicePtr
+	if _, _, err := sliceFuncs(p); err != nil {
+		return 0, err
 	}
 	e.Lock()
 	defer e.Unlock()
@@ -214,12 +279,8 @@ func (e *encoder) EncodeStructSlice(p marsha.StructSlicePtr) (n int, err error)
 		return n, err
 	}
 
-	for _, s := range cbp.Val() {
-		elem, ok := s.(StructPtr)
-		if !ok {
-			return n, ErrNotCBORStructPtr
-		}
-		n_, err := elem.MarshalCBOR(e.w)
+	for _, s := range p.Val() {
+		n_, err := e.marshal(s)
 		n += n_
 		if err != nil {
 			return n, err
@@ -229,9 +290,20 @@ func (e *encoder) EncodeStructSlice(p marsha.StructSlicePtr) (n int, err error)
 	return n, nil
 }
 
+// marshal writes `p`, buffering it if cborutil.Output may change it.
+func (e *encoder) marshal(p marsha.StructPtr) (int, error) {
+	if !cborutil.Buffered(e.opts) {
+		return marshal(e.w, p, e.opts.Registry)
+	}
+	return cborutil.WriteAppended(e.w, func(dst []byte) ([]byte, error) {
+		return appendStruct(e.opts, dst, p)
+	})
+}
+
 type decoder struct {
 	sync.Mutex // each item must be sent atomically
 	refmt      *refmt.Refmt
+	opts       marsha.Options
 	r          io.Reader
 }
 
@@ -241,29 +313,51 @@ func (d *decoder) DecodePrimitive(p interface{}) (int, error) {
 	// goroutines can share a decoder.
 	d.Lock()
 	defer d.Unlock()
-	return -1, d.refmt.Unmarshaller.Decode(d.r, p)
+	r, done, err := cborutil.Reader(d.opts, d.r, p, fields.CBORGen)
+	if err != nil {
+		return 0, err
+	}
+	if err = d.refmt.Unmarshaller.Decode(r, p); err != nil {
+		return -1, err
+	}
+	return done(-1), nil
 }
 
 func (d *decoder) DecodeStruct(p marsha.StructPtr) (int, error) {
-	cbp, ok := p.(StructPtr)
-	if !ok {
-		return 0, ErrNotCBORStructPtr
-	}
 	d.Lock()
 	defer d.Unlock()
-	return unmarshal(d.r, cbp)
+	if lp, ok := p.(LazyStructPtr); ok {
+		item, err := cbor.ReadItem(d.r)
+		if err != nil {
+			return 0, err
+		}
+		return index(d.opts, item, lp)
+	}
+	r, done, err := cborutil.Reader(d.opts, d.r, p, fields.CBORGen)
+	if err != nil {
+		return 0, err
+	}
+	read, err := unmarshal(r, p, d.opts.Registry)
+	if err != nil {
+		return read, err
+	}
+	return done(read), nil
 }
 
 func (d *decoder) DecodeStructSlice(p marsha.StructSlicePtr) (int, error) {
-	cbp, ok := p.(StructSlicePtr)
-	if !ok {
-		return 0, ErrNotCBORStructSlicePtr
+	newPtr, appendPtr, err := sliceFuncs(p)
+	if err != nil {
+		return 0, err
 	}
 	d.Lock()
 	defer d.Unlock()
+	r, done, err := cborutil.Reader(d.opts, d.r, p, fields.CBORGen)
+	if err != nil {
+		return 0, err
+	}
 
 	bytesRead := 0
-	if majorType, _, read, err := cbg.CborReadHeader(d.r); err != nil {
+	if majorType, _, read, err := cbg.CborReadHeader(r); err != nil {
 		return read, err
 	} else if majorType != cbg.MajArray {
 		return read, ErrNotCBORArrayBytes
@@ -272,11 +366,8 @@ func (d *decoder) DecodeStructSlice(p marsha.StructSlicePtr) (int, error) {
 	}
 
 	for {
-		s, ok := cbp.NewStructPtr().(StructPtr)
-		if !ok {
-			return 0, ErrNotCBORStructPtr
-		}
-		if read, err := unmarshal(d.r, s); err != nil {
+		s := newPtr()
+		if read, err := unmarshal(r, s, d.opts.Registry); err != nil {
 			if err.Error() == "EOF" {
 				break
 			}
@@ -284,13 +375,122 @@ func (d *decoder) DecodeStructSlice(p marsha.StructSlicePtr) (int, error) {
 		} else {
 			bytesRead += read
 		}
-		cbp.Append(s)
+		appendPtr(s)
+	}
+	return done(bytesRead), nil
+}
+
+// sliceFuncs returns functions creating and appending elements of the struct slice `p` points to,
+// which is either a StructSlicePtr or a *marsha.Unions.
+func sliceFuncs(p marsha.StructSlicePtr) (
+	newPtr func() marsha.StructPtr, appendPtr func(marsha.StructPtr), err error) {
+	switch sp := p.(type) {
+	case *marsha.Unions:
+		return sp.NewStructPtr, func(s marsha.StructPtr) { sp.Append(s.(*marsha.Union)) }, nil
+	case StructSlicePtr:
+		return sp.NewStructPtr, func(s marsha.StructPtr) { sp.Append(s.(StructPtr)) }, nil
+	default:
+		return nil, nil, ErrNotCBORStructSlicePtr
 	}
-	return bytesRead, nil
 }
 
-func unmarshal(r io.Reader, p StructPtr) (read int, err error) {
-	if read, err = p.UnmarshalCBOR(r); err != nil {
+func marshal(w io.Writer, p marsha.StructPtr, reg *marsha.Registry) (int, error) {
+	if u, ok := p.(*marsha.Union); ok {
+		h, _, err := cborutil.UnionValue(reg, u)
+		if err != nil {
+			return 0, err
+		}
+		cbp, ok := u.Value.(StructPtr)
+		if !ok {
+			return 0, ErrNotCBORStructPtr
+		}
+		n, err := w.Write(h)
+		if err != nil {
+			return n, err
+		}
+		n_, err := cbp.MarshalCBOR(w)
+		return n + n_, err
+	}
+	cbp, ok := p.(StructPtr)
+	if !ok {
+		return 0, ErrNotCBORStructPtr
+	}
+	return cbp.MarshalCBOR(w)
+}
+
+// appendStruct appends the encoding of `p` to `dst` according to `opts`.
+func appendStruct(opts marsha.Options, dst []byte, p marsha.StructPtr) ([]byte, error) {
+	return cborutil.Append(opts, dst, p, fields.CBORGen, func(w io.Writer) error {
+		_, err := marshal(w, p, opts.Registry)
+		return err
+	})
+}
+
+// index indexes the lazy struct `lp` in `bin` according to `opts`.
+func index(opts marsha.Options, bin []byte, lp LazyStructPtr) (int, error) {
+	if !opts.Strict && opts.UnknownPolicy == marsha.UnknownDefault {
+		return lp.IndexCBOR(bin)
+	}
+	in, done, err := cborutil.Input(opts, bin, lp.Model(), fields.CBORGen)
+	if err != nil {
+		return 0, err
+	}
+	read, err := lp.IndexCBOR(in)
+	if err != nil {
+		return read, err
+	}
+	return done(read), nil
+}
+
+// unmarshalBytes unmarshals `p` from `r`, which reads `in`, without copying byte slices and
+// strings into it with marsha.WithZeroCopy if its type is supported by zeroCopyDecoder.
+func (m *Marsha) unmarshalBytes(r *bytes.Reader, in []byte, p marsha.StructPtr) (int, error) {
+	if !m.opts.ZeroCopy {
+		return unmarshal(r, p, m.opts.Registry)
+	}
+	v := reflect.ValueOf(p)
+	if v.Kind() != reflect.Ptr || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+		return unmarshal(r, p, m.opts.Registry)
+	}
+	dec := zeroCopyDecoder(v.Elem().Type())
+	if dec == nil {
+		return unmarshal(r, p, m.opts.Registry)
+	}
+	off := len(in) - r.Len()
+	if off == len(in) {
+		return 0, io.EOF
+	}
+	end, err := dec(in, off, v.Elem())
+	if err != nil {
+		if errors.Is(err, errWrongType) {
+			err = ErrTypeNotMatch
+		}
+		return 0, err
+	}
+	_, err = r.Seek(int64(end), io.SeekStart)
+	return end - off, err
+}
+
+func unmarshal(r io.Reader, p marsha.StructPtr, reg *marsha.Registry) (read int, err error) {
+	if u, ok := p.(*marsha.Union); ok {
+		ti, read, err := cborutil.ReadUnionHeader(r, reg)
+		if err != nil {
+			return read, err
+		}
+		v := ti.New()
+		n, err := unmarshal(r, v, reg)
+		read += n
+		if err == nil {
+			u.Value = v
+		}
+		return read, err
+	}
+	cbp, ok := p.(StructPtr)
+	if !ok {
+		return 0, ErrNotCBORStructPtr
+	}
+	if read, err = cbp.UnmarshalCBOR(r); err != nil {
+		// The code generated by cbor-gen fails with unwrapped errors.
 		if strings.Contains(err.Error(), "wrong type") {
 			err = ErrTypeNotMatch
 		}
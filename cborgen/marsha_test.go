@@ -1,11 +1,17 @@
 package cborgen_test
 
 import (
+	"bytes"
+	"errors"
+	"math"
+	"strings"
+	"sync"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
+	"github.com/daotl/go-marsha"
 	"github.com/daotl/go-marsha/cborgen"
 	"github.com/daotl/go-marsha/test"
 )
@@ -29,3 +35,415 @@ func TestSpecial(t *testing.T) {
 		asrt.Equal(cborgen.ErrTypeNotMatch, err)
 	})
 }
+
+func TestUnion(t *testing.T) {
+	mrsh := cborgen.New(marsha.WithRegistry(test.NewRegistry()))
+	test.SubTestUnion(t, mrsh)
+}
+
+func TestValue(t *testing.T) {
+	test.SubTestValue(t, cborgen.New())
+
+	mrsh := cborgen.New(marsha.WithStrict())
+	bin, err := mrsh.MarshalStruct(&test.TestStruct2{Data2: -3})
+	require.NoError(t, err)
+	v, read, err := mrsh.DecodeValue(bin)
+	require.NoError(t, err)
+	assert.Equal(t, len(bin), read)
+	assert.True(t, marsha.NewArray(marsha.NewInt(-3)).Equal(v))
+
+	_, _, err = mrsh.DecodeValue(append(bin, 0))
+	assert.True(t, errors.Is(err, marsha.ErrTrailingData))
+}
+
+func TestGet(t *testing.T) {
+	mrsh := cborgen.New()
+	bin, err := mrsh.MarshalStruct(&test.TestStructV2{
+		Name: "parent", Child: &test.TestChildV2{ID: 7, Note: "child"}, Age: 30,
+	})
+	require.NoError(t, err)
+
+	// Fields of the tuple layout are indexed by their order.
+	v, err := marsha.Get(bin, "/1/1")
+	require.NoError(t, err)
+	assert.True(t, marsha.NewString("child").Equal(v))
+	v, err = marsha.Get(bin, "/2")
+	require.NoError(t, err)
+	assert.True(t, marsha.NewInt(30).Equal(v))
+
+	raw, err := marsha.GetRaw(bin, "/1")
+	require.NoError(t, err)
+	v, _, err = mrsh.DecodeValue(raw)
+	require.NoError(t, err)
+	assert.True(t, marsha.NewArray(marsha.NewInt(7), marsha.NewString("child")).Equal(v))
+
+	_, err = marsha.Get(bin, "/3")
+	assert.True(t, errors.Is(err, marsha.ErrPathNotFound))
+}
+
+func TestPatch(t *testing.T) {
+	mrsh := cborgen.New(marsha.WithStrict())
+	bin, err := mrsh.MarshalStruct(&test.TestStructV2{
+		Name: "parent", Child: &test.TestChildV2{ID: 7, Note: "child"}, Age: 30,
+	})
+	require.NoError(t, err)
+
+	bin, err = marsha.Patch(bin,
+		marsha.PatchOp{Op: marsha.OpReplace, Path: "/1/1", Value: marsha.NewString("renamed")},
+		marsha.PatchOp{Op: marsha.OpReplace, Path: "/2", Value: marsha.NewInt(31)})
+	require.NoError(t, err)
+	s := &test.TestStructV2{}
+	_, err = mrsh.UnmarshalStruct(bin, s)
+	require.NoError(t, err)
+	assert.Equal(t, &test.TestStructV2{
+		Name: "parent", Child: &test.TestChildV2{ID: 7, Note: "renamed"}, Age: 31,
+	}, s)
+}
+
+func TestDeterministic(t *testing.T) {
+	mrsh := cborgen.New(marsha.WithDeterministic(), marsha.WithStrict())
+	test.SubTestDeterministic(t, mrsh)
+}
+
+func TestStrict(t *testing.T) {
+	mrsh := cborgen.New(marsha.WithStrict())
+	test.SubTestStrict(t, mrsh)
+}
+
+func TestUnknown(t *testing.T) {
+	test.SubTestUnknown(t, func(p marsha.UnknownPolicy) marsha.Marsha {
+		return cborgen.New(marsha.WithUnknownPolicy(p))
+	})
+}
+
+func TestAppend(t *testing.T) {
+	test.SubTestAppend(t, cborgen.New())
+	t.Run("deterministic", func(t *testing.T) {
+		test.SubTestAppend(t, cborgen.New(marsha.WithDeterministic()))
+	})
+
+	t.Run("allocations", func(t *testing.T) {
+		// The code generated by cbor-gen allocates a scratch buffer per struct, nested ones included.
+		mrsh := cborgen.New()
+		s := &test.TestStruct{Data: "test"}
+		nested := &test.TestStructV2{Name: "parent", Child: &test.TestChildV2{ID: 7}, Age: 30}
+		ss := &test.TestStructs{{Data: "a"}, {Data: "b"}, {Data: "c"}}
+		buf := make([]byte, 0, 256)
+		for _, tc := range []struct {
+			name   string
+			f      func() ([]byte, error)
+			allocs float64
+		}{
+			{"struct", func() ([]byte, error) { return mrsh.MarshalStructAppend(buf[:0], s) }, 1},
+			{"nested struct", func() ([]byte, error) { return mrsh.MarshalStructAppend(buf[:0], nested) }, 2},
+			// Plus the slice of pointers returned by TestStructs.Val.
+			{"struct slice", func() ([]byte, error) { return mrsh.MarshalStructSliceAppend(buf[:0], ss) }, 4},
+		} {
+			var err error
+			allocs := testing.AllocsPerRun(100, func() { _, err = tc.f() })
+			assert.NoError(t, err, tc.name)
+			assert.Equal(t, tc.allocs, allocs, tc.name)
+		}
+	})
+}
+
+func TestLazy(t *testing.T) {
+	mrsh := cborgen.New()
+	s := &test.TestStructV2{Name: "parent", Child: &test.TestChildV2{ID: 7, Note: "child"}, Age: 30}
+	bin, err := mrsh.MarshalStruct(s)
+	require.NoError(t, err)
+
+	t.Run("accessors", func(t *testing.T) {
+		l := &test.LazyTestStructV2{}
+		read, err := mrsh.UnmarshalStruct(bin, l)
+		require.NoError(t, err)
+		assert.Equal(t, len(bin), read)
+		age, err := l.Age()
+		require.NoError(t, err)
+		assert.Equal(t, int64(30), age)
+		child, err := l.Child()
+		require.NoError(t, err)
+		assert.Equal(t, s.Child, child)
+		name, err := l.Name()
+		require.NoError(t, err)
+		assert.Equal(t, "parent", name)
+
+		full, err := l.Struct()
+		require.NoError(t, err)
+		assert.Equal(t, s, full)
+		out, err := mrsh.MarshalStruct(l)
+		require.NoError(t, err)
+		assert.Equal(t, bin, out)
+	})
+
+	t.Run("zero", func(t *testing.T) {
+		l := &test.LazyTestStructV2{}
+		name, err := l.Name()
+		require.NoError(t, err)
+		assert.Equal(t, "", name)
+		out, err := mrsh.MarshalStruct(l)
+		require.NoError(t, err)
+		zero, err := mrsh.MarshalStruct(&test.TestStructV2{})
+		require.NoError(t, err)
+		assert.Equal(t, zero, out)
+	})
+
+	t.Run("fields are only decoded on access", func(t *testing.T) {
+		bad, err := marsha.Patch(bin,
+			marsha.PatchOp{Op: marsha.OpReplace, Path: "/2", Value: marsha.NewString("thirty")})
+		require.NoError(t, err)
+		l := &test.LazyTestStructV2{}
+		_, err = mrsh.UnmarshalStruct(bad, l)
+		require.NoError(t, err)
+		name, err := l.Name()
+		require.NoError(t, err)
+		assert.Equal(t, "parent", name)
+		_, err = l.Age()
+		assert.Error(t, err)
+	})
+
+	t.Run("copies share decoded fields", func(t *testing.T) {
+		l := &test.LazyTestStructV2{}
+		_, err := mrsh.UnmarshalStruct(bin, l)
+		require.NoError(t, err)
+		cp := l.Val().(test.LazyTestStructV2)
+		name, err := l.Name()
+		require.NoError(t, err)
+		assert.Equal(t, "parent", name)
+		name, err = cp.Name()
+		require.NoError(t, err)
+		assert.Equal(t, "parent", name)
+		c1, err := l.Child()
+		require.NoError(t, err)
+		c2, err := cp.Child()
+		require.NoError(t, err)
+		assert.Same(t, c1, c2)
+	})
+
+	t.Run("concurrent access", func(t *testing.T) {
+		l := &test.LazyTestStructV2{}
+		_, err := mrsh.UnmarshalStruct(bin, l)
+		require.NoError(t, err)
+		var wg sync.WaitGroup
+		children := make([]*test.TestChildV2, 8)
+		for i := range children {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				cp := *l
+				name, err := cp.Name()
+				assert.NoError(t, err)
+				assert.Equal(t, "parent", name)
+				children[i], err = l.Child()
+				assert.NoError(t, err)
+			}(i)
+		}
+		wg.Wait()
+		for _, c := range children {
+			assert.Same(t, children[0], c)
+		}
+	})
+
+	t.Run("wrong number of fields", func(t *testing.T) {
+		bin, err := mrsh.MarshalStruct(&test.TestStruct{Data: "test"})
+		require.NoError(t, err)
+		_, err = mrsh.UnmarshalStruct(bin, &test.LazyTestStructV2{})
+		assert.Error(t, err)
+	})
+
+	t.Run("strict", func(t *testing.T) {
+		_, err := cborgen.New(marsha.WithStrict()).UnmarshalStruct(append(bin, 0), &test.LazyTestStructV2{})
+		assert.True(t, errors.Is(err, marsha.ErrTrailingData))
+	})
+
+	t.Run("unknown fields", func(t *testing.T) {
+		ext, err := marsha.Patch(bin,
+			marsha.PatchOp{Op: marsha.OpAdd, Path: "/-", Value: marsha.NewString("new")})
+		require.NoError(t, err)
+		_, err = mrsh.UnmarshalStruct(ext, &test.LazyTestStructV2{})
+		assert.Error(t, err)
+		l := &test.LazyTestStructV2{}
+		read, err := cborgen.New(marsha.WithUnknownPolicy(marsha.UnknownIgnore)).UnmarshalStruct(ext, l)
+		require.NoError(t, err)
+		assert.Equal(t, len(ext), read)
+		age, err := l.Age()
+		require.NoError(t, err)
+		assert.Equal(t, int64(30), age)
+	})
+
+	t.Run("Decoder", func(t *testing.T) {
+		var buf bytes.Buffer
+		enc := mrsh.NewEncoder(&buf)
+		_, err := enc.EncodeStruct(s)
+		require.NoError(t, err)
+		_, err = enc.EncodeStruct(&test.TestStructV2{Name: "second"})
+		require.NoError(t, err)
+		dec := mrsh.NewDecoder(&buf)
+		for _, want := range []string{"parent", "second"} {
+			l := &test.LazyTestStructV2{}
+			_, err := dec.DecodeStruct(l)
+			require.NoError(t, err)
+			name, err := l.Name()
+			require.NoError(t, err)
+			assert.Equal(t, want, name)
+		}
+	})
+}
+
+func TestZeroCopy(t *testing.T) {
+	t.Run("suite", func(t *testing.T) {
+		test.SubTestAll(t, cborgen.New(marsha.WithZeroCopy()))
+	})
+	t.Run("strict", func(t *testing.T) {
+		test.SubTestStrict(t, cborgen.New(marsha.WithZeroCopy(), marsha.WithStrict()))
+	})
+	t.Run("unknown", func(t *testing.T) {
+		test.SubTestUnknown(t, func(p marsha.UnknownPolicy) marsha.Marsha {
+			return cborgen.New(marsha.WithZeroCopy(), marsha.WithUnknownPolicy(p))
+		})
+	})
+
+	mrsh := cborgen.New(marsha.WithZeroCopy())
+	s := &test.TestStructV2{Name: "parent", Child: &test.TestChildV2{ID: 7, Note: "child"}, Age: 30}
+	bin, err := mrsh.MarshalStruct(s)
+	require.NoError(t, err)
+
+	t.Run("strings share memory with the input", func(t *testing.T) {
+		got := &test.TestStructV2{}
+		read, err := mrsh.UnmarshalStruct(bin, got)
+		require.NoError(t, err)
+		assert.Equal(t, len(bin), read)
+		assert.Equal(t, s, got)
+
+		in := append([]byte(nil), bin...)
+		_, err = mrsh.UnmarshalStruct(in, got)
+		require.NoError(t, err)
+		in[bytes.Index(in, []byte("parent"))] = 'P'
+		assert.Equal(t, "Parent", got.Name)
+	})
+
+	t.Run("type mismatch", func(t *testing.T) {
+		bin, err := mrsh.MarshalStruct(&test.TestStruct{Data: "test"})
+		require.NoError(t, err)
+		_, err = mrsh.UnmarshalStruct(bin, &test.TestStruct2{})
+		assert.Equal(t, cborgen.ErrTypeNotMatch, err)
+	})
+
+	t.Run("truncated input", func(t *testing.T) {
+		_, err := mrsh.UnmarshalStruct(bin[:len(bin)-3], &test.TestStructV2{})
+		assert.Error(t, err)
+	})
+
+	t.Run("integers like the generated decoder", func(t *testing.T) {
+		generated := cborgen.New()
+		ints := &test.TestInts{I16: math.MinInt16, U16: math.MaxUint16, U64: math.MaxUint64}
+		bin, err := generated.MarshalStruct(ints)
+		require.NoError(t, err)
+		got := &test.TestInts{}
+		_, err = mrsh.UnmarshalStruct(bin, got)
+		require.NoError(t, err)
+		assert.Equal(t, ints, got)
+
+		for _, c := range []struct {
+			field int
+			item  []byte
+			want  string
+		}{
+			{0, []byte{0x19, 0x80, 0x00}, "int16 positive overflow"},
+			{0, []byte{0x39, 0x80, 0x00}, "int16 negative oveflow"},
+			{1, []byte{0x1a, 0x00, 0x01, 0x00, 0x00}, "integer in input was too large for uint16 field"},
+			{0, []byte{0x60}, cborgen.ErrTypeNotMatch.Error()},
+			{1, []byte{0x20}, cborgen.ErrTypeNotMatch.Error()},
+			{2, []byte{0x20}, cborgen.ErrTypeNotMatch.Error()},
+		} {
+			in := []byte{0x83}
+			for i := 0; i < 3; i++ {
+				if i == c.field {
+					in = append(in, c.item...)
+				} else {
+					in = append(in, 0x00)
+				}
+			}
+			_, err := generated.UnmarshalStruct(in, &test.TestInts{})
+			if assert.Error(t, err, "%x", in) {
+				assert.Equal(t, c.want, err.Error(), "%x", in)
+			}
+			_, err = mrsh.UnmarshalStruct(in, &test.TestInts{})
+			if assert.Error(t, err, "%x", in) {
+				assert.Equal(t, c.want, err.Error(), "%x", in)
+			}
+		}
+	})
+
+	t.Run("UnmarshalStructSlice", func(t *testing.T) {
+		ss := &test.TestStructs{{Data: "a"}, {Data: "b"}}
+		bin, err := mrsh.MarshalStructSlice(ss)
+		require.NoError(t, err)
+		got := &test.TestStructs{}
+		read, err := mrsh.UnmarshalStructSlice(bin, got)
+		require.NoError(t, err)
+		assert.Equal(t, len(bin), read)
+		assert.Equal(t, ss, got)
+	})
+}
+
+func benchmarkUnmarshal(b *testing.B, mrsh marsha.Marsha, p marsha.StructPtr, read func()) {
+	bin, err := mrsh.MarshalStruct(&test.TestStructV2{
+		Name:  strings.Repeat("name", 64),
+		Child: &test.TestChildV2{ID: 7, Note: strings.Repeat("note", 256)},
+		Age:   30,
+	})
+	require.NoError(b, err)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := mrsh.UnmarshalStruct(bin, p); err != nil {
+			b.Fatal(err)
+		}
+		read()
+	}
+}
+
+func BenchmarkUnmarshalOneField(b *testing.B) {
+	b.Run("eager", func(b *testing.B) {
+		s := &test.TestStructV2{}
+		benchmarkUnmarshal(b, cborgen.New(), s, func() { _ = s.Age })
+	})
+	b.Run("lazy", func(b *testing.B) {
+		l := &test.LazyTestStructV2{}
+		benchmarkUnmarshal(b, cborgen.New(), l, func() { _, _ = l.Age() })
+	})
+}
+
+func BenchmarkUnmarshalZeroCopy(b *testing.B) {
+	b.Run("copy", func(b *testing.B) {
+		s := &test.TestStructV2{}
+		benchmarkUnmarshal(b, cborgen.New(), s, func() {})
+	})
+	b.Run("zero-copy", func(b *testing.B) {
+		s := &test.TestStructV2{}
+		benchmarkUnmarshal(b, cborgen.New(marsha.WithZeroCopy()), s, func() {})
+	})
+}
+
+func BenchmarkMarshalStruct(b *testing.B) {
+	mrsh := cborgen.New()
+	s := &test.TestStructV2{Name: "parent", Child: &test.TestChildV2{ID: 7, Note: "child"}, Age: 30}
+	b.Run("MarshalStruct", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := mrsh.MarshalStruct(s); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("MarshalStructAppend", func(b *testing.B) {
+		b.ReportAllocs()
+		var buf []byte
+		for i := 0; i < b.N; i++ {
+			var err error
+			if buf, err = mrsh.MarshalStructAppend(buf[:0], s); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
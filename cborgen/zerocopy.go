@@ -0,0 +1,337 @@
+package cborgen
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"sync"
+	"unsafe"
+
+	cbg "github.com/daotl/cbor-gen"
+	"github.com/ipfs/go-cid"
+
+	"github.com/daotl/go-marsha/internal/cbor"
+	"github.com/daotl/go-marsha/internal/fields"
+)
+
+// decodeFunc decodes the item at offset `off` of `b` into `v` without copying byte slices and
+// strings, and returns the offset just after the item.
+type decodeFunc func(b []byte, off int, v reflect.Value) (int, error)
+
+// structDecoders caches the decodeFuncs of struct types, which are nil for structs not supported
+// by zeroCopyDecoder.
+var structDecoders sync.Map // reflect.Type -> decodeFunc
+
+// errWrongType is wrapped by the errors of items of the wrong type for their fields, which are
+// reported as ErrTypeNotMatch.
+var errWrongType = errors.New("wrong type")
+
+var (
+	cidType         = reflect.TypeOf(cid.Cid{})
+	cborUnmarshaler = reflect.TypeOf((*cbg.CBORUnmarshaler)(nil)).Elem()
+)
+
+// zeroCopyDecoder returns the decodeFunc of struct type `t`, which decodes it like the code
+// generated by cbor-gen for the tuple layout does, or nil if the type of a field is not supported.
+// Supported are booleans, integers, strings, byte slices, CIDs, structs with code generated by
+// cbor-gen and pointers to them, and slices of these.
+func zeroCopyDecoder(t reflect.Type) decodeFunc {
+	if d, ok := structDecoders.Load(t); ok {
+		return d.(decodeFunc)
+	}
+	d := compileStruct(t)
+	structDecoders.Store(t, d)
+	return d
+}
+
+func compileStruct(t reflect.Type) decodeFunc {
+	fs, ok := fields.CBORGen.Fields(t)
+	if !ok {
+		return nil
+	}
+	decs := make([]decodeFunc, len(fs))
+	for i, f := range fs {
+		if decs[i] = compile(t.FieldByIndex(f.Index).Type); decs[i] == nil {
+			return nil
+		}
+	}
+	n := uint64(len(fs))
+	return func(b []byte, off int, v reflect.Value) (int, error) {
+		h, err := cbor.ReadHead(b, off)
+		if err != nil {
+			return 0, err
+		}
+		if h.Major != cbor.MajArray {
+			return 0, fmt.Errorf("cbor input should be of type array")
+		}
+		if h.Indefinite() || h.Arg != n {
+			return 0, fmt.Errorf("cbor input had wrong number of fields")
+		}
+		v.Set(reflect.Zero(t))
+		off = h.End()
+		for i, f := range fs {
+			if off, err = decs[i](b, off, fieldByIndex(v, f.Index)); err != nil {
+				return 0, err
+			}
+		}
+		return off, nil
+	}
+}
+
+// fieldByIndex returns the field of the struct `v` at `index`, allocating the nil pointers to
+// embedded structs on the way as cbor-gen does.
+func fieldByIndex(v reflect.Value, index []int) reflect.Value {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v
+}
+
+// compile returns the decodeFunc of a field of type `t`, or nil if `t` is not supported.
+func compile(t reflect.Type) decodeFunc {
+	switch {
+	case t == cidType:
+		return decodeCid
+	case t.Kind() == reflect.Ptr && t.Elem() == cidType:
+		return nullable(decodeCid)
+	case t.Kind() == reflect.Ptr && t.Elem().Kind() == reflect.Struct:
+		if d := compile(t.Elem()); d != nil {
+			return nullable(d)
+		}
+		return nil
+	case t.Kind() == reflect.Struct:
+		if reflect.PtrTo(t).Implements(cborUnmarshaler) {
+			return decodeStruct
+		}
+		return nil
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return func(b []byte, off int, v reflect.Value) (int, error) {
+			x, end, err := readBool(b, off)
+			v.SetBool(x)
+			return end, err
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return func(b []byte, off int, v reflect.Value) (int, error) {
+			x, end, err := readInt64(b, off)
+			if err != nil {
+				return 0, err
+			}
+			// Fail like the code generated by cbor-gen for integers narrower than int64.
+			if v.OverflowInt(x) {
+				if x > 0 {
+					return 0, fmt.Errorf("int%d positive overflow", v.Type().Bits())
+				}
+				return 0, fmt.Errorf("int%d negative oveflow", v.Type().Bits())
+			}
+			v.SetInt(x)
+			return end, nil
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return func(b []byte, off int, v reflect.Value) (int, error) {
+			x, end, err := readUint64(b, off)
+			if err != nil {
+				return 0, err
+			}
+			if v.OverflowUint(x) {
+				return 0, fmt.Errorf("integer in input was too large for uint%d field", v.Type().Bits())
+			}
+			v.SetUint(x)
+			return end, nil
+		}
+	case reflect.String:
+		return func(b []byte, off int, v reflect.Value) (int, error) {
+			s, end, err := readDefinite(b, off, cbor.MajTextString)
+			if err != nil {
+				return 0, err
+			}
+			if len(s) > cbg.MaxLength {
+				return 0, fmt.Errorf("string in input was too long")
+			}
+			v.SetString(unsafeString(s))
+			return end, nil
+		}
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return decodeBytes
+		}
+		elem := compile(t.Elem())
+		if elem == nil {
+			return nil
+		}
+		return func(b []byte, off int, v reflect.Value) (int, error) {
+			h, err := cbor.ReadHead(b, off)
+			if err != nil {
+				return 0, err
+			}
+			if h.Major != cbor.MajArray || h.Indefinite() {
+				return 0, fmt.Errorf("expected cbor array")
+			}
+			if h.Arg > cbg.MaxLength {
+				return 0, fmt.Errorf("array too large (%d)", h.Arg)
+			}
+			off = h.End()
+			if h.Arg > 0 {
+				v.Set(reflect.MakeSlice(t, int(h.Arg), int(h.Arg)))
+			}
+			for i := 0; i < int(h.Arg); i++ {
+				if off, err = elem(b, off, v.Index(i)); err != nil {
+					return 0, err
+				}
+			}
+			return off, nil
+		}
+	}
+	return nil
+}
+
+// nullable returns a decodeFunc of pointers, which decodes null as nil and other items with `d`
+// into a new value.
+func nullable(d decodeFunc) decodeFunc {
+	return func(b []byte, off int, v reflect.Value) (int, error) {
+		if off < len(b) && b[off] == cbg.CborNull[0] {
+			return off + 1, nil
+		}
+		p := reflect.New(v.Type().Elem())
+		end, err := d(b, off, p.Elem())
+		if err != nil {
+			return 0, err
+		}
+		v.Set(p)
+		return end, nil
+	}
+}
+
+// decodeStruct decodes a struct with its zero-copy decoder, or with its UnmarshalCBOR method if it
+// has none.
+func decodeStruct(b []byte, off int, v reflect.Value) (int, error) {
+	if d := zeroCopyDecoder(v.Type()); d != nil {
+		return d(b, off, v)
+	}
+	end, err := cbor.Skip(b, off)
+	if err != nil {
+		return 0, err
+	}
+	if err := DecodeStruct(b[off:end], v.Addr().Interface().(cbg.CBORUnmarshaler)); err != nil {
+		return 0, err
+	}
+	return end, nil
+}
+
+func decodeBytes(b []byte, off int, v reflect.Value) (int, error) {
+	s, end, err := readDefinite(b, off, cbor.MajByteString)
+	if err != nil {
+		return 0, err
+	}
+	if len(s) > cbg.ByteArrayMaxLen {
+		return 0, fmt.Errorf("byte array too large (%d)", len(s))
+	}
+	if len(s) > 0 {
+		// Limit the capacity, so that appending to the field doesn't overwrite the input.
+		v.SetBytes(s[:len(s):len(s)])
+	}
+	return end, nil
+}
+
+func decodeCid(b []byte, off int, v reflect.Value) (int, error) {
+	c, end, err := readCid(b, off)
+	if err != nil {
+		return 0, err
+	}
+	v.Set(reflect.ValueOf(c))
+	return end, nil
+}
+
+// unsafeString returns a string sharing its bytes with `b`.
+func unsafeString(b []byte) string {
+	if len(b) == 0 {
+		return ""
+	}
+	return *(*string)(unsafe.Pointer(&b))
+}
+
+func readBool(b []byte, off int) (bool, int, error) {
+	h, err := cbor.ReadHead(b, off)
+	if err != nil {
+		return false, 0, err
+	}
+	if h.Major != cbor.MajOther {
+		return false, 0, fmt.Errorf("%w: booleans must be major type 7", errWrongType)
+	}
+	switch h.Arg {
+	case 20:
+		return false, h.End(), nil
+	case 21:
+		return true, h.End(), nil
+	}
+	return false, 0, fmt.Errorf("booleans are either major type 7, value 20 or 21 (got %d)", h.Arg)
+}
+
+func readInt64(b []byte, off int) (int64, int, error) {
+	h, err := cbor.ReadHead(b, off)
+	if err != nil {
+		return 0, 0, err
+	}
+	switch h.Major {
+	case cbor.MajUnsignedInt:
+		if h.Arg > math.MaxInt64 {
+			return 0, 0, fmt.Errorf("int64 positive overflow")
+		}
+		return int64(h.Arg), h.End(), nil
+	case cbor.MajNegativeInt:
+		if h.Arg > math.MaxInt64 {
+			return 0, 0, fmt.Errorf("int64 negative oveflow")
+		}
+		return -1 - int64(h.Arg), h.End(), nil
+	}
+	return 0, 0, fmt.Errorf("%w for int64 field: %d", errWrongType, h.Major)
+}
+
+func readUint64(b []byte, off int) (uint64, int, error) {
+	h, err := cbor.ReadHead(b, off)
+	if err != nil {
+		return 0, 0, err
+	}
+	if h.Major != cbor.MajUnsignedInt {
+		return 0, 0, fmt.Errorf("%w for uint64 field", errWrongType)
+	}
+	return h.Arg, h.End(), nil
+}
+
+// readDefinite returns the content of the definite-length string of major type `major` at offset
+// `off` of `b`, and the offset just after it.
+func readDefinite(b []byte, off int, major byte) ([]byte, int, error) {
+	h, err := cbor.ReadHead(b, off)
+	if err != nil {
+		return nil, 0, err
+	}
+	if h.Major != major || h.Indefinite() {
+		return nil, 0, fmt.Errorf("%w: expected string of major type %d, got major type %d",
+			errWrongType, major, h.Major)
+	}
+	if h.Arg > uint64(len(b)-h.End()) {
+		return nil, 0, &cbor.SyntaxError{Offset: off, Msg: "unexpected end of input"}
+	}
+	end := h.End() + int(h.Arg)
+	return b[h.End():end], end, nil
+}
+
+func readCid(b []byte, off int) (cid.Cid, int, error) {
+	h, err := cbor.ReadHead(b, off)
+	if err != nil {
+		return cid.Undef, 0, err
+	}
+	if h.Major != cbor.MajTag || h.Arg != cbor.TagLink {
+		return cid.Undef, 0, fmt.Errorf("expected cbor type 'tag' in input")
+	}
+	return cbor.ReadLink(b, h.End())
+}
@@ -0,0 +1,323 @@
+// Package cddl generates RFC 8610 CDDL schemas describing how the CBOR Marsha implementations
+// encode Go models, so that they can be implemented in other languages, and validates CBOR
+// against CDDL schemas, either directly or as a Marsha middleware checking input before it is
+// unmarshaled.
+package cddl
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"reflect"
+	"regexp"
+	"strings"
+
+	cbg "github.com/daotl/cbor-gen"
+	"github.com/polydawn/refmt/obj/atlas"
+
+	"github.com/daotl/go-marsha"
+	"github.com/daotl/go-marsha/internal/model"
+)
+
+var (
+	ErrNotStruct   = model.ErrNotStruct
+	ErrUnsupported = model.ErrUnsupported
+)
+
+// Layout is how an implementation lays out structs.
+type Layout int
+
+const (
+	// Tuple lays out structs as arrays of their fields in order, like the encoders generated by
+	// `cbg.WriteTupleEncodersToFile` for the `cborgen` implementation.
+	Tuple Layout = iota
+	// Map lays out structs as maps keyed by field names or `cborgen` struct tags, like the
+	// encoders generated by `cbg.WriteMapEncodersToFile`.
+	Map
+	// Refmt lays out structs as maps keyed like the `cbor_refmt` implementation: by field names
+	// with the first letter lowercased or by `refmt` struct tags, fields tagged `omitempty` being
+	// optional.
+	Refmt
+)
+
+var (
+	bigIntType   = reflect.TypeOf(big.Int{})
+	deferredType = reflect.TypeOf(cbg.Deferred{})
+	keeperType   = reflect.TypeOf((*marsha.UnknownKeeper)(nil)).Elem()
+)
+
+// Generator generates the CDDL rules of Go models and of the struct types they refer to.
+type Generator struct {
+	layout Layout
+	rules  []rule
+	names  *model.Namer
+}
+
+type rule struct {
+	name string
+	def  string
+}
+
+// NewGenerator creates a Generator for structs laid out by `layout`.
+func NewGenerator(layout Layout) *Generator {
+	return &Generator{
+		layout: layout,
+		names:  model.NewNamer(model.Qualify),
+	}
+}
+
+// Add generates the rules of the models, which are struct values or pointers to them, like those
+// passed to `cbg.WriteTupleEncodersToFile` or `cbor_refmt.Marsha.Register`. The rule of the
+// first model added is the root of the schema.
+func (g *Generator) Add(models ...interface{}) error {
+	for _, m := range models {
+		t, err := model.Struct(m)
+		if err != nil {
+			return err
+		}
+		if _, err := g.ref(t); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AddUnion generates the rule `name` for a marsha.Union holding a value of any type registered in
+// `reg`, along with the rules of these types.
+func (g *Generator) AddUnion(name string, reg *marsha.Registry) error {
+	if reg == nil {
+		return marsha.ErrNoRegistry
+	}
+	var choices []string
+	for _, ti := range reg.Types() {
+		ref, err := g.ref(ti.Type)
+		if err != nil {
+			return err
+		}
+		switch {
+		case ti.CBORTag != 0:
+			choices = append(choices, fmt.Sprintf("#6.%d(%s)", ti.CBORTag, ref))
+		case ti.Name != "":
+			choices = append(choices, fmt.Sprintf("[%q, %s]", ti.Name, ref))
+		}
+	}
+	if len(choices) == 0 {
+		return fmt.Errorf("%w: no type with a CBOR tag or a name", marsha.ErrNotRegistered)
+	}
+	if t, ok := g.names.Type(name); ok {
+		return fmt.Errorf("rule %s already generated for %s", name, t)
+	}
+	g.names.Reserve(name)
+	g.rules = append(g.rules, rule{name: name, def: strings.Join(choices, " / ")})
+	return nil
+}
+
+// WriteTo writes the rules generated so far to `w`.
+func (g *Generator) WriteTo(w io.Writer) (int64, error) {
+	var buf bytes.Buffer
+	for i, r := range g.rules {
+		if i > 0 {
+			buf.WriteByte('\n')
+		}
+		fmt.Fprintf(&buf, "%s = %s\n", r.name, r.def)
+	}
+	return buf.WriteTo(w)
+}
+
+// String returns the rules generated so far.
+func (g *Generator) String() string {
+	var sb strings.Builder
+	g.WriteTo(&sb)
+	return sb.String()
+}
+
+// WriteFile writes the CDDL rules of `models` laid out by `layout` to the file `path`.
+func WriteFile(path string, layout Layout, models ...interface{}) error {
+	g := NewGenerator(layout)
+	if err := g.Add(models...); err != nil {
+		return err
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprint(f, "; Code generated by github.com/daotl/go-marsha/cddl. DO NOT EDIT.\n\n"); err != nil {
+		f.Close()
+		return err
+	}
+	if _, err := g.WriteTo(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// ref returns the name of the rule of the struct type `t`, generating it the first time.
+func (g *Generator) ref(t reflect.Type) (string, error) {
+	if name, ok := g.names.Lookup(t); ok {
+		return name, nil
+	}
+	name, err := g.names.Name(t)
+	if err != nil {
+		return "", err
+	}
+	i := len(g.rules)
+	g.rules = append(g.rules, rule{name: name})
+	def, err := g.def(t)
+	if err != nil {
+		return "", err
+	}
+	g.rules[i].def = def
+	return name, nil
+}
+
+// entry is a group entry of a struct rule.
+type entry struct {
+	key, typ string
+	optional bool
+}
+
+// def returns the definition of the struct type `t`.
+func (g *Generator) def(t reflect.Type) (string, error) {
+	var entries []entry
+	switch g.layout {
+	case Tuple, Map:
+		gti, _, err := cbg.ParseTypeInfo(reflect.New(t).Elem().Interface(), true)
+		if err != nil {
+			return "", err
+		}
+		for _, f := range gti.Fields {
+			ft := f.Type
+			if f.Pointer {
+				ft = reflect.PtrTo(ft)
+			}
+			typ, err := g.typ(ft)
+			if err != nil {
+				return "", fmt.Errorf("%s.%s: %w", t.Name(), f.Name, err)
+			}
+			key := f.Name
+			if g.layout == Map {
+				key = memberKey(f.MapKey)
+			}
+			entries = append(entries, entry{key: key, typ: typ})
+		}
+	case Refmt:
+		e := atlas.BuildEntry(reflect.New(t).Elem().Interface()).StructMap().
+			AutogenerateWithSortingScheme(atlas.KeySortMode_RFC7049).Complete()
+		for _, f := range e.StructMap.Fields {
+			typ, err := g.typ(f.Type)
+			if err != nil {
+				return "", fmt.Errorf("%s.%s: %w", t.Name(), f.SerialName, err)
+			}
+			entries = append(entries, entry{key: memberKey(f.SerialName), typ: typ, optional: f.OmitEmpty})
+		}
+	default:
+		panic(fmt.Sprintf("cddl: invalid layout %d", g.layout))
+	}
+	// Structs keeping unknown fields round-trip fields added by later versions.
+	if reflect.PtrTo(t).Implements(keeperType) {
+		if g.layout == Tuple {
+			entries = append(entries, entry{key: "*", typ: "any"})
+		} else {
+			entries = append(entries, entry{key: "* tstr =>", typ: "any"})
+		}
+	}
+
+	begin, end := "{", "}"
+	if g.layout == Tuple {
+		begin, end = "[", "]"
+	}
+	if len(entries) == 0 {
+		return begin + end, nil
+	}
+	var sb strings.Builder
+	sb.WriteString(begin + "\n")
+	for i, e := range entries {
+		sb.WriteString("  ")
+		if e.optional {
+			sb.WriteString("? ")
+		}
+		if g.layout == Tuple && e.key != "*" {
+			e.key += ":"
+		}
+		sb.WriteString(e.key + " " + e.typ)
+		if i < len(entries)-1 {
+			sb.WriteByte(',')
+		}
+		sb.WriteByte('\n')
+	}
+	sb.WriteString(end)
+	return sb.String(), nil
+}
+
+var bareword = regexp.MustCompile(`^[A-Za-z@_$](?:[-.]*[A-Za-z0-9@_$])*$`)
+
+// memberKey returns the member key of the text string `key` in a map.
+func memberKey(key string) string {
+	if bareword.MatchString(key) {
+		return key + ":"
+	}
+	return fmt.Sprintf("%q:", key)
+}
+
+// typ returns the CDDL type of values of `t`.
+func (g *Generator) typ(t reflect.Type) (string, error) {
+	switch t {
+	case model.CidType:
+		return "#6.42(bstr)", nil
+	case bigIntType:
+		return "biguint", nil
+	case deferredType:
+		return "any", nil
+	}
+	switch t.Kind() {
+	case reflect.Ptr:
+		elem, err := g.typ(t.Elem())
+		if err != nil {
+			return "", err
+		}
+		return elem + " / null", nil
+	case reflect.Bool:
+		return "bool", nil
+	case reflect.String:
+		return "tstr", nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return "int", nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "uint", nil
+	case reflect.Float32, reflect.Float64:
+		return "float", nil
+	case reflect.Interface:
+		return "any", nil
+	case reflect.Slice, reflect.Array:
+		if k := t.Elem().Kind(); k == reflect.Uint8 || k == reflect.Int8 && g.layout != Refmt {
+			if t.Kind() == reflect.Array {
+				return fmt.Sprintf("bstr .size %d", t.Len()), nil
+			}
+			return "bstr", nil
+		}
+		elem, err := g.typ(t.Elem())
+		if err != nil {
+			return "", err
+		}
+		if t.Kind() == reflect.Array {
+			return fmt.Sprintf("[%d*%d %s]", t.Len(), t.Len(), elem), nil
+		}
+		return "[* " + elem + "]", nil
+	case reflect.Map:
+		key, err := g.typ(t.Key())
+		if err != nil {
+			return "", err
+		}
+		elem, err := g.typ(t.Elem())
+		if err != nil {
+			return "", err
+		}
+		return "{* " + key + " => " + elem + "}", nil
+	case reflect.Struct:
+		return g.ref(t)
+	}
+	return "", fmt.Errorf("%w: %s", ErrUnsupported, t)
+}
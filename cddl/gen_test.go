@@ -0,0 +1,113 @@
+package cddl_test
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/daotl/go-marsha"
+	"github.com/daotl/go-marsha/cddl"
+	"github.com/daotl/go-marsha/test"
+)
+
+type Item struct {
+	SKU string `refmt:"sku" cborgen:"sku"`
+	Qty uint32
+}
+
+type Order struct {
+	marsha.Unknown
+	ID       uint64 `refmt:"id"`
+	Customer string `refmt:"1st-customer" cborgen:"1st-customer"`
+	Note     string `refmt:",omitempty"`
+	Items    []*Item
+	Tags     map[string]string
+	Link     cid.Cid
+	Hash     [4]byte
+	Skip     bool `refmt:"-"`
+}
+
+func TestTupleModels(t *testing.T) {
+	want, err := os.ReadFile("../test/models.cddl")
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	require.NoError(t, cddl.WriteFile(dir+"/models.cddl", cddl.Tuple,
+		test.TestStruct{}, test.TestStruct2{}, test.TestLinked{},
+		test.TestStructV2{}, test.TestChildV2{}, test.TestStructV1{}, test.TestChildV1{},
+		test.TestPersonV1{}, test.TestPersonV2{}, test.TestPerson{}))
+	got, err := os.ReadFile(dir + "/models.cddl")
+	require.NoError(t, err)
+	assert.Equal(t, string(want), string(got), "test/models.cddl is outdated, run go generate")
+}
+
+func TestLayouts(t *testing.T) {
+	g := cddl.NewGenerator(cddl.Refmt)
+	require.NoError(t, g.Add(&Order{}))
+	assert.Equal(t, `Order = {
+  id: uint,
+  hash: bstr .size 4,
+  link: #6.42(bstr),
+  ? note: tstr,
+  tags: {* tstr => tstr},
+  items: [* Item / null],
+  "1st-customer": tstr,
+  * tstr => any
+}
+
+Item = {
+  qty: uint,
+  sku: tstr
+}
+`, g.String())
+
+	g = cddl.NewGenerator(cddl.Map)
+	require.NoError(t, g.Add(Order{}))
+	assert.Equal(t, `Order = {
+  ID: uint,
+  "1st-customer": tstr,
+  Note: tstr,
+  Items: [* Item / null],
+  Tags: {* tstr => tstr},
+  Link: #6.42(bstr),
+  Hash: bstr .size 4,
+  Skip: bool,
+  * tstr => any
+}
+
+Item = {
+  sku: tstr,
+  Qty: uint
+}
+`, g.String())
+}
+
+func TestUnion(t *testing.T) {
+	g := cddl.NewGenerator(cddl.Tuple)
+	require.NoError(t, g.AddUnion("Union", test.NewRegistry()))
+	assert.Equal(t, `TestStruct = [
+  Data: tstr
+]
+
+TestStruct2 = [
+  Data2: int
+]
+
+Union = #6.80001(TestStruct) / ["test2", TestStruct2]
+`, g.String())
+}
+
+type Chan struct {
+	C chan int
+}
+
+func TestErrors(t *testing.T) {
+	g := cddl.NewGenerator(cddl.Tuple)
+	assert.True(t, errors.Is(g.Add(42), cddl.ErrNotStruct))
+	assert.True(t, errors.Is(g.Add(Chan{}), cddl.ErrUnsupported))
+	assert.True(t, errors.Is(g.AddUnion("Union", nil), marsha.ErrNoRegistry))
+}
@@ -0,0 +1,169 @@
+package cddl
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"sync"
+
+	"github.com/daotl/go-marsha"
+	"github.com/daotl/go-marsha/internal/cbor"
+	"github.com/daotl/go-marsha/internal/model"
+)
+
+type options struct {
+	rules map[reflect.Type]string
+}
+
+// Option configures a Marsha.
+type Option func(*options)
+
+// WithRule validates structs of the type of `s` against the rule `name` instead of the rule
+// named after the type.
+func WithRule(s marsha.Struct, name string) Option {
+	return func(o *options) {
+		o.rules[structType(s)] = name
+	}
+}
+
+func structType(s interface{}) reflect.Type {
+	t := reflect.TypeOf(s)
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t
+}
+
+// Marsha is a marsha.Marsha middleware which validates CBOR against a Schema before a wrapped
+// CBOR Marsha unmarshals it, failing with a *ValidationError listing the violations found.
+//
+// Structs are validated against the rule bound to their type by WithRule, or else the rule named
+// after their type like Generator names it, such as "Union" for a marsha.Union, or
+// "other.Union" for a type of the same name in the package `other`. The elements of
+// struct slices are validated against the rule of their type. Primitives and everything marshaled
+// are passed through unchanged.
+//
+// Decoder reads one data item at a time from the stream, so the wrapped Marsha must transmit bare
+// CBOR data items like the `cborgen` and `cbor_refmt` implementations do.
+type Marsha struct {
+	inner  marsha.Marsha
+	schema *Schema
+	opts   options
+}
+
+var _ marsha.Marsha = (*Marsha)(nil)
+
+// New creates a Marsha validating the input of `m` against `s`.
+func New(m marsha.Marsha, s *Schema, opts ...Option) *Marsha {
+	o := options{rules: map[reflect.Type]string{}}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return &Marsha{inner: m, schema: s, opts: o}
+}
+
+// rule returns a reference to the rule to validate values of type `t` against.
+func (m *Marsha) rule(t reflect.Type) (*typ, error) {
+	name, ok := m.opts.rules[t]
+	if !ok {
+		// Generator qualifies types sharing the name of a type added before by their package.
+		name = model.Qualify(t)
+		if _, ok := m.schema.types[name]; !ok {
+			name = t.Name()
+		}
+	}
+	if _, ok := m.schema.types[name]; !ok {
+		return nil, fmt.Errorf("%w for %s: %s", ErrNoSuchRule, t, name)
+	}
+	return &typ{choices: []*type1{{kind: kRef, name: name, src: name}}}, nil
+}
+
+// validate validates `bin` against the rule of structs of type `t`, or of slices of them.
+func (m *Marsha) validate(bin []byte, t reflect.Type, slice bool) error {
+	rt, err := m.rule(t)
+	if err != nil {
+		return err
+	}
+	if slice {
+		elems := &groupEntry{min: 0, max: -1, typ: rt}
+		rt = &typ{choices: []*type1{{kind: kArray, group: &group{choices: [][]*groupEntry{{elems}}}}}}
+	}
+	return m.schema.validate(rt, bin)
+}
+
+func (m *Marsha) MarshalPrimitive(p interface{}) ([]byte, error) {
+	return m.inner.MarshalPrimitive(p)
+}
+
+func (m *Marsha) UnmarshalPrimitive(bin []byte, p interface{}) (int, error) {
+	return m.inner.UnmarshalPrimitive(bin, p)
+}
+
+func (m *Marsha) MarshalStruct(p marsha.StructPtr) ([]byte, error) {
+	return m.inner.MarshalStruct(p)
+}
+
+// UnmarshalStruct validates `bin` against the rule of the type of `p` before unmarshaling it
+// into `p`.
+func (m *Marsha) UnmarshalStruct(bin []byte, p marsha.StructPtr) (int, error) {
+	if err := m.validate(bin, structType(p), false); err != nil {
+		return 0, err
+	}
+	return m.inner.UnmarshalStruct(bin, p)
+}
+
+func (m *Marsha) MarshalStructSlice(p marsha.StructSlicePtr) ([]byte, error) {
+	return m.inner.MarshalStructSlice(p)
+}
+
+// UnmarshalStructSlice validates `bin` as an array of elements matching the rule of the element
+// type of `p` before unmarshaling it into `p`.
+func (m *Marsha) UnmarshalStructSlice(bin []byte, p marsha.StructSlicePtr) (int, error) {
+	t := structType(p)
+	if t.Kind() != reflect.Slice {
+		return 0, fmt.Errorf("%w for %s: not a slice", ErrNoSuchRule, t)
+	}
+	if err := m.validate(bin, t.Elem(), true); err != nil {
+		return 0, err
+	}
+	return m.inner.UnmarshalStructSlice(bin, p)
+}
+
+func (m *Marsha) NewEncoder(w io.Writer) marsha.Encoder {
+	return m.inner.NewEncoder(w)
+}
+
+func (m *Marsha) NewDecoder(r io.Reader) marsha.Decoder {
+	return &decoder{m: m, r: r}
+}
+
+type decoder struct {
+	sync.Mutex // each item must be read atomically
+	m          *Marsha
+	r          io.Reader
+}
+
+func (d *decoder) DecodePrimitive(p interface{}) (int, error) {
+	return d.decode(func(bin []byte) (int, error) { return d.m.UnmarshalPrimitive(bin, p) })
+}
+
+func (d *decoder) DecodeStruct(p marsha.StructPtr) (int, error) {
+	return d.decode(func(bin []byte) (int, error) { return d.m.UnmarshalStruct(bin, p) })
+}
+
+func (d *decoder) DecodeStructSlice(p marsha.StructSlicePtr) (int, error) {
+	return d.decode(func(bin []byte) (int, error) { return d.m.UnmarshalStructSlice(bin, p) })
+}
+
+// decode reads a data item and passes its bytes to `unmarshal`. It returns the count of bytes
+// read from the stream.
+func (d *decoder) decode(unmarshal func([]byte) (int, error)) (int, error) {
+	d.Lock()
+	defer d.Unlock()
+	bin, err := cbor.ReadItem(d.r)
+	if err != nil {
+		return len(bin), err
+	}
+	_, err = unmarshal(bin)
+	return len(bin), err
+}
@@ -0,0 +1,836 @@
+package cddl
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var ErrSyntax = errors.New("invalid CDDL")
+
+// SyntaxError reports invalid or unsupported CDDL at a line and column.
+type SyntaxError struct {
+	Line, Col int
+	Msg       string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("%s at %d:%d: %s", ErrSyntax, e.Line, e.Col, e.Msg)
+}
+
+func (e *SyntaxError) Unwrap() error { return ErrSyntax }
+
+// Schema is a parsed CDDL schema.
+type Schema struct {
+	// root is the name of the first rule.
+	root  string
+	names []string
+	types map[string]*typ
+	// groups are the rules defining groups, such as `name = (a: int, b: tstr)`.
+	groups map[string]*group
+}
+
+// Rules returns the names of the rules of `s` in the order they are defined, the first being
+// the root.
+func (s *Schema) Rules() []string {
+	return append([]string(nil), s.names...)
+}
+
+// The AST of CDDL types (RFC 8610 appendix B): a typ is a choice of type1s, which may be a
+// type2 or a range or control operator applied to two type2s.
+type typ struct {
+	choices []*type1
+}
+
+type kind int
+
+const (
+	kValue kind = iota
+	kRef
+	kParen
+	kMap
+	kArray
+	kUnwrap
+	kEnum
+	kTag
+	kMajor
+	kAny
+)
+
+type type1 struct {
+	kind  kind
+	value *value
+	// name is the rule referred to by kRef, kUnwrap and kEnum with a group name.
+	name  string
+	group *group
+	// inner is the type in parentheses for kParen or tagged for kTag.
+	inner *typ
+	// major and arg are the major type and optional argument of `#major.arg` for kMajor, arg
+	// the tag number of kTag.
+	major byte
+	arg   *uint64
+	// op is a range ("..", "...") or control operator (".size") with `target` its right operand.
+	op     string
+	target *type1
+	// re is the compiled regular expression of a ".regexp" control.
+	re *regexp.Regexp
+	// src is the source text, for messages.
+	src string
+}
+
+type valueKind int
+
+const (
+	vInt valueKind = iota
+	vFloat
+	vText
+	vBytes
+)
+
+type value struct {
+	kind  valueKind
+	int   *big.Int
+	float float64
+	text  string
+}
+
+type group struct {
+	choices [][]*groupEntry
+}
+
+type groupEntry struct {
+	min, max int // max is -1 for unbounded
+	key      *keyType
+	typ      *typ
+	// group is set instead of typ for an entry which is a group in parentheses.
+	group *group
+}
+
+type keyType struct {
+	typ *type1
+	cut bool
+}
+
+// Parse parses the CDDL source `src`. The standard prelude (RFC 8610 appendix D) is predefined.
+// Generic rules, sockets and the `.bits`, `.and` and `.within` controls are not supported.
+func Parse(src string) (*Schema, error) {
+	s := &Schema{types: map[string]*typ{}, groups: map[string]*group{}}
+	if err := s.parse(prelude, false); err != nil {
+		panic(err)
+	}
+	s.names, s.root = nil, ""
+	if err := s.parse(src, true); err != nil {
+		return nil, err
+	}
+	if s.root == "" {
+		return nil, &SyntaxError{Line: 1, Col: 1, Msg: "no rules"}
+	}
+	if err := s.check(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// MustParse is like Parse but panics on error.
+func MustParse(src string) *Schema {
+	s, err := Parse(src)
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+const prelude = `
+any = #
+uint = #0
+nint = #1
+int = uint / nint
+bstr = #2
+bytes = bstr
+tstr = #3
+text = tstr
+tdate = #6.0(tstr)
+time = #6.1(number)
+number = int / float
+biguint = #6.2(bstr)
+bignint = #6.3(bstr)
+bigint = biguint / bignint
+integer = int / bigint
+unsigned = uint / biguint
+decfrac = #6.4([e10: int, m: integer])
+bigfloat = #6.5([e2: int, m: integer])
+encoded-cbor = #6.24(bstr)
+uri = #6.32(tstr)
+b64url = #6.33(tstr)
+b64legacy = #6.34(tstr)
+regexp = #6.35(tstr)
+mime-message = #6.36(tstr)
+cbor-any = #6.55799(any)
+float16 = #7.25
+float32 = #7.26
+float64 = #7.27
+float16-32 = float16 / float32
+float32-64 = float32 / float64
+float = float16-32 / float64
+false = #7.20
+true = #7.21
+bool = false / true
+nil = #7.22
+null = nil
+undefined = #7.23
+`
+
+type tokKind int
+
+const (
+	tEOF tokKind = iota
+	tName
+	tNumber
+	tText
+	tBytes
+	tHash
+	tCtl
+	tSym
+)
+
+type tok struct {
+	kind      tokKind
+	text      string
+	off       int
+	line, col int
+}
+
+var (
+	nameRe   = regexp.MustCompile(`^[A-Za-z@_$](?:[-.]*[A-Za-z0-9@_$])*`)
+	numberRe = regexp.MustCompile(`^-?(?:0x[0-9A-Fa-f]+|0b[01]+|[0-9]+(?:\.[0-9]+)?(?:[eE][-+]?[0-9]+)?)`)
+	hashRe   = regexp.MustCompile(`^#(?:[0-7](?:\.[0-9]+)?)?`)
+	ctlRe    = regexp.MustCompile(`^\.[A-Za-z][A-Za-z0-9_-]*`)
+	symbols  = []string{"//=", "/=", "//", "...", "..", "=>", "=", "/", "(", ")", "{", "}", "[", "]",
+		",", ":", "^", "?", "*", "+", "~", "&", "<", ">"}
+)
+
+func tokenize(src string) ([]tok, error) {
+	var toks []tok
+	line, col := 1, 1
+	for i := 0; i < len(src); {
+		c := src[i]
+		t := tok{off: i, line: line, col: col}
+		n := 0
+		switch {
+		case c == '\n':
+			line, col = line+1, 1
+			i++
+			continue
+		case c == ' ' || c == '\t' || c == '\r':
+			i, col = i+1, col+1
+			continue
+		case c == ';':
+			for i < len(src) && src[i] != '\n' {
+				i++
+			}
+			continue
+		case c == '"':
+			j := i + 1
+			for ; j < len(src) && src[j] != '"'; j++ {
+				if src[j] == '\\' {
+					j++
+				}
+			}
+			if j >= len(src) {
+				return nil, &SyntaxError{line, col, "unterminated text string"}
+			}
+			n = j + 1 - i
+			t.kind = tText
+		case c == '\'' || strings.HasPrefix(src[i:], "h'") || strings.HasPrefix(src[i:], "b64'"):
+			q := strings.IndexByte(src[i:], '\'')
+			end := strings.IndexByte(src[i+q+1:], '\'')
+			if end < 0 {
+				return nil, &SyntaxError{line, col, "unterminated byte string"}
+			}
+			n = q + end + 2
+			t.kind = tBytes
+		case c == '#':
+			n = len(hashRe.FindString(src[i:]))
+			t.kind = tHash
+		case c == '.' && ctlRe.MatchString(src[i:]):
+			n = len(ctlRe.FindString(src[i:]))
+			t.kind = tCtl
+		case c == '-' || c >= '0' && c <= '9':
+			n = len(numberRe.FindString(src[i:]))
+			t.kind = tNumber
+		case nameRe.MatchString(src[i:]):
+			n = len(nameRe.FindString(src[i:]))
+			t.kind = tName
+		default:
+			for _, sym := range symbols {
+				if strings.HasPrefix(src[i:], sym) {
+					n = len(sym)
+					t.kind = tSym
+					break
+				}
+			}
+		}
+		if n == 0 {
+			return nil, &SyntaxError{line, col, fmt.Sprintf("unexpected %q", c)}
+		}
+		t.text = src[i : i+n]
+		toks = append(toks, t)
+		line += strings.Count(t.text, "\n")
+		i, col = i+n, col+n
+	}
+	return append(toks, tok{kind: tEOF, off: len(src), line: line, col: col}), nil
+}
+
+type parser struct {
+	src  string
+	toks []tok
+	pos  int
+}
+
+func (p *parser) peek() tok { return p.toks[p.pos] }
+
+func (p *parser) peekAt(i int) tok {
+	if p.pos+i >= len(p.toks) {
+		return p.toks[len(p.toks)-1]
+	}
+	return p.toks[p.pos+i]
+}
+
+func (p *parser) next() tok {
+	t := p.toks[p.pos]
+	if t.kind != tEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) is(sym string) bool {
+	t := p.peek()
+	return t.kind == tSym && t.text == sym
+}
+
+func (p *parser) errorf(t tok, format string, args ...interface{}) error {
+	msg := fmt.Sprintf(format, args...)
+	if t.kind == tEOF {
+		msg += " at end of input"
+	} else {
+		msg += fmt.Sprintf(", got %q", t.text)
+	}
+	return &SyntaxError{Line: t.line, Col: t.col, Msg: msg}
+}
+
+func (p *parser) expect(sym string) error {
+	if !p.is(sym) {
+		return p.errorf(p.peek(), "expected %q", sym)
+	}
+	p.next()
+	return nil
+}
+
+func (s *Schema) parse(src string, user bool) error {
+	toks, err := tokenize(src)
+	if err != nil {
+		return err
+	}
+	p := &parser{src: src, toks: toks}
+	for p.peek().kind != tEOF {
+		name := p.next()
+		if name.kind != tName {
+			return p.errorf(name, "expected rule name")
+		}
+		if p.is("<") {
+			return p.errorf(p.peek(), "generic rules are not supported")
+		}
+		assign := p.next()
+		if assign.kind != tSym || assign.text != "=" && assign.text != "/=" && assign.text != "//=" {
+			return p.errorf(assign, `expected "=", "/=" or "//="`)
+		}
+		_, isType := s.types[name.text]
+		_, isGroup := s.groups[name.text]
+		switch {
+		case assign.text == "=" && (isType || isGroup) && user:
+			return &SyntaxError{name.line, name.col, fmt.Sprintf("rule %s defined twice", name.text)}
+		case assign.text == "/=" && !isType, assign.text == "//=" && !isGroup:
+			return &SyntaxError{name.line, name.col, fmt.Sprintf("rule %s extended before it is defined", name.text)}
+		}
+		if assign.text == "//=" {
+			g, err := p.group("")
+			if err != nil {
+				return err
+			}
+			s.groups[name.text].choices = append(s.groups[name.text].choices, g.choices...)
+			continue
+		}
+		start := p.pos
+		if assign.text == "=" && p.is("(") {
+			// A group, unless it is a single type in parentheses.
+			p.next()
+			g, err := p.group(")")
+			if err != nil {
+				return err
+			}
+			if !g.isType() {
+				s.define(name.text, nil, g)
+				continue
+			}
+			p.pos = start
+		} else if assign.text == "=" && p.isGroupEntry() {
+			e, err := p.entry()
+			if err != nil {
+				return err
+			}
+			s.define(name.text, nil, &group{choices: [][]*groupEntry{{e}}})
+			continue
+		}
+		t, err := p.typ()
+		if err != nil {
+			return err
+		}
+		if assign.text == "/=" {
+			s.types[name.text].choices = append(s.types[name.text].choices, t.choices...)
+			continue
+		}
+		s.define(name.text, t, nil)
+	}
+	return nil
+}
+
+func (s *Schema) define(name string, t *typ, g *group) {
+	delete(s.types, name)
+	delete(s.groups, name)
+	if t != nil {
+		s.types[name] = t
+	} else {
+		s.groups[name] = g
+	}
+	s.names = append(s.names, name)
+	if s.root == "" {
+		s.root = name
+	}
+}
+
+// atRuleEnd tells whether the next token starts a new rule or ends the input.
+func (p *parser) atRuleEnd() bool {
+	t := p.peek()
+	if t.kind == tEOF {
+		return true
+	}
+	a := p.peekAt(1)
+	return t.kind == tName && a.kind == tSym && (a.text == "=" || a.text == "/=" || a.text == "//=")
+}
+
+// isGroupEntry tells whether the next tokens start a group entry with a member key or an
+// occurrence, which can't be a type.
+func (p *parser) isGroupEntry() bool {
+	t, a := p.peek(), p.peekAt(1)
+	switch {
+	case t.kind == tSym && (t.text == "?" || t.text == "*" || t.text == "+"):
+		return true
+	case t.kind == tNumber && a.kind == tSym && a.text == "*":
+		return true
+	case (t.kind == tName || t.kind == tText || t.kind == tNumber || t.kind == tBytes) &&
+		a.kind == tSym && (a.text == ":" || a.text == "=>" || a.text == "^"):
+		return true
+	}
+	return false
+}
+
+// isType tells whether the group is a single type in parentheses.
+func (g *group) isType() bool {
+	return len(g.choices) == 1 && len(g.choices[0]) == 1 &&
+		g.choices[0][0].key == nil && g.choices[0][0].typ != nil &&
+		g.choices[0][0].min == 1 && g.choices[0][0].max == 1
+}
+
+func (p *parser) typ() (*typ, error) {
+	t := &typ{}
+	for {
+		t1, err := p.type1()
+		if err != nil {
+			return nil, err
+		}
+		t.choices = append(t.choices, t1)
+		if !p.is("/") {
+			return t, nil
+		}
+		p.next()
+	}
+}
+
+func (p *parser) type1() (*type1, error) {
+	start := p.peek()
+	t, err := p.type2()
+	if err != nil {
+		return nil, err
+	}
+	t.src = p.source(start)
+	op := p.peek()
+	switch {
+	case op.kind == tSym && (op.text == ".." || op.text == "..."):
+	case op.kind == tCtl:
+		switch op.text {
+		case ".size", ".regexp", ".lt", ".le", ".gt", ".ge", ".eq", ".ne", ".default", ".cbor",
+			".cborseq":
+		default:
+			return nil, p.errorf(op, "unsupported control operator")
+		}
+	default:
+		return t, nil
+	}
+	p.next()
+	target, err := p.type2()
+	if err != nil {
+		return nil, err
+	}
+	r := &type1{kind: kParen, inner: &typ{choices: []*type1{t}}, op: op.text, target: target}
+	if op.text == ".." || op.text == "..." {
+		if t.kind != kValue || target.kind != kValue || !t.value.numeric() || !target.value.numeric() {
+			return nil, &SyntaxError{op.line, op.col, "ranges must be between numbers"}
+		}
+	}
+	if op.text == ".regexp" {
+		if target.kind != kValue || target.value.kind != vText {
+			return nil, &SyntaxError{op.line, op.col, ".regexp needs a text string"}
+		}
+		if r.re, err = regexp.Compile(`^(?:` + target.value.text + `)$`); err != nil {
+			return nil, &SyntaxError{op.line, op.col, err.Error()}
+		}
+	}
+	r.src = p.source(start)
+	return r, nil
+}
+
+// source returns the source from the token `start` to the last one read.
+func (p *parser) source(start tok) string {
+	last := p.toks[p.pos-1]
+	return p.src[start.off : last.off+len(last.text)]
+}
+
+func (p *parser) type2() (*type1, error) {
+	t := p.next()
+	switch t.kind {
+	case tNumber, tText, tBytes:
+		v, err := parseValue(t)
+		if err != nil {
+			return nil, err
+		}
+		return &type1{kind: kValue, value: v}, nil
+	case tName:
+		if p.is("<") {
+			return nil, p.errorf(p.peek(), "generic arguments are not supported")
+		}
+		return &type1{kind: kRef, name: t.text}, nil
+	case tHash:
+		return parseHash(p, t)
+	case tSym:
+		switch t.text {
+		case "(":
+			inner, err := p.typ()
+			if err != nil {
+				return nil, err
+			}
+			return &type1{kind: kParen, inner: inner}, p.expect(")")
+		case "{", "[":
+			k, end := kMap, "}"
+			if t.text == "[" {
+				k, end = kArray, "]"
+			}
+			g, err := p.group(end)
+			if err != nil {
+				return nil, err
+			}
+			return &type1{kind: k, group: g}, nil
+		case "~":
+			name := p.next()
+			if name.kind != tName {
+				return nil, p.errorf(name, "expected rule name")
+			}
+			return &type1{kind: kUnwrap, name: name.text}, nil
+		case "&":
+			if p.is("(") {
+				p.next()
+				g, err := p.group(")")
+				if err != nil {
+					return nil, err
+				}
+				return &type1{kind: kEnum, group: g}, nil
+			}
+			name := p.next()
+			if name.kind != tName {
+				return nil, p.errorf(name, "expected group name")
+			}
+			return &type1{kind: kEnum, name: name.text}, nil
+		}
+	}
+	return nil, p.errorf(t, "expected type")
+}
+
+func parseHash(p *parser, t tok) (*type1, error) {
+	if t.text == "#" {
+		return &type1{kind: kAny}, nil
+	}
+	major := t.text[1] - '0'
+	var arg *uint64
+	if len(t.text) > 3 {
+		n, err := strconv.ParseUint(t.text[3:], 10, 64)
+		if err != nil {
+			return nil, &SyntaxError{t.line, t.col, err.Error()}
+		}
+		arg = &n
+	}
+	if major != 6 {
+		return &type1{kind: kMajor, major: major, arg: arg}, nil
+	}
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+	inner, err := p.typ()
+	if err != nil {
+		return nil, err
+	}
+	return &type1{kind: kTag, arg: arg, inner: inner}, p.expect(")")
+}
+
+func parseValue(t tok) (*value, error) {
+	switch t.kind {
+	case tNumber:
+		s := t.text
+		neg := strings.HasPrefix(s, "-")
+		s = strings.TrimPrefix(s, "-")
+		if strings.ContainsAny(s, ".eE") && !strings.HasPrefix(s, "0x") {
+			f, err := strconv.ParseFloat(t.text, 64)
+			if err != nil {
+				return nil, &SyntaxError{t.line, t.col, err.Error()}
+			}
+			return &value{kind: vFloat, float: f}, nil
+		}
+		i, ok := new(big.Int).SetString(s, 0)
+		if !ok {
+			return nil, &SyntaxError{t.line, t.col, "invalid number " + t.text}
+		}
+		if neg {
+			i.Neg(i)
+		}
+		return &value{kind: vInt, int: i}, nil
+	case tText:
+		s, err := strconv.Unquote(t.text)
+		if err != nil {
+			return nil, &SyntaxError{t.line, t.col, "invalid text string " + t.text}
+		}
+		return &value{kind: vText, text: s}, nil
+	default:
+		q := strings.IndexByte(t.text, '\'')
+		body := t.text[q+1 : len(t.text)-1]
+		var b []byte
+		var err error
+		switch t.text[:q] {
+		case "h":
+			b, err = hex.DecodeString(strings.Join(strings.Fields(body), ""))
+		case "b64":
+			b, err = base64.URLEncoding.DecodeString(body)
+			if err != nil {
+				b, err = base64.StdEncoding.DecodeString(body)
+			}
+		default:
+			b = []byte(body)
+		}
+		if err != nil {
+			return nil, &SyntaxError{t.line, t.col, "invalid byte string " + t.text}
+		}
+		return &value{kind: vBytes, text: string(b)}, nil
+	}
+}
+
+func (v *value) numeric() bool { return v.kind == vInt || v.kind == vFloat }
+
+// group parses a group up to the closing symbol `end`, or a single group choice at the top level
+// of a rule if `end` is empty.
+func (p *parser) group(end string) (*group, error) {
+	g := &group{choices: [][]*groupEntry{nil}}
+	for {
+		if end != "" && p.is(end) {
+			p.next()
+			return g, nil
+		}
+		if end == "" && p.atRuleEnd() {
+			return g, nil
+		}
+		if p.is("//") {
+			p.next()
+			g.choices = append(g.choices, nil)
+			continue
+		}
+		if p.peek().kind == tEOF {
+			return nil, p.errorf(p.peek(), "expected %q", end)
+		}
+		e, err := p.entry()
+		if err != nil {
+			return nil, err
+		}
+		last := len(g.choices) - 1
+		g.choices[last] = append(g.choices[last], e)
+		if p.is(",") {
+			p.next()
+		}
+	}
+}
+
+func (p *parser) entry() (*groupEntry, error) {
+	e := &groupEntry{min: 1, max: 1}
+	t := p.peek()
+	switch {
+	case t.kind == tSym && t.text == "?":
+		p.next()
+		e.min = 0
+	case t.kind == tSym && t.text == "+":
+		p.next()
+		e.max = -1
+	case t.kind == tSym && t.text == "*", t.kind == tNumber && p.peekAt(1).text == "*":
+		e.min, e.max = 0, -1
+		if t.kind == tNumber {
+			n, err := strconv.Atoi(p.next().text)
+			if err != nil || n < 0 {
+				return nil, p.errorf(t, "invalid occurrence")
+			}
+			e.min = n
+		}
+		p.next()
+		if n := p.peek(); n.kind == tNumber && !p.isKeyAt(1) {
+			m, err := strconv.Atoi(p.next().text)
+			if err != nil || m < e.min {
+				return nil, p.errorf(n, "invalid occurrence")
+			}
+			e.max = m
+		}
+	}
+
+	// Member key.
+	t = p.peek()
+	if (t.kind == tName || t.kind == tText || t.kind == tNumber || t.kind == tBytes) && p.isKeyAt(1) &&
+		p.peekAt(1).text == ":" {
+		p.next()
+		p.next()
+		v := &value{kind: vText, text: t.text}
+		if t.kind != tName {
+			var err error
+			if v, err = parseValue(t); err != nil {
+				return nil, err
+			}
+		}
+		// Keys given as barewords and values always cut.
+		e.key = &keyType{typ: &type1{kind: kValue, value: v, src: t.text}, cut: true}
+	} else if start := p.pos; !p.is("(") {
+		k, err := p.type1()
+		if err == nil && (p.is("^") || p.is("=>")) {
+			cut := p.is("^")
+			if cut {
+				p.next()
+			}
+			if err := p.expect("=>"); err != nil {
+				return nil, err
+			}
+			e.key = &keyType{typ: k, cut: cut}
+		} else {
+			p.pos = start
+		}
+	}
+
+	if e.key == nil && p.is("(") {
+		start := p.pos
+		p.next()
+		g, err := p.group(")")
+		if err != nil {
+			return nil, err
+		}
+		if !g.isType() || p.is("/") || p.is("..") || p.is("...") || p.peek().kind == tCtl {
+			if !g.isType() {
+				e.group = g
+				return e, nil
+			}
+			p.pos = start
+		} else {
+			e.typ = g.choices[0][0].typ
+			return e, nil
+		}
+	}
+	var err error
+	e.typ, err = p.typ()
+	return e, err
+}
+
+// isKeyAt tells whether the token at `i` ends a member key.
+func (p *parser) isKeyAt(i int) bool {
+	t := p.peekAt(i)
+	return t.kind == tSym && (t.text == ":" || t.text == "=>" || t.text == "^")
+}
+
+// check verifies that all rules referred to are defined.
+func (s *Schema) check() error {
+	var err error
+	var visitType func(t *typ)
+	var visitGroup func(g *group)
+	ref := func(name string, group bool) {
+		_, isType := s.types[name]
+		_, isGroup := s.groups[name]
+		if err == nil && !isType && !isGroup {
+			err = &SyntaxError{Line: 1, Col: 1, Msg: fmt.Sprintf("undefined rule %s", name)}
+		}
+		if err == nil && group && !isGroup {
+			err = &SyntaxError{Line: 1, Col: 1, Msg: fmt.Sprintf("rule %s is not a group", name)}
+		}
+	}
+	visitType1 := func(t *type1) {
+		switch t.kind {
+		case kRef:
+			ref(t.name, false)
+		case kUnwrap:
+			ref(t.name, false)
+		case kEnum:
+			if t.group == nil {
+				ref(t.name, true)
+			}
+		}
+		if t.inner != nil {
+			visitType(t.inner)
+		}
+		if t.group != nil {
+			visitGroup(t.group)
+		}
+	}
+	visitType = func(t *typ) {
+		for _, c := range t.choices {
+			visitType1(c)
+			if c.target != nil {
+				visitType1(c.target)
+			}
+		}
+	}
+	visitGroup = func(g *group) {
+		for _, c := range g.choices {
+			for _, e := range c {
+				if e.key != nil {
+					visitType1(e.key.typ)
+				}
+				if e.typ != nil {
+					visitType(e.typ)
+				}
+				if e.group != nil {
+					visitGroup(e.group)
+				}
+			}
+		}
+	}
+	for _, name := range s.names {
+		if t, ok := s.types[name]; ok {
+			visitType(t)
+		} else {
+			visitGroup(s.groups[name])
+		}
+	}
+	return err
+}
@@ -0,0 +1,635 @@
+package cddl
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"github.com/daotl/go-marsha"
+	"github.com/daotl/go-marsha/internal/cbor"
+)
+
+var (
+	ErrInvalid    = errors.New("CBOR does not match the CDDL schema")
+	ErrNoSuchRule = errors.New("no such rule")
+)
+
+// Violation is a data item which doesn't match a schema.
+type Violation struct {
+	// Path locates the item from the top-level one with the map keys and array indexes leading to
+	// it, like "/items/3/name", or is empty for the top-level item.
+	Path   string
+	Offset int
+	Msg    string
+
+	// mismatch tells whether the item doesn't even have the kind the type expects, so that a
+	// choice of types reports the alternatives instead.
+	mismatch bool
+}
+
+func (v Violation) String() string {
+	if v.Path == "" {
+		return fmt.Sprintf("%s at offset %d", v.Msg, v.Offset)
+	}
+	return fmt.Sprintf("%s: %s at offset %d", v.Path, v.Msg, v.Offset)
+}
+
+// ValidationError lists the violations of a schema found in CBOR.
+type ValidationError struct {
+	Violations []Violation
+}
+
+func (e *ValidationError) Error() string {
+	msg := fmt.Sprintf("%s: %s", ErrInvalid, e.Violations[0])
+	if n := len(e.Violations) - 1; n > 0 {
+		msg += fmt.Sprintf(" (and %d more)", n)
+	}
+	return msg
+}
+
+func (e *ValidationError) Unwrap() error { return ErrInvalid }
+
+// Validate validates the single CBOR data item `b` against the root rule of `s`. It returns a
+// *ValidationError listing the violations found if `b` doesn't match.
+func (s *Schema) Validate(b []byte) error {
+	return s.ValidateRule(s.root, b)
+}
+
+// ValidateRule is like Validate but validates against the rule `name`.
+func (s *Schema) ValidateRule(name string, b []byte) error {
+	t, ok := s.types[name]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrNoSuchRule, name)
+	}
+	return s.validate(t, b)
+}
+
+// validate validates the single CBOR data item `b` against `t`.
+func (s *Schema) validate(t *typ, b []byte) error {
+	end, err := cbor.Skip(b, 0)
+	if err != nil {
+		return err
+	}
+	if end != len(b) {
+		return &marsha.StrictError{Offset: end, Err: marsha.ErrTrailingData}
+	}
+	m := &matcher{s: s, b: b}
+	if vs := m.typ(t, 0, ""); len(vs) > 0 {
+		return &ValidationError{Violations: vs}
+	}
+	return nil
+}
+
+// matcher matches the well-formed CBOR `b` against types of `s`.
+type matcher struct {
+	s     *Schema
+	b     []byte
+	depth int
+}
+
+func (m *matcher) head(off int) cbor.Head {
+	h, _ := cbor.ReadHead(m.b, off)
+	return h
+}
+
+func (m *matcher) end(off int) int {
+	end, _ := cbor.Skip(m.b, off)
+	return end
+}
+
+// items returns the offsets of the elements of the array or of the keys and values of the map
+// at `off`.
+func (m *matcher) items(off int) []int {
+	h := m.head(off)
+	var items []int
+	for i, p := uint64(0), h.End(); ; i++ {
+		if h.Indefinite() && m.b[p] == cbor.Break || !h.Indefinite() && i == h.Arg*uint64(1+h.Major-cbor.MajArray) {
+			return items
+		}
+		items = append(items, p)
+		p = m.end(p)
+	}
+}
+
+// str returns the contents of the byte or text string at `off`, joining indefinite-length chunks.
+func (m *matcher) str(off int) []byte {
+	h := m.head(off)
+	if !h.Indefinite() {
+		return m.b[h.End() : h.End()+int(h.Arg)]
+	}
+	var s []byte
+	for _, c := range m.items(off) {
+		s = append(s, m.str(c)...)
+	}
+	return s
+}
+
+// number returns the integer or float at `off`.
+func (m *matcher) number(off int) (*big.Int, float64, bool) {
+	h := m.head(off)
+	switch {
+	case h.Major == cbor.MajUnsignedInt:
+		return new(big.Int).SetUint64(h.Arg), 0, true
+	case h.Major == cbor.MajNegativeInt:
+		i := new(big.Int).SetUint64(h.Arg)
+		return i.Neg(i).Sub(i, big.NewInt(1)), 0, true
+	case h.IsFloat():
+		return nil, h.Float(), true
+	}
+	return nil, 0, false
+}
+
+// compare compares the number at `off` with `v`, returning false if they aren't comparable.
+func (m *matcher) compare(off int, v *value) (int, bool) {
+	i, f, ok := m.number(off)
+	if !ok || !v.numeric() {
+		return 0, false
+	}
+	if i != nil && v.kind == vInt {
+		return i.Cmp(v.int), true
+	}
+	x, y := f, v.float
+	if i != nil {
+		x, _ = new(big.Float).SetInt(i).Float64()
+	}
+	if v.kind == vInt {
+		y, _ = new(big.Float).SetInt(v.int).Float64()
+	}
+	if math.IsNaN(x) || math.IsNaN(y) {
+		return 0, false
+	}
+	switch {
+	case x < y:
+		return -1, true
+	case x > y:
+		return 1, true
+	}
+	return 0, true
+}
+
+// key returns the path segment of the map key at `off`.
+func (m *matcher) key(off int) string {
+	h := m.head(off)
+	switch h.Major {
+	case cbor.MajTextString:
+		return strings.NewReplacer("~", "~0", "/", "~1").Replace(string(m.str(off)))
+	case cbor.MajUnsignedInt, cbor.MajNegativeInt:
+		i, _, _ := m.number(off)
+		return i.String()
+	}
+	return fmt.Sprintf("@%d", off)
+}
+
+func mismatch(path string, off int, format string, args ...interface{}) []Violation {
+	return []Violation{{Path: path, Offset: off, Msg: fmt.Sprintf(format, args...), mismatch: true}}
+}
+
+func violation(path string, off int, format string, args ...interface{}) []Violation {
+	return []Violation{{Path: path, Offset: off, Msg: fmt.Sprintf(format, args...)}}
+}
+
+// typ returns the violations of the type `t` by the item at `off`, none if it matches.
+func (m *matcher) typ(t *typ, off int, path string) []Violation {
+	if len(t.choices) == 1 {
+		return m.type1(t.choices[0], off, path)
+	}
+	var deep [][]Violation
+	for _, c := range t.choices {
+		vs := m.type1(c, off, path)
+		if len(vs) == 0 {
+			return nil
+		}
+		if !(len(vs) == 1 && vs[0].mismatch) {
+			deep = append(deep, vs)
+		}
+	}
+	// Report the violations inside the only alternative the item has the kind of.
+	if len(deep) == 1 {
+		return deep[0]
+	}
+	return mismatch(path, off, "expected %s", t.src())
+}
+
+func (t *typ) src() string {
+	srcs := make([]string, 0, len(t.choices))
+	for _, c := range t.choices {
+		srcs = append(srcs, c.src)
+	}
+	return strings.Join(srcs, " / ")
+}
+
+func (m *matcher) type1(t *type1, off int, path string) []Violation {
+	m.depth++
+	defer func() { m.depth-- }()
+	if m.depth > cbor.MaxDepth {
+		return violation(path, off, "schema recursion deeper than %d", cbor.MaxDepth)
+	}
+	h := m.head(off)
+	switch t.kind {
+	case kAny:
+		return nil
+	case kValue:
+		if !m.equal(off, t.value) {
+			return mismatch(path, off, "expected %s", t.src)
+		}
+		return nil
+	case kRef:
+		rt, ok := m.s.types[t.name]
+		if !ok {
+			return violation(path, off, "group %s used as a type", t.name)
+		}
+		vs := m.typ(rt, off, path)
+		if len(vs) == 1 && vs[0].mismatch && vs[0].Offset == off && vs[0].Path == path {
+			// Name the rule rather than its definition.
+			return mismatch(path, off, "expected %s", t.name)
+		}
+		return vs
+	case kParen:
+		if t.op == ".." || t.op == "..." {
+			// The operands of ranges are bounds rather than types.
+			return m.control(t, off, path)
+		}
+		if vs := m.typ(t.inner, off, path); len(vs) > 0 || t.op == "" {
+			return vs
+		}
+		return m.control(t, off, path)
+	case kMap:
+		if h.Major != cbor.MajMap {
+			return mismatch(path, off, "expected a map")
+		}
+		return m.mapGroup(t.group, off, path)
+	case kArray:
+		if h.Major != cbor.MajArray {
+			return mismatch(path, off, "expected an array")
+		}
+		return m.arrayGroup(t.group, off, path)
+	case kUnwrap:
+		rt := m.s.types[t.name]
+		if len(rt.choices) == 1 && rt.choices[0].kind == kTag {
+			return m.typ(rt.choices[0].inner, off, path)
+		}
+		return m.typ(rt, off, path)
+	case kEnum:
+		g := t.group
+		if g == nil {
+			g = m.s.groups[t.name]
+		}
+		for _, c := range g.choices {
+			for _, e := range c {
+				if e.typ != nil && len(m.typ(e.typ, off, path)) == 0 {
+					return nil
+				}
+			}
+		}
+		return mismatch(path, off, "expected %s", t.src)
+	case kTag:
+		if h.Major != cbor.MajTag || t.arg != nil && h.Arg != *t.arg {
+			return mismatch(path, off, "expected %s", t.src)
+		}
+		return m.typ(t.inner, h.End(), path)
+	case kMajor:
+		if h.Major != t.major {
+			return mismatch(path, off, "expected %s", t.src)
+		}
+		if t.arg != nil {
+			if h.Major == cbor.MajOther && uint64(h.Info) != *t.arg && !(h.Info == 24 && h.Arg == *t.arg) ||
+				h.Major != cbor.MajOther && h.Arg != *t.arg {
+				return mismatch(path, off, "expected %s", t.src)
+			}
+		}
+		return nil
+	}
+	panic("unreachable")
+}
+
+// equal tells whether the item at `off` equals `v`.
+func (m *matcher) equal(off int, v *value) bool {
+	h := m.head(off)
+	switch v.kind {
+	case vText:
+		return h.Major == cbor.MajTextString && string(m.str(off)) == v.text
+	case vBytes:
+		return h.Major == cbor.MajByteString && string(m.str(off)) == v.text
+	case vInt:
+		i, _, ok := m.number(off)
+		return ok && i != nil && i.Cmp(v.int) == 0
+	default:
+		_, f, ok := m.number(off)
+		return ok && h.IsFloat() && f == v.float
+	}
+}
+
+// control applies the range or control operator of `t` to the item at `off`, which matches its
+// left operand.
+func (m *matcher) control(t *type1, off int, path string) []Violation {
+	h := m.head(off)
+	target := t.target
+	switch t.op {
+	case "..", "...":
+		lo, ok1 := m.compare(off, t.inner.choices[0].value)
+		hi, ok2 := m.compare(off, target.value)
+		if !ok1 || !ok2 {
+			return mismatch(path, off, "expected %s", t.src)
+		}
+		if lo < 0 || hi > 0 || t.op == "..." && hi == 0 {
+			return violation(path, off, "not in %s", t.src)
+		}
+	case ".size":
+		var n *big.Int
+		switch h.Major {
+		case cbor.MajByteString, cbor.MajTextString:
+			n = big.NewInt(int64(len(m.str(off))))
+		case cbor.MajUnsignedInt:
+			// The value must fit in the size in bytes.
+			bits := new(big.Int).SetUint64(h.Arg).BitLen()
+			n = big.NewInt(int64((bits + 7) / 8))
+			if !m.sizeFits(target, n, true) {
+				return violation(path, off, "does not fit %s", t.src)
+			}
+			return nil
+		default:
+			return violation(path, off, "%s applies to strings and unsigned integers", t.op)
+		}
+		if !m.sizeFits(target, n, false) {
+			return violation(path, off, "size %s does not match %s", n, t.src)
+		}
+	case ".regexp":
+		if h.Major != cbor.MajTextString || !t.re.Match(m.str(off)) {
+			return violation(path, off, "does not match %s", t.src)
+		}
+	case ".lt", ".le", ".gt", ".ge", ".eq", ".ne":
+		var ok bool
+		if target.kind == kValue && target.value.numeric() {
+			var c int
+			if c, ok = m.compare(off, target.value); ok {
+				switch t.op {
+				case ".lt":
+					ok = c < 0
+				case ".le":
+					ok = c <= 0
+				case ".gt":
+					ok = c > 0
+				case ".ge":
+					ok = c >= 0
+				case ".eq":
+					ok = c == 0
+				case ".ne":
+					ok = c != 0
+				}
+			}
+		} else if target.kind == kValue && (t.op == ".eq" || t.op == ".ne") {
+			ok = m.equal(off, target.value) == (t.op == ".eq")
+		}
+		if !ok {
+			return violation(path, off, "does not satisfy %s", t.src)
+		}
+	case ".default":
+	case ".cbor", ".cborseq":
+		if h.Major != cbor.MajByteString {
+			return violation(path, off, "%s applies to byte strings", t.op)
+		}
+		inner := &matcher{s: m.s, b: m.str(off), depth: m.depth}
+		for p, i := 0, 0; p < len(inner.b) || t.op == ".cbor" && i == 0; i++ {
+			end, err := cbor.Skip(inner.b, p)
+			if err != nil {
+				return violation(path, off, "embedded CBOR is invalid: %v", err)
+			}
+			if t.op == ".cbor" && end != len(inner.b) {
+				return violation(path, off, "embedded CBOR has trailing data")
+			}
+			elemPath := path
+			if t.op == ".cborseq" {
+				elemPath += "/" + strconv.Itoa(i)
+			}
+			tt := &typ{choices: []*type1{target}}
+			if vs := inner.typ(tt, p, elemPath); len(vs) > 0 {
+				for i := range vs {
+					vs[i].Msg += fmt.Sprintf(" in embedded CBOR at offset %d", off)
+				}
+				return vs
+			}
+			p = end
+		}
+	}
+	return nil
+}
+
+// sizeFits tells whether the size `n` matches the target of a ".size" control, a number or a
+// range. For unsigned integers, `n` fits any larger size.
+func (m *matcher) sizeFits(target *type1, n *big.Int, atMost bool) bool {
+	switch {
+	case target.kind == kValue && target.value.kind == vInt:
+		if atMost {
+			return n.Cmp(target.value.int) <= 0
+		}
+		return n.Cmp(target.value.int) == 0
+	case target.kind == kParen && (target.op == ".." || target.op == "..."):
+		lo, hi := target.inner.choices[0].value, target.target.value
+		if lo.kind != vInt || hi.kind != vInt {
+			return false
+		}
+		c := n.Cmp(hi.int)
+		return (atMost || n.Cmp(lo.int) >= 0) && (c < 0 || c == 0 && target.op == "..")
+	case target.kind == kParen && target.op == "" && len(target.inner.choices) == 1:
+		return m.sizeFits(target.inner.choices[0], n, atMost)
+	case target.kind == kRef:
+		if rt, ok := m.s.types[target.name]; ok && len(rt.choices) == 1 {
+			return m.sizeFits(rt.choices[0], n, atMost)
+		}
+	}
+	return false
+}
+
+// groupOf returns the group an entry without a key stands for, if it is one: a group in
+// parentheses, a group rule or an unwrapped map or array rule.
+func (m *matcher) groupOf(e *groupEntry) *group {
+	if e.group != nil {
+		return e.group
+	}
+	if e.key != nil || len(e.typ.choices) != 1 {
+		return nil
+	}
+	t := e.typ.choices[0]
+	switch t.kind {
+	case kRef:
+		return m.s.groups[t.name]
+	case kUnwrap:
+		rt := m.s.types[t.name]
+		if len(rt.choices) == 1 && (rt.choices[0].kind == kMap || rt.choices[0].kind == kArray) {
+			return rt.choices[0].group
+		}
+	}
+	return nil
+}
+
+// arrayGroup matches the elements of the array at `off` against the group `g`, backtracking over
+// occurrences and choices.
+func (m *matcher) arrayGroup(g *group, off int, path string) []Violation {
+	items := m.items(off)
+	a := &arrayMatch{m: m, items: items, path: path, failAt: -1}
+	if a.group(g, 0, func(j int) bool { return j == len(items) }) {
+		return nil
+	}
+	switch {
+	case a.missing != nil:
+		return violation(path, off, "missing element %s", a.missing.src())
+	case a.fail != nil:
+		return a.fail
+	default:
+		return violation(path+"/"+strconv.Itoa(a.furthest), items[a.furthest], "unexpected element")
+	}
+}
+
+type arrayMatch struct {
+	m     *matcher
+	items []int
+	path  string
+	// furthest is the index of the furthest element reached, fail the violations of the furthest
+	// element which didn't match.
+	furthest int
+	failAt   int
+	fail     []Violation
+	// missing is the entry which needed more elements than there are.
+	missing *groupEntry
+}
+
+func (a *arrayMatch) group(g *group, j int, k func(int) bool) bool {
+	for _, c := range g.choices {
+		if a.entries(c, j, k) {
+			return true
+		}
+	}
+	return false
+}
+
+// entries matches `es` from element `j`, calling the continuation `k` with the index of the
+// element after them until it accepts.
+func (a *arrayMatch) entries(es []*groupEntry, j int, k func(int) bool) bool {
+	if j > a.furthest {
+		a.furthest = j
+	}
+	if len(es) == 0 {
+		return k(j)
+	}
+	return a.occur(es[0], 0, j, func(j int) bool { return a.entries(es[1:], j, k) })
+}
+
+// occur matches the entry `e`, which already matched `n` times, from element `j` as many times
+// as possible.
+func (a *arrayMatch) occur(e *groupEntry, n, j int, k func(int) bool) bool {
+	if e.max < 0 || n < e.max {
+		if g := a.m.groupOf(e); g != nil {
+			if a.group(g, j, func(next int) bool { return next > j && a.occur(e, n+1, next, k) }) {
+				return true
+			}
+		} else if j < len(a.items) {
+			vs := a.m.typ(e.typ, a.items[j], a.path+"/"+strconv.Itoa(j))
+			if len(vs) == 0 {
+				if a.occur(e, n+1, j+1, k) {
+					return true
+				}
+			} else if j >= a.failAt {
+				a.failAt, a.fail, a.missing = j, vs, nil
+			}
+		} else if n < e.min && a.failAt < j {
+			a.failAt, a.missing = j, e
+		}
+	}
+	return n >= e.min && k(j)
+}
+
+// mapGroup matches the pairs of the map at `off` against the group `g`.
+func (m *matcher) mapGroup(g *group, off int, path string) []Violation {
+	items := m.items(off)
+	var best []Violation
+	for i, c := range g.choices {
+		used := make([]bool, len(items)/2)
+		vs := m.mapEntries(c, off, items, used, path)
+		for p, u := range used {
+			if !u {
+				k := items[2*p]
+				vs = append(vs, violation(path+"/"+m.key(k), k, "unexpected key")...)
+			}
+		}
+		if len(vs) == 0 {
+			return nil
+		}
+		if i == 0 || len(vs) < len(best) {
+			best = vs
+		}
+	}
+	return best
+}
+
+func (m *matcher) mapEntries(es []*groupEntry, off int, items []int, used []bool, path string) []Violation {
+	var vs []Violation
+	for _, e := range es {
+		if g := m.groupOf(e); g != nil {
+			saved := append([]bool(nil), used...)
+			gvs := m.mapNested(g, off, items, used, path)
+			if len(gvs) > 0 && e.min == 0 {
+				// An optional group which isn't there.
+				copy(used, saved)
+				continue
+			}
+			vs = append(vs, gvs...)
+			continue
+		}
+		if e.key == nil {
+			vs = append(vs, violation(path, off, "entry %s has no key", e.typ.src())...)
+			continue
+		}
+		n := 0
+		for p := range used {
+			if used[p] || e.max >= 0 && n >= e.max {
+				continue
+			}
+			k, v := items[2*p], items[2*p+1]
+			if len(m.type1(e.key.typ, k, path)) > 0 {
+				continue
+			}
+			evs := m.typ(e.typ, v, path+"/"+m.key(k))
+			if len(evs) > 0 && !e.key.cut {
+				continue
+			}
+			used[p] = true
+			n++
+			vs = append(vs, evs...)
+		}
+		if n < e.min {
+			vs = append(vs, violation(path, off, "missing key %s", e.key.typ.src)...)
+		}
+	}
+	return vs
+}
+
+// mapNested matches a group nested in a map group, trying its choices in turn.
+func (m *matcher) mapNested(g *group, off int, items []int, used []bool, path string) []Violation {
+	var best []Violation
+	saved := append([]bool(nil), used...)
+	for i, c := range g.choices {
+		copy(used, saved)
+		vs := m.mapEntries(c, off, items, used, path)
+		if len(vs) == 0 {
+			return nil
+		}
+		if i == 0 || len(vs) < len(best) {
+			best = vs
+		}
+	}
+	return best
+}
+
+// src returns the source of the entry, for messages.
+func (e *groupEntry) src() string {
+	if e.group != nil || e.typ == nil {
+		return "group"
+	}
+	if e.key != nil && e.key.cut && e.key.typ.kind == kValue {
+		return e.key.typ.src + ": " + e.typ.src()
+	}
+	return e.typ.src()
+}
@@ -0,0 +1,216 @@
+package cddl_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/daotl/go-marsha"
+	cbor_refmt "github.com/daotl/go-marsha/cbor-refmt"
+	"github.com/daotl/go-marsha/cborgen"
+	"github.com/daotl/go-marsha/cddl"
+	"github.com/daotl/go-marsha/test"
+)
+
+func modelsSchema(t *testing.T) *cddl.Schema {
+	src, err := os.ReadFile("../test/models.cddl")
+	require.NoError(t, err)
+	s, err := cddl.Parse(string(src))
+	require.NoError(t, err)
+	return s
+}
+
+func violations(t *testing.T, err error) []string {
+	var verr *cddl.ValidationError
+	require.True(t, errors.As(err, &verr), "%v", err)
+	assert.True(t, errors.Is(err, cddl.ErrInvalid))
+	var vs []string
+	for _, v := range verr.Violations {
+		vs = append(vs, v.String())
+	}
+	return vs
+}
+
+func TestValidateModels(t *testing.T) {
+	s := modelsSchema(t)
+	assert.Equal(t, "TestStruct", s.Rules()[0])
+	mrsh := cborgen.New()
+	for _, c := range []struct {
+		rule string
+		p    marsha.StructPtr
+	}{
+		{"TestStruct", &test.TestStruct{Data: "test"}},
+		{"TestLinked", &test.TestLinked{Name: "a"}},
+		{"TestStructV2", &test.TestStructV2{Name: "a", Child: &test.TestChildV2{ID: 1, Note: "n"}, Age: 3}},
+		{"TestStructV2", &test.TestStructV2{Name: "a", Age: -3}},
+		// Later versions match the rules of earlier ones keeping unknown fields.
+		{"TestStructV1", &test.TestStructV2{Name: "a", Child: &test.TestChildV2{ID: 1}, Age: 3}},
+	} {
+		bin, err := mrsh.MarshalStruct(c.p)
+		require.NoError(t, err)
+		assert.NoError(t, s.ValidateRule(c.rule, bin), c.rule)
+	}
+
+	bin, err := mrsh.MarshalStruct(&test.TestStructV2{Name: "a", Child: &test.TestChildV2{ID: 1}, Age: 3})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"/0: expected int at offset 1"}, violations(t, s.ValidateRule("TestChildV2", bin)))
+	assert.Equal(t, []string{"/1: unexpected element at offset 3"}, violations(t, s.Validate(bin)))
+	bin, err = mrsh.MarshalStruct(&test.TestPersonV2{Version: 2, First: "a"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"missing element Age: int at offset 0"},
+		violations(t, s.ValidateRule("TestPerson", bin)))
+
+	assert.True(t, errors.Is(s.ValidateRule("Nope", bin), cddl.ErrNoSuchRule))
+	var serr *marsha.StrictError
+	require.True(t, errors.As(s.ValidateRule("TestPersonV2", append(bin, 0)), &serr))
+	assert.Equal(t, len(bin), serr.Offset)
+	assert.True(t, errors.Is(s.ValidateRule("TestPersonV2", bin[:3]), marsha.ErrInvalidCBOR))
+}
+
+func TestValidateUnion(t *testing.T) {
+	g := cddl.NewGenerator(cddl.Tuple)
+	require.NoError(t, g.AddUnion("Union", test.NewRegistry()))
+	s, err := cddl.Parse(g.String())
+	require.NoError(t, err)
+
+	mrsh := cborgen.New(marsha.WithRegistry(test.NewRegistry()))
+	bin, err := mrsh.MarshalStruct(&marsha.Union{Value: &test.TestStruct{Data: "a"}})
+	require.NoError(t, err)
+	assert.NoError(t, s.ValidateRule("Union", bin))
+	bin, err = mrsh.MarshalStruct(&marsha.Union{Value: &test.TestStruct2{Data2: 2}})
+	require.NoError(t, err)
+	assert.NoError(t, s.ValidateRule("Union", bin))
+	bin, err = mrsh.MarshalStruct(&test.TestStruct{Data: "a"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"expected #6.80001(TestStruct) / [\"test2\", TestStruct2] at offset 0"},
+		violations(t, s.ValidateRule("Union", bin)))
+}
+
+type m = map[string]interface{}
+type a = []interface{}
+
+const orders = `
+order = {
+  id: uint,
+  items: [+ item],
+  ? note: tstr .size (1..10),
+  ? status: "open" / "closed" / &codes,
+  * tstr => any
+}
+item = {sku: tstr .regexp "[A-Z]+-[0-9]+", qty: 1..100}
+codes = (cancelled: 10, refunded: 11)
+`
+
+func TestValidate(t *testing.T) {
+	s := cddl.MustParse(orders)
+	assert.Equal(t, []string{"order", "item", "codes"}, s.Rules())
+	mrsh := cbor_refmt.New()
+	for _, c := range []struct {
+		order m
+		want  []string
+	}{
+		{m{"id": 1, "items": a{m{"sku": "AB-1", "qty": 2}}, "note": "hi", "status": 10, "extra": true}, nil},
+		{m{"id": 1, "items": a{m{"sku": "AB-1", "qty": 2}}, "status": "closed"}, nil},
+		{m{"id": 1, "items": a{m{"sku": "AB-1", "qty": 2}, m{"sku": "AB-1", "qty": 200}}, "note": "hello world!"},
+			[]string{"/items/1/qty: not in 1..100 at offset 50", "/note: size 12 does not match tstr .size (1..10) at offset 10"}},
+		{m{"id": -1, "items": a{}},
+			[]string{"/id: expected uint at offset 4", "/items: missing element item at offset 11"}},
+		{m{"items": a{m{"sku": "ab-1", "qty": 1}}},
+			[]string{"missing key id at offset 0", `/items/0/sku: does not match tstr .regexp "[A-Z]+-[0-9]+" at offset 18`}},
+		{m{"id": 1, "items": a{m{"sku": "AB-1", "qty": 2, "x": 1}}, "status": 12},
+			[]string{`/status: expected "open" / "closed" / &codes at offset 37`, "/items/0/x: unexpected key at offset 13"}},
+	} {
+		bin, err := mrsh.MarshalPrimitive(&c.order)
+		require.NoError(t, err)
+		if err := s.Validate(bin); c.want == nil {
+			assert.NoError(t, err)
+		} else {
+			assert.ElementsMatch(t, c.want, violations(t, err))
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for src, want := range map[string]string{
+		"":                       "invalid CDDL at 1:1: no rules",
+		"a = [b":                 `invalid CDDL at 1:7: expected "]" at end of input`,
+		"a = b":                  "invalid CDDL at 1:1: undefined rule b",
+		"a = int\na = tstr":      "invalid CDDL at 2:1: rule a defined twice",
+		"a = tstr .bits b":       "invalid CDDL at 1:10: unsupported control operator, got \".bits\"",
+		"a = tstr .regexp \"[\"": "invalid CDDL at 1:10: error parsing regexp: missing closing ]: `[)$`",
+		"a<t> = [t]":             "invalid CDDL at 1:2: generic rules are not supported, got \"<\"",
+	} {
+		_, err := cddl.Parse(src)
+		if assert.Error(t, err, src) {
+			assert.True(t, errors.Is(err, cddl.ErrSyntax))
+			assert.Equal(t, want, err.Error(), src)
+		}
+	}
+}
+
+func TestMarsha(t *testing.T) {
+	test.SubTestAll(t, cddl.New(cborgen.New(), modelsSchema(t)))
+
+	mrsh := cddl.New(cborgen.New(), modelsSchema(t))
+	bin, err := mrsh.MarshalStruct(&test.TestStruct2{Data2: 2})
+	require.NoError(t, err)
+	_, err = mrsh.UnmarshalStruct(bin, &test.TestStruct{})
+	assert.Equal(t, []string{"/0: expected tstr at offset 1"}, violations(t, err))
+	_, err = mrsh.UnmarshalStructSlice(bin, &test.TestStructs{})
+	assert.Equal(t, []string{"/0: expected TestStruct at offset 1"}, violations(t, err))
+	_, err = mrsh.UnmarshalStruct(bin, &test.TestPerson{})
+	assert.Equal(t, []string{"missing element First: tstr at offset 0"}, violations(t, err))
+	_, err = mrsh.UnmarshalStruct(bin, &marsha.Union{})
+	assert.True(t, errors.Is(err, cddl.ErrNoSuchRule))
+
+	mrsh = cddl.New(cborgen.New(), modelsSchema(t), cddl.WithRule(test.TestStruct2{}, "TestStruct"))
+	_, err = mrsh.UnmarshalStruct(bin, &test.TestStruct2{})
+	assert.Equal(t, []string{"/0: expected tstr at offset 1"}, violations(t, err))
+
+	var buf bytes.Buffer
+	enc := mrsh.NewEncoder(&buf)
+	_, err = enc.EncodeStruct(&test.TestStruct{Data: "a"})
+	require.NoError(t, err)
+	_, err = enc.EncodeStruct(&test.TestStruct2{Data2: 2})
+	require.NoError(t, err)
+	dec := mrsh.NewDecoder(&buf)
+	var s test.TestStruct
+	n, err := dec.DecodeStruct(&s)
+	require.NoError(t, err)
+	assert.Equal(t, 3, n)
+	assert.Equal(t, "a", s.Data)
+	n, err = dec.DecodeStruct(&s)
+	assert.Equal(t, 2, n)
+	assert.Equal(t, []string{"/0: expected tstr at offset 1"}, violations(t, err))
+	_, err = dec.DecodeStruct(&s)
+	assert.Equal(t, io.EOF, err)
+}
+
+// TestStruct shares its name with test.TestStruct.
+type TestStruct struct {
+	Count uint64
+}
+
+func (s TestStruct) Ptr() marsha.StructPtr { return &s }
+func (s *TestStruct) Val() marsha.Struct   { return *s }
+
+func TestMarshaQualified(t *testing.T) {
+	g := cddl.NewGenerator(cddl.Tuple)
+	require.NoError(t, g.Add(test.TestStruct{}, TestStruct{}))
+	s, err := cddl.Parse(g.String())
+	require.NoError(t, err)
+	assert.Equal(t, []string{"TestStruct", "cddl_test.TestStruct"}, s.Rules())
+
+	mrsh := cddl.New(cborgen.New(), s)
+	bin, err := mrsh.MarshalStruct(&test.TestStruct{Data: "a"})
+	require.NoError(t, err)
+	_, err = mrsh.UnmarshalStruct(bin, &test.TestStruct{})
+	require.NoError(t, err)
+	_, err = mrsh.UnmarshalStruct(bin, &TestStruct{})
+	assert.Equal(t, []string{"/0: expected uint at offset 1"}, violations(t, err))
+}
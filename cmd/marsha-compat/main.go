@@ -0,0 +1,97 @@
+// Command marsha-compat compares two versions of marsha models and reports the changes which
+// break wire compatibility, exiting with status 1 if any is found so that it can gate CI:
+//
+//	marsha-compat [flags] OLD NEW
+//
+// OLD and NEW are either two directories containing versions of a Go package of models, compared
+// for the `cborgen` and `cbor_refmt` backends, or two `.proto` files, compared for `protobuf`. To
+// compare with a previous commit, check it out first, for example with `git worktree add`.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/daotl/go-marsha/compat"
+)
+
+func main() {
+	os.Exit(run(os.Args[1:]))
+}
+
+func run(args []string) int {
+	fl := flag.NewFlagSet("marsha-compat", flag.ContinueOnError)
+	backends := fl.String("backends", "cborgen,cbor_refmt",
+		"comma-separated backends to compare Go models for")
+	strict := fl.Bool("strict", false, "also exit with status 1 on warnings")
+	verbose := fl.Bool("v", false, "also report safe changes")
+	fl.Usage = func() {
+		fmt.Fprintln(fl.Output(), "usage: marsha-compat [flags] OLD NEW")
+		fl.PrintDefaults()
+	}
+	if err := fl.Parse(args); err != nil {
+		return 2
+	}
+	if fl.NArg() != 2 {
+		fl.Usage()
+		return 2
+	}
+	report, err := compare(fl.Arg(0), fl.Arg(1), *backends)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "marsha-compat:", err)
+		return 2
+	}
+	min := compat.Warning
+	if *verbose {
+		min = compat.Safe
+	}
+	for _, c := range report.Filter(min) {
+		fmt.Println(c)
+	}
+	if max := report.Max(); max == compat.Breaking || *strict && max == compat.Warning {
+		return 1
+	}
+	return 0
+}
+
+func compare(old, new, backends string) (compat.Report, error) {
+	if isProto(old) != isProto(new) {
+		return nil, fmt.Errorf("can't compare %s with %s", old, new)
+	}
+	if isProto(old) {
+		of, err := compat.ParseProtoFile(old)
+		if err != nil {
+			return nil, err
+		}
+		nf, err := compat.ParseProtoFile(new)
+		if err != nil {
+			return nil, err
+		}
+		return compat.CompareProto(of, nf), nil
+	}
+	var bs []compat.Backend
+	for _, b := range strings.Split(backends, ",") {
+		switch b := compat.Backend(strings.TrimSpace(b)); b {
+		case compat.CBORGen, compat.CBORRefmt:
+			bs = append(bs, b)
+		default:
+			return nil, fmt.Errorf("can't compare Go models for backend %q", b)
+		}
+	}
+	op, err := compat.ParseDir(old)
+	if err != nil {
+		return nil, err
+	}
+	np, err := compat.ParseDir(new)
+	if err != nil {
+		return nil, err
+	}
+	return compat.ComparePackages(op, np, bs...), nil
+}
+
+func isProto(path string) bool {
+	return filepath.Ext(path) == ".proto"
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/ipfs/go-cid"
+	mh "github.com/multiformats/go-multihash"
+)
+
+// codecs are the multicodec names of the codecs of CIDs, besides those of cid.Codecs.
+var codecs = map[string]uint64{
+	"dag-cbor": cid.DagCBOR,
+	"dag-pb":   cid.DagProtobuf,
+	"dag-json": 0x0129,
+	"json":     0x0200,
+}
+
+func cidCmd(e *env, args []string) int {
+	fl := e.flags("cid", "[FILE]")
+	codec := fl.String("codec", "", "multicodec `name` of the block, such as dag-cbor, dag-json or raw "+
+		"(default detected)")
+	hash := fl.String("hash", "sha2-256", "multihash `name` of the hash function")
+	version := fl.Uint64("version", 1, "CID version")
+	b, status := e.parse(fl, args)
+	if status != 0 {
+		return status
+	}
+	prefix := cid.Prefix{Version: *version, MhLength: -1}
+
+	name := *codec
+	if name == "" {
+		switch enc, _ := detect(b, false); enc {
+		case encCBOR:
+			name = "dag-cbor"
+		case encJSON:
+			name = "dag-json"
+		default:
+			name = "raw"
+		}
+	}
+	var ok bool
+	if prefix.Codec, ok = codecs[name]; !ok {
+		if prefix.Codec, ok = cid.Codecs[name]; !ok {
+			return e.fail(fmt.Errorf("unknown codec %q", name))
+		}
+	}
+	if prefix.MhType, ok = mh.Names[*hash]; !ok {
+		return e.fail(fmt.Errorf("unknown hash function %q", *hash))
+	}
+	if prefix.Version == 0 && (prefix.Codec != cid.DagProtobuf || prefix.MhType != mh.SHA2_256) {
+		return e.fail(fmt.Errorf("CIDv0 requires codec dag-pb and hash sha2-256"))
+	}
+
+	c, err := prefix.Sum(b)
+	if err != nil {
+		return e.fail(err)
+	}
+	fmt.Fprintln(e.stdout, c)
+	return 0
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"github.com/daotl/go-marsha/cbordiag"
+	"github.com/daotl/go-marsha/internal/cbor"
+)
+
+// Encodings of payloads.
+const (
+	encCBOR     = "cbor"
+	encJSON     = "json"
+	encDiag     = "diag"
+	encProtobuf = "protobuf"
+)
+
+var errUnrecognized = errors.New("unrecognized encoding, set it with -from")
+
+// detect returns the encoding of the payload `b`, trying JSON, CBOR and the protobuf wire format
+// in turn. Text payloads which are neither JSON nor CBOR are diagnostic notation if `diag`.
+func detect(b []byte, diag bool) (string, error) {
+	switch {
+	case len(bytes.TrimSpace(b)) > 0 && json.Valid(b):
+		return encJSON, nil
+	case isCBOR(b):
+		return encCBOR, nil
+	case len(b) > 0 && isMessage(b):
+		return encProtobuf, nil
+	}
+	if diag && utf8.Valid(b) {
+		if _, err := cbordiag.Parse(string(b)); err == nil {
+			return encDiag, nil
+		}
+	}
+	return "", errUnrecognized
+}
+
+// isCBOR returns whether `b` is exactly one well-formed CBOR data item.
+func isCBOR(b []byte) bool {
+	end, err := cbor.Skip(b, 0)
+	return err == nil && end == len(b)
+}
+
+// isMessage returns whether `b` is a well-formed sequence of protobuf fields.
+func isMessage(b []byte) bool {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return false
+		}
+		m := protowire.ConsumeFieldValue(num, typ, b[n:])
+		if m < 0 {
+			return false
+		}
+		b = b[n+m:]
+	}
+	return true
+}
+
+// isText returns whether `b` is printable UTF-8 text.
+func isText(b []byte) bool {
+	for _, r := range string(b) {
+		if r == utf8.RuneError || !unicode.IsPrint(r) && !unicode.IsSpace(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// printRaw writes the protobuf fields encoded in `b` like `protoc --decode_raw`: each field on its
+// own line as its number and value, with length-delimited fields which are printable text shown
+// as quoted strings, those which are valid messages as nested messages and others as quoted bytes.
+func printRaw(w io.Writer, b []byte, indent string) error {
+	var sb strings.Builder
+	if err := writeRaw(&sb, b, indent, 0); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+func writeRaw(sb *strings.Builder, b []byte, indent string, depth int) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+		pad := strings.Repeat(indent, depth)
+		switch typ {
+		case protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			fmt.Fprintf(sb, "%s%d: %d\n", pad, num, v)
+			b = b[n:]
+		case protowire.Fixed32Type:
+			v, n := protowire.ConsumeFixed32(b)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			fmt.Fprintf(sb, "%s%d: 0x%08x\n", pad, num, v)
+			b = b[n:]
+		case protowire.Fixed64Type:
+			v, n := protowire.ConsumeFixed64(b)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			fmt.Fprintf(sb, "%s%d: 0x%016x\n", pad, num, v)
+			b = b[n:]
+		case protowire.BytesType:
+			v, n := protowire.ConsumeBytes(b)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			if len(v) > 0 && !isText(v) && isMessage(v) {
+				fmt.Fprintf(sb, "%s%d {\n", pad, num)
+				if err := writeRaw(sb, v, indent, depth+1); err != nil {
+					return err
+				}
+				fmt.Fprintf(sb, "%s}\n", pad)
+			} else {
+				fmt.Fprintf(sb, "%s%d: %s\n", pad, num, strconv.Quote(string(v)))
+			}
+			b = b[n:]
+		case protowire.StartGroupType:
+			v, n := protowire.ConsumeGroup(num, b)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			fmt.Fprintf(sb, "%s%d {\n", pad, num)
+			if err := writeRaw(sb, v, indent, depth+1); err != nil {
+				return err
+			}
+			fmt.Fprintf(sb, "%s}\n", pad)
+			b = b[n:]
+		default:
+			return fmt.Errorf("unexpected wire type %d of field %d", typ, num)
+		}
+	}
+	return nil
+}
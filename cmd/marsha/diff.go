@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+
+	"github.com/daotl/go-marsha/diff"
+)
+
+func diffCmd(e *env, args []string) int {
+	fl := e.flags("diff", "OLD NEW")
+	from := fl.String("from", "", "format of the inputs: cbor, json, diag or hex (default detected)")
+	descs := fl.String("descriptors", "",
+		"`file` of the protobuf FileDescriptorSet to compare messages with, as written by "+
+			"protoc --descriptor_set_out --include_imports")
+	msg := fl.String("message", "", "full `name` of the protobuf message in -descriptors")
+	if err := fl.Parse(args); err != nil {
+		return 2
+	}
+	if fl.NArg() != 2 {
+		fl.Usage()
+		return 2
+	}
+	var inputs [2][]byte
+	for i := range inputs {
+		in, err := e.open(fl.Arg(i))
+		if err != nil {
+			e.fail(err)
+			return 2
+		}
+		inputs[i], err = ioutil.ReadAll(in)
+		in.Close()
+		if err != nil {
+			e.fail(err)
+			return 2
+		}
+	}
+
+	diffs, err := diffInputs(inputs[0], inputs[1], *from, *descs, *msg)
+	if err != nil {
+		e.fail(err)
+		return 2
+	}
+	for _, d := range diffs {
+		fmt.Fprintln(e.stdout, d)
+	}
+	if len(diffs) > 0 {
+		return 1
+	}
+	return 0
+}
+
+func diffInputs(old, new []byte, from, descs, msg string) ([]diff.Difference, error) {
+	if descs != "" {
+		md, err := findMessage(descs, msg)
+		if err != nil {
+			return nil, err
+		}
+		return diff.Protobuf(old, new, md)
+	}
+	old, err := toCBOR(old, from)
+	if err != nil {
+		return nil, fmt.Errorf("old: %w", err)
+	}
+	if new, err = toCBOR(new, from); err != nil {
+		return nil, fmt.Errorf("new: %w", err)
+	}
+	return diff.CBOR(old, new)
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"google.golang.org/protobuf/encoding/prototext"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/dynamicpb"
+
+	"github.com/daotl/go-marsha"
+	"github.com/daotl/go-marsha/cbordiag"
+)
+
+func inspect(e *env, args []string) int {
+	fl := e.flags("inspect", "[FILE]")
+	from := fl.String("from", "", "encoding of the input: cbor, json or protobuf (default detected)")
+	offsets := fl.Bool("offsets", false, "show the byte offset of each CBOR item")
+	rawLinks := fl.Bool("raw-links", false, "show CBOR links as tagged byte strings")
+	descs := fl.String("descriptors", "", "`file` of a protobuf FileDescriptorSet to decode messages with")
+	msg := fl.String("message", "", "full `name` of the protobuf message in -descriptors")
+	b, status := e.parse(fl, args)
+	if status != 0 {
+		return status
+	}
+	enc := *from
+	if enc == "" {
+		var err error
+		if enc, err = detect(b, false); err != nil {
+			return e.fail(err)
+		}
+	}
+	fmt.Fprintf(e.stdout, "# %s, %d bytes", enc, len(b))
+	if enc == encCBOR && marsha.IsCanonical(b) {
+		fmt.Fprint(e.stdout, ", canonical")
+	}
+	fmt.Fprintln(e.stdout)
+
+	switch enc {
+	case encCBOR:
+		opts := []cbordiag.Option{cbordiag.WithIndent("  ")}
+		if *offsets {
+			opts = append(opts, cbordiag.WithOffsets())
+		}
+		if *rawLinks {
+			opts = append(opts, cbordiag.WithRawLinks())
+		}
+		diag, err := cbordiag.Format(b, opts...)
+		fmt.Fprintln(e.stdout, diag)
+		if err != nil {
+			return e.fail(err)
+		}
+	case encJSON:
+		var buf bytes.Buffer
+		if err := json.Indent(&buf, bytes.TrimSpace(b), "", "  "); err != nil {
+			return e.fail(err)
+		}
+		fmt.Fprintln(e.stdout, buf.String())
+	case encProtobuf:
+		if *descs == "" {
+			if err := printRaw(e.stdout, b, "  "); err != nil {
+				return e.fail(err)
+			}
+			break
+		}
+		md, err := findMessage(*descs, *msg)
+		if err != nil {
+			return e.fail(err)
+		}
+		m := dynamicpb.NewMessage(md)
+		if err := proto.Unmarshal(b, m); err != nil {
+			return e.fail(err)
+		}
+		text, err := prototext.MarshalOptions{Multiline: true, Indent: "  "}.Marshal(m)
+		if err != nil {
+			return e.fail(err)
+		}
+		e.stdout.Write(text)
+	default:
+		return e.fail(fmt.Errorf("unknown encoding %q", enc))
+	}
+	return 0
+}
+
+func convert(e *env, args []string) int {
+	fl := e.flags("convert", "-to FORMAT [FILE]")
+	from := fl.String("from", "", "format of the input: cbor, json, diag or hex (default detected)")
+	to := fl.String("to", "", "format of the output: cbor, json, diag or hex")
+	indent := fl.Bool("indent", false, "indent JSON and diagnostic notation")
+	b, status := e.parse(fl, args)
+	if status != 0 {
+		return status
+	}
+
+	// Convert to CBOR first.
+	b, err := toCBOR(b, *from)
+	if err != nil {
+		return e.fail(err)
+	}
+
+	var out []byte
+	switch *to {
+	case encCBOR:
+		out = b
+	case encJSON:
+		if out, err = toJSON(b); err == nil && *indent {
+			var buf bytes.Buffer
+			json.Indent(&buf, out, "", "  ")
+			out = buf.Bytes()
+		}
+		out = append(out, '\n')
+	case encDiag:
+		var opts []cbordiag.Option
+		if *indent {
+			opts = append(opts, cbordiag.WithIndent("  "))
+		}
+		var diag string
+		diag, err = cbordiag.Format(b, opts...)
+		out = []byte(diag + "\n")
+	case "hex":
+		out = []byte(hex.EncodeToString(b) + "\n")
+	case "":
+		fl.Usage()
+		return 2
+	default:
+		err = fmt.Errorf("can't convert to %q", *to)
+	}
+	if err != nil {
+		return e.fail(err)
+	}
+	e.stdout.Write(out)
+	return 0
+}
+
+// toCBOR converts `b` from the format `from`, or the detected one if empty, to CBOR.
+func toCBOR(b []byte, from string) ([]byte, error) {
+	if from == "" {
+		var err error
+		if from, err = detect(b, true); err != nil {
+			return nil, err
+		}
+	}
+	switch from {
+	case encCBOR:
+		return b, nil
+	case encJSON:
+		return fromJSON(b)
+	case encDiag:
+		return cbordiag.Parse(string(b))
+	case "hex":
+		return hex.DecodeString(strings.Join(strings.Fields(string(b)), ""))
+	}
+	return nil, fmt.Errorf("can't convert from %q", from)
+}
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/ipfs/go-cid"
+
+	"github.com/daotl/go-marsha"
+	"github.com/daotl/go-marsha/internal/cbor"
+)
+
+// Tags of bignums (RFC 8949 section 3.4.3).
+const (
+	tagPosBignum = 2
+	tagNegBignum = 3
+)
+
+// toJSON converts the CBOR data item `b` to DAG-JSON. Map keys must be text strings, and the only
+// tags supported are links and bignums.
+func toJSON(b []byte) ([]byte, error) {
+	// Concatenate indefinite-length strings and reject duplicate keys first.
+	b, err := marsha.Canonicalize(b)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if _, err := writeJSON(&buf, b, 0); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// writeJSON writes the item at offset `off` of the canonical CBOR `b` to `buf` as DAG-JSON and
+// returns the offset after it.
+func writeJSON(buf *bytes.Buffer, b []byte, off int) (int, error) {
+	h, err := cbor.ReadHead(b, off)
+	if err != nil {
+		return 0, err
+	}
+	end := h.End()
+	switch h.Major {
+	case cbor.MajUnsignedInt:
+		buf.WriteString(strconv.FormatUint(h.Arg, 10))
+	case cbor.MajNegativeInt:
+		n := new(big.Int).SetUint64(h.Arg)
+		buf.WriteString(n.Neg(n.Add(n, big.NewInt(1))).String())
+	case cbor.MajByteString:
+		end += int(h.Arg)
+		buf.WriteString(`{"/":{"bytes":"`)
+		buf.WriteString(base64.RawStdEncoding.EncodeToString(b[h.End():end]))
+		buf.WriteString(`"}}`)
+	case cbor.MajTextString:
+		end += int(h.Arg)
+		s := b[h.End():end]
+		if !utf8.Valid(s) {
+			return 0, fmt.Errorf("invalid UTF-8 in text string at offset %d", off)
+		}
+		writeString(buf, string(s))
+	case cbor.MajArray:
+		buf.WriteByte('[')
+		for i := uint64(0); i < h.Arg; i++ {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			if end, err = writeJSON(buf, b, end); err != nil {
+				return 0, err
+			}
+		}
+		buf.WriteByte(']')
+	case cbor.MajMap:
+		buf.WriteByte('{')
+		for i := uint64(0); i < h.Arg; i++ {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			k, err := cbor.ReadHead(b, end)
+			if err != nil {
+				return 0, err
+			}
+			if k.Major != cbor.MajTextString {
+				return 0, fmt.Errorf("map key at offset %d is not a text string", end)
+			}
+			if end, err = writeJSON(buf, b, end); err != nil {
+				return 0, err
+			}
+			buf.WriteByte(':')
+			if end, err = writeJSON(buf, b, end); err != nil {
+				return 0, err
+			}
+		}
+		buf.WriteByte('}')
+	case cbor.MajTag:
+		return writeTag(buf, b, h)
+	default:
+		if h.IsFloat() {
+			f := h.Float()
+			if math.IsNaN(f) || math.IsInf(f, 0) {
+				return 0, fmt.Errorf("%v at offset %d has no JSON form", f, off)
+			}
+			s := strconv.FormatFloat(f, 'g', -1, 64)
+			if !strings.ContainsAny(s, ".e") {
+				s += ".0"
+			}
+			buf.WriteString(s)
+			break
+		}
+		switch h.Arg {
+		case 20:
+			buf.WriteString("false")
+		case 21:
+			buf.WriteString("true")
+		case 22:
+			buf.WriteString("null")
+		default:
+			return 0, fmt.Errorf("simple value %d at offset %d has no JSON form", h.Arg, off)
+		}
+	}
+	return end, nil
+}
+
+// writeTag writes the tagged item of head `h` as DAG-JSON.
+func writeTag(buf *bytes.Buffer, b []byte, h cbor.Head) (int, error) {
+	switch h.Arg {
+	case cbor.TagLink:
+		c, end, err := cbor.ReadLink(b, h.End())
+		if err != nil {
+			return 0, err
+		}
+		buf.WriteString(`{"/":`)
+		writeString(buf, c.String())
+		buf.WriteByte('}')
+		return end, nil
+	case tagPosBignum, tagNegBignum:
+		s, err := cbor.ReadHead(b, h.End())
+		if err != nil {
+			return 0, err
+		}
+		if s.Major != cbor.MajByteString {
+			break
+		}
+		end := s.End() + int(s.Arg)
+		n := new(big.Int).SetBytes(b[s.End():end])
+		if h.Arg == tagNegBignum {
+			n.Neg(n.Add(n, big.NewInt(1)))
+		}
+		buf.WriteString(n.String())
+		return end, nil
+	}
+	return 0, fmt.Errorf("tag %d at offset %d has no JSON form", h.Arg, h.Offset)
+}
+
+// writeString writes `s` as a JSON string without escaping HTML.
+func writeString(buf *bytes.Buffer, s string) {
+	enc := json.NewEncoder(buf)
+	enc.SetEscapeHTML(false)
+	enc.Encode(s)
+	buf.Truncate(buf.Len() - 1) // the newline
+}
+
+// fromJSON converts the DAG-JSON value `b` to CBOR in core deterministic encoding. Integers
+// beyond 64 bits become bignums.
+func fromJSON(b []byte) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	out, err := appendJSON(nil, dec)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, fmt.Errorf("unexpected data after the JSON value at offset %d", dec.InputOffset())
+	}
+	return marsha.Canonicalize(out)
+}
+
+// appendJSON appends the CBOR encoding of the next JSON value of `dec` to `dst`.
+func appendJSON(dst []byte, dec *json.Decoder) ([]byte, error) {
+	t, err := dec.Token()
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	switch t := t.(type) {
+	case nil:
+		return append(dst, 0xf6), nil
+	case bool:
+		if t {
+			return append(dst, 0xf5), nil
+		}
+		return append(dst, 0xf4), nil
+	case string:
+		dst = cbor.AppendHead(dst, cbor.MajTextString, uint64(len(t)))
+		return append(dst, t...), nil
+	case json.Number:
+		return appendNumber(dst, t)
+	case json.Delim:
+		if t == '[' {
+			var items []byte
+			var n uint64
+			for ; dec.More(); n++ {
+				if items, err = appendJSON(items, dec); err != nil {
+					return nil, err
+				}
+			}
+			dec.Token() // ']'
+			dst = cbor.AppendHead(dst, cbor.MajArray, n)
+			return append(dst, items...), nil
+		}
+		return appendObject(dst, dec)
+	}
+	return nil, fmt.Errorf("unexpected JSON token %v", t)
+}
+
+// appendObject appends the CBOR encoding of the JSON object whose '{' was just read from `dec`,
+// which is a link or a byte string if it is a DAG-JSON `{"/": ...}` object.
+func appendObject(dst []byte, dec *json.Decoder) ([]byte, error) {
+	var entries []byte
+	var n uint64
+	for ; dec.More(); n++ {
+		var err error
+		if entries, err = appendJSON(entries, dec); err != nil {
+			return nil, err
+		}
+		if n == 0 && bytes.Equal(entries, []byte{0x61, '/'}) {
+			return appendSlash(dst, dec)
+		}
+		if entries, err = appendJSON(entries, dec); err != nil {
+			return nil, err
+		}
+	}
+	dec.Token() // '}'
+	dst = cbor.AppendHead(dst, cbor.MajMap, n)
+	return append(dst, entries...), nil
+}
+
+// appendSlash appends the link or byte string of a `{"/": ...}` object whose first key was just
+// read from `dec`.
+func appendSlash(dst []byte, dec *json.Decoder) ([]byte, error) {
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	if t, _ := dec.Token(); t != json.Delim('}') {
+		return nil, fmt.Errorf(`"/" must be the only key of its object at offset %d`, dec.InputOffset())
+	}
+	switch v := v.(type) {
+	case string:
+		c, err := cid.Decode(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid link %q: %w", v, err)
+		}
+		dst = cbor.AppendHead(dst, cbor.MajTag, cbor.TagLink)
+		dst = cbor.AppendHead(dst, cbor.MajByteString, uint64(c.ByteLen()+1))
+		return append(append(dst, 0), c.Bytes()...), nil
+	case map[string]interface{}:
+		if s, ok := v["bytes"].(string); ok && len(v) == 1 {
+			data, err := base64.RawStdEncoding.DecodeString(strings.TrimRight(s, "="))
+			if err != nil {
+				return nil, fmt.Errorf("invalid bytes %q: %w", s, err)
+			}
+			dst = cbor.AppendHead(dst, cbor.MajByteString, uint64(len(data)))
+			return append(dst, data...), nil
+		}
+	}
+	return nil, fmt.Errorf(`invalid "/" object at offset %d`, dec.InputOffset())
+}
+
+// appendNumber appends the integer, bignum or float `n`.
+func appendNumber(dst []byte, n json.Number) ([]byte, error) {
+	s := string(n)
+	if strings.ContainsAny(s, ".eE") {
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return nil, err
+		}
+		return cbor.AppendFloat(dst, f), nil
+	}
+	i, ok := new(big.Int).SetString(s, 10)
+	if !ok {
+		return nil, fmt.Errorf("invalid number %s", s)
+	}
+	major, tag := byte(cbor.MajUnsignedInt), uint64(tagPosBignum)
+	if i.Sign() < 0 {
+		major, tag = cbor.MajNegativeInt, tagNegBignum
+		i.Neg(i.Add(i, big.NewInt(1)))
+	}
+	if i.IsUint64() {
+		return cbor.AppendHead(dst, major, i.Uint64()), nil
+	}
+	dst = cbor.AppendHead(dst, cbor.MajTag, tag)
+	dst = cbor.AppendHead(dst, cbor.MajByteString, uint64(len(i.Bytes())))
+	return append(dst, i.Bytes()...), nil
+}
@@ -0,0 +1,119 @@
+// Command marsha inspects and converts marshaled payloads without writing Go programs against
+// the backends:
+//
+//	marsha inspect [flags] [FILE]      detect the encoding and pretty-print a payload
+//	marsha convert -to FORMAT [FILE]   convert between CBOR, JSON and diagnostic notation
+//	marsha validate [flags] [FILE]     validate against a CDDL schema or a protobuf descriptor set
+//	marsha cid [flags] [FILE]          compute the CID of a block
+//	marsha split [flags] [FILE]        print or extract the records of an Encoder stream
+//	marsha diff [flags] OLD NEW        compare two payloads and print their differences by path
+//
+// Each command reads FILE, or the standard input if FILE is missing or "-". Run
+// `marsha COMMAND -h` for the flags of a command. diff exits with status 1 if the payloads differ
+// and 2 on errors, like diff(1).
+//
+// CBOR is printed in diagnostic notation (see package cbordiag) and protobuf messages without a
+// descriptor like `protoc --decode_raw`. JSON conversions follow DAG-JSON: links are
+// `{"/": "bafy..."}` and byte strings `{"/": {"bytes": "base64"}}`.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+// command is a subcommand run with its arguments, returning the exit status.
+type command struct {
+	name    string
+	summary string
+	run     func(env *env, args []string) int
+}
+
+var commands = []command{
+	{"inspect", "detect the encoding and pretty-print a payload", inspect},
+	{"convert", "convert between CBOR, JSON and diagnostic notation", convert},
+	{"validate", "validate against a CDDL schema or a protobuf descriptor set", validate},
+	{"cid", "compute the CID of a block", cidCmd},
+	{"split", "print or extract the records of an Encoder stream", split},
+	{"diff", "compare two payloads and print their differences by path", diffCmd},
+}
+
+// env is the environment commands run in.
+type env struct {
+	stdin          io.Reader
+	stdout, stderr io.Writer
+}
+
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	e := &env{stdin: stdin, stdout: stdout, stderr: stderr}
+	if len(args) > 0 {
+		for _, c := range commands {
+			if c.name == args[0] {
+				return c.run(e, args[1:])
+			}
+		}
+		if args[0] != "help" && args[0] != "-h" && args[0] != "-help" {
+			fmt.Fprintf(stderr, "marsha: unknown command %q\n", args[0])
+		}
+	}
+	fmt.Fprintln(stderr, "usage: marsha COMMAND [flags] [FILE]")
+	fmt.Fprintln(stderr, "\ncommands:")
+	for _, c := range commands {
+		fmt.Fprintf(stderr, "  %-9s %s\n", c.name, c.summary)
+	}
+	return 2
+}
+
+// flags returns the flag set of command `name`, whose usage lists `args` after the flags.
+func (e *env) flags(name, args string) *flag.FlagSet {
+	fl := flag.NewFlagSet("marsha "+name, flag.ContinueOnError)
+	fl.SetOutput(e.stderr)
+	fl.Usage = func() {
+		fmt.Fprintf(e.stderr, "usage: marsha %s [flags] %s\n", name, args)
+		fl.PrintDefaults()
+	}
+	return fl
+}
+
+// parse parses the flags of `fl` and returns the input named by its only optional argument, or
+// the exit status if it fails.
+func (e *env) parse(fl *flag.FlagSet, args []string) ([]byte, int) {
+	if err := fl.Parse(args); err != nil {
+		return nil, 2
+	}
+	if fl.NArg() > 1 {
+		fl.Usage()
+		return nil, 2
+	}
+	in, err := e.open(fl.Arg(0))
+	if err != nil {
+		return nil, e.fail(err)
+	}
+	defer in.Close()
+	b, err := ioutil.ReadAll(in)
+	if err != nil {
+		return nil, e.fail(err)
+	}
+	return b, 0
+}
+
+// open opens the file `path`, or the standard input if it is empty or "-".
+func (e *env) open(path string) (io.ReadCloser, error) {
+	if path == "" || path == "-" {
+		return ioutil.NopCloser(e.stdin), nil
+	}
+	return os.Open(path)
+}
+
+// fail reports `err` and returns exit status 1.
+func (e *env) fail(err error) int {
+	fmt.Fprintln(e.stderr, "marsha:", err)
+	return 1
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/types/descriptorpb"
+
+	"github.com/daotl/go-marsha/blockstore"
+	"github.com/daotl/go-marsha/cbordiag"
+	"github.com/daotl/go-marsha/cborgen"
+	"github.com/daotl/go-marsha/crcframe"
+	"github.com/daotl/go-marsha/protobuf"
+	"github.com/daotl/go-marsha/test"
+)
+
+const link = "bafyreidykglsfhoixmivffc5uwhcgshx4j465xwqntbmu43nb2dzqwfvae"
+
+// runMarsha runs the command line `args` with `stdin` and returns its exit status and outputs.
+func runMarsha(t *testing.T, stdin []byte, args ...string) (int, string, string) {
+	t.Helper()
+	var stdout, stderr bytes.Buffer
+	status := run(args, bytes.NewReader(stdin), &stdout, &stderr)
+	return status, stdout.String(), stderr.String()
+}
+
+func linked(t *testing.T) []byte {
+	c, err := cid.Decode(link)
+	require.NoError(t, err)
+	bin, err := cborgen.New().MarshalStruct(&test.TestLinked{Name: "a", Left: &c})
+	require.NoError(t, err)
+	return bin
+}
+
+func TestInspect(t *testing.T) {
+	status, out, _ := runMarsha(t, linked(t), "inspect")
+	assert.Equal(t, 0, status)
+	assert.Equal(t, `# cbor, 45 bytes, canonical
+[
+  "a",
+  42("`+link+`"),
+  null
+]
+`, out)
+
+	status, out, _ = runMarsha(t, []byte(`{"a": [1, {"b": null}]}`), "inspect")
+	assert.Equal(t, 0, status)
+	assert.Equal(t, `# json, 23 bytes
+{
+  "a": [
+    1,
+    {
+      "b": null
+    }
+  ]
+}
+`, out)
+
+	var msg []byte
+	msg = protowire.AppendTag(msg, 1, protowire.VarintType)
+	msg = protowire.AppendVarint(msg, 150)
+	msg = protowire.AppendTag(msg, 2, protowire.BytesType)
+	msg = protowire.AppendBytes(msg, protowire.AppendString(protowire.AppendTag(nil, 1, protowire.BytesType), "hi"))
+	msg = protowire.AppendTag(msg, 3, protowire.Fixed32Type)
+	msg = protowire.AppendFixed32(msg, 7)
+	status, out, _ = runMarsha(t, msg, "inspect")
+	assert.Equal(t, 0, status)
+	assert.Equal(t, `# protobuf, 14 bytes
+1: 150
+2 {
+  1: "hi"
+}
+3: 0x00000007
+`, out)
+
+	status, _, errOut := runMarsha(t, []byte{0xff, 0xff}, "inspect")
+	assert.Equal(t, 1, status)
+	assert.Equal(t, "marsha: unrecognized encoding, set it with -from\n", errOut)
+}
+
+func TestInspectDescriptors(t *testing.T) {
+	descs := descriptorSet(t)
+	bin, err := proto.Marshal(&protobuf.Test{Data: "hi"})
+	require.NoError(t, err)
+	status, out, _ := runMarsha(t, bin, "inspect", "-descriptors", descs, "-message", "protobuf.Test")
+	assert.Equal(t, 0, status)
+	// The text format randomly varies spaces.
+	assert.Equal(t, []string{"#", "protobuf,", "4", "bytes", "data:", `"hi"`}, strings.Fields(out))
+}
+
+func TestConvert(t *testing.T) {
+	json := `{"big":-18446744073709551617,"bytes":{"/":{"bytes":"AQI"}},"f":1.0,"link":{"/":"` + link +
+		`"},"s":"<&>","xs":[true,null]}`
+	status, out, _ := runMarsha(t, []byte(json), "convert", "-to", "diag")
+	assert.Equal(t, 0, status)
+	diag := `{"f": 1.0, "s": "<&>", "xs": [true, null], "big": 3(h'010000000000000000'), "link": 42("` +
+		link + `"), "bytes": h'0102'}`
+	assert.Equal(t, diag+"\n", out)
+
+	// JSON is written in the canonical order of CBOR map keys.
+	status, out, _ = runMarsha(t, []byte(diag), "convert", "-to", "json")
+	assert.Equal(t, 0, status)
+	assert.Equal(t, `{"f":1.0,"s":"<&>","xs":[true,null],"big":-18446744073709551617,"link":{"/":"`+link+
+		`"},"bytes":{"/":{"bytes":"AQI"}}}`+"\n", out)
+
+	status, out, _ = runMarsha(t, []byte(diag), "convert", "-to", "cbor")
+	assert.Equal(t, 0, status)
+	assert.Equal(t, cbordiag.MustParse(diag), []byte(out))
+
+	status, out, _ = runMarsha(t, []byte("a1 6161\n01\n"), "convert", "-from", "hex", "-to", "json", "-indent")
+	assert.Equal(t, 0, status)
+	assert.Equal(t, "{\n  \"a\": 1\n}\n", out)
+
+	for in, want := range map[string]string{
+		`{1: 2}`:           "marsha: map key at offset 1 is not a text string\n",
+		`1(0)`:             "marsha: tag 1 at offset 0 has no JSON form\n",
+		`NaN`:              "marsha: NaN at offset 0 has no JSON form\n",
+		`{"a": 1, "a": 2}`: "marsha: invalid CBOR at offset 0: duplicate map key\n",
+	} {
+		status, _, errOut := runMarsha(t, []byte(in), "convert", "-to", "json")
+		assert.Equal(t, 1, status, in)
+		assert.Equal(t, want, errOut, in)
+	}
+	status, _, errOut := runMarsha(t, []byte(`{"/": "nope"}`), "convert", "-to", "cbor")
+	assert.Equal(t, 1, status)
+	assert.Contains(t, errOut, `invalid link "nope"`)
+}
+
+func TestValidate(t *testing.T) {
+	bin, err := cborgen.New().MarshalStruct(&test.TestStruct{Data: "test"})
+	require.NoError(t, err)
+	status, out, _ := runMarsha(t, bin, "validate", "-cddl", "../../test/models.cddl", "-rule", "TestStruct")
+	assert.Equal(t, 0, status)
+	assert.Equal(t, "valid\n", out)
+
+	status, _, errOut := runMarsha(t, cbordiag.MustParse(`[1]`), "validate", "-cddl", "../../test/models.cddl",
+		"-rule", "TestStruct")
+	assert.Equal(t, 1, status)
+	assert.Contains(t, errOut, "/0")
+
+	descs := descriptorSet(t)
+	bin, err = proto.Marshal(&protobuf.Test{Data: "hi"})
+	require.NoError(t, err)
+	status, out, _ = runMarsha(t, bin, "validate", "-descriptors", descs, "-message", "protobuf.Test", "-strict")
+	assert.Equal(t, 0, status)
+	assert.Equal(t, "valid\n", out)
+
+	bin = protowire.AppendVarint(protowire.AppendTag(bin, 15, protowire.VarintType), 1)
+	status, _, errOut = runMarsha(t, bin, "validate", "-descriptors", descs, "-message", "protobuf.Test")
+	assert.Equal(t, 1, status)
+	assert.Contains(t, errOut, "unknown field")
+
+	status, _, _ = runMarsha(t, bin, "validate")
+	assert.Equal(t, 2, status)
+}
+
+// descriptorSet writes the descriptors of the protobuf test messages to a file and returns its
+// path.
+func descriptorSet(t *testing.T) string {
+	set := &descriptorpb.FileDescriptorSet{File: []*descriptorpb.FileDescriptorProto{
+		protodesc.ToFileDescriptorProto(protobuf.File_test_proto),
+	}}
+	b, err := proto.Marshal(set)
+	require.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "test.pb")
+	require.NoError(t, os.WriteFile(path, b, 0o644))
+	return path
+}
+
+func TestCID(t *testing.T) {
+	c, bin, err := blockstore.CID(cborgen.New(), &test.TestStruct{Data: "test"})
+	require.NoError(t, err)
+	status, out, _ := runMarsha(t, bin, "cid")
+	assert.Equal(t, 0, status)
+	assert.Equal(t, c.String()+"\n", out)
+
+	status, out, _ = runMarsha(t, bin, "cid", "-codec", "raw", "-hash", "sha2-512")
+	assert.Equal(t, 0, status)
+	c, err = cid.Decode(strings.TrimSpace(out))
+	require.NoError(t, err)
+	assert.Equal(t, uint64(cid.Raw), c.Type())
+
+	status, _, errOut := runMarsha(t, bin, "cid", "-hash", "md4")
+	assert.Equal(t, 1, status)
+	assert.Equal(t, "marsha: unknown hash function \"md4\"\n", errOut)
+}
+
+func TestSplit(t *testing.T) {
+	var buf bytes.Buffer
+	enc := cborgen.New().NewEncoder(&buf)
+	for _, s := range []string{"a", "b"} {
+		_, err := enc.EncodeStruct(&test.TestStruct{Data: s})
+		require.NoError(t, err)
+	}
+	status, out, _ := runMarsha(t, buf.Bytes(), "split")
+	assert.Equal(t, 0, status)
+	assert.Equal(t, "[\"a\"]\n[\"b\"]\n", out)
+
+	buf.Reset()
+	enc = crcframe.New(cborgen.New()).NewEncoder(&buf)
+	for _, s := range []string{"a", "b", "c"} {
+		_, err := enc.EncodeStruct(&test.TestStruct{Data: s})
+		require.NoError(t, err)
+	}
+	stream := buf.Bytes()
+	stream[len(stream)/2] ^= 1
+	status, out, errOut := runMarsha(t, stream, "split", "-framing", "crcframe", "-resync", "-to", "hex")
+	assert.Equal(t, 0, status)
+	assert.Equal(t, "816161\n816163\n", out)
+	assert.Equal(t, "marsha: skipped 19 bytes at offset 19: corrupted record at offset 19: header checksum mismatch\n",
+		errOut)
+
+	status, _, errOut = runMarsha(t, stream, "split", "-framing", "crcframe")
+	assert.Equal(t, 1, status)
+	assert.Contains(t, errOut, "record 1: corrupted record")
+
+	dir := t.TempDir()
+	status, _, _ = runMarsha(t, buf.Bytes(), "split", "-framing", "crcframe", "-resync", "-o", dir)
+	assert.Equal(t, 0, status)
+	rec, err := os.ReadFile(filepath.Join(dir, "000001.cbor"))
+	require.NoError(t, err)
+	assert.Equal(t, cbordiag.MustParse(`["c"]`), rec)
+}
+
+func TestUsage(t *testing.T) {
+	status, _, errOut := runMarsha(t, nil, "nope")
+	assert.Equal(t, 2, status)
+	assert.True(t, strings.HasPrefix(errOut, "marsha: unknown command \"nope\"\nusage: marsha COMMAND"))
+}
+
+func TestDiff(t *testing.T) {
+	dir := t.TempDir()
+	old := filepath.Join(dir, "old.cbor")
+	require.NoError(t, os.WriteFile(old, cbordiag.MustParse(`{"items": [{"name": "a"}], "n": 1}`), 0o644))
+
+	status, out, _ := runMarsha(t, []byte(`{"items": [{"name": "b"}, {}], "n": 1}`), "diff", old, "-")
+	assert.Equal(t, 1, status)
+	assert.Equal(t, "~ /items/0/name: \"a\" -> \"b\"\n+ /items/1: {}\n", out)
+
+	oldJSON := filepath.Join(dir, "old.json")
+	require.NoError(t, os.WriteFile(oldJSON, []byte(`{"items": [{"name": "a"}], "n": 1}`), 0o644))
+	status, out, _ = runMarsha(t, []byte(`{"n": 1, "items": [{"name": "a"}]}`), "diff", "-from", "json", oldJSON, "-")
+	assert.Equal(t, 0, status)
+	assert.Equal(t, "", out)
+
+	descs := descriptorSet(t)
+	bin, err := proto.Marshal(&protobuf.Test{Data: "hi"})
+	require.NoError(t, err)
+	oldPB := filepath.Join(dir, "old.pb")
+	require.NoError(t, os.WriteFile(oldPB, bin, 0o644))
+	bin, err = proto.Marshal(&protobuf.Test{Data: "ho"})
+	require.NoError(t, err)
+	status, out, _ = runMarsha(t, bin, "diff", "-descriptors", descs, "-message", "protobuf.Test", oldPB, "-")
+	assert.Equal(t, 1, status)
+	assert.Equal(t, "~ /data: \"hi\" -> \"ho\"\n", out)
+
+	status, _, errOut := runMarsha(t, []byte{0x82}, "diff", "-from", "cbor", old, "-")
+	assert.Equal(t, 2, status)
+	assert.Contains(t, errOut, "invalid CBOR")
+	status, _, _ = runMarsha(t, nil, "diff", old)
+	assert.Equal(t, 2, status)
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+
+	"github.com/daotl/go-marsha"
+	"github.com/daotl/go-marsha/cbordiag"
+	"github.com/daotl/go-marsha/crcframe"
+	"github.com/daotl/go-marsha/internal/cbor"
+)
+
+func split(e *env, args []string) int {
+	fl := e.flags("split", "[FILE]")
+	framing := fl.String("framing", "cbor",
+		"framing of the records: cbor for the concatenated items written by the CBOR Encoders, "+
+			"or crcframe")
+	resync := fl.Bool("resync", false, "skip corrupted crcframe frames instead of failing")
+	to := fl.String("to", encDiag, "format records are printed in: diag, json or hex")
+	dir := fl.String("o", "", "write each record to a numbered file in `dir` instead of printing it")
+	if err := fl.Parse(args); err != nil {
+		return 2
+	}
+	if fl.NArg() > 1 {
+		fl.Usage()
+		return 2
+	}
+	in, err := e.open(fl.Arg(0))
+	if err != nil {
+		return e.fail(err)
+	}
+	defer in.Close()
+	r := bufio.NewReader(in)
+
+	var next func() ([]byte, error)
+	switch *framing {
+	case "cbor":
+		next = func() ([]byte, error) { return cbor.ReadItem(r) }
+	case "crcframe":
+		var opts []crcframe.Option
+		if *resync {
+			opts = append(opts, crcframe.WithResync(func(s crcframe.Skipped) {
+				fmt.Fprintf(e.stderr, "marsha: skipped %d bytes at offset %d: %s\n", s.Length, s.Offset, s.Err)
+			}))
+		}
+		dec := crcframe.New(rawMarsha{}, opts...).NewDecoder(r)
+		next = func() ([]byte, error) {
+			var rec []byte
+			_, err := dec.DecodePrimitive(&rec)
+			return rec, err
+		}
+	default:
+		return e.fail(fmt.Errorf("unknown framing %q", *framing))
+	}
+
+	for i := 0; ; i++ {
+		rec, err := next()
+		if err == io.EOF {
+			return 0
+		}
+		if err != nil {
+			return e.fail(fmt.Errorf("record %d: %w", i, err))
+		}
+		if *dir != "" {
+			ext := ".bin"
+			if isCBOR(rec) {
+				ext = ".cbor"
+			}
+			err = ioutil.WriteFile(filepath.Join(*dir, fmt.Sprintf("%06d%s", i, ext)), rec, 0o644)
+		} else {
+			err = printRecord(e.stdout, rec, *to)
+		}
+		if err != nil {
+			return e.fail(fmt.Errorf("record %d: %w", i, err))
+		}
+	}
+}
+
+// printRecord prints the record `rec` on its own line in format `to`.
+func printRecord(w io.Writer, rec []byte, to string) error {
+	var s string
+	switch to {
+	case encDiag:
+		diag, err := cbordiag.Format(rec)
+		if err != nil {
+			return err
+		}
+		s = diag
+	case encJSON:
+		b, err := toJSON(rec)
+		if err != nil {
+			return err
+		}
+		s = string(b)
+	case "hex":
+		s = hex.EncodeToString(rec)
+	default:
+		return fmt.Errorf("can't print records as %q", to)
+	}
+	_, err := fmt.Fprintln(w, s)
+	return err
+}
+
+// rawMarsha is a marsha.Marsha whose Decoder decodes the raw bytes of whole records into
+// *[]byte, to unwrap records framed by middlewares.
+type rawMarsha struct{}
+
+func (rawMarsha) MarshalPrimitive(interface{}) ([]byte, error) {
+	return nil, marsha.ErrUnimplemented
+}
+
+func (rawMarsha) UnmarshalPrimitive([]byte, interface{}) (int, error) {
+	return -1, marsha.ErrUnimplemented
+}
+
+func (rawMarsha) MarshalStruct(marsha.StructPtr) ([]byte, error) {
+	return nil, marsha.ErrUnimplemented
+}
+
+func (rawMarsha) UnmarshalStruct([]byte, marsha.StructPtr) (int, error) {
+	return -1, marsha.ErrUnimplemented
+}
+
+func (rawMarsha) MarshalStructSlice(marsha.StructSlicePtr) ([]byte, error) {
+	return nil, marsha.ErrUnimplemented
+}
+
+func (rawMarsha) UnmarshalStructSlice([]byte, marsha.StructSlicePtr) (int, error) {
+	return -1, marsha.ErrUnimplemented
+}
+
+func (rawMarsha) NewEncoder(io.Writer) marsha.Encoder {
+	panic(marsha.ErrUnimplemented)
+}
+
+func (rawMarsha) NewDecoder(r io.Reader) marsha.Decoder {
+	return rawDecoder{r}
+}
+
+type rawDecoder struct {
+	r io.Reader
+}
+
+func (d rawDecoder) DecodePrimitive(p interface{}) (int, error) {
+	b, err := ioutil.ReadAll(d.r)
+	*p.(*[]byte) = b
+	return len(b), err
+}
+
+func (d rawDecoder) DecodeStruct(marsha.StructPtr) (int, error) {
+	return 0, marsha.ErrUnimplemented
+}
+
+func (d rawDecoder) DecodeStructSlice(marsha.StructSlicePtr) (int, error) {
+	return 0, marsha.ErrUnimplemented
+}
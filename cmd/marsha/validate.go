@@ -0,0 +1,127 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+
+	"github.com/daotl/go-marsha"
+	"github.com/daotl/go-marsha/cddl"
+	"github.com/daotl/go-marsha/protobuf"
+)
+
+func validate(e *env, args []string) int {
+	fl := e.flags("validate", "(-cddl FILE [-rule NAME] | -descriptors FILE -message NAME) [FILE]")
+	schema := fl.String("cddl", "", "`file` of the CDDL schema to validate CBOR against")
+	rule := fl.String("rule", "", "`name` of the CDDL rule to validate against (default the first)")
+	descs := fl.String("descriptors", "",
+		"`file` of the protobuf FileDescriptorSet to validate messages against, as written by "+
+			"protoc --descriptor_set_out --include_imports")
+	msg := fl.String("message", "", "full `name` of the protobuf message in -descriptors")
+	strict := fl.Bool("strict", false, "also require the canonical encoding")
+	b, status := e.parse(fl, args)
+	if status != 0 {
+		return status
+	}
+
+	var err error
+	switch {
+	case *schema != "" && *descs == "":
+		err = validateCDDL(b, *schema, *rule, *strict)
+	case *descs != "" && *schema == "":
+		err = validateProto(b, *descs, *msg, *strict)
+	default:
+		fl.Usage()
+		return 2
+	}
+	if err != nil {
+		return e.fail(err)
+	}
+	fmt.Fprintln(e.stdout, "valid")
+	return 0
+}
+
+func validateCDDL(b []byte, path, rule string, strict bool) error {
+	src, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	s, err := cddl.Parse(string(src))
+	if err != nil {
+		return err
+	}
+	if rule == "" {
+		err = s.Validate(b)
+	} else {
+		err = s.ValidateRule(rule, b)
+	}
+	if err == nil && strict {
+		err = marsha.CheckCanonical(b)
+	}
+	return err
+}
+
+func validateProto(b []byte, path, name string, strict bool) error {
+	md, err := findMessage(path, name)
+	if err != nil {
+		return err
+	}
+	// Reject unknown fields, and with strict also non-deterministic encodings.
+	opts := []marsha.Option{marsha.WithUnknownPolicy(marsha.UnknownError)}
+	if strict {
+		opts = append(opts, marsha.WithStrict())
+	}
+	_, err = protobuf.New(opts...).UnmarshalStruct(b, &dynamicStruct{md: md})
+	return err
+}
+
+// findMessage returns the descriptor of the message named `name` in the FileDescriptorSet stored
+// in the file `path`.
+func findMessage(path, name string) (protoreflect.MessageDescriptor, error) {
+	if name == "" {
+		return nil, errors.New("-message is required with -descriptors")
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var set descriptorpb.FileDescriptorSet
+	if err := proto.Unmarshal(b, &set); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	files, err := protodesc.NewFiles(&set)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	d, err := files.FindDescriptorByName(protoreflect.FullName(name))
+	if err != nil {
+		return nil, fmt.Errorf("message %s: %w", name, err)
+	}
+	md, ok := d.(protoreflect.MessageDescriptor)
+	if !ok {
+		return nil, fmt.Errorf("%s is not a message", name)
+	}
+	return md, nil
+}
+
+// dynamicStruct is a protobuf.StructPtr holding a message of any type described at runtime.
+type dynamicStruct struct {
+	md  protoreflect.MessageDescriptor
+	msg proto.Message
+}
+
+func (s dynamicStruct) Ptr() marsha.StructPtr   { return &s }
+func (s *dynamicStruct) Val() marsha.Struct     { return *s }
+func (s *dynamicStruct) EmptyPB() proto.Message { return dynamicpb.NewMessage(s.md) }
+func (s *dynamicStruct) PB() proto.Message      { return s.msg }
+
+func (s *dynamicStruct) LoadPB(m proto.Message) error {
+	s.msg = m
+	return nil
+}
@@ -0,0 +1,88 @@
+// Package compat compares two versions of marsha models, either the Go structs of a package or the
+// messages of a `.proto` file, and reports which changes break wire compatibility for each
+// backend.
+package compat
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Backend is the name of a marsha implementation changes are reported for.
+type Backend string
+
+const (
+	CBORGen   Backend = "cborgen"
+	CBORRefmt Backend = "cbor_refmt"
+	Protobuf  Backend = "protobuf"
+)
+
+// Severity tells how a change affects reading data written by the other version.
+type Severity int
+
+const (
+	// Safe changes don't affect the wire format.
+	Safe Severity = iota
+	// Warning changes keep the wire format readable under some conditions, such as readers
+	// ignoring unknown fields, or break other encodings than the binary one.
+	Warning
+	// Breaking changes make data written by one version unreadable or misread by the other.
+	Breaking
+)
+
+func (s Severity) String() string {
+	switch s {
+	case Safe:
+		return "safe"
+	case Warning:
+		return "warning"
+	case Breaking:
+		return "breaking"
+	default:
+		return fmt.Sprintf("Severity(%d)", int(s))
+	}
+}
+
+// Change is a difference between two versions of a model.
+type Change struct {
+	Backend  Backend
+	Severity Severity
+	// Type is the name of the model or message, Field the name of its field if the change is about
+	// one.
+	Type  string
+	Field string
+	Msg   string
+}
+
+func (c Change) String() string {
+	where := c.Type
+	if c.Field != "" {
+		where += "." + c.Field
+	}
+	return fmt.Sprintf("%s %s %s: %s", strings.ToUpper(c.Severity.String()), c.Backend, where, c.Msg)
+}
+
+// Report is the changes found between two versions, in the order they were found.
+type Report []Change
+
+// Max returns the highest severity in `r`, `Safe` if `r` is empty.
+func (r Report) Max() Severity {
+	max := Safe
+	for _, c := range r {
+		if c.Severity > max {
+			max = c.Severity
+		}
+	}
+	return max
+}
+
+// Filter returns the changes in `r` with at least severity `min`.
+func (r Report) Filter(min Severity) Report {
+	var out Report
+	for _, c := range r {
+		if c.Severity >= min {
+			out = append(out, c)
+		}
+	}
+	return out
+}
@@ -0,0 +1,94 @@
+package compat_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/daotl/go-marsha/compat"
+)
+
+func lines(r compat.Report) []string {
+	out := make([]string, 0, len(r))
+	for _, c := range r {
+		out = append(out, c.String())
+	}
+	return out
+}
+
+func TestComparePackages(t *testing.T) {
+	old, err := compat.ParseDir("testdata/old")
+	require.NoError(t, err)
+	new, err := compat.ParseDir("testdata/new")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Legacy", "Order"}, old.Models)
+	assert.Equal(t, []string{"Fresh", "Order"}, new.Models)
+
+	r := compat.ComparePackages(old, new, compat.CBORGen)
+	assert.Equal(t, []string{
+		"WARNING cborgen Legacy: model removed, data written by it can't be read anymore",
+		"SAFE cborgen Fresh: model added",
+		"SAFE cborgen Order.Customer: renamed to Client",
+		"BREAKING cborgen Order.Note: removed from position 3",
+		"BREAKING cborgen Order.Link: moved from position 4 to 3",
+		"BREAKING cborgen Order.Total: added at position 4, tuples of the other version have a different length",
+		"BREAKING cborgen LineItem.SKU: moved from position 0 to 1",
+		"BREAKING cborgen LineItem.Qty: moved from position 1 to 0",
+	}, lines(r))
+	assert.Equal(t, compat.Breaking, r.Max())
+
+	r = compat.ComparePackages(old, new, compat.CBORRefmt)
+	assert.Equal(t, []string{
+		"WARNING cbor_refmt Legacy: model removed, data written by it can't be read anymore",
+		"SAFE cbor_refmt Fresh: model added",
+		"SAFE cbor_refmt Order.Client: Go field Customer renamed to Client, key \"customer\" unchanged",
+		"WARNING cbor_refmt Order.Note: key \"note\" removed, data written before can only be read ignoring unknown fields",
+		"WARNING cbor_refmt Order.Total: key \"total\" added, older readers only read it ignoring unknown fields",
+	}, lines(r))
+	assert.Equal(t, compat.Warning, r.Max())
+
+	assert.Empty(t, compat.ComparePackages(old, old))
+}
+
+func TestCompareProto(t *testing.T) {
+	old, err := compat.ParseProtoFile("testdata/old.proto")
+	require.NoError(t, err)
+	new, err := compat.ParseProtoFile("testdata/new.proto")
+	require.NoError(t, err)
+	require.Contains(t, old.Messages, "Order.Item")
+	assert.Equal(t, [][2]int32{{9, 9}, {11, 15}}, old.Messages["Order"].Reserved)
+	assert.Equal(t, "payment", old.Messages["Order"].Fields[4].Oneof)
+	assert.Equal(t, "map<string, Item>", new.Messages["Order"].Fields[6].Type)
+
+	r := compat.CompareProto(old, new)
+	assert.Equal(t, []string{
+		"WARNING protobuf Gone: message removed",
+		"WARNING protobuf Order.client: renamed from customer, breaking the JSON and text formats",
+		"SAFE protobuf Order.note: removed, number 3 reserved",
+		"WARNING protobuf Order.count: type changed from int32 to int64, wire compatible but values may be truncated or reinterpreted",
+		"WARNING protobuf Order.iban: removed without reserving number 6, a later field may reuse it",
+		"WARNING protobuf Order.legacy: reuses reserved name \"legacy\"",
+		"BREAKING protobuf Order.price: reuses reserved number 12",
+		"SAFE protobuf Order.tags: added with number 8",
+		"BREAKING protobuf Order.Item.code: reuses number 1 of string sku as int64",
+		"SAFE protobuf Status.CLOSED: value 2 removed and reserved",
+		"SAFE protobuf Status.ARCHIVED: value 3 added",
+	}, lines(r))
+	assert.Len(t, r.Filter(compat.Breaking), 2)
+
+	assert.Empty(t, compat.CompareProto(old, old))
+}
+
+func TestParseProtoErrors(t *testing.T) {
+	for _, src := range []string{
+		"message A { string a = ; }",
+		"message A { string a = 1 }",
+		"message A { string a = 1;",
+		"message A { /* open",
+		"enum E { A = 0; reserved 1 to; }",
+	} {
+		_, err := compat.ParseProto(src)
+		assert.Error(t, err, src)
+	}
+}
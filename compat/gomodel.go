@@ -0,0 +1,478 @@
+package compat
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"io/fs"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+const marshaPath = "github.com/daotl/go-marsha"
+
+// Package is the marsha models declared in the Go source of a package.
+type Package struct {
+	Name string
+	// Models are the names of the struct types implementing `marsha.Struct`, sorted.
+	Models []string
+
+	structs map[string]*structDecl
+}
+
+type structDecl struct {
+	typ *ast.StructType
+	// imports maps the import names of the declaring file to their paths.
+	imports map[string]string
+}
+
+// ParseDir parses the Go files of the package in `dir`, skipping tests.
+func ParseDir(dir string) (*Package, error) {
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseDir(fset, dir, func(fi fs.FileInfo) bool {
+		return !strings.HasSuffix(fi.Name(), "_test.go")
+	}, 0)
+	if err != nil {
+		return nil, err
+	}
+	if len(pkgs) != 1 {
+		return nil, fmt.Errorf("%s: found %d packages, expected 1", dir, len(pkgs))
+	}
+	for _, pkg := range pkgs {
+		files := make([]*ast.File, 0, len(pkg.Files))
+		for _, f := range pkg.Files {
+			files = append(files, f)
+		}
+		return NewPackage(files...)
+	}
+	panic("unreachable")
+}
+
+// NewPackage collects the models declared in `files`, which must belong to the same package.
+func NewPackage(files ...*ast.File) (*Package, error) {
+	p := &Package{structs: map[string]*structDecl{}}
+	ptr := map[string]bool{}
+	for _, f := range files {
+		if p.Name == "" {
+			p.Name = f.Name.Name
+		} else if f.Name.Name != p.Name {
+			return nil, fmt.Errorf("files of packages %s and %s", p.Name, f.Name.Name)
+		}
+		imports := map[string]string{}
+		for _, spec := range f.Imports {
+			path, _ := strconv.Unquote(spec.Path.Value)
+			// Guess the name of packages by the conventions of paths like "github.com/x/go-y".
+			name := strings.TrimPrefix(path[strings.LastIndexByte(path, '/')+1:], "go-")
+			if spec.Name != nil {
+				name = spec.Name.Name
+			}
+			imports[name] = path
+		}
+		for _, decl := range f.Decls {
+			switch decl := decl.(type) {
+			case *ast.GenDecl:
+				for _, spec := range decl.Specs {
+					if ts, ok := spec.(*ast.TypeSpec); ok {
+						if st, ok := ts.Type.(*ast.StructType); ok {
+							p.structs[ts.Name.Name] = &structDecl{typ: st, imports: imports}
+						}
+					}
+				}
+			case *ast.FuncDecl:
+				// Models implement marsha.Struct with a value receiver.
+				if decl.Recv == nil || decl.Name.Name != "Ptr" || decl.Type.Params.NumFields() != 0 {
+					continue
+				}
+				if id, ok := decl.Recv.List[0].Type.(*ast.Ident); ok {
+					ptr[id.Name] = true
+				}
+			}
+		}
+	}
+	for name := range ptr {
+		if p.structs[name] != nil {
+			p.Models = append(p.Models, name)
+		}
+	}
+	sort.Strings(p.Models)
+	return p, nil
+}
+
+// field is a field as seen by a backend.
+type field struct {
+	// name is the Go name, key the refmt map key.
+	name      string
+	key       string
+	omitEmpty bool
+	typ       ast.Expr
+	depth     int
+}
+
+// local returns the name of the struct type declared in `p` `e` refers to, following a pointer.
+func (p *Package) local(e ast.Expr) string {
+	if s, ok := e.(*ast.StarExpr); ok {
+		e = s.X
+	}
+	if id, ok := e.(*ast.Ident); ok && p.structs[id.Name] != nil {
+		return id.Name
+	}
+	return ""
+}
+
+// embeddedName returns the name of the field embedding `e`.
+func embeddedName(e ast.Expr) string {
+	switch e := e.(type) {
+	case *ast.StarExpr:
+		return embeddedName(e.X)
+	case *ast.Ident:
+		return e.Name
+	case *ast.SelectorExpr:
+		return e.Sel.Name
+	}
+	return types.ExprString(e)
+}
+
+// isUnknown tells whether `e` embeds `marsha.Unknown`, which neither backend sees fields of.
+func (d *structDecl) isUnknown(e ast.Expr) bool {
+	sel, ok := e.(*ast.SelectorExpr)
+	if !ok || sel.Sel.Name != "Unknown" {
+		return false
+	}
+	x, ok := sel.X.(*ast.Ident)
+	return ok && d.imports[x.Name] == marshaPath
+}
+
+// tuple returns the fields of the struct `name` in the order cbor-gen encodes them in tuples,
+// flattening embedded structs like `cbg.WriteTupleEncodersToFile` does with `flatten` set.
+func (p *Package) tuple(name string) []field {
+	var out []field
+	p.flatten(name, 0, &out, map[string]bool{})
+	return out
+}
+
+func (p *Package) flatten(name string, depth int, out *[]field, visiting map[string]bool) {
+	d := p.structs[name]
+	if visiting[name] {
+		return
+	}
+	visiting[name] = true
+	defer delete(visiting, name)
+	for _, f := range d.typ.Fields.List {
+		names := make([]string, 0, len(f.Names))
+		for _, n := range f.Names {
+			names = append(names, n.Name)
+		}
+		if len(f.Names) == 0 {
+			if d.isUnknown(f.Type) {
+				continue
+			}
+			if local := p.local(f.Type); local != "" && ast.IsExported(embeddedName(f.Type)) {
+				p.flatten(local, depth+1, out, visiting)
+				continue
+			}
+			names = append(names, embeddedName(f.Type))
+		}
+		for _, n := range names {
+			if !ast.IsExported(n) {
+				continue
+			}
+			i := indexOf(*out, n)
+			if i >= 0 {
+				// A shallower field of the same name hides deeper ones, a later one replaces
+				// an earlier one at the same depth.
+				if (*out)[i].depth < depth {
+					continue
+				}
+				*out = append((*out)[:i], (*out)[i+1:]...)
+			}
+			*out = append(*out, field{name: n, typ: f.Type, depth: depth})
+		}
+	}
+}
+
+func indexOf(fs []field, name string) int {
+	for i, f := range fs {
+		if f.name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// entries returns the fields of the struct `name` as refmt autogenerates its map entries with
+// the `refmt` struct tag.
+func (p *Package) entries(name string) []field {
+	var all []field
+	p.explore(name, 0, &all, map[string]bool{})
+	// Keep the shallowest field of each key.
+	sort.SliceStable(all, func(i, j int) bool { return all[i].depth < all[j].depth })
+	seen := map[string]bool{}
+	out := all[:0]
+	for _, f := range all {
+		if !seen[f.key] {
+			seen[f.key] = true
+			out = append(out, f)
+		}
+	}
+	return out
+}
+
+func (p *Package) explore(name string, depth int, out *[]field, visiting map[string]bool) {
+	d := p.structs[name]
+	if visiting[name] {
+		return
+	}
+	visiting[name] = true
+	defer delete(visiting, name)
+	for _, f := range d.typ.Fields.List {
+		var tag string
+		if f.Tag != nil {
+			raw, _ := strconv.Unquote(f.Tag.Value)
+			tag = reflect.StructTag(raw).Get("refmt")
+		}
+		if tag == "-" {
+			continue
+		}
+		key, opts := tag, ""
+		if i := strings.IndexByte(tag, ','); i >= 0 {
+			key, opts = tag[:i], tag[i+1:]
+		}
+		omitEmpty := false
+		for _, o := range strings.Split(opts, ",") {
+			omitEmpty = omitEmpty || o == "omitempty"
+		}
+		names := make([]string, 0, len(f.Names))
+		for _, n := range f.Names {
+			if ast.IsExported(n.Name) {
+				names = append(names, n.Name)
+			}
+		}
+		if len(f.Names) == 0 {
+			if d.isUnknown(f.Type) {
+				continue
+			}
+			if local := p.local(f.Type); local != "" && key == "" {
+				p.explore(local, depth+1, out, visiting)
+				continue
+			}
+			names = append(names, embeddedName(f.Type))
+		}
+		for _, n := range names {
+			k := key
+			if k == "" {
+				k = downcaseFirstLetter(n)
+			}
+			*out = append(*out, field{name: n, key: k, omitEmpty: omitEmpty, typ: f.Type, depth: depth})
+		}
+	}
+}
+
+func downcaseFirstLetter(s string) string {
+	r := rune(s[0])
+	if !unicode.IsUpper(r) {
+		return s
+	}
+	return string(unicode.ToLower(r)) + s[1:]
+}
+
+// ComparePackages compares the models of `old` and `new` for each of `backends`, which must be
+// `CBORGen` or `CBORRefmt`, all of them if empty. Models are matched by name, the struct types of
+// their fields by position.
+func ComparePackages(old, new *Package, backends ...Backend) Report {
+	if len(backends) == 0 {
+		backends = []Backend{CBORGen, CBORRefmt}
+	}
+	var r Report
+	for _, b := range backends {
+		c := &goComparer{backend: b, old: old, new: new, seen: map[[2]string]bool{}}
+		for _, name := range old.Models {
+			if new.structs[name] == nil {
+				c.add(Warning, name, "", "model removed, data written by it can't be read anymore")
+				continue
+			}
+			c.queue = append(c.queue, [2]string{name, name})
+		}
+		for _, name := range new.Models {
+			if old.structs[name] == nil {
+				c.add(Safe, name, "", "model added")
+			}
+		}
+		for len(c.queue) > 0 {
+			pair := c.queue[0]
+			c.queue = c.queue[1:]
+			if c.seen[pair] {
+				continue
+			}
+			c.seen[pair] = true
+			switch b {
+			case CBORGen:
+				c.compareTuple(pair[0], pair[1])
+			case CBORRefmt:
+				c.compareMap(pair[0], pair[1])
+			default:
+				panic(fmt.Sprintf("compat: can't compare Go models for %s", b))
+			}
+		}
+		r = append(r, c.report...)
+	}
+	return r
+}
+
+type goComparer struct {
+	backend  Backend
+	old, new *Package
+	// queue holds the pairs of struct types to compare, seen those already compared.
+	queue  [][2]string
+	seen   map[[2]string]bool
+	report Report
+}
+
+func (c *goComparer) add(s Severity, typ, field, format string, args ...interface{}) {
+	c.report = append(c.report, Change{
+		Backend: c.backend, Severity: s, Type: typ, Field: field, Msg: fmt.Sprintf(format, args...),
+	})
+}
+
+// sameType tells whether `o` and `n` have the same shape, queueing the pairs of struct types
+// declared in the packages they refer to at the same place to be compared.
+func (c *goComparer) sameType(o, n ast.Expr) bool {
+	var pairs [][2]string
+	if !c.shape(o, n, &pairs) {
+		return false
+	}
+	c.queue = append(c.queue, pairs...)
+	return true
+}
+
+func (c *goComparer) shape(o, n ast.Expr, pairs *[][2]string) bool {
+	switch o := o.(type) {
+	case *ast.StarExpr:
+		n, ok := n.(*ast.StarExpr)
+		return ok && c.shape(o.X, n.X, pairs)
+	case *ast.ArrayType:
+		n, ok := n.(*ast.ArrayType)
+		return ok && exprString(o.Len) == exprString(n.Len) && c.shape(o.Elt, n.Elt, pairs)
+	case *ast.MapType:
+		n, ok := n.(*ast.MapType)
+		return ok && c.shape(o.Key, n.Key, pairs) && c.shape(o.Value, n.Value, pairs)
+	case *ast.Ident:
+		n, ok := n.(*ast.Ident)
+		if !ok {
+			return false
+		}
+		if c.old.structs[o.Name] != nil && c.new.structs[n.Name] != nil {
+			*pairs = append(*pairs, [2]string{o.Name, n.Name})
+			return true
+		}
+		return o.Name == n.Name
+	}
+	return exprString(o) == exprString(n)
+}
+
+func exprString(e ast.Expr) string {
+	if e == nil {
+		return ""
+	}
+	return types.ExprString(e)
+}
+
+// compareTuple compares the struct types encoded as tuples by cbor-gen, where fields are
+// identified by position only.
+func (c *goComparer) compareTuple(oldName, newName string) {
+	of, nf := c.old.tuple(oldName), c.new.tuple(newName)
+	inOld, inNew := map[string]int{}, map[string]int{}
+	for i, f := range of {
+		inOld[f.name] = i
+	}
+	for i, f := range nf {
+		inNew[f.name] = i
+	}
+	renamed := map[int]bool{}
+	for i, f := range of {
+		j, ok := inNew[f.name]
+		switch {
+		case ok && i != j:
+			c.add(Breaking, newName, f.name, "moved from position %d to %d", i, j)
+		case ok:
+			if !c.sameType(f.typ, nf[j].typ) {
+				c.add(Breaking, newName, f.name, "type changed from %s to %s",
+					exprString(f.typ), exprString(nf[j].typ))
+			}
+		case i < len(nf) && !has(inOld, nf[i].name) && c.sameType(f.typ, nf[i].typ):
+			renamed[i] = true
+			c.add(Safe, newName, f.name, "renamed to %s", nf[i].name)
+		default:
+			c.add(Breaking, newName, f.name, "removed from position %d", i)
+		}
+	}
+	for j, f := range nf {
+		if !has(inOld, f.name) && !renamed[j] {
+			c.add(Breaking, newName, f.name,
+				"added at position %d, tuples of the other version have a different length", j)
+		}
+	}
+}
+
+// compareMap compares the struct types encoded as maps by refmt, where fields are identified by
+// key.
+func (c *goComparer) compareMap(oldName, newName string) {
+	of, nf := c.old.entries(oldName), c.new.entries(newName)
+	byKey, byName := map[string]int{}, map[string]int{}
+	for i, f := range nf {
+		byKey[f.key] = i
+		byName[f.name] = i
+	}
+	matched := map[int]bool{}
+	for _, f := range of {
+		if j, ok := byKey[f.key]; ok {
+			matched[j] = true
+			g := nf[j]
+			if !c.sameType(f.typ, g.typ) {
+				c.add(Breaking, newName, g.name, "type of key %q changed from %s to %s",
+					f.key, exprString(f.typ), exprString(g.typ))
+			}
+			if f.name != g.name {
+				c.add(Safe, newName, g.name, "Go field %s renamed to %s, key %q unchanged",
+					f.name, g.name, f.key)
+			}
+			if f.omitEmpty != g.omitEmpty {
+				c.add(Safe, newName, g.name, "omitempty changed to %t", g.omitEmpty)
+			}
+			continue
+		}
+		if j, ok := byName[f.name]; ok && !has(byKey, f.key) {
+			if _, taken := keyIn(of, nf[j].key); !taken {
+				matched[j] = true
+				c.add(Breaking, newName, f.name, "key renamed from %q to %q", f.key, nf[j].key)
+				continue
+			}
+		}
+		c.add(Warning, newName, f.name,
+			"key %q removed, data written before can only be read ignoring unknown fields", f.key)
+	}
+	for j, f := range nf {
+		if !matched[j] {
+			c.add(Warning, newName, f.name,
+				"key %q added, older readers only read it ignoring unknown fields", f.key)
+		}
+	}
+}
+
+func keyIn(fs []field, key string) (int, bool) {
+	for i, f := range fs {
+		if f.key == key {
+			return i, true
+		}
+	}
+	return -1, false
+}
+
+func has(m map[string]int, k string) bool {
+	_, ok := m[k]
+	return ok
+}
@@ -0,0 +1,706 @@
+package compat
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// ProtoFile is the messages and enums declared in a `.proto` file.
+type ProtoFile struct {
+	// Messages and Enums are keyed by their names qualified by the messages they are nested in,
+	// such as "Outer.Inner", without the package.
+	Messages map[string]*Message
+	Enums    map[string]*Enum
+}
+
+// Message is a protobuf message.
+type Message struct {
+	Name   string
+	Fields []*ProtoField
+	// Reserved are the reserved field number ranges, both ends included, ReservedNames the
+	// reserved field names.
+	Reserved      [][2]int32
+	ReservedNames []string
+}
+
+// ProtoField is a field of a Message.
+type ProtoField struct {
+	Name   string
+	Number int32
+	// Label is "repeated", "optional", "required" or empty.
+	Label string
+	// Type is as written, such as "int64", "Inner" or "map<string, Inner>".
+	Type string
+	// Oneof is the name of the oneof the field belongs to, if any.
+	Oneof string
+}
+
+// Enum is a protobuf enum.
+type Enum struct {
+	Name          string
+	Values        map[int32]string
+	Reserved      [][2]int32
+	ReservedNames []string
+}
+
+// maxFieldNumber is the number `max` stands for in reserved ranges.
+const maxFieldNumber = 1<<29 - 1
+
+// ParseProtoFile parses the `.proto` file at `path`.
+func ParseProtoFile(path string) (*ProtoFile, error) {
+	src, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	f, err := ParseProto(string(src))
+	if err != nil {
+		return nil, fmt.Errorf("%s:%w", path, err)
+	}
+	return f, nil
+}
+
+// ParseProto parses the messages and enums of the protobuf source `src`. Services, extensions
+// and options are skipped.
+func ParseProto(src string) (*ProtoFile, error) {
+	p := &protoParser{
+		lex:  lexer{src: src, line: 1},
+		file: &ProtoFile{Messages: map[string]*Message{}, Enums: map[string]*Enum{}},
+	}
+	if err := p.parseFile(); err != nil {
+		return nil, err
+	}
+	return p.file, nil
+}
+
+type tok struct {
+	text string
+	line int
+	// str tells whether the token is a string literal, text being its unquoted value.
+	str bool
+}
+
+type lexer struct {
+	src  string
+	pos  int
+	line int
+	peek *tok
+}
+
+func (l *lexer) next() (tok, error) {
+	if l.peek != nil {
+		t := *l.peek
+		l.peek = nil
+		return t, nil
+	}
+	for l.pos < len(l.src) {
+		c := l.src[l.pos]
+		switch {
+		case c == '\n':
+			l.line++
+			l.pos++
+		case c == ' ' || c == '\t' || c == '\r':
+			l.pos++
+		case strings.HasPrefix(l.src[l.pos:], "//"):
+			for l.pos < len(l.src) && l.src[l.pos] != '\n' {
+				l.pos++
+			}
+		case strings.HasPrefix(l.src[l.pos:], "/*"):
+			end := strings.Index(l.src[l.pos+2:], "*/")
+			if end < 0 {
+				return tok{}, fmt.Errorf("%d: unterminated comment", l.line)
+			}
+			l.line += strings.Count(l.src[l.pos:l.pos+2+end], "\n")
+			l.pos += end + 4
+		case c == '"' || c == '\'':
+			start, line := l.pos, l.line
+			for l.pos++; l.pos < len(l.src) && l.src[l.pos] != c; l.pos++ {
+				if l.src[l.pos] == '\\' {
+					l.pos++
+				}
+			}
+			if l.pos >= len(l.src) {
+				return tok{}, fmt.Errorf("%d: unterminated string", line)
+			}
+			l.pos++
+			lit := l.src[start:l.pos]
+			if c == '\'' {
+				lit = `"` + strings.ReplaceAll(lit[1:len(lit)-1], `"`, `\"`) + `"`
+			}
+			s, err := strconv.Unquote(lit)
+			if err != nil {
+				return tok{}, fmt.Errorf("%d: invalid string %s", line, l.src[start:l.pos])
+			}
+			return tok{text: s, line: line, str: true}, nil
+		case isWord(rune(c)):
+			start := l.pos
+			for l.pos < len(l.src) && (isWord(rune(l.src[l.pos])) || l.src[l.pos] == '.') {
+				l.pos++
+			}
+			return tok{text: l.src[start:l.pos], line: l.line}, nil
+		default:
+			l.pos++
+			return tok{text: string(c), line: l.line}, nil
+		}
+	}
+	return tok{line: l.line}, nil
+}
+
+func isWord(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+type protoParser struct {
+	lex  lexer
+	file *ProtoFile
+}
+
+func (p *protoParser) next() (tok, error) { return p.lex.next() }
+
+func (p *protoParser) peek() (tok, error) {
+	t, err := p.lex.next()
+	if err == nil {
+		p.lex.peek = &t
+	}
+	return t, err
+}
+
+func (p *protoParser) expect(text string) error {
+	t, err := p.next()
+	if err != nil {
+		return err
+	}
+	if t.text != text || t.str {
+		return unexpected(t, text)
+	}
+	return nil
+}
+
+func unexpected(t tok, want string) error {
+	got := strconv.Quote(t.text)
+	if t.text == "" && !t.str {
+		got = "end of file"
+	}
+	return fmt.Errorf("%d: expected %s, got %s", t.line, want, got)
+}
+
+func (p *protoParser) ident() (string, error) {
+	t, err := p.next()
+	if err != nil {
+		return "", err
+	}
+	if t.str || t.text == "" || !isWord(rune(t.text[0])) {
+		return "", unexpected(t, "identifier")
+	}
+	return t.text, nil
+}
+
+func (p *protoParser) number() (int32, error) {
+	t, err := p.next()
+	if err != nil {
+		return 0, err
+	}
+	n, err := strconv.ParseInt(t.text, 0, 32)
+	if t.str || err != nil {
+		return 0, unexpected(t, "number")
+	}
+	return int32(n), nil
+}
+
+// skip skips the rest of a statement or block, including nested blocks.
+func (p *protoParser) skip() error {
+	depth := 0
+	for {
+		t, err := p.next()
+		if err != nil {
+			return err
+		}
+		if t.str {
+			continue
+		}
+		switch t.text {
+		case "":
+			return unexpected(t, `";" or "}"`)
+		case "{":
+			depth++
+		case "}":
+			depth--
+			if depth == 0 {
+				return nil
+			}
+		case ";":
+			if depth == 0 {
+				return nil
+			}
+		}
+	}
+}
+
+func (p *protoParser) parseFile() error {
+	for {
+		t, err := p.next()
+		if err != nil {
+			return err
+		}
+		switch t.text {
+		case "":
+			return nil
+		case ";":
+		case "message":
+			if err := p.parseMessage(""); err != nil {
+				return err
+			}
+		case "enum":
+			if err := p.parseEnum(""); err != nil {
+				return err
+			}
+		default:
+			// syntax, package, import, option, service, extend...
+			if err := p.skip(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (p *protoParser) parseMessage(prefix string) error {
+	name, err := p.ident()
+	if err != nil {
+		return err
+	}
+	m := &Message{Name: prefix + name}
+	p.file.Messages[m.Name] = m
+	if err := p.expect("{"); err != nil {
+		return err
+	}
+	return p.parseBody(m, "")
+}
+
+// parseBody parses the body of the message `m`, or of its oneof `oneof`, after "{".
+func (p *protoParser) parseBody(m *Message, oneof string) error {
+	for {
+		t, err := p.peek()
+		if err != nil {
+			return err
+		}
+		switch t.text {
+		case "}":
+			p.next()
+			return nil
+		case ";":
+			p.next()
+			continue
+		case "message", "enum", "oneof", "reserved":
+			if oneof != "" {
+				break
+			}
+			p.next()
+			switch t.text {
+			case "message":
+				err = p.parseMessage(m.Name + ".")
+			case "enum":
+				err = p.parseEnum(m.Name + ".")
+			case "oneof":
+				var name string
+				if name, err = p.ident(); err == nil {
+					if err = p.expect("{"); err == nil {
+						err = p.parseBody(m, name)
+					}
+				}
+			case "reserved":
+				err = p.parseReserved(&m.Reserved, &m.ReservedNames)
+			}
+			if err != nil {
+				return err
+			}
+			continue
+		case "option", "extensions", "extend", "group":
+			if err := p.skip(); err != nil {
+				return err
+			}
+			continue
+		case "":
+			return unexpected(t, `"}"`)
+		}
+		f, err := p.parseField()
+		if err != nil {
+			return err
+		}
+		f.Oneof = oneof
+		m.Fields = append(m.Fields, f)
+	}
+}
+
+func (p *protoParser) parseField() (*ProtoField, error) {
+	f := &ProtoField{}
+	typ, err := p.ident()
+	if err != nil {
+		return nil, err
+	}
+	switch typ {
+	case "repeated", "optional", "required":
+		f.Label = typ
+		if typ, err = p.ident(); err != nil {
+			return nil, err
+		}
+	}
+	if typ == "map" {
+		var key, value string
+		if err = p.expect("<"); err == nil {
+			if key, err = p.ident(); err == nil {
+				if err = p.expect(","); err == nil {
+					if value, err = p.ident(); err == nil {
+						err = p.expect(">")
+					}
+				}
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+		typ = "map<" + key + ", " + value + ">"
+	}
+	f.Type = strings.TrimPrefix(typ, ".")
+	if f.Name, err = p.ident(); err != nil {
+		return nil, err
+	}
+	if err = p.expect("="); err != nil {
+		return nil, err
+	}
+	if f.Number, err = p.number(); err != nil {
+		return nil, err
+	}
+	t, err := p.peek()
+	if err != nil {
+		return nil, err
+	}
+	if t.text == "[" {
+		// Field options.
+		return f, p.skip()
+	}
+	return f, p.expect(";")
+}
+
+// parseReserved parses the ranges or names of a "reserved" statement.
+func (p *protoParser) parseReserved(ranges *[][2]int32, names *[]string) error {
+	for {
+		t, err := p.next()
+		if err != nil {
+			return err
+		}
+		if t.str {
+			*names = append(*names, t.text)
+		} else {
+			p.lex.peek = &t
+			from, err := p.number()
+			if err != nil {
+				return err
+			}
+			to := from
+			if t, err = p.peek(); err != nil {
+				return err
+			}
+			if t.text == "to" {
+				p.next()
+				if t, err = p.peek(); err != nil {
+					return err
+				}
+				if t.text == "max" {
+					p.next()
+					to = maxFieldNumber
+				} else if to, err = p.number(); err != nil {
+					return err
+				}
+			}
+			*ranges = append(*ranges, [2]int32{from, to})
+		}
+		if t, err = p.next(); err != nil {
+			return err
+		}
+		switch t.text {
+		case ";":
+			return nil
+		case ",":
+		default:
+			return unexpected(t, `"," or ";"`)
+		}
+	}
+}
+
+func (p *protoParser) parseEnum(prefix string) error {
+	name, err := p.ident()
+	if err != nil {
+		return err
+	}
+	e := &Enum{Name: prefix + name, Values: map[int32]string{}}
+	p.file.Enums[e.Name] = e
+	if err := p.expect("{"); err != nil {
+		return err
+	}
+	for {
+		t, err := p.next()
+		if err != nil {
+			return err
+		}
+		switch t.text {
+		case "}":
+			return nil
+		case ";":
+			continue
+		case "option":
+			err = p.skip()
+		case "reserved":
+			err = p.parseReserved(&e.Reserved, &e.ReservedNames)
+		default:
+			if err = p.expect("="); err != nil {
+				return err
+			}
+			neg := false
+			if t, err := p.peek(); err == nil && t.text == "-" {
+				p.next()
+				neg = true
+			}
+			var n int32
+			if n, err = p.number(); err != nil {
+				return err
+			}
+			if neg {
+				n = -n
+			}
+			// Aliases keep the first name.
+			if _, ok := e.Values[n]; !ok {
+				e.Values[n] = t.text
+			}
+			if t, err = p.peek(); err == nil && t.text == "[" {
+				err = p.skip()
+			} else if err == nil {
+				err = p.expect(";")
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func reserved(ranges [][2]int32, n int32) bool {
+	for _, r := range ranges {
+		if n >= r[0] && n <= r[1] {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// wireKinds group the scalar types which are encoded the same way on the wire, so that changing
+// a field between them keeps it readable, although values may be truncated or reinterpreted.
+var wireKinds = map[string]string{
+	"int32": "varint", "uint32": "varint", "int64": "varint", "uint64": "varint", "bool": "varint",
+	"sint32": "zigzag", "sint64": "zigzag",
+	"fixed32": "fixed32", "sfixed32": "fixed32",
+	"fixed64": "fixed64", "sfixed64": "fixed64",
+	"string": "bytes", "bytes": "bytes",
+	"float": "float", "double": "double",
+}
+
+// CompareProto compares the messages and enums of `old` and `new`, matched by name. Fields are
+// matched by number.
+func CompareProto(old, new *ProtoFile) Report {
+	c := &protoComparer{old: old, new: new}
+	for _, name := range sortedKeys(old.Messages) {
+		if m := new.Messages[name]; m != nil {
+			c.compareMessage(old.Messages[name], m)
+		} else {
+			c.add(Warning, name, "", "message removed")
+		}
+	}
+	for _, name := range sortedKeys(new.Messages) {
+		if old.Messages[name] == nil {
+			c.add(Safe, name, "", "message added")
+		}
+	}
+	for _, name := range sortedKeys(old.Enums) {
+		if e := new.Enums[name]; e != nil {
+			c.compareEnum(old.Enums[name], e)
+		} else {
+			c.add(Warning, name, "", "enum removed")
+		}
+	}
+	return c.report
+}
+
+func sortedKeys(m interface{}) []string {
+	var keys []string
+	switch m := m.(type) {
+	case map[string]*Message:
+		for k := range m {
+			keys = append(keys, k)
+		}
+	case map[string]*Enum:
+		for k := range m {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+type protoComparer struct {
+	old, new *ProtoFile
+	report   Report
+}
+
+func (c *protoComparer) add(s Severity, typ, field, format string, args ...interface{}) {
+	c.report = append(c.report, Change{
+		Backend: Protobuf, Severity: s, Type: typ, Field: field, Msg: fmt.Sprintf(format, args...),
+	})
+}
+
+// kind returns the wire kind of the type `typ` of a field of `m`, resolving enums.
+func kind(f *ProtoFile, m *Message, typ string) string {
+	if k, ok := wireKinds[typ]; ok {
+		return k
+	}
+	// Resolve from the innermost scope outwards.
+	scope := m.Name
+	for {
+		name := typ
+		if scope != "" {
+			name = scope + "." + typ
+		}
+		if f.Enums[name] != nil {
+			return "varint"
+		}
+		if f.Messages[name] != nil {
+			return "message " + name
+		}
+		if scope == "" {
+			break
+		}
+		if i := strings.LastIndexByte(scope, '.'); i >= 0 {
+			scope = scope[:i]
+		} else {
+			scope = ""
+		}
+	}
+	return typ
+}
+
+func (c *protoComparer) compareMessage(om, nm *Message) {
+	byNumber := map[int32]*ProtoField{}
+	for _, f := range nm.Fields {
+		byNumber[f.Number] = f
+	}
+	for _, of := range om.Fields {
+		nf := byNumber[of.Number]
+		if nf == nil {
+			if reserved(nm.Reserved, of.Number) {
+				c.add(Safe, nm.Name, of.Name, "removed, number %d reserved", of.Number)
+			} else {
+				c.add(Warning, nm.Name, of.Name,
+					"removed without reserving number %d, a later field may reuse it", of.Number)
+			}
+			continue
+		}
+		c.compareField(om, nm, of, nf)
+	}
+	for _, nf := range nm.Fields {
+		if reserved(om.Reserved, nf.Number) {
+			c.add(Breaking, nm.Name, nf.Name, "reuses reserved number %d", nf.Number)
+		} else if contains(om.ReservedNames, nf.Name) {
+			c.add(Warning, nm.Name, nf.Name, "reuses reserved name %q", nf.Name)
+		} else if !hasNumber(om, nf.Number) {
+			c.add(Safe, nm.Name, nf.Name, "added with number %d", nf.Number)
+		}
+	}
+}
+
+func hasNumber(m *Message, n int32) bool {
+	for _, f := range m.Fields {
+		if f.Number == n {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *protoComparer) compareField(om, nm *Message, of, nf *ProtoField) {
+	ok, nk := kind(c.old, om, of.Type), kind(c.new, nm, nf.Type)
+	typeChanged := of.Type != nf.Type
+	if typeChanged && of.Name != nf.Name && ok != nk {
+		c.add(Breaking, nm.Name, nf.Name, "reuses number %d of %s %s as %s",
+			nf.Number, of.Type, of.Name, nf.Type)
+		return
+	}
+	if of.Name != nf.Name {
+		c.add(Warning, nm.Name, nf.Name, "renamed from %s, breaking the JSON and text formats",
+			of.Name)
+	}
+	switch {
+	case !typeChanged:
+	case ok == nk && strings.HasPrefix(ok, "message "):
+		c.add(Safe, nm.Name, nf.Name, "type %s now written %s", of.Type, nf.Type)
+	case ok == nk:
+		c.add(Warning, nm.Name, nf.Name,
+			"type changed from %s to %s, wire compatible but values may be truncated or reinterpreted",
+			of.Type, nf.Type)
+	case strings.HasPrefix(ok, "message ") && strings.HasPrefix(nk, "message ") &&
+		c.new.Messages[strings.TrimPrefix(ok, "message ")] == nil:
+		c.add(Warning, nm.Name, nf.Name,
+			"type changed from %s to %s, compatible only if the messages are", of.Type, nf.Type)
+	default:
+		c.add(Breaking, nm.Name, nf.Name, "type changed from %s to %s", of.Type, nf.Type)
+	}
+	if (of.Label == "repeated") != (nf.Label == "repeated") {
+		c.add(Breaking, nm.Name, nf.Name, "label changed from %q to %q", of.Label, nf.Label)
+	}
+	if of.Oneof != nf.Oneof {
+		c.add(Warning, nm.Name, nf.Name, "moved from oneof %q to %q", of.Oneof, nf.Oneof)
+	}
+}
+
+func (c *protoComparer) compareEnum(oe, ne *Enum) {
+	for _, n := range sortedNumbers(oe.Values) {
+		name, ok := ne.Values[n]
+		switch {
+		case !ok && reserved(ne.Reserved, n):
+			c.add(Safe, ne.Name, oe.Values[n], "value %d removed and reserved", n)
+		case !ok:
+			c.add(Warning, ne.Name, oe.Values[n], "value %d removed without reserving it", n)
+		case name != oe.Values[n]:
+			c.add(Warning, ne.Name, name,
+				"value %d renamed from %s, breaking the JSON and text formats", n, oe.Values[n])
+		}
+	}
+	for _, n := range sortedNumbers(ne.Values) {
+		if _, ok := oe.Values[n]; ok {
+			continue
+		}
+		if reserved(oe.Reserved, n) {
+			c.add(Breaking, ne.Name, ne.Values[n], "reuses reserved value %d", n)
+		} else {
+			c.add(Safe, ne.Name, ne.Values[n], "value %d added", n)
+		}
+	}
+}
+
+func sortedNumbers(m map[int32]string) []int32 {
+	ns := make([]int32, 0, len(m))
+	for n := range m {
+		ns = append(ns, n)
+	}
+	sort.Slice(ns, func(i, j int) bool { return ns[i] < ns[j] })
+	return ns
+}
@@ -0,0 +1,35 @@
+package models
+
+import (
+	"github.com/ipfs/go-cid"
+
+	"github.com/daotl/go-marsha"
+)
+
+type Base struct {
+	ID int64
+}
+
+type Order struct {
+	marsha.Unknown
+	Base
+	Client string `refmt:"customer"`
+	Items  []LineItem
+	Link   *cid.Cid
+	Total  uint64
+}
+
+func (s Order) Ptr() marsha.StructPtr { return &s }
+func (s *Order) Val() marsha.Struct   { return *s }
+
+type LineItem struct {
+	Qty int64
+	SKU string
+}
+
+type Fresh struct {
+	Y string
+}
+
+func (s Fresh) Ptr() marsha.StructPtr { return &s }
+func (s *Fresh) Val() marsha.Struct   { return *s }
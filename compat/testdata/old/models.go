@@ -0,0 +1,34 @@
+package models
+
+import (
+	"github.com/ipfs/go-cid"
+
+	"github.com/daotl/go-marsha"
+)
+
+type Base struct {
+	ID int64
+}
+
+type Order struct {
+	Base
+	Customer string `refmt:"customer"`
+	Items    []Item
+	Note     string `refmt:"note,omitempty"`
+	Link     *cid.Cid
+}
+
+func (s Order) Ptr() marsha.StructPtr { return &s }
+func (s *Order) Val() marsha.Struct   { return *s }
+
+type Item struct {
+	SKU string
+	Qty int64
+}
+
+type Legacy struct {
+	X int64
+}
+
+func (s Legacy) Ptr() marsha.StructPtr { return &s }
+func (s *Legacy) Val() marsha.Struct   { return *s }
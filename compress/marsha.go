@@ -0,0 +1,415 @@
+// Package compress provides a Marsha middleware which transparently compresses the bytes and
+// streams produced by any other Marsha with `compress/flate`, `compress/gzip` or `compress/zlib`.
+package compress
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sync"
+	"sync/atomic"
+
+	"github.com/daotl/go-marsha"
+	"github.com/daotl/go-marsha/internal/frame"
+)
+
+var (
+	ErrUnknownAlgorithm = errors.New("unknown compression algorithm")
+	ErrEmptyMessage     = errors.New("compressed message is empty")
+	ErrTooLarge         = errors.New("decompressed message is too large")
+)
+
+// Algorithm is a compression algorithm. Its value is written as the flag byte preceding each
+// message, so it must not change.
+type Algorithm byte
+
+const (
+	// Raw means the message is stored uncompressed.
+	Raw Algorithm = iota
+	Flate
+	Gzip
+	Zlib
+)
+
+func (a Algorithm) String() string {
+	switch a {
+	case Raw:
+		return "raw"
+	case Flate:
+		return "flate"
+	case Gzip:
+		return "gzip"
+	case Zlib:
+		return "zlib"
+	default:
+		return fmt.Sprintf("Algorithm(%d)", byte(a))
+	}
+}
+
+const (
+	// DefaultThreshold is the default size in bytes below which messages are stored raw.
+	DefaultThreshold = 256
+	// DefaultMaxSize is the default size in bytes above which decompressed messages are rejected.
+	DefaultMaxSize = 64 << 20
+)
+
+type options struct {
+	algorithm Algorithm
+	level     int
+	threshold int
+	maxSize   int
+}
+
+// Option configures a Marsha.
+type Option func(*options)
+
+// WithAlgorithm sets the algorithm used to compress messages, Flate by default.
+// Messages compressed with any algorithm can be decompressed regardless of this option.
+func WithAlgorithm(a Algorithm) Option {
+	return func(o *options) {
+		o.algorithm = a
+	}
+}
+
+// WithLevel sets the compression level as defined by `compress/flate`,
+// `flate.DefaultCompression` by default.
+func WithLevel(level int) Option {
+	return func(o *options) {
+		o.level = level
+	}
+}
+
+// WithThreshold sets the size in bytes below which messages are stored raw, DefaultThreshold
+// by default.
+func WithThreshold(n int) Option {
+	return func(o *options) {
+		o.threshold = n
+	}
+}
+
+// WithMaxSize sets the size in bytes above which decompressed messages are rejected with
+// ErrTooLarge, DefaultMaxSize by default. It guards against small messages which decompress to
+// huge outputs. A size of 0 or less means no limit.
+func WithMaxSize(n int) Option {
+	return func(o *options) {
+		o.maxSize = n
+	}
+}
+
+// Counter counts the messages and bytes passing through a Marsha in one direction.
+type Counter struct {
+	// Messages is the count of messages.
+	Messages uint64
+	// Compressed is the count of messages stored compressed rather than raw.
+	Compressed uint64
+	// UncompressedBytes is the count of bytes produced or consumed by the wrapped Marsha.
+	UncompressedBytes uint64
+	// CompressedBytes is the count of bytes produced or consumed by this Marsha, including flags and
+	// frame headers.
+	CompressedBytes uint64
+}
+
+func (c *Counter) add(compressed bool, uncompressed, total int) {
+	atomic.AddUint64(&c.Messages, 1)
+	if compressed {
+		atomic.AddUint64(&c.Compressed, 1)
+	}
+	atomic.AddUint64(&c.UncompressedBytes, uint64(uncompressed))
+	atomic.AddUint64(&c.CompressedBytes, uint64(total))
+}
+
+func (c *Counter) load() Counter {
+	return Counter{
+		Messages:          atomic.LoadUint64(&c.Messages),
+		Compressed:        atomic.LoadUint64(&c.Compressed),
+		UncompressedBytes: atomic.LoadUint64(&c.UncompressedBytes),
+		CompressedBytes:   atomic.LoadUint64(&c.CompressedBytes),
+	}
+}
+
+// Stats holds the counters of a Marsha.
+type Stats struct {
+	// Out counts marshaled and encoded messages.
+	Out Counter
+	// In counts unmarshaled and decoded messages.
+	In Counter
+}
+
+// Marsha is a marsha.Marsha middleware which compresses the bytes produced by the wrapped Marsha.
+//
+// Each message produced by Marshal* methods is a flag byte holding the Algorithm followed by the
+// (possibly compressed) bytes. Each message transmitted by an Encoder is a flag byte followed by
+// the uvarint length of the bytes and the bytes. Messages smaller than the threshold, or which
+// compression doesn't make smaller, are stored Raw.
+type Marsha struct {
+	inner marsha.Marsha
+	opts  options
+	stats Stats
+}
+
+var _ marsha.Marsha = (*Marsha)(nil)
+
+// New creates a Marsha wrapping `m`.
+func New(m marsha.Marsha, opts ...Option) *Marsha {
+	o := options{
+		algorithm: Flate,
+		level:     flate.DefaultCompression,
+		threshold: DefaultThreshold,
+		maxSize:   DefaultMaxSize,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return &Marsha{inner: m, opts: o}
+}
+
+// Stats returns a snapshot of the counters of the Marsha, including its Encoders and Decoders.
+func (m *Marsha) Stats() Stats {
+	return Stats{Out: m.stats.Out.load(), In: m.stats.In.load()}
+}
+
+func (m *Marsha) MarshalPrimitive(p interface{}) ([]byte, error) {
+	return m.pack(m.inner.MarshalPrimitive(p))
+}
+
+func (m *Marsha) UnmarshalPrimitive(bin []byte, p interface{}) (int, error) {
+	return m.unpack(bin, func(bin []byte) (int, error) { return m.inner.UnmarshalPrimitive(bin, p) })
+}
+
+func (m *Marsha) MarshalStruct(p marsha.StructPtr) ([]byte, error) {
+	return m.pack(m.inner.MarshalStruct(p))
+}
+
+func (m *Marsha) UnmarshalStruct(bin []byte, p marsha.StructPtr) (int, error) {
+	return m.unpack(bin, func(bin []byte) (int, error) { return m.inner.UnmarshalStruct(bin, p) })
+}
+
+func (m *Marsha) MarshalStructSlice(p marsha.StructSlicePtr) ([]byte, error) {
+	return m.pack(m.inner.MarshalStructSlice(p))
+}
+
+func (m *Marsha) UnmarshalStructSlice(bin []byte, p marsha.StructSlicePtr) (int, error) {
+	return m.unpack(bin, func(bin []byte) (int, error) { return m.inner.UnmarshalStructSlice(bin, p) })
+}
+
+func (m *Marsha) NewEncoder(w io.Writer) marsha.Encoder {
+	e := &encoder{m: m, w: w}
+	e.inner = m.inner.NewEncoder(&e.buf)
+	return e
+}
+
+func (m *Marsha) NewDecoder(r io.Reader) marsha.Decoder {
+	d := &decoder{m: m, r: r}
+	d.inner = m.inner.NewDecoder(&d.buf)
+	return d
+}
+
+// pack prepends the flag byte to `bin` after compressing it if worthwhile.
+func (m *Marsha) pack(bin []byte, err error) ([]byte, error) {
+	if err != nil {
+		return nil, err
+	}
+	alg, body, err := m.compress(bin)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]byte, 1+len(body))
+	out[0] = byte(alg)
+	copy(out[1:], body)
+	m.stats.Out.add(alg != Raw, len(bin), len(out))
+	return out, nil
+}
+
+// unpack decompresses `bin` and passes the result to `unmarshal`.
+// It returns the count of bytes of `bin` read, which is all of it if the wrapped Marsha read all
+// decompressed bytes, or -1 if the wrapped Marsha does not support returning the count.
+func (m *Marsha) unpack(bin []byte, unmarshal func([]byte) (int, error)) (int, error) {
+	if len(bin) == 0 {
+		return 0, ErrEmptyMessage
+	}
+	alg := Algorithm(bin[0])
+	raw, err := m.decompress(alg, bin[1:])
+	if err != nil {
+		return 1, err
+	}
+	read, err := unmarshal(raw)
+	if err != nil {
+		return read, err
+	}
+	m.stats.In.add(alg != Raw, len(raw), len(bin))
+	switch {
+	case read == -1:
+		return -1, nil
+	case alg == Raw:
+		return 1 + read, nil
+	case read == len(raw):
+		return len(bin), nil
+	default:
+		return -1, nil
+	}
+}
+
+// compress returns the Algorithm actually used and the resulting bytes.
+func (m *Marsha) compress(bin []byte) (Algorithm, []byte, error) {
+	if m.opts.algorithm == Raw || len(bin) < m.opts.threshold {
+		return Raw, bin, nil
+	}
+	var buf bytes.Buffer
+	var w io.WriteCloser
+	var err error
+	switch m.opts.algorithm {
+	case Flate:
+		w, err = flate.NewWriter(&buf, m.opts.level)
+	case Gzip:
+		w, err = gzip.NewWriterLevel(&buf, m.opts.level)
+	case Zlib:
+		w, err = zlib.NewWriterLevel(&buf, m.opts.level)
+	default:
+		return Raw, nil, fmt.Errorf("%w: %s", ErrUnknownAlgorithm, m.opts.algorithm)
+	}
+	if err != nil {
+		return Raw, nil, err
+	}
+	if _, err = w.Write(bin); err != nil {
+		return Raw, nil, err
+	}
+	if err = w.Close(); err != nil {
+		return Raw, nil, err
+	}
+	if buf.Len() >= len(bin) {
+		return Raw, bin, nil
+	}
+	return m.opts.algorithm, buf.Bytes(), nil
+}
+
+// decompress returns the bytes compressed with `alg` in `bin`, or ErrTooLarge if there are more
+// than the maximum size.
+func (m *Marsha) decompress(alg Algorithm, bin []byte) ([]byte, error) {
+	var r io.ReadCloser
+	var err error
+	switch alg {
+	case Raw:
+		return bin, nil
+	case Flate:
+		r = flate.NewReader(bytes.NewReader(bin))
+	case Gzip:
+		r, err = gzip.NewReader(bytes.NewReader(bin))
+	case Zlib:
+		r, err = zlib.NewReader(bytes.NewReader(bin))
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnknownAlgorithm, alg)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	if m.opts.maxSize <= 0 {
+		return ioutil.ReadAll(r)
+	}
+	raw, err := ioutil.ReadAll(io.LimitReader(r, int64(m.opts.maxSize)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(raw) > m.opts.maxSize {
+		return nil, fmt.Errorf("%w: more than %d bytes", ErrTooLarge, m.opts.maxSize)
+	}
+	return raw, nil
+}
+
+type encoder struct {
+	sync.Mutex // each item must be sent atomically
+	m          *Marsha
+	inner      marsha.Encoder
+	buf        bytes.Buffer
+	w          io.Writer
+}
+
+func (e *encoder) EncodePrimitive(p interface{}) (int, error) {
+	return e.encode(func() (int, error) { return e.inner.EncodePrimitive(p) })
+}
+
+func (e *encoder) EncodeStruct(p marsha.StructPtr) (int, error) {
+	return e.encode(func() (int, error) { return e.inner.EncodeStruct(p) })
+}
+
+func (e *encoder) EncodeStructSlice(p marsha.StructSlicePtr) (int, error) {
+	return e.encode(func() (int, error) { return e.inner.EncodeStructSlice(p) })
+}
+
+// encode encodes an item with the wrapped Encoder into the buffer and transmits it as a frame.
+func (e *encoder) encode(enc func() (int, error)) (int, error) {
+	// Make sure we're single-threaded through here, so multiple
+	// goroutines can share an encoder.
+	e.Lock()
+	defer e.Unlock()
+	e.buf.Reset()
+	if _, err := enc(); err != nil {
+		return 0, err
+	}
+	alg, body, err := e.m.compress(e.buf.Bytes())
+	if err != nil {
+		return 0, err
+	}
+	n, err := frame.Write(e.w, []byte{byte(alg)}, body)
+	if err != nil {
+		return n, err
+	}
+	e.m.stats.Out.add(alg != Raw, e.buf.Len(), n)
+	return n, nil
+}
+
+type decoder struct {
+	sync.Mutex // each item must be sent atomically
+	m          *Marsha
+	inner      marsha.Decoder
+	buf        bytes.Buffer
+	r          io.Reader
+}
+
+func (d *decoder) DecodePrimitive(p interface{}) (int, error) {
+	return d.decode(func() (int, error) { return d.inner.DecodePrimitive(p) })
+}
+
+func (d *decoder) DecodeStruct(p marsha.StructPtr) (int, error) {
+	return d.decode(func() (int, error) { return d.inner.DecodeStruct(p) })
+}
+
+func (d *decoder) DecodeStructSlice(p marsha.StructSlicePtr) (int, error) {
+	return d.decode(func() (int, error) { return d.inner.DecodeStructSlice(p) })
+}
+
+// decode reads a frame into the buffer and decodes an item from it with the wrapped Decoder.
+// It returns the count of bytes of the frame read.
+func (d *decoder) decode(dec func() (int, error)) (int, error) {
+	// Make sure we're single-threaded through here, so multiple
+	// goroutines can share a decoder.
+	d.Lock()
+	defer d.Unlock()
+
+	fr := &frame.Reader{R: d.r}
+	flag, err := fr.ReadByte()
+	if err != nil {
+		return fr.N, err
+	}
+	body, err := fr.ReadBody()
+	if err != nil {
+		return fr.N, frame.NoEOF(err)
+	}
+	alg := Algorithm(flag)
+	raw, err := d.m.decompress(alg, body)
+	if err != nil {
+		return fr.N, err
+	}
+	d.buf.Reset()
+	d.buf.Write(raw)
+	if _, err = dec(); err != nil {
+		return fr.N, err
+	}
+	d.m.stats.In.add(alg != Raw, len(raw), fr.N)
+	return fr.N, nil
+}
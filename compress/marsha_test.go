@@ -0,0 +1,125 @@
+package compress_test
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/daotl/go-marsha"
+	"github.com/daotl/go-marsha/cborgen"
+	"github.com/daotl/go-marsha/compress"
+	"github.com/daotl/go-marsha/test"
+)
+
+func TestSuite(t *testing.T) {
+	for _, alg := range []compress.Algorithm{compress.Raw, compress.Flate, compress.Gzip, compress.Zlib} {
+		t.Run(alg.String(), func(t *testing.T) {
+			test.SubTestAll(t, compress.New(cborgen.New(), compress.WithAlgorithm(alg), compress.WithThreshold(0)))
+		})
+	}
+}
+
+func TestThreshold(t *testing.T) {
+	req := require.New(t)
+	asrt := assert.New(t)
+	mrsh := compress.New(cborgen.New(), compress.WithAlgorithm(compress.Gzip), compress.WithThreshold(64))
+	small := &test.TestStruct{Data: "small"}
+	large := &test.TestStruct{Data: strings.Repeat("large", 100)}
+
+	bin, err := mrsh.MarshalStruct(small)
+	req.NoError(err)
+	asrt.Equal(byte(compress.Raw), bin[0])
+
+	bin, err = mrsh.MarshalStruct(large)
+	req.NoError(err)
+	asrt.Equal(byte(compress.Gzip), bin[0])
+	asrt.Less(len(bin), len(large.Data))
+
+	s := &test.TestStruct{}
+	read, err := mrsh.UnmarshalStruct(bin, s)
+	req.NoError(err)
+	asrt.Equal(large.Data, s.Data)
+	asrt.Equal(len(bin), read)
+
+	stats := mrsh.Stats()
+	asrt.Equal(uint64(2), stats.Out.Messages)
+	asrt.Equal(uint64(1), stats.Out.Compressed)
+	asrt.Equal(uint64(1), stats.In.Messages)
+	asrt.Equal(uint64(len(bin)), stats.In.CompressedBytes)
+	asrt.Equal(uint64(len(large.Data)+4), stats.In.UncompressedBytes)
+}
+
+func TestMarshalStructAppend(t *testing.T) {
+	// Middlewares aren't AppendMarshas, so marsha.MarshalStructAppend appends a copy.
+	mrsh := compress.New(cborgen.New(), compress.WithThreshold(64))
+	s := &test.TestStruct{Data: "small"}
+	want, err := mrsh.MarshalStruct(s)
+	require.NoError(t, err)
+	bin, err := marsha.MarshalStructAppend(mrsh, []byte{0xca, 0xfe}, s)
+	require.NoError(t, err)
+	assert.Equal(t, append([]byte{0xca, 0xfe}, want...), bin)
+
+	ss := &test.TestStructs{*s}
+	want, err = mrsh.MarshalStructSlice(ss)
+	require.NoError(t, err)
+	bin, err = marsha.MarshalStructSliceAppend(mrsh, nil, ss)
+	require.NoError(t, err)
+	assert.Equal(t, want, bin)
+}
+
+func TestEncoderDecoder(t *testing.T) {
+	req := require.New(t)
+	asrt := assert.New(t)
+	mrsh := compress.New(cborgen.New(), compress.WithAlgorithm(compress.Zlib), compress.WithThreshold(64))
+	items := []*test.TestStruct{{Data: "small"}, {Data: strings.Repeat("large", 100)}, {Data: "small2"}}
+
+	var buf bytes.Buffer
+	enc := mrsh.NewEncoder(&buf)
+	total := 0
+	for _, s := range items {
+		n, err := enc.EncodeStruct(s)
+		req.NoError(err)
+		total += n
+	}
+	asrt.Equal(buf.Len(), total)
+
+	dec := mrsh.NewDecoder(&buf)
+	for _, s := range items {
+		s2 := &test.TestStruct{}
+		_, err := dec.DecodeStruct(s2)
+		req.NoError(err)
+		asrt.Equal(s.Data, s2.Data)
+	}
+
+	stats := mrsh.Stats()
+	asrt.Equal(uint64(3), stats.Out.Messages)
+	asrt.Equal(uint64(1), stats.Out.Compressed)
+	asrt.Equal(uint64(total), stats.Out.CompressedBytes)
+	asrt.Equal(stats.Out, stats.In)
+}
+
+func TestMaxSize(t *testing.T) {
+	req := require.New(t)
+	asrt := assert.New(t)
+	large := &test.TestStruct{Data: strings.Repeat("a", 8000)}
+	bin, err := compress.New(cborgen.New()).MarshalStruct(large)
+	req.NoError(err)
+	asrt.Less(len(bin), 100)
+
+	s := &test.TestStruct{}
+	_, err = compress.New(cborgen.New(), compress.WithMaxSize(4096)).UnmarshalStruct(bin, s)
+	asrt.True(errors.Is(err, compress.ErrTooLarge))
+	_, err = compress.New(cborgen.New(), compress.WithMaxSize(8004)).UnmarshalStruct(bin, s)
+	req.NoError(err)
+	asrt.Equal(large.Data, s.Data)
+
+	var buf bytes.Buffer
+	_, err = compress.New(cborgen.New()).NewEncoder(&buf).EncodeStruct(large)
+	req.NoError(err)
+	_, err = compress.New(cborgen.New(), compress.WithMaxSize(4096)).NewDecoder(&buf).DecodeStruct(s)
+	asrt.True(errors.Is(err, compress.ErrTooLarge))
+}
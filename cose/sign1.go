@@ -0,0 +1,399 @@
+// Package cose implements COSE_Sign1 (RFC 9052) envelopes signed with Ed25519 or ECDSA over the
+// CBOR bytes produced by a Marsha, typically the `cborgen` implementation.
+package cose
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+
+	cbg "github.com/daotl/cbor-gen"
+
+	"github.com/daotl/go-marsha"
+)
+
+var (
+	ErrUnsupportedKey    = errors.New("unsupported key")
+	ErrAlgorithmNotMatch = errors.New("algorithm does not match the key")
+	ErrVerification      = errors.New("signature verification failed")
+	ErrDetachedPayload   = errors.New("payload is detached")
+	ErrMalformed         = errors.New("malformed COSE_Sign1 message")
+)
+
+// TagSign1 is the CBOR tag of COSE_Sign1 messages.
+const TagSign1 = 18
+
+// Header labels from the IANA "COSE Header Parameters" registry.
+const (
+	LabelAlg = 1
+	LabelKid = 4
+)
+
+const (
+	contextSignature1 = "Signature1"
+	maxBytesLen       = cbg.ByteArrayMaxLen
+)
+
+// Headers holds COSE header parameters.
+type Headers struct {
+	// Alg is the `alg` (1) parameter, 0 if absent.
+	Alg Algorithm
+	// Kid is the `kid` (4) parameter, nil if absent.
+	Kid []byte
+	// Other holds the encoded values of other integer-labeled parameters.
+	Other map[int64][]byte
+}
+
+// Sign1 is a COSE_Sign1 message.
+type Sign1 struct {
+	Protected   Headers
+	Unprotected Headers
+	// Payload is nil if the payload is detached.
+	Payload   []byte
+	Signature []byte
+
+	// protected is the encoded protected header as signed or received.
+	protected []byte
+}
+
+// Sign signs the message with `signer`, setting the `alg` protected header and, if the Signer has a
+// key ID, the `kid` unprotected header. `externalAAD` is additional data authenticated but not
+// carried by the message, nil if none. A detached payload must be set as Payload before signing and
+// cleared afterwards.
+func (s *Sign1) Sign(signer *Signer, externalAAD []byte) (err error) {
+	s.Protected.Alg = signer.alg
+	if signer.kid != nil {
+		s.Unprotected.Kid = signer.kid
+	}
+	if s.protected, err = s.Protected.encode(); err != nil {
+		return err
+	}
+	s.Signature, err = signer.sign(toBeSigned(s.protected, externalAAD, s.Payload))
+	return err
+}
+
+// Verify verifies the signature of the message with `verifier`. `externalAAD` must be the same as
+// passed to Sign. A detached payload must be set as Payload before verifying.
+func (s *Sign1) Verify(verifier *Verifier, externalAAD []byte) error {
+	if s.Payload == nil {
+		return ErrDetachedPayload
+	}
+	if s.Protected.Alg != verifier.alg {
+		return fmt.Errorf("%w: message uses %s, key is for %s", ErrAlgorithmNotMatch,
+			s.Protected.Alg, verifier.alg)
+	}
+	protected := s.protected
+	if protected == nil {
+		var err error
+		if protected, err = s.Protected.encode(); err != nil {
+			return err
+		}
+	}
+	if !verifier.verify(toBeSigned(protected, externalAAD, s.Payload), s.Signature) {
+		return ErrVerification
+	}
+	return nil
+}
+
+// toBeSigned returns the encoded Sig_structure.
+func toBeSigned(protected, externalAAD, payload []byte) []byte {
+	var buf bytes.Buffer
+	_, _ = cbg.WriteMajorTypeHeader(&buf, cbg.MajArray, 4)
+	_, _ = cbg.WriteMajorTypeHeader(&buf, cbg.MajTextString, uint64(len(contextSignature1)))
+	buf.WriteString(contextSignature1)
+	writeBytes(&buf, protected)
+	writeBytes(&buf, externalAAD)
+	writeBytes(&buf, payload)
+	return buf.Bytes()
+}
+
+func writeBytes(buf *bytes.Buffer, b []byte) {
+	_, _ = cbg.WriteMajorTypeHeader(buf, cbg.MajByteString, uint64(len(b)))
+	buf.Write(b)
+}
+
+// MarshalCBOR writes the message tagged with TagSign1 to `w`.
+func (s *Sign1) MarshalCBOR(w io.Writer) (int, error) {
+	protected := s.protected
+	if protected == nil {
+		var err error
+		if protected, err = s.Protected.encode(); err != nil {
+			return 0, err
+		}
+	}
+	unprotected, err := s.Unprotected.encodeMap()
+	if err != nil {
+		return 0, err
+	}
+
+	var buf bytes.Buffer
+	_, _ = cbg.WriteMajorTypeHeader(&buf, cbg.MajTag, TagSign1)
+	_, _ = cbg.WriteMajorTypeHeader(&buf, cbg.MajArray, 4)
+	writeBytes(&buf, protected)
+	buf.Write(unprotected)
+	if s.Payload == nil {
+		buf.Write(cbg.CborNull)
+	} else {
+		writeBytes(&buf, s.Payload)
+	}
+	writeBytes(&buf, s.Signature)
+	return w.Write(buf.Bytes())
+}
+
+// UnmarshalCBOR reads a message, tagged with TagSign1 or not, from `r`.
+func (s *Sign1) UnmarshalCBOR(r io.Reader) (int, error) {
+	*s = Sign1{}
+	br := cbg.GetPeeker(r)
+	maj, extra, read, err := cbg.CborReadHeader(br)
+	if err != nil {
+		return read, err
+	}
+	if maj == cbg.MajTag {
+		if extra != TagSign1 {
+			return read, fmt.Errorf("%w: unexpected tag %d", ErrMalformed, extra)
+		}
+		var n int
+		maj, extra, n, err = cbg.CborReadHeader(br)
+		read += n
+		if err != nil {
+			return read, err
+		}
+	}
+	if maj != cbg.MajArray || extra != 4 {
+		return read, fmt.Errorf("%w: not a 4-element array", ErrMalformed)
+	}
+
+	protected, n, err := cbg.ReadByteArray(br, maxBytesLen)
+	read += n
+	if err != nil {
+		return read, err
+	}
+	if err = s.Protected.decode(protected); err != nil {
+		return read, err
+	}
+	s.protected = protected
+
+	n, err = s.Unprotected.decodeMap(br)
+	read += n
+	if err != nil {
+		return read, err
+	}
+	// RFC 9052 section 3: labels must not appear in both the protected and unprotected headers.
+	if label, ok := s.Protected.overlap(&s.Unprotected); ok {
+		return read, fmt.Errorf("%w: header label %d is both protected and unprotected", ErrMalformed, label)
+	}
+
+	b, err := br.ReadByte()
+	if err != nil {
+		return read, err
+	}
+	if b == cbg.CborNull[0] {
+		read++
+	} else {
+		if err = br.UnreadByte(); err != nil {
+			return read, err
+		}
+		s.Payload, n, err = cbg.ReadByteArray(br, maxBytesLen)
+		read += n
+		if err != nil {
+			return read, err
+		}
+	}
+
+	s.Signature, n, err = cbg.ReadByteArray(br, maxBytesLen)
+	read += n
+	return read, err
+}
+
+// encode returns the protected header encoding: an empty byte string for no parameters, otherwise
+// the encoded map.
+func (h *Headers) encode() ([]byte, error) {
+	if h.Alg == 0 && h.Kid == nil && len(h.Other) == 0 {
+		return []byte{}, nil
+	}
+	return h.encodeMap()
+}
+
+// encodeMap encodes the parameters as a map with keys in deterministic order.
+func (h *Headers) encodeMap() ([]byte, error) {
+	type entry struct{ key, val []byte }
+	var entries []entry
+	if h.Alg != 0 {
+		entries = append(entries, entry{encodeInt(LabelAlg), encodeInt(int64(h.Alg))})
+	}
+	if h.Kid != nil {
+		var val bytes.Buffer
+		writeBytes(&val, h.Kid)
+		entries = append(entries, entry{encodeInt(LabelKid), val.Bytes()})
+	}
+	for label, val := range h.Other {
+		if label == LabelAlg || label == LabelKid {
+			return nil, fmt.Errorf("%w: label %d must not be in Other", ErrMalformed, label)
+		}
+		entries = append(entries, entry{encodeInt(label), val})
+	}
+	// RFC 8949 section 4.2.1: sort keys by the bytewise lexicographic order of their encodings.
+	sort.Slice(entries, func(i, j int) bool { return bytes.Compare(entries[i].key, entries[j].key) < 0 })
+
+	var buf bytes.Buffer
+	_, _ = cbg.WriteMajorTypeHeader(&buf, cbg.MajMap, uint64(len(entries)))
+	for _, e := range entries {
+		buf.Write(e.key)
+		buf.Write(e.val)
+	}
+	return buf.Bytes(), nil
+}
+
+func (h *Headers) decode(bin []byte) error {
+	if len(bin) == 0 {
+		return nil
+	}
+	r := bytes.NewReader(bin)
+	if _, err := h.decodeMap(r); err != nil {
+		return err
+	}
+	if r.Len() != 0 {
+		return fmt.Errorf("%w: trailing bytes in protected header", ErrMalformed)
+	}
+	return nil
+}
+
+func (h *Headers) decodeMap(r io.Reader) (int, error) {
+	maj, extra, read, err := cbg.CborReadHeader(r)
+	if err != nil {
+		return read, err
+	}
+	if maj != cbg.MajMap {
+		return read, fmt.Errorf("%w: header is not a map", ErrMalformed)
+	}
+	if extra > cbg.MaxLength {
+		return read, fmt.Errorf("%w: header map too long", ErrMalformed)
+	}
+	seen := make(map[int64]bool, extra)
+	for i := uint64(0); i < extra; i++ {
+		label, n, err := readInt(r)
+		read += n
+		if err != nil {
+			return read, err
+		}
+		// RFC 9052 section 3: labels must be unique within a header map.
+		if seen[label] {
+			return read, fmt.Errorf("%w: duplicate header label %d", ErrMalformed, label)
+		}
+		seen[label] = true
+		switch label {
+		case LabelAlg:
+			alg, n, err := readInt(r)
+			read += n
+			if err != nil {
+				return read, err
+			}
+			h.Alg = Algorithm(alg)
+		case LabelKid:
+			h.Kid, n, err = cbg.ReadByteArray(r, maxBytesLen)
+			read += n
+			if err != nil {
+				return read, err
+			}
+		default:
+			var d cbg.Deferred
+			n, err := d.UnmarshalCBOR(r)
+			read += n
+			if err != nil {
+				return read, err
+			}
+			if h.Other == nil {
+				h.Other = make(map[int64][]byte)
+			}
+			h.Other[label] = d.Raw
+		}
+	}
+	return read, nil
+}
+
+// has reports whether the parameter labeled `label` is present.
+func (h *Headers) has(label int64) bool {
+	switch label {
+	case LabelAlg:
+		return h.Alg != 0
+	case LabelKid:
+		return h.Kid != nil
+	default:
+		_, ok := h.Other[label]
+		return ok
+	}
+}
+
+// overlap returns a label present in both `h` and `o`.
+func (h *Headers) overlap(o *Headers) (int64, bool) {
+	for _, label := range []int64{LabelAlg, LabelKid} {
+		if h.has(label) && o.has(label) {
+			return label, true
+		}
+	}
+	for label := range h.Other {
+		if o.has(label) {
+			return label, true
+		}
+	}
+	return 0, false
+}
+
+func encodeInt(i int64) []byte {
+	if i < 0 {
+		return cbg.CborEncodeMajorType(cbg.MajNegativeInt, uint64(-1-i))
+	}
+	return cbg.CborEncodeMajorType(cbg.MajUnsignedInt, uint64(i))
+}
+
+func readInt(r io.Reader) (int64, int, error) {
+	maj, extra, read, err := cbg.CborReadHeader(r)
+	if err != nil {
+		return 0, read, err
+	}
+	if extra > 1<<63-1 {
+		return 0, read, fmt.Errorf("%w: integer overflow", ErrMalformed)
+	}
+	switch maj {
+	case cbg.MajUnsignedInt:
+		return int64(extra), read, nil
+	case cbg.MajNegativeInt:
+		return -1 - int64(extra), read, nil
+	default:
+		return 0, read, fmt.Errorf("%w: expected an integer label or value", ErrMalformed)
+	}
+}
+
+// SignStruct marshals the struct `p` points to with `m` and returns it signed by `signer` as an
+// encoded COSE_Sign1 message.
+func SignStruct(m marsha.Marsha, p marsha.StructPtr, signer *Signer) ([]byte, error) {
+	payload, err := m.MarshalStruct(p)
+	if err != nil {
+		return nil, err
+	}
+	s := &Sign1{Payload: payload}
+	if err = s.Sign(signer, nil); err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if _, err = s.MarshalCBOR(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// VerifyStruct verifies the encoded COSE_Sign1 message `bin` with `verifier` and unmarshals its
+// payload with `m` into the struct `p` points to. `p` is not modified if verification fails.
+func VerifyStruct(m marsha.Marsha, bin []byte, p marsha.StructPtr, verifier *Verifier) error {
+	s := &Sign1{}
+	if _, err := s.UnmarshalCBOR(bytes.NewReader(bin)); err != nil {
+		return err
+	}
+	if err := s.Verify(verifier, nil); err != nil {
+		return err
+	}
+	_, err := m.UnmarshalStruct(s.Payload, p)
+	return err
+}
@@ -0,0 +1,189 @@
+package cose_test
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/daotl/go-marsha/cborgen"
+	"github.com/daotl/go-marsha/cose"
+	"github.com/daotl/go-marsha/test"
+)
+
+func unhex(s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+// Key "11" of the COSE examples (RFC 8152 appendix C.7).
+func p256Key11() *ecdsa.PrivateKey {
+	k := &ecdsa.PrivateKey{D: new(big.Int).SetBytes(unhex(
+		"57c92077664146e876760c9520d054aa93c3afb04e306705db6090308507b4d3"))}
+	k.Curve = elliptic.P256()
+	k.X = new(big.Int).SetBytes(unhex("bac5b11cad8f99f9c72b05cf4b9e26d244dc189f745228255a219a86d6a09eff"))
+	k.Y = new(big.Int).SetBytes(unhex("20138bf82dc1b6d562be0fa54ab7804a3a64b6d72ccfed6b6fb6ed28bbfc117e"))
+	return k
+}
+
+// Test key 1 of RFC 8032 section 7.1.
+func ed25519Key() ed25519.PrivateKey {
+	return ed25519.NewKeyFromSeed(unhex("9d61b19deffd5a60ba844af492ec2cc44449c5697b326919703bac031cae7f60"))
+}
+
+var vectors = []struct {
+	name string
+	msg  string
+}{
+	{
+		// RFC 8152 appendix C.2.1: single ECDSA signature.
+		name: "ES256",
+		msg: "d28443a10126a10442313154546869732069732074686520636f6e74656e742e58408eb33e4ca31d1c465ab0" +
+			"5aac34cc6b23d58fef5c083106c4d25a91aef0b0117e2af9a291aa32e14ab834dc56ed2a223444547e01f11d" +
+			"3b0916e5a4c345cacb36",
+	},
+	{
+		// The same message signed with EdDSA by test key 1 of RFC 8032. EdDSA signatures are
+		// deterministic, and TestVectors checks this one against crypto/ed25519 directly.
+		name: "EdDSA",
+		msg: "d28443a10127a10442313154546869732069732074686520636f6e74656e742e58406354488f9f290e36cd80" +
+			"e23762e664a5cb03e4267c66a8cffaef7c66d89a40bf2cbb8222432a08e5ee410d8b540c6931d26fb6af673f" +
+			"7e2100655d8bae765c04",
+	},
+}
+
+func TestVectors(t *testing.T) {
+	req := require.New(t)
+	asrt := assert.New(t)
+	verifiers := map[string]*cose.Verifier{}
+	v, err := cose.NewVerifier(&p256Key11().PublicKey)
+	req.NoError(err)
+	verifiers["ES256"] = v
+	v, err = cose.NewVerifier(ed25519Key().Public())
+	req.NoError(err)
+	verifiers["EdDSA"] = v
+
+	for _, vec := range vectors {
+		t.Run(vec.name, func(t *testing.T) {
+			bin := unhex(vec.msg)
+			s := &cose.Sign1{}
+			read, err := s.UnmarshalCBOR(bytes.NewReader(bin))
+			req.NoError(err)
+			asrt.Equal(len(bin), read)
+			asrt.Equal([]byte("11"), s.Unprotected.Kid)
+			asrt.Equal("This is the content.", string(s.Payload))
+			req.NoError(s.Verify(verifiers[vec.name], nil))
+
+			var buf bytes.Buffer
+			_, err = s.MarshalCBOR(&buf)
+			req.NoError(err)
+			asrt.Equal(bin, buf.Bytes())
+
+			s.Payload[0] ^= 1
+			asrt.Equal(cose.ErrVerification, s.Verify(verifiers[vec.name], nil))
+		})
+	}
+
+	t.Run("EdDSA signature", func(t *testing.T) {
+		// Sig_structure of RFC 9052 section 4.4: ["Signature1", h'a10127', h'', payload].
+		toBeSigned := unhex("846a5369676e61747572653143a101274054546869732069732074686520636f6e74656e742e")
+		msg := unhex(vectors[1].msg)
+		asrt.Equal(ed25519.Sign(ed25519Key(), toBeSigned), msg[len(msg)-ed25519.SignatureSize:])
+	})
+
+	t.Run("EdDSA deterministic", func(t *testing.T) {
+		signer, err := cose.NewSigner(ed25519Key(), []byte("11"))
+		req.NoError(err)
+		s := &cose.Sign1{Payload: []byte("This is the content.")}
+		req.NoError(s.Sign(signer, nil))
+		var buf bytes.Buffer
+		_, err = s.MarshalCBOR(&buf)
+		req.NoError(err)
+		asrt.Equal(unhex(vectors[1].msg), buf.Bytes())
+	})
+}
+
+func TestSignVerifyStruct(t *testing.T) {
+	req := require.New(t)
+	asrt := assert.New(t)
+	mrsh := cborgen.New()
+	s := &test.TestStruct{Data: "test"}
+
+	p384, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	req.NoError(err)
+	_, ed, err := ed25519.GenerateKey(rand.Reader)
+	req.NoError(err)
+
+	for _, tc := range []struct {
+		signer   func() (*cose.Signer, error)
+		verifier func() (*cose.Verifier, error)
+	}{
+		{
+			func() (*cose.Signer, error) { return cose.NewSigner(p384, []byte("p384")) },
+			func() (*cose.Verifier, error) { return cose.NewVerifier(&p384.PublicKey) },
+		},
+		{
+			func() (*cose.Signer, error) { return cose.NewSigner(ed, nil) },
+			func() (*cose.Verifier, error) { return cose.NewVerifier(ed.Public()) },
+		},
+	} {
+		signer, err := tc.signer()
+		req.NoError(err)
+		verifier, err := tc.verifier()
+		req.NoError(err)
+
+		t.Run(signer.Algorithm().String(), func(t *testing.T) {
+			bin, err := cose.SignStruct(mrsh, s, signer)
+			req.NoError(err)
+			s2 := &test.TestStruct{}
+			req.NoError(cose.VerifyStruct(mrsh, bin, s2, verifier))
+			asrt.Equal(s.Data, s2.Data)
+
+			bin[len(bin)-1] ^= 1
+			s3 := &test.TestStruct{}
+			asrt.Equal(cose.ErrVerification, cose.VerifyStruct(mrsh, bin, s3, verifier))
+			asrt.Empty(s3.Data)
+		})
+	}
+
+	t.Run("Error: algorithm does not match", func(t *testing.T) {
+		signer, err := cose.NewSigner(ed, nil)
+		req.NoError(err)
+		bin, err := cose.SignStruct(mrsh, s, signer)
+		req.NoError(err)
+		verifier, err := cose.NewVerifier(&p384.PublicKey)
+		req.NoError(err)
+		err = cose.VerifyStruct(mrsh, bin, &test.TestStruct{}, verifier)
+		asrt.True(errors.Is(err, cose.ErrAlgorithmNotMatch))
+	})
+}
+
+func TestMalformed(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		msg  string
+	}{
+		// Unprotected header {4: h'3131', 4: h'3131'}.
+		{"duplicate unprotected label", "d28443a10127a2044231310442313154546869732069732074686520636f6e74656e742e40"},
+		// Protected header {1: -8, 1: -8}.
+		{"duplicate protected label", "d28445a201270127a054546869732069732074686520636f6e74656e742e40"},
+		// Protected header {1: -8} and unprotected header {1: -8}.
+		{"protected and unprotected label", "d28443a10127a1012754546869732069732074686520636f6e74656e742e40"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := (&cose.Sign1{}).UnmarshalCBOR(bytes.NewReader(unhex(tc.msg)))
+			assert.True(t, errors.Is(err, cose.ErrMalformed), "%v", err)
+		})
+	}
+}
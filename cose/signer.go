@@ -0,0 +1,165 @@
+package cose
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	_ "crypto/sha256" // registers crypto.SHA256
+	_ "crypto/sha512" // registers crypto.SHA384 and crypto.SHA512
+	"fmt"
+	"io"
+	"math/big"
+)
+
+// Algorithm is a COSE algorithm identifier from the IANA "COSE Algorithms" registry.
+type Algorithm int64
+
+const (
+	// ES256 is ECDSA with curve P-256 and SHA-256.
+	ES256 Algorithm = -7
+	// EdDSA is EdDSA, of which only Ed25519 is supported.
+	EdDSA Algorithm = -8
+	// ES384 is ECDSA with curve P-384 and SHA-384.
+	ES384 Algorithm = -35
+	// ES512 is ECDSA with curve P-521 and SHA-512.
+	ES512 Algorithm = -36
+)
+
+func (a Algorithm) String() string {
+	switch a {
+	case ES256:
+		return "ES256"
+	case EdDSA:
+		return "EdDSA"
+	case ES384:
+		return "ES384"
+	case ES512:
+		return "ES512"
+	default:
+		return fmt.Sprintf("Algorithm(%d)", int64(a))
+	}
+}
+
+// hash returns the hash function used by the ECDSA algorithm `a`.
+func (a Algorithm) hash() crypto.Hash {
+	switch a {
+	case ES256:
+		return crypto.SHA256
+	case ES384:
+		return crypto.SHA384
+	case ES512:
+		return crypto.SHA512
+	default:
+		return 0
+	}
+}
+
+// ecdsaAlgorithm returns the algorithm using `curve`.
+func ecdsaAlgorithm(curve elliptic.Curve) (Algorithm, error) {
+	switch curve {
+	case elliptic.P256():
+		return ES256, nil
+	case elliptic.P384():
+		return ES384, nil
+	case elliptic.P521():
+		return ES512, nil
+	default:
+		return 0, fmt.Errorf("%w: curve %s", ErrUnsupportedKey, curve.Params().Name)
+	}
+}
+
+// Signer signs COSE_Sign1 messages with an Ed25519 or ECDSA private key.
+type Signer struct {
+	alg  Algorithm
+	key  crypto.Signer
+	kid  []byte
+	rand io.Reader
+}
+
+// NewSigner creates a Signer for an `ed25519.PrivateKey` or `*ecdsa.PrivateKey`. The algorithm is
+// derived from the key. If `kid` is not nil, it is put in the unprotected header of the messages
+// signed.
+func NewSigner(key crypto.Signer, kid []byte) (*Signer, error) {
+	s := &Signer{key: key, kid: kid, rand: rand.Reader}
+	switch k := key.(type) {
+	case ed25519.PrivateKey:
+		s.alg = EdDSA
+	case *ecdsa.PrivateKey:
+		alg, err := ecdsaAlgorithm(k.Curve)
+		if err != nil {
+			return nil, err
+		}
+		s.alg = alg
+	default:
+		return nil, fmt.Errorf("%w: %T", ErrUnsupportedKey, key)
+	}
+	return s, nil
+}
+
+// Algorithm returns the algorithm of the Signer.
+func (s *Signer) Algorithm() Algorithm { return s.alg }
+
+// sign signs `toBeSigned`, returning ECDSA signatures as the fixed-length concatenation of r and s.
+func (s *Signer) sign(toBeSigned []byte) ([]byte, error) {
+	if s.alg == EdDSA {
+		return s.key.Sign(s.rand, toBeSigned, crypto.Hash(0))
+	}
+	h := s.alg.hash().New()
+	h.Write(toBeSigned)
+	k := s.key.(*ecdsa.PrivateKey)
+	r, ss, err := ecdsa.Sign(s.rand, k, h.Sum(nil))
+	if err != nil {
+		return nil, err
+	}
+	size := (k.Curve.Params().BitSize + 7) / 8
+	sig := make([]byte, 2*size)
+	r.FillBytes(sig[:size])
+	ss.FillBytes(sig[size:])
+	return sig, nil
+}
+
+// Verifier verifies COSE_Sign1 messages with an Ed25519 or ECDSA public key.
+type Verifier struct {
+	alg Algorithm
+	key crypto.PublicKey
+}
+
+// NewVerifier creates a Verifier for an `ed25519.PublicKey` or `*ecdsa.PublicKey`.
+// The algorithm is derived from the key, and messages signed with any other algorithm are rejected.
+func NewVerifier(key crypto.PublicKey) (*Verifier, error) {
+	v := &Verifier{key: key}
+	switch k := key.(type) {
+	case ed25519.PublicKey:
+		v.alg = EdDSA
+	case *ecdsa.PublicKey:
+		alg, err := ecdsaAlgorithm(k.Curve)
+		if err != nil {
+			return nil, err
+		}
+		v.alg = alg
+	default:
+		return nil, fmt.Errorf("%w: %T", ErrUnsupportedKey, key)
+	}
+	return v, nil
+}
+
+// Algorithm returns the algorithm of the Verifier.
+func (v *Verifier) Algorithm() Algorithm { return v.alg }
+
+func (v *Verifier) verify(toBeSigned, sig []byte) bool {
+	if v.alg == EdDSA {
+		return ed25519.Verify(v.key.(ed25519.PublicKey), toBeSigned, sig)
+	}
+	k := v.key.(*ecdsa.PublicKey)
+	size := (k.Curve.Params().BitSize + 7) / 8
+	if len(sig) != 2*size {
+		return false
+	}
+	h := v.alg.hash().New()
+	h.Write(toBeSigned)
+	r := new(big.Int).SetBytes(sig[:size])
+	s := new(big.Int).SetBytes(sig[size:])
+	return ecdsa.Verify(k, h.Sum(nil), r, s)
+}
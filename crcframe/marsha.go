@@ -0,0 +1,419 @@
+// Package crcframe provides a Marsha middleware which frames each record produced by any other
+// Marsha with its length and CRC32C checksums, so corruption is detected on decoding and a Decoder
+// can resynchronise past corrupted records.
+package crcframe
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"sync"
+
+	"github.com/daotl/go-marsha"
+)
+
+var (
+	ErrCorrupt  = errors.New("corrupted record")
+	ErrTooLarge = errors.New("record too large")
+)
+
+// Magic marks the start of each frame.
+var Magic = [4]byte{0xc3, 0x5d, 0x9a, 0x1e}
+
+const (
+	// HeaderSize is the size of a frame header: Magic, the big-endian uint32 length of the record and
+	// the CRC32C of the length.
+	HeaderSize = 12
+	// TrailerSize is the size of a frame trailer: the CRC32C of the record.
+	TrailerSize = 4
+	// DefaultMaxRecordSize is the default maximum size of a record.
+	DefaultMaxRecordSize = 64 << 20
+)
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// CorruptError reports a corrupted frame.
+type CorruptError struct {
+	// Offset is the offset of the frame from the start of the input.
+	Offset int64
+	// Reason describes the corruption.
+	Reason string
+}
+
+func (e *CorruptError) Error() string {
+	return fmt.Sprintf("%s at offset %d: %s", ErrCorrupt, e.Offset, e.Reason)
+}
+
+func (e *CorruptError) Unwrap() error { return ErrCorrupt }
+
+// Skipped reports bytes skipped by a Decoder while resynchronising past corrupted records.
+type Skipped struct {
+	// Offset is the offset of the first byte skipped from the start of the input.
+	Offset int64
+	// Length is the count of bytes skipped.
+	Length int64
+	// Err is the corruption which triggered resynchronisation.
+	Err *CorruptError
+}
+
+type options struct {
+	maxRecordSize int
+	resync        bool
+	onSkip        func(Skipped)
+}
+
+// Option configures a Marsha.
+type Option func(*options)
+
+// WithMaxRecordSize sets the maximum size of a record, DefaultMaxRecordSize by default.
+// Frames announcing larger records are treated as corrupted.
+func WithMaxRecordSize(n int) Option {
+	return func(o *options) {
+		o.maxRecordSize = n
+	}
+}
+
+// WithResync makes Decoders skip corrupted records and resume at the next valid frame instead of
+// returning a CorruptError. `onSkip`, if not nil, is called with each run of bytes skipped.
+func WithResync(onSkip func(Skipped)) Option {
+	return func(o *options) {
+		o.resync = true
+		o.onSkip = onSkip
+	}
+}
+
+// Marsha is a marsha.Marsha middleware which frames the records produced by the wrapped Marsha.
+//
+// A frame is laid out as:
+//
+//   Magic (4 bytes) | length (4 bytes) | CRC32C of length (4 bytes) | record | CRC32C of record (4 bytes)
+//
+// Integers are big-endian. The checksum of the length prevents a corrupted length from making a
+// Decoder read far ahead, and Magic lets it find the next frame after a corrupted one.
+type Marsha struct {
+	inner marsha.Marsha
+	opts  options
+}
+
+var _ marsha.Marsha = (*Marsha)(nil)
+
+// New creates a Marsha wrapping `m`.
+func New(m marsha.Marsha, opts ...Option) *Marsha {
+	o := options{maxRecordSize: DefaultMaxRecordSize}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return &Marsha{inner: m, opts: o}
+}
+
+func (m *Marsha) MarshalPrimitive(p interface{}) ([]byte, error) {
+	return m.frame(m.inner.MarshalPrimitive(p))
+}
+
+func (m *Marsha) UnmarshalPrimitive(bin []byte, p interface{}) (int, error) {
+	return m.unframe(bin, func(bin []byte) (int, error) { return m.inner.UnmarshalPrimitive(bin, p) })
+}
+
+func (m *Marsha) MarshalStruct(p marsha.StructPtr) ([]byte, error) {
+	return m.frame(m.inner.MarshalStruct(p))
+}
+
+func (m *Marsha) UnmarshalStruct(bin []byte, p marsha.StructPtr) (int, error) {
+	return m.unframe(bin, func(bin []byte) (int, error) { return m.inner.UnmarshalStruct(bin, p) })
+}
+
+func (m *Marsha) MarshalStructSlice(p marsha.StructSlicePtr) ([]byte, error) {
+	return m.frame(m.inner.MarshalStructSlice(p))
+}
+
+func (m *Marsha) UnmarshalStructSlice(bin []byte, p marsha.StructSlicePtr) (int, error) {
+	return m.unframe(bin, func(bin []byte) (int, error) { return m.inner.UnmarshalStructSlice(bin, p) })
+}
+
+func (m *Marsha) NewEncoder(w io.Writer) marsha.Encoder {
+	e := &encoder{m: m, w: w}
+	e.inner = m.inner.NewEncoder(&e.buf)
+	return e
+}
+
+// NewDecoder returns a new decoder that reads from the io.Reader.
+// The decoder buffers input, so it may read beyond the last frame it decodes.
+func (m *Marsha) NewDecoder(r io.Reader) marsha.Decoder {
+	d := &decoder{m: m, r: r}
+	d.inner = m.inner.NewDecoder(&d.body)
+	return d
+}
+
+func (m *Marsha) frame(rec []byte, err error) ([]byte, error) {
+	if err != nil {
+		return nil, err
+	}
+	if len(rec) > m.opts.maxRecordSize {
+		return nil, ErrTooLarge
+	}
+	out := make([]byte, HeaderSize+len(rec)+TrailerSize)
+	putHeader(out, len(rec))
+	copy(out[HeaderSize:], rec)
+	binary.BigEndian.PutUint32(out[HeaderSize+len(rec):], crc32.Checksum(rec, castagnoli))
+	return out, nil
+}
+
+// unframe verifies the frame `bin` and passes its record to `unmarshal`.
+// It returns the count of bytes of `bin` read, which is the whole frame if the wrapped Marsha read
+// all of the record, or -1 otherwise.
+func (m *Marsha) unframe(bin []byte, unmarshal func([]byte) (int, error)) (int, error) {
+	l, err := m.checkHeader(bin, 0)
+	if err != nil {
+		return 0, err
+	}
+	if len(bin) < HeaderSize+l+TrailerSize {
+		return 0, &CorruptError{Reason: "truncated record"}
+	}
+	if err = checkRecord(bin[:HeaderSize+l+TrailerSize], 0); err != nil {
+		return 0, err
+	}
+	rec := bin[HeaderSize : HeaderSize+l]
+	read, err := unmarshal(rec)
+	if err != nil {
+		return read, err
+	}
+	if read == len(rec) {
+		return HeaderSize + l + TrailerSize, nil
+	}
+	return -1, nil
+}
+
+func putHeader(h []byte, l int) {
+	copy(h, Magic[:])
+	binary.BigEndian.PutUint32(h[4:], uint32(l))
+	binary.BigEndian.PutUint32(h[8:], crc32.Checksum(h[4:8], castagnoli))
+}
+
+// checkHeader verifies the header at the start of `h` and returns the record length.
+func (m *Marsha) checkHeader(h []byte, off int64) (int, error) {
+	if len(h) < HeaderSize {
+		return 0, &CorruptError{Offset: off, Reason: "truncated header"}
+	}
+	if !bytes.Equal(h[:4], Magic[:]) {
+		return 0, &CorruptError{Offset: off, Reason: "bad magic"}
+	}
+	if crc32.Checksum(h[4:8], castagnoli) != binary.BigEndian.Uint32(h[8:12]) {
+		return 0, &CorruptError{Offset: off, Reason: "header checksum mismatch"}
+	}
+	l := binary.BigEndian.Uint32(h[4:8])
+	if uint64(l) > uint64(m.opts.maxRecordSize) {
+		return 0, &CorruptError{Offset: off, Reason: fmt.Sprintf("record length %d too large", l)}
+	}
+	return int(l), nil
+}
+
+// checkRecord verifies the checksum of the record in the whole frame `f`.
+func checkRecord(f []byte, off int64) error {
+	rec := f[HeaderSize : len(f)-TrailerSize]
+	if crc32.Checksum(rec, castagnoli) != binary.BigEndian.Uint32(f[len(f)-TrailerSize:]) {
+		return &CorruptError{Offset: off, Reason: "record checksum mismatch"}
+	}
+	return nil
+}
+
+type encoder struct {
+	sync.Mutex // each item must be sent atomically
+	m          *Marsha
+	inner      marsha.Encoder
+	buf        bytes.Buffer
+	w          io.Writer
+}
+
+func (e *encoder) EncodePrimitive(p interface{}) (int, error) {
+	return e.encode(func() (int, error) { return e.inner.EncodePrimitive(p) })
+}
+
+func (e *encoder) EncodeStruct(p marsha.StructPtr) (int, error) {
+	return e.encode(func() (int, error) { return e.inner.EncodeStruct(p) })
+}
+
+func (e *encoder) EncodeStructSlice(p marsha.StructSlicePtr) (int, error) {
+	return e.encode(func() (int, error) { return e.inner.EncodeStructSlice(p) })
+}
+
+// encode encodes an item with the wrapped Encoder into the buffer and transmits it framed.
+func (e *encoder) encode(enc func() (int, error)) (int, error) {
+	// Make sure we're single-threaded through here, so multiple
+	// goroutines can share an encoder.
+	e.Lock()
+	defer e.Unlock()
+	e.buf.Reset()
+	if _, err := enc(); err != nil {
+		return 0, err
+	}
+	f, err := e.m.frame(e.buf.Bytes(), nil)
+	if err != nil {
+		return 0, err
+	}
+	return e.w.Write(f)
+}
+
+type decoder struct {
+	sync.Mutex // each item must be sent atomically
+	m          *Marsha
+	inner      marsha.Decoder
+	r          io.Reader
+	buf        []byte // bytes read from r but not consumed yet
+	off        int64  // offset of buf[0] from the start of the input
+	eof        bool
+	body       bytes.Buffer
+}
+
+func (d *decoder) DecodePrimitive(p interface{}) (int, error) {
+	return d.decode(func() (int, error) { return d.inner.DecodePrimitive(p) })
+}
+
+func (d *decoder) DecodeStruct(p marsha.StructPtr) (int, error) {
+	return d.decode(func() (int, error) { return d.inner.DecodeStruct(p) })
+}
+
+func (d *decoder) DecodeStructSlice(p marsha.StructSlicePtr) (int, error) {
+	return d.decode(func() (int, error) { return d.inner.DecodeStructSlice(p) })
+}
+
+// decode reads the next valid frame and decodes an item from its record with the wrapped Decoder.
+// It returns the count of bytes read, including bytes skipped while resynchronising.
+func (d *decoder) decode(dec func() (int, error)) (int, error) {
+	// Make sure we're single-threaded through here, so multiple
+	// goroutines can share a decoder.
+	d.Lock()
+	defer d.Unlock()
+
+	start := d.off
+	rec, err := d.next()
+	if err != nil {
+		return int(d.off - start), err
+	}
+	d.body.Reset()
+	d.body.Write(rec)
+	_, err = dec()
+	return int(d.off - start), err
+}
+
+// next returns the record of the next valid frame, resynchronising past corrupted frames if
+// enabled.
+func (d *decoder) next() ([]byte, error) {
+	var skipped *Skipped
+	for {
+		rec, err := d.frame()
+		if err == nil || err == io.EOF && skipped == nil {
+			if skipped != nil && d.m.opts.onSkip != nil {
+				d.m.opts.onSkip(*skipped)
+			}
+			return rec, err
+		}
+		cerr, ok := err.(*CorruptError)
+		if !ok && err != io.EOF || !d.m.opts.resync {
+			return nil, err
+		}
+		if skipped == nil {
+			skipped = &Skipped{Offset: d.off, Err: cerr}
+		}
+		if err == io.EOF {
+			// The input ended in the middle of skipped bytes.
+			if d.m.opts.onSkip != nil {
+				d.m.opts.onSkip(*skipped)
+			}
+			return nil, io.EOF
+		}
+		n, err := d.seekMagic()
+		skipped.Length += n
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+	}
+}
+
+// frame consumes and returns the record of a valid frame at the start of the buffer.
+// On corruption, the frame is not consumed.
+func (d *decoder) frame() ([]byte, error) {
+	if err := d.fill(HeaderSize); err != nil {
+		if err == io.EOF && len(d.buf) > 0 {
+			return nil, &CorruptError{Offset: d.off, Reason: "truncated header"}
+		}
+		return nil, err
+	}
+	l, err := d.m.checkHeader(d.buf, d.off)
+	if err != nil {
+		return nil, err
+	}
+	n := HeaderSize + l + TrailerSize
+	if err = d.fill(n); err != nil {
+		if err == io.EOF {
+			return nil, &CorruptError{Offset: d.off, Reason: "truncated record"}
+		}
+		return nil, err
+	}
+	if err = checkRecord(d.buf[:n], d.off); err != nil {
+		return nil, err
+	}
+	rec := append([]byte(nil), d.buf[HeaderSize:n-TrailerSize]...)
+	d.consume(n)
+	return rec, nil
+}
+
+// seekMagic consumes bytes up to the next occurrence of Magic after the first byte of the buffer
+// and returns the count of bytes consumed. It returns io.EOF with all bytes consumed if there is no
+// such occurrence.
+func (d *decoder) seekMagic() (int64, error) {
+	var n int64
+	d.consume(1)
+	n++
+	for {
+		if i := bytes.Index(d.buf, Magic[:]); i >= 0 {
+			d.consume(i)
+			return n + int64(i), nil
+		}
+		// Keep a possible prefix of Magic at the end of the buffer.
+		keep := len(Magic) - 1
+		if keep > len(d.buf) {
+			keep = len(d.buf)
+		}
+		drop := len(d.buf) - keep
+		d.consume(drop)
+		n += int64(drop)
+		if err := d.fill(len(d.buf) + 4096); err != nil && err != io.EOF {
+			return n, err
+		}
+		if d.eof && bytes.Index(d.buf, Magic[:]) < 0 {
+			n += int64(len(d.buf))
+			d.consume(len(d.buf))
+			return n, io.EOF
+		}
+	}
+}
+
+// fill reads until the buffer holds at least `n` bytes, returning io.EOF if the input ends first.
+func (d *decoder) fill(n int) error {
+	for len(d.buf) < n {
+		if d.eof {
+			return io.EOF
+		}
+		if cap(d.buf)-len(d.buf) < n-len(d.buf) {
+			buf := make([]byte, len(d.buf), n+4096)
+			copy(buf, d.buf)
+			d.buf = buf
+		}
+		m, err := d.r.Read(d.buf[len(d.buf):cap(d.buf)])
+		d.buf = d.buf[:len(d.buf)+m]
+		if err == io.EOF {
+			d.eof = true
+		} else if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *decoder) consume(n int) {
+	d.buf = d.buf[n:]
+	d.off += int64(n)
+}
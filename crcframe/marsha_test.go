@@ -0,0 +1,115 @@
+package crcframe_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/daotl/go-marsha/cborgen"
+	"github.com/daotl/go-marsha/crcframe"
+	"github.com/daotl/go-marsha/test"
+)
+
+func TestSuite(t *testing.T) {
+	test.SubTestAll(t, crcframe.New(cborgen.New()))
+}
+
+// encode encodes `items` and returns the stream and the offsets of the frames.
+func encode(t *testing.T, mrsh *crcframe.Marsha, items []string) ([]byte, []int) {
+	var buf bytes.Buffer
+	enc := mrsh.NewEncoder(&buf)
+	var offsets []int
+	for _, s := range items {
+		offsets = append(offsets, buf.Len())
+		_, err := enc.EncodeStruct(&test.TestStruct{Data: s})
+		require.NoError(t, err)
+	}
+	return buf.Bytes(), offsets
+}
+
+func TestCorruption(t *testing.T) {
+	req := require.New(t)
+	asrt := assert.New(t)
+	items := []string{"first", "second", "third"}
+
+	t.Run("UnmarshalStruct error: corrupted record", func(t *testing.T) {
+		mrsh := crcframe.New(cborgen.New())
+		bin, err := mrsh.MarshalStruct(&test.TestStruct{Data: "test"})
+		req.NoError(err)
+		bin[crcframe.HeaderSize+2] ^= 1
+		_, err = mrsh.UnmarshalStruct(bin, &test.TestStruct{})
+		asrt.True(errors.Is(err, crcframe.ErrCorrupt))
+	})
+
+	t.Run("DecodeStruct error: corrupted record", func(t *testing.T) {
+		mrsh := crcframe.New(cborgen.New())
+		bin, offsets := encode(t, mrsh, items)
+		bin[offsets[1]+crcframe.HeaderSize+3] ^= 1
+		dec := mrsh.NewDecoder(bytes.NewReader(bin))
+		s := &test.TestStruct{}
+		_, err := dec.DecodeStruct(s)
+		req.NoError(err)
+		_, err = dec.DecodeStruct(s)
+		var cerr *crcframe.CorruptError
+		req.True(errors.As(err, &cerr))
+		asrt.Equal(int64(offsets[1]), cerr.Offset)
+	})
+
+	for name, corrupt := range map[string]func(bin []byte, off int){
+		"record":  func(bin []byte, off int) { bin[off+crcframe.HeaderSize+3] ^= 1 },
+		"length":  func(bin []byte, off int) { bin[off+5] ^= 0x40 },
+		"magic":   func(bin []byte, off int) { bin[off] ^= 1 },
+		"trailer": func(bin []byte, off int) { bin[off+crcframe.HeaderSize+7] ^= 1 },
+	} {
+		t.Run("Resync past corrupted "+name, func(t *testing.T) {
+			var skips []crcframe.Skipped
+			mrsh := crcframe.New(cborgen.New(), crcframe.WithResync(func(s crcframe.Skipped) {
+				skips = append(skips, s)
+			}))
+			bin, offsets := encode(t, mrsh, items)
+			corrupt(bin, offsets[1])
+
+			dec := mrsh.NewDecoder(bytes.NewReader(bin))
+			total := 0
+			for _, want := range []string{"first", "third"} {
+				s := &test.TestStruct{}
+				n, err := dec.DecodeStruct(s)
+				req.NoError(err)
+				asrt.Equal(want, s.Data)
+				total += n
+			}
+			_, err := dec.DecodeStruct(&test.TestStruct{})
+			asrt.Equal(io.EOF, err)
+			asrt.Equal(len(bin), total)
+
+			req.Len(skips, 1)
+			asrt.Equal(int64(offsets[1]), skips[0].Offset)
+			asrt.Equal(int64(offsets[2]-offsets[1]), skips[0].Length)
+			asrt.True(errors.Is(skips[0].Err, crcframe.ErrCorrupt))
+		})
+	}
+
+	t.Run("Resync past truncated tail", func(t *testing.T) {
+		var skips []crcframe.Skipped
+		mrsh := crcframe.New(cborgen.New(), crcframe.WithResync(func(s crcframe.Skipped) {
+			skips = append(skips, s)
+		}))
+		bin, offsets := encode(t, mrsh, items)
+		bin = bin[:len(bin)-3]
+
+		dec := mrsh.NewDecoder(bytes.NewReader(bin))
+		for i := 0; i < 2; i++ {
+			_, err := dec.DecodeStruct(&test.TestStruct{})
+			req.NoError(err)
+		}
+		_, err := dec.DecodeStruct(&test.TestStruct{})
+		asrt.Equal(io.EOF, err)
+		req.Len(skips, 1)
+		asrt.Equal(int64(offsets[2]), skips[0].Offset)
+		asrt.Equal(int64(len(bin)-offsets[2]), skips[0].Length)
+	})
+}
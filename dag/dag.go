@@ -0,0 +1,224 @@
+// Package dag traverses Merkle DAGs of blocks in a blockstore.BlockStore linked by CIDs, such as
+// models with `cid.Cid` fields encoded by the `cborgen` or `cbor_refmt` implementations, without
+// needing their concrete Go types.
+package dag
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/ipfs/go-cid"
+
+	"github.com/daotl/go-marsha"
+	"github.com/daotl/go-marsha/blockstore"
+	"github.com/daotl/go-marsha/internal/cbor"
+)
+
+var (
+	ErrUnsupportedCodec = errors.New("unsupported codec")
+	ErrTrailingBytes    = errors.New("trailing bytes after node")
+)
+
+// Node is a decoded block.
+type Node struct {
+	Cid cid.Cid
+	// Raw is the block.
+	Raw []byte
+	// Links are the CIDs the block links to, in the order they appear.
+	Links []cid.Cid
+}
+
+// Decode decodes the block `raw` with CID `c`. DAG-CBOR blocks are scanned for links (byte strings
+// tagged with CBOR tag 42), raw blocks have no links.
+func Decode(c cid.Cid, raw []byte) (*Node, error) {
+	n := &Node{Cid: c, Raw: raw}
+	switch c.Type() {
+	case cid.DagCBOR:
+		end, err := cbor.Links(raw, 0, func(l cid.Cid) { n.Links = append(n.Links, l) })
+		if err != nil {
+			return nil, fmt.Errorf("decoding %s: %w", c, err)
+		}
+		if end != len(raw) {
+			return nil, fmt.Errorf("decoding %s: %w", c, ErrTrailingBytes)
+		}
+	case cid.Raw:
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedCodec, cid.CodecToStr[c.Type()])
+	}
+	return n, nil
+}
+
+// Unmarshal unmarshals the block with `m` into the struct `p` points to.
+func (n *Node) Unmarshal(m marsha.Marsha, p marsha.StructPtr) error {
+	_, err := m.UnmarshalStruct(n.Raw, p)
+	return err
+}
+
+// Get gets and decodes the block with CID `c` from `bs`.
+func Get(bs blockstore.BlockStore, c cid.Cid) (*Node, error) {
+	raw, err := bs.Get(c)
+	if err != nil {
+		return nil, err
+	}
+	return Decode(c, raw)
+}
+
+// VisitFunc is called for each node visited by Walk with its depth, 0 for the root.
+// It returns whether to follow the links of the node.
+type VisitFunc func(n *Node, depth int) (bool, error)
+
+// Walk visits the nodes reachable from `root` in `bs` depth-first in pre-order, visiting each node
+// once. It fails with an error wrapping blockstore.ErrNotFound on the first missing block.
+func Walk(bs blockstore.BlockStore, root cid.Cid, visit VisitFunc) error {
+	seen := make(map[string]struct{})
+	return walk(bs, root, 0, seen, visit)
+}
+
+func walk(bs blockstore.BlockStore, c cid.Cid, depth int, seen map[string]struct{}, visit VisitFunc) error {
+	if _, ok := seen[c.KeyString()]; ok {
+		return nil
+	}
+	seen[c.KeyString()] = struct{}{}
+	n, err := Get(bs, c)
+	if err != nil {
+		return err
+	}
+	follow, err := visit(n, depth)
+	if err != nil || !follow {
+		return err
+	}
+	for _, l := range n.Links {
+		if err = walk(bs, l, depth+1, seen, visit); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Missing returns the CIDs of the blocks reachable from `root` which are not in `bs`, the links
+// of present blocks being followed.
+func Missing(bs blockstore.BlockStore, root cid.Cid) ([]cid.Cid, error) {
+	var missing []cid.Cid
+	seen := make(map[string]struct{})
+	queue := []cid.Cid{root}
+	for len(queue) > 0 {
+		c := queue[0]
+		queue = queue[1:]
+		if _, ok := seen[c.KeyString()]; ok {
+			continue
+		}
+		seen[c.KeyString()] = struct{}{}
+		n, err := Get(bs, c)
+		if errors.Is(err, blockstore.ErrNotFound) {
+			missing = append(missing, c)
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		queue = append(queue, n.Links...)
+	}
+	return missing, nil
+}
+
+// Selector decides whether to fetch the block linked as `link` by node `parent` at depth `depth`.
+// `parent` is nil for the root.
+type Selector func(parent *Node, link cid.Cid, depth int) bool
+
+// All is a Selector selecting all blocks.
+func All(*Node, cid.Cid, int) bool { return true }
+
+// MaxDepth returns a Selector selecting blocks up to depth `max`.
+func MaxDepth(max int) Selector {
+	return func(_ *Node, _ cid.Cid, depth int) bool { return depth <= max }
+}
+
+// Fetch copies the blocks reachable from `root` in `src` and selected by `sel` to `dst`, verifying
+// each block, and returns the count of blocks copied. Blocks already in `dst` are not copied but
+// their links are still followed.
+func Fetch(src, dst blockstore.BlockStore, root cid.Cid, sel Selector) (int, error) {
+	copied := 0
+	seen := make(map[string]struct{})
+	ms := multiStore{dst, src}
+	var fetch func(parent *Node, c cid.Cid, depth int) error
+	fetch = func(parent *Node, c cid.Cid, depth int) error {
+		if _, ok := seen[c.KeyString()]; ok || !sel(parent, c, depth) {
+			return nil
+		}
+		seen[c.KeyString()] = struct{}{}
+		n, err := Get(ms, c)
+		if err != nil {
+			return err
+		}
+		if has, err := dst.Has(c); err != nil {
+			return err
+		} else if !has {
+			if err = dst.Put(c, n.Raw); err != nil {
+				return err
+			}
+			copied++
+		}
+		for _, l := range n.Links {
+			if err = fetch(n, l, depth+1); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	err := fetch(nil, root, 0)
+	return copied, err
+}
+
+// Reachable returns the keys (cid.Cid.KeyString()) of the blocks reachable from `roots` in `bs`.
+// It fails on the first missing block.
+func Reachable(bs blockstore.BlockStore, roots ...cid.Cid) (map[string]struct{}, error) {
+	seen := make(map[string]struct{})
+	for _, root := range roots {
+		if err := walk(bs, root, 0, seen, func(*Node, int) (bool, error) { return true, nil }); err != nil {
+			return nil, err
+		}
+	}
+	return seen, nil
+}
+
+// GC deletes the blocks in `bs` which are not reachable from `roots` and returns their CIDs.
+// Nothing is deleted if a reachable block is missing.
+func GC(bs blockstore.BlockStore, roots ...cid.Cid) ([]cid.Cid, error) {
+	live, err := Reachable(bs, roots...)
+	if err != nil {
+		return nil, err
+	}
+	var garbage []cid.Cid
+	if err = bs.ForEach(func(c cid.Cid) error {
+		if _, ok := live[c.KeyString()]; !ok {
+			garbage = append(garbage, c)
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	for _, c := range garbage {
+		if err = bs.Delete(c); err != nil {
+			return nil, err
+		}
+	}
+	return garbage, nil
+}
+
+// multiStore reads from the first BlockStore having the block.
+type multiStore []blockstore.BlockStore
+
+func (ms multiStore) Get(c cid.Cid) ([]byte, error) {
+	for _, bs := range ms[:len(ms)-1] {
+		if has, err := bs.Has(c); err != nil {
+			return nil, err
+		} else if has {
+			return bs.Get(c)
+		}
+	}
+	return ms[len(ms)-1].Get(c)
+}
+
+func (ms multiStore) Put(cid.Cid, []byte) error         { return marsha.ErrUnimplemented }
+func (ms multiStore) Has(cid.Cid) (bool, error)         { return false, marsha.ErrUnimplemented }
+func (ms multiStore) Delete(cid.Cid) error              { return marsha.ErrUnimplemented }
+func (ms multiStore) ForEach(func(cid.Cid) error) error { return marsha.ErrUnimplemented }
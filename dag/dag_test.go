@@ -0,0 +1,116 @@
+package dag_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/daotl/go-marsha/blockstore"
+	"github.com/daotl/go-marsha/cborgen"
+	"github.com/daotl/go-marsha/dag"
+	"github.com/daotl/go-marsha/test"
+)
+
+type tree struct {
+	bs                     *blockstore.Memory
+	root, mid, left, right cid.Cid
+	garbage                cid.Cid
+}
+
+// newTree stores root -> {mid, left}, mid -> {left, right}.
+func newTree(t *testing.T) *tree {
+	req := require.New(t)
+	mrsh := cborgen.New()
+	tr := &tree{bs: blockstore.NewMemory()}
+	put := func(s *test.TestLinked) cid.Cid {
+		c, err := blockstore.PutStruct(tr.bs, mrsh, s)
+		req.NoError(err)
+		return c
+	}
+	tr.left = put(&test.TestLinked{Name: "left"})
+	tr.right = put(&test.TestLinked{Name: "right"})
+	tr.mid = put(&test.TestLinked{Name: "mid", Left: &tr.left, Right: &tr.right})
+	tr.root = put(&test.TestLinked{Name: "root", Left: &tr.mid, Right: &tr.left})
+	tr.garbage = put(&test.TestLinked{Name: "garbage", Left: &tr.left})
+	return tr
+}
+
+func TestDecode(t *testing.T) {
+	tr := newTree(t)
+	n, err := dag.Get(tr.bs, tr.mid)
+	require.NoError(t, err)
+	assert.Equal(t, []cid.Cid{tr.left, tr.right}, n.Links)
+
+	s := &test.TestLinked{}
+	require.NoError(t, n.Unmarshal(cborgen.New(), s))
+	assert.Equal(t, "mid", s.Name)
+}
+
+func TestWalk(t *testing.T) {
+	req := require.New(t)
+	asrt := assert.New(t)
+	tr := newTree(t)
+
+	var visited []cid.Cid
+	var depths []int
+	req.NoError(dag.Walk(tr.bs, tr.root, func(n *dag.Node, depth int) (bool, error) {
+		visited = append(visited, n.Cid)
+		depths = append(depths, depth)
+		return true, nil
+	}))
+	asrt.Equal([]cid.Cid{tr.root, tr.mid, tr.left, tr.right}, visited)
+	asrt.Equal([]int{0, 1, 2, 2}, depths)
+
+	visited = nil
+	req.NoError(dag.Walk(tr.bs, tr.root, func(n *dag.Node, depth int) (bool, error) {
+		visited = append(visited, n.Cid)
+		return n.Cid != tr.mid, nil
+	}))
+	asrt.Equal([]cid.Cid{tr.root, tr.mid, tr.left}, visited)
+
+	req.NoError(tr.bs.Delete(tr.right))
+	err := dag.Walk(tr.bs, tr.root, func(*dag.Node, int) (bool, error) { return true, nil })
+	asrt.True(errors.Is(err, blockstore.ErrNotFound))
+	missing, err := dag.Missing(tr.bs, tr.root)
+	req.NoError(err)
+	asrt.Equal([]cid.Cid{tr.right}, missing)
+}
+
+func TestFetch(t *testing.T) {
+	req := require.New(t)
+	asrt := assert.New(t)
+	tr := newTree(t)
+
+	dst := blockstore.NewMemory()
+	n, err := dag.Fetch(tr.bs, dst, tr.root, dag.MaxDepth(1))
+	req.NoError(err)
+	asrt.Equal(3, n)
+	missing, err := dag.Missing(dst, tr.root)
+	req.NoError(err)
+	asrt.Equal([]cid.Cid{tr.right}, missing)
+
+	n, err = dag.Fetch(tr.bs, dst, tr.root, dag.All)
+	req.NoError(err)
+	asrt.Equal(1, n)
+	missing, err = dag.Missing(dst, tr.root)
+	req.NoError(err)
+	asrt.Empty(missing)
+}
+
+func TestGC(t *testing.T) {
+	req := require.New(t)
+	asrt := assert.New(t)
+	tr := newTree(t)
+
+	garbage, err := dag.GC(tr.bs, tr.mid)
+	req.NoError(err)
+	asrt.ElementsMatch([]cid.Cid{tr.root, tr.garbage}, garbage)
+	for _, c := range []cid.Cid{tr.mid, tr.left, tr.right} {
+		has, err := tr.bs.Has(c)
+		req.NoError(err)
+		asrt.True(has)
+	}
+}
@@ -0,0 +1,436 @@
+// Package diff compares two encodings of data item by item and reports the differences by path,
+// to debug mismatches between payloads which should be the same, such as replicas of a record.
+//
+// CBOR is compared without a Go type: identical items are skipped by their bytes, arrays are
+// compared element by element after aligning their identical elements and maps entry by entry by
+// key. Protocol Buffers messages are
+// compared as the Value trees of their descriptors. Paths are written like JSON Pointers, as for
+// marsha.Get.
+package diff
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"google.golang.org/protobuf/reflect/protoreflect"
+
+	"github.com/daotl/go-marsha"
+	"github.com/daotl/go-marsha/cbordiag"
+	"github.com/daotl/go-marsha/internal/cbor"
+	"github.com/daotl/go-marsha/internal/pointer"
+	"github.com/daotl/go-marsha/protobuf"
+)
+
+// Kind is the kind of a Difference.
+type Kind int
+
+const (
+	// Changed is a value changed to another value of the same type.
+	Changed Kind = iota
+	// TypeChanged is a value changed to a value of another type.
+	TypeChanged
+	// Added is an element or an entry only in the new data.
+	Added
+	// Removed is an element or an entry only in the old data.
+	Removed
+	// Encoding is an equal value encoded differently, such as a non-minimal integer or an
+	// indefinite-length array, which changes hashes and signatures.
+	Encoding
+)
+
+var kindNames = [...]string{"changed", "type changed", "added", "removed", "encoding"}
+
+func (k Kind) String() string {
+	if k < 0 || int(k) >= len(kindNames) {
+		return "invalid"
+	}
+	return kindNames[k]
+}
+
+// Difference is a difference between the old and the new data at a path.
+type Difference struct {
+	Kind Kind
+	Path string
+	// Old and New are the CBOR data items at Path, Old is nil if Added and New if Removed.
+	Old, New []byte
+	// OldOffset and NewOffset are the offsets of Old and New in the compared CBOR, or -1 if they
+	// are nil or Values were compared.
+	OldOffset, NewOffset int
+}
+
+// String returns a line describing `d`, with items in diagnostic notation, such as
+// `~ /items/1/name: "a" -> "b"`.
+func (d Difference) String() string {
+	path := d.Path
+	if path == "" {
+		path = "/"
+	}
+	switch d.Kind {
+	case Added:
+		return fmt.Sprintf("+ %s: %s", path, diag(d.New))
+	case Removed:
+		return fmt.Sprintf("- %s: %s", path, diag(d.Old))
+	case TypeChanged:
+		return fmt.Sprintf("! %s: %s (%s) -> %s (%s)", path, diag(d.Old), typeOf(d.Old), diag(d.New), typeOf(d.New))
+	case Encoding:
+		return fmt.Sprintf("= %s: %s -> %s", path, diag(d.Old), diag(d.New))
+	}
+	return fmt.Sprintf("~ %s: %s -> %s", path, diag(d.Old), diag(d.New))
+}
+
+func diag(b []byte) string {
+	s, _ := cbordiag.Format(b)
+	return s
+}
+
+// CBOR returns the differences between the CBOR data items `old` and `new`, in the order of the
+// items in `old` followed by entries added to maps. Arrays are aligned on a longest common
+// subsequence of identical elements, so elements inserted or removed in the middle of an array are
+// reported as Added or Removed without changing the elements after them, and the remaining
+// elements between aligned ones are paired in order. Paths of Added elements hold their indexes in
+// the new array, others their indexes in the old array.
+func CBOR(old, new []byte) ([]Difference, error) {
+	for _, b := range [][]byte{old, new} {
+		end, err := cbor.Skip(b, 0)
+		if err != nil {
+			return nil, err
+		}
+		if end != len(b) {
+			return nil, &marsha.StrictError{Offset: end, Err: marsha.ErrTrailingData}
+		}
+	}
+	d := &differ{old: old, new: new}
+	if err := d.item(0, 0, nil); err != nil {
+		return nil, err
+	}
+	return d.diffs, nil
+}
+
+// Values returns the differences between the Value trees `old` and `new`, as CBOR would for their
+// encodings, so entries of maps are in the order of their keys.
+func Values(old, new marsha.Value) ([]Difference, error) {
+	ob, err := marsha.EncodeCBORValue(old)
+	if err != nil {
+		return nil, err
+	}
+	nb, err := marsha.EncodeCBORValue(new)
+	if err != nil {
+		return nil, err
+	}
+	diffs, err := CBOR(ob, nb)
+	for i := range diffs {
+		diffs[i].OldOffset, diffs[i].NewOffset = -1, -1
+	}
+	return diffs, err
+}
+
+// Protobuf returns the differences between the Protocol Buffers messages `old` and `new`
+// described by `md`, compared as their Value trees.
+func Protobuf(old, new []byte, md protoreflect.MessageDescriptor) ([]Difference, error) {
+	m := protobuf.New()
+	ov, err := m.DecodeValue(old, md)
+	if err != nil {
+		return nil, fmt.Errorf("old: %w", err)
+	}
+	nv, err := m.DecodeValue(new, md)
+	if err != nil {
+		return nil, fmt.Errorf("new: %w", err)
+	}
+	return Values(ov, nv)
+}
+
+type differ struct {
+	old, new []byte
+	diffs    []Difference
+}
+
+// item compares the items at offsets `oo` of the old data and `no` of the new data, at the path
+// of tokens `toks`.
+func (d *differ) item(oo, no int, toks []string) error {
+	oEnd, err := cbor.Skip(d.old, oo)
+	if err != nil {
+		return err
+	}
+	nEnd, err := cbor.Skip(d.new, no)
+	if err != nil {
+		return err
+	}
+	if bytes.Equal(d.old[oo:oEnd], d.new[no:nEnd]) {
+		return nil
+	}
+	diff := Difference{
+		Path:      pointer.Join(toks),
+		Old:       d.old[oo:oEnd],
+		New:       d.new[no:nEnd],
+		OldOffset: oo,
+		NewOffset: no,
+	}
+	if typeOf(diff.Old) != typeOf(diff.New) {
+		diff.Kind = TypeChanged
+		d.diffs = append(d.diffs, diff)
+		return nil
+	}
+
+	oh, _ := cbor.ReadHead(d.old, oo)
+	nh, _ := cbor.ReadHead(d.new, no)
+	n := len(d.diffs)
+	switch {
+	case oh.Major == cbor.MajArray:
+		err = d.array(oh, nh, toks)
+	case oh.Major == cbor.MajMap:
+		err = d.dict(oh, nh, toks)
+	case oh.Major == cbor.MajTag && nh.Major == cbor.MajTag && strings.HasPrefix(typeOf(diff.Old), "tag"):
+		// Tags of the same number.
+		err = d.item(oh.End(), nh.End(), toks)
+	default:
+		ov, _, oErr := marsha.DecodeCBORValue(diff.Old)
+		nv, _, nErr := marsha.DecodeCBORValue(diff.New)
+		if oErr != nil || nErr != nil || !ov.Equal(nv) {
+			diff.Kind = Changed
+			d.diffs = append(d.diffs, diff)
+			return nil
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if len(d.diffs) == n {
+		diff.Kind = Encoding
+		d.diffs = append(d.diffs, diff)
+	}
+	return nil
+}
+
+func (d *differ) array(oh, nh cbor.Head, toks []string) error {
+	oElems, err := children(d.old, oh)
+	if err != nil {
+		return err
+	}
+	nElems, err := children(d.new, nh)
+	if err != nil {
+		return err
+	}
+	pairs := align(items(d.old, oElems), items(d.new, nElems))
+	i, j := 0, 0
+	for _, m := range append(pairs, [2]int{len(oElems), len(nElems)}) {
+		if err := d.unaligned(oElems[i:m[0]], i, nElems[j:m[1]], j, toks); err != nil {
+			return err
+		}
+		i, j = m[0]+1, m[1]+1
+	}
+	return nil
+}
+
+// unaligned compares the elements at offsets `oElems` of the old data, from index `oi`, and
+// `nElems` of the new data, from index `ni`, between two aligned elements: they are paired in
+// order, and the elements left over are removed or added.
+func (d *differ) unaligned(oElems []int, oi int, nElems []int, ni int, toks []string) error {
+	for k := 0; k < len(oElems) || k < len(nElems); k++ {
+		switch {
+		case k >= len(nElems):
+			d.diffs = append(d.diffs, d.removed(oElems[k], index(toks, oi+k)))
+		case k >= len(oElems):
+			d.diffs = append(d.diffs, d.added(nElems[k], index(toks, ni+k)))
+		default:
+			if err := d.item(oElems[k], nElems[k], index(toks, oi+k)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// index returns the path of the element with index `i` of the array at the path `toks`.
+func index(toks []string, i int) []string {
+	return append(toks[:len(toks):len(toks)], strconv.Itoa(i))
+}
+
+// maxAlign is the largest product of the lengths of the unaligned parts of two arrays which are
+// aligned on a longest common subsequence, bounding its quadratic time and memory. Larger arrays
+// are only aligned on their common prefix and suffix.
+const maxAlign = 1 << 20
+
+// align returns the pairs of indexes of identical elements of `o` and `n` on which they are
+// aligned, in increasing order.
+func align(o, n [][]byte) [][2]int {
+	var pairs [][2]int
+	pre := 0
+	for pre < len(o) && pre < len(n) && bytes.Equal(o[pre], n[pre]) {
+		pairs = append(pairs, [2]int{pre, pre})
+		pre++
+	}
+	suf := 0
+	for suf < len(o)-pre && suf < len(n)-pre && bytes.Equal(o[len(o)-1-suf], n[len(n)-1-suf]) {
+		suf++
+	}
+	om, nm := o[pre:len(o)-suf], n[pre:len(n)-suf]
+	if len(om) > 0 && len(nm) > 0 && len(om)*len(nm) <= maxAlign {
+		// lcs[i][j] is the length of the longest common subsequence of om[i:] and nm[j:].
+		lcs := make([][]int, len(om)+1)
+		for i := range lcs {
+			lcs[i] = make([]int, len(nm)+1)
+		}
+		for i := len(om) - 1; i >= 0; i-- {
+			for j := len(nm) - 1; j >= 0; j-- {
+				switch {
+				case bytes.Equal(om[i], nm[j]):
+					lcs[i][j] = lcs[i+1][j+1] + 1
+				case lcs[i+1][j] >= lcs[i][j+1]:
+					lcs[i][j] = lcs[i+1][j]
+				default:
+					lcs[i][j] = lcs[i][j+1]
+				}
+			}
+		}
+		for i, j := 0, 0; i < len(om) && j < len(nm); {
+			switch {
+			case bytes.Equal(om[i], nm[j]):
+				pairs = append(pairs, [2]int{pre + i, pre + j})
+				i++
+				j++
+			case lcs[i+1][j] >= lcs[i][j+1]:
+				i++
+			default:
+				j++
+			}
+		}
+	}
+	for k := suf; k > 0; k-- {
+		pairs = append(pairs, [2]int{len(o) - k, len(n) - k})
+	}
+	return pairs
+}
+
+func (d *differ) dict(oh, nh cbor.Head, toks []string) error {
+	oItems, err := children(d.old, oh)
+	if err != nil {
+		return err
+	}
+	nItems, err := children(d.new, nh)
+	if err != nil {
+		return err
+	}
+	matched := make([]bool, len(nItems)/2)
+	for i := 0; i < len(oItems); i += 2 {
+		oKey := d.old[oItems[i]:oItems[i+1]]
+		path := append(toks[:len(toks):len(toks)], keyToken(oKey))
+		j := 0
+		for ; j < len(nItems); j += 2 {
+			if !matched[j/2] && bytes.Equal(oKey, d.new[nItems[j]:nItems[j+1]]) {
+				break
+			}
+		}
+		if j == len(nItems) {
+			d.diffs = append(d.diffs, d.removed(oItems[i+1], path))
+			continue
+		}
+		matched[j/2] = true
+		if err := d.item(oItems[i+1], nItems[j+1], path); err != nil {
+			return err
+		}
+	}
+	for j := 0; j < len(nItems); j += 2 {
+		if !matched[j/2] {
+			path := append(toks[:len(toks):len(toks)], keyToken(d.new[nItems[j]:nItems[j+1]]))
+			d.diffs = append(d.diffs, d.added(nItems[j+1], path))
+		}
+	}
+	return nil
+}
+
+func (d *differ) removed(off int, toks []string) Difference {
+	end, _ := cbor.Skip(d.old, off)
+	return Difference{Kind: Removed, Path: pointer.Join(toks), Old: d.old[off:end], OldOffset: off, NewOffset: -1}
+}
+
+func (d *differ) added(off int, toks []string) Difference {
+	end, _ := cbor.Skip(d.new, off)
+	return Difference{Kind: Added, Path: pointer.Join(toks), New: d.new[off:end], OldOffset: -1, NewOffset: off}
+}
+
+// children returns the offsets of the elements of the array, or of the keys and values of the
+// map, of head `h`.
+func children(b []byte, h cbor.Head) ([]int, error) {
+	count := h.Arg
+	if h.Major == cbor.MajMap {
+		count *= 2
+	}
+	var offs []int
+	off := h.End()
+	for i := uint64(0); h.Indefinite() || i < count; i++ {
+		if h.Indefinite() && b[off] == cbor.Break {
+			break
+		}
+		offs = append(offs, off)
+		var err error
+		if off, err = cbor.Skip(b, off); err != nil {
+			return nil, err
+		}
+	}
+	return offs, nil
+}
+
+// items returns the data items of `b` at offsets `offs`.
+func items(b []byte, offs []int) [][]byte {
+	its := make([][]byte, len(offs))
+	for i, off := range offs {
+		end, _ := cbor.Skip(b, off)
+		its[i] = b[off:end]
+	}
+	return its
+}
+
+// keyToken returns the path token of the map key `key`: text as is, integers in decimal and other
+// keys in diagnostic notation.
+func keyToken(key []byte) string {
+	if v, _, err := marsha.DecodeCBORValue(key); err == nil {
+		switch v.Kind {
+		case marsha.KindString:
+			return v.Text
+		case marsha.KindInt:
+			return v.Int.String()
+		}
+	}
+	return diag(key)
+}
+
+// typeOf returns the type of the CBOR data item `b`, named like the kinds of marsha.Value.
+func typeOf(b []byte) string {
+	h, err := cbor.ReadHead(b, 0)
+	if err != nil {
+		return "invalid"
+	}
+	switch h.Major {
+	case cbor.MajUnsignedInt, cbor.MajNegativeInt:
+		return marsha.KindInt.String()
+	case cbor.MajByteString:
+		return marsha.KindBytes.String()
+	case cbor.MajTextString:
+		return marsha.KindString.String()
+	case cbor.MajArray:
+		return marsha.KindArray.String()
+	case cbor.MajMap:
+		return marsha.KindMap.String()
+	case cbor.MajTag:
+		switch h.Arg {
+		case cbor.TagLink:
+			return marsha.KindLink.String()
+		case 2, 3:
+			return marsha.KindInt.String()
+		}
+		return fmt.Sprintf("tag %d", h.Arg)
+	}
+	switch {
+	case h.IsFloat():
+		return marsha.KindFloat.String()
+	case h.Arg == 20 || h.Arg == 21:
+		return marsha.KindBool.String()
+	case h.Arg == 22:
+		return marsha.KindNull.String()
+	case h.Arg == 23:
+		return "undefined"
+	}
+	return fmt.Sprintf("simple(%d)", h.Arg)
+}
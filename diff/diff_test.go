@@ -0,0 +1,86 @@
+package diff_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+
+	"github.com/daotl/go-marsha"
+	"github.com/daotl/go-marsha/cbordiag"
+	"github.com/daotl/go-marsha/diff"
+)
+
+func lines(diffs []diff.Difference) []string {
+	out := []string{}
+	for _, d := range diffs {
+		out = append(out, d.String())
+	}
+	return out
+}
+
+func TestCBOR(t *testing.T) {
+	for _, tc := range []struct {
+		old, new string
+		want     []string
+	}{
+		{`{"a": [1, 2]}`, `{"a": [1, 2]}`, []string{}},
+		{`1`, `2`, []string{`~ /: 1 -> 2`}},
+		{`1`, `"1"`, []string{`! /: 1 (int) -> "1" (string)`}},
+		{`1`, `2(h'01')`, []string{`= /: 1 -> 2(h'01')`}},
+		{`{"items": [{"name": "a"}, {"name": "b"}], "n": 1}`,
+			`{"items": [{"name": "a"}, {"name": "c", "id": 2}, {"name": "d"}], "n": 1_0}`, []string{
+				`~ /items/1/name: "b" -> "c"`,
+				`+ /items/1/id: 2`,
+				`+ /items/2: {"name": "d"}`,
+				`= /n: 1 -> 1_0`,
+			}},
+		{`[1, 2, 3]`, `[1]`, []string{`- /1: 2`, `- /2: 3`}},
+		{`[1, 2, 3, 4]`, `[1, 3, 4]`, []string{`- /1: 2`}},
+		{`[1, 3]`, `[0, 1, 2, 3, 4]`, []string{`+ /0: 0`, `+ /2: 2`, `+ /4: 4`}},
+		{`[1, 2, 3, 4, 5]`, `[1, 9, 4, 6, 5]`, []string{`~ /1: 2 -> 9`, `- /2: 3`, `+ /3: 6`}},
+		{`{"items": [{"n": "a"}, {"n": "b"}, {"n": "c"}]}`, `{"items": [{"n": "a"}, {"n": "c"}]}`,
+			[]string{`- /items/1: {"n": "b"}`}},
+		{`[_ 1, 2]`, `[1, 2]`, []string{`= /: [_ 1, 2] -> [1, 2]`}},
+		{`{1: 1(0), "a/b": 0}`, `{1: 1(1), "a/b": null}`, []string{
+			`~ /1: 0 -> 1`, `! /a~1b: 0 (int) -> null (null)`,
+		}},
+		{`1(0)`, `2(h'00')`, []string{`! /: 1(0) (tag 1) -> 2(h'00') (int)`}},
+	} {
+		diffs, err := diff.CBOR(cbordiag.MustParse(tc.old), cbordiag.MustParse(tc.new))
+		require.NoError(t, err, tc.old)
+		assert.Equal(t, tc.want, lines(diffs), tc.old)
+	}
+
+	diffs, err := diff.CBOR(cbordiag.MustParse(`[1, 2]`), cbordiag.MustParse(`[1, 3]`))
+	require.NoError(t, err)
+	assert.Equal(t, []diff.Difference{{
+		Kind: diff.Changed, Path: "/1", Old: []byte{2}, New: []byte{3}, OldOffset: 2, NewOffset: 2,
+	}}, diffs)
+
+	_, err = diff.CBOR([]byte{1, 2}, []byte{1})
+	assert.True(t, errors.Is(err, marsha.ErrTrailingData))
+	_, err = diff.CBOR([]byte{1}, []byte{0x82})
+	assert.True(t, errors.Is(err, marsha.ErrInvalidCBOR))
+}
+
+func TestProtobuf(t *testing.T) {
+	old := &descriptorpb.FieldDescriptorProto{Name: proto.String("id"), Number: proto.Int32(1)}
+	new := &descriptorpb.FieldDescriptorProto{Name: proto.String("sku"), JsonName: proto.String("sku")}
+	ob, err := proto.Marshal(old)
+	require.NoError(t, err)
+	nb, err := proto.Marshal(new)
+	require.NoError(t, err)
+
+	diffs, err := diff.Protobuf(ob, nb, old.ProtoReflect().Descriptor())
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		`~ /name: "id" -> "sku"`,
+		`- /number: 1`,
+		`+ /json_name: "sku"`,
+	}, lines(diffs))
+	assert.Equal(t, -1, diffs[0].OldOffset)
+}
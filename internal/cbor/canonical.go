@@ -0,0 +1,289 @@
+package cbor
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+)
+
+var ErrNotCanonical = errors.New("not in deterministic encoding")
+
+// NonCanonicalError reports CBOR which is well-formed but not in core deterministic encoding
+// (RFC 8949 section 4.2.1) at an offset.
+type NonCanonicalError struct {
+	Offset int
+	Msg    string
+}
+
+func (e *NonCanonicalError) Error() string {
+	return fmt.Sprintf("%s at offset %d: %s", ErrNotCanonical, e.Offset, e.Msg)
+}
+
+func (e *NonCanonicalError) Unwrap() error { return ErrNotCanonical }
+
+func nonCanonical(off int, format string, args ...interface{}) error {
+	return &NonCanonicalError{Offset: off, Msg: fmt.Sprintf(format, args...)}
+}
+
+// Float16ToFloat64 converts the bits of an IEEE 754 half-precision float.
+func Float16ToFloat64(h uint16) float64 {
+	sign := 1.0
+	if h&0x8000 != 0 {
+		sign = -1
+	}
+	exp := int(h>>10) & 0x1f
+	mant := float64(h & 0x3ff)
+	switch exp {
+	case 0:
+		return sign * math.Ldexp(mant, -24)
+	case 0x1f:
+		if mant == 0 {
+			return math.Inf(int(sign))
+		}
+		return math.NaN()
+	default:
+		return sign * math.Ldexp(mant+1024, exp-25)
+	}
+}
+
+// float16Bits returns the bits of `f` as a half-precision float if it is exactly representable.
+func float16Bits(f float64) (uint16, bool) {
+	if math.IsNaN(f) {
+		return 0x7e00, true
+	}
+	var sign uint16
+	if math.Signbit(f) {
+		sign = 0x8000
+	}
+	if math.IsInf(f, 0) {
+		return sign | 0x7c00, true
+	}
+	if f == 0 {
+		return sign, true
+	}
+	frac, exp := math.Frexp(math.Abs(f)) // |f| = frac * 2^exp, frac in [0.5, 1)
+	switch {
+	case exp > 16:
+		return 0, false
+	case exp >= -13:
+		// Normal: 11 significant bits.
+		m := frac * 2048
+		if m != math.Trunc(m) {
+			return 0, false
+		}
+		return sign | uint16(exp+14)<<10 | uint16(m)&0x3ff, true
+	default:
+		// Subnormal: multiples of 2^-24.
+		m := math.Ldexp(math.Abs(f), 24)
+		if m != math.Trunc(m) || m >= 1024 {
+			return 0, false
+		}
+		return sign | uint16(m), true
+	}
+}
+
+// Float returns the value of a float item's head.
+func (h Head) Float() float64 {
+	switch h.Info {
+	case 25:
+		return Float16ToFloat64(uint16(h.Arg))
+	case 26:
+		return float64(math.Float32frombits(uint32(h.Arg)))
+	default:
+		return math.Float64frombits(h.Arg)
+	}
+}
+
+// IsFloat returns whether the head is the head of a float item.
+func (h Head) IsFloat() bool {
+	return h.Major == MajOther && h.Info >= 25 && h.Info <= 27
+}
+
+// AppendFloat appends the shortest encoding of `f` preserving its value, with NaN encoded as
+// 0xf97e00.
+func AppendFloat(b []byte, f float64) []byte {
+	if h, ok := float16Bits(f); ok {
+		return append(b, MajOther<<5|25, byte(h>>8), byte(h))
+	}
+	if f32 := float32(f); float64(f32) == f {
+		bits := math.Float32bits(f32)
+		return append(b, MajOther<<5|26, byte(bits>>24), byte(bits>>16), byte(bits>>8), byte(bits))
+	}
+	bits := math.Float64bits(f)
+	b = append(b, MajOther<<5|27)
+	for i := 56; i >= 0; i -= 8 {
+		b = append(b, byte(bits>>uint(i)))
+	}
+	return b
+}
+
+// CheckCanonical returns the offset just after the item at offset `off` of `b`, or an error
+// wrapping ErrNotCanonical at the first violation of core deterministic encoding:
+// a non-minimal head, an indefinite length, a float not in its shortest form or map keys not in
+// strictly increasing bytewise order, which also rules out duplicate keys.
+func CheckCanonical(b []byte, off int) (int, error) {
+	return checkCanonical(b, off, 0)
+}
+
+func checkCanonical(b []byte, off, depth int) (int, error) {
+	if depth > MaxDepth {
+		return 0, syntaxError(off, "nesting deeper than %d", MaxDepth)
+	}
+	h, err := ReadHead(b, off)
+	if err != nil {
+		return 0, err
+	}
+	if h.Indefinite() {
+		return 0, nonCanonical(off, "indefinite length")
+	}
+	if h.IsFloat() {
+		if short := AppendFloat(nil, h.Float()); len(short) != h.Size || !bytes.Equal(short, b[off:h.End()]) {
+			return 0, nonCanonical(off, "float not in shortest form")
+		}
+		return h.End(), nil
+	}
+	if !h.Minimal() {
+		return 0, nonCanonical(off, "argument not in shortest form")
+	}
+	end := h.End()
+	switch h.Major {
+	case MajArray:
+		for i := uint64(0); i < h.Arg; i++ {
+			if end, err = checkCanonical(b, end, depth+1); err != nil {
+				return 0, err
+			}
+		}
+		return end, nil
+	case MajMap:
+		var prev []byte
+		for i := uint64(0); i < h.Arg; i++ {
+			kEnd, err := checkCanonical(b, end, depth+1)
+			if err != nil {
+				return 0, err
+			}
+			key := b[end:kEnd]
+			if i > 0 {
+				switch c := bytes.Compare(prev, key); {
+				case c == 0:
+					return 0, nonCanonical(end, "duplicate map key")
+				case c > 0:
+					return 0, nonCanonical(end, "map keys not sorted")
+				}
+			}
+			prev = key
+			if end, err = checkCanonical(b, kEnd, depth+1); err != nil {
+				return 0, err
+			}
+		}
+		return end, nil
+	case MajTag:
+		return checkCanonical(b, end, depth+1)
+	default:
+		return Skip(b, off)
+	}
+}
+
+// Canonicalize appends the core deterministic encoding of the item at offset `off` of `b` to
+// `dst` and returns it along with the offset just after the item. It fails on duplicate map keys.
+func Canonicalize(dst, b []byte, off int) ([]byte, int, error) {
+	return canonicalize(dst, b, off, 0)
+}
+
+func canonicalize(dst, b []byte, off, depth int) ([]byte, int, error) {
+	if depth > MaxDepth {
+		return nil, 0, syntaxError(off, "nesting deeper than %d", MaxDepth)
+	}
+	h, err := ReadHead(b, off)
+	if err != nil {
+		return nil, 0, err
+	}
+	end := h.End()
+	switch h.Major {
+	case MajByteString, MajTextString:
+		if !h.Indefinite() {
+			if end, err = Skip(b, off); err != nil {
+				return nil, 0, err
+			}
+			dst = AppendHead(dst, h.Major, h.Arg)
+			return append(dst, b[h.End():end]...), end, nil
+		}
+		var content []byte
+		for {
+			if end >= len(b) {
+				return nil, 0, syntaxError(end, "unexpected end of input")
+			}
+			if b[end] == Break {
+				break
+			}
+			ch, err := ReadHead(b, end)
+			if err != nil {
+				return nil, 0, err
+			}
+			chEnd, err := Skip(b, end)
+			if err != nil {
+				return nil, 0, err
+			}
+			content = append(content, b[ch.End():chEnd]...)
+			end = chEnd
+		}
+		dst = AppendHead(dst, h.Major, uint64(len(content)))
+		return append(dst, content...), end + 1, nil
+	case MajArray:
+		var items [][]byte
+		for i := uint64(0); h.Indefinite() || i < h.Arg; i++ {
+			if h.Indefinite() && end < len(b) && b[end] == Break {
+				end++
+				break
+			}
+			var item []byte
+			if item, end, err = canonicalize(nil, b, end, depth+1); err != nil {
+				return nil, 0, err
+			}
+			items = append(items, item)
+		}
+		dst = AppendHead(dst, MajArray, uint64(len(items)))
+		for _, item := range items {
+			dst = append(dst, item...)
+		}
+		return dst, end, nil
+	case MajMap:
+		type pair struct{ key, val []byte }
+		var pairs []pair
+		for i := uint64(0); h.Indefinite() || i < h.Arg; i++ {
+			if h.Indefinite() && end < len(b) && b[end] == Break {
+				end++
+				break
+			}
+			var p pair
+			if p.key, end, err = canonicalize(nil, b, end, depth+1); err != nil {
+				return nil, 0, err
+			}
+			if p.val, end, err = canonicalize(nil, b, end, depth+1); err != nil {
+				return nil, 0, err
+			}
+			pairs = append(pairs, p)
+		}
+		sort.Slice(pairs, func(i, j int) bool { return bytes.Compare(pairs[i].key, pairs[j].key) < 0 })
+		dst = AppendHead(dst, MajMap, uint64(len(pairs)))
+		for i, p := range pairs {
+			if i > 0 && bytes.Equal(pairs[i-1].key, p.key) {
+				return nil, 0, syntaxError(off, "duplicate map key")
+			}
+			dst = append(dst, p.key...)
+			dst = append(dst, p.val...)
+		}
+		return dst, end, nil
+	case MajTag:
+		dst = AppendHead(dst, MajTag, h.Arg)
+		return canonicalize(dst, b, end, depth+1)
+	case MajOther:
+		if h.IsFloat() {
+			return AppendFloat(dst, h.Float()), end, nil
+		}
+		return AppendHead(dst, MajOther, h.Arg), end, nil
+	default:
+		return AppendHead(dst, h.Major, h.Arg), end, nil
+	}
+}
@@ -0,0 +1,310 @@
+// Package cbor provides a low-level parser for CBOR (RFC 8949) data items in byte slices.
+package cbor
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/ipfs/go-cid"
+)
+
+// Major types.
+const (
+	MajUnsignedInt = 0
+	MajNegativeInt = 1
+	MajByteString  = 2
+	MajTextString  = 3
+	MajArray       = 4
+	MajMap         = 5
+	MajTag         = 6
+	MajOther       = 7
+)
+
+const (
+	// InfoIndefinite is the additional information of indefinite-length items.
+	InfoIndefinite = 31
+	// Break terminates indefinite-length items.
+	Break = 0xff
+	// TagLink is the CBOR tag of IPLD links.
+	TagLink = 42
+	// MaxDepth is the maximum nesting depth of items.
+	MaxDepth = 1024
+)
+
+var ErrSyntax = errors.New("invalid CBOR")
+
+// SyntaxError reports invalid CBOR at an offset.
+type SyntaxError struct {
+	Offset int
+	Msg    string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("%s at offset %d: %s", ErrSyntax, e.Offset, e.Msg)
+}
+
+func (e *SyntaxError) Unwrap() error { return ErrSyntax }
+
+func syntaxError(off int, format string, args ...interface{}) error {
+	return &SyntaxError{Offset: off, Msg: fmt.Sprintf(format, args...)}
+}
+
+// Head is the initial byte and argument of a CBOR data item.
+type Head struct {
+	Major byte
+	// Info is the additional information, the low 5 bits of the initial byte.
+	Info byte
+	// Arg is the argument: the value of integers and simple values, the length of strings, the count
+	// of array elements or map pairs, the tag number, or the bits of floats.
+	Arg uint64
+	// Offset is the offset of the initial byte.
+	Offset int
+	// Size is the size of the head in bytes.
+	Size int
+}
+
+// Indefinite returns whether the item has indefinite length.
+func (h Head) Indefinite() bool { return h.Info == InfoIndefinite }
+
+// End returns the offset just after the head.
+func (h Head) End() int { return h.Offset + h.Size }
+
+// Minimal returns whether the argument is encoded in the fewest bytes possible. Floats and
+// indefinite-length heads are always considered minimal.
+func (h Head) Minimal() bool {
+	if h.Major == MajOther && h.Info >= 25 || h.Indefinite() {
+		return true
+	}
+	switch h.Info {
+	case 24:
+		return h.Arg >= 24
+	case 25:
+		return h.Arg > 0xff
+	case 26:
+		return h.Arg > 0xffff
+	case 27:
+		return h.Arg > 0xffffffff
+	default:
+		return true
+	}
+}
+
+// ReadHead reads the head of the item at offset `off` of `b`.
+func ReadHead(b []byte, off int) (Head, error) {
+	if off >= len(b) {
+		return Head{}, syntaxError(off, "unexpected end of input")
+	}
+	h := Head{Major: b[off] >> 5, Info: b[off] & 0x1f, Offset: off, Size: 1}
+	switch {
+	case h.Info < 24:
+		h.Arg = uint64(h.Info)
+	case h.Info <= 27:
+		n := 1 << (h.Info - 24)
+		if off+1+n > len(b) {
+			return Head{}, syntaxError(off, "unexpected end of input")
+		}
+		switch n {
+		case 1:
+			h.Arg = uint64(b[off+1])
+		case 2:
+			h.Arg = uint64(binary.BigEndian.Uint16(b[off+1:]))
+		case 4:
+			h.Arg = uint64(binary.BigEndian.Uint32(b[off+1:]))
+		case 8:
+			h.Arg = binary.BigEndian.Uint64(b[off+1:])
+		}
+		h.Size += n
+	case h.Info == InfoIndefinite:
+		switch h.Major {
+		case MajByteString, MajTextString, MajArray, MajMap:
+		case MajOther:
+			return Head{}, syntaxError(off, "unexpected break")
+		default:
+			return Head{}, syntaxError(off, "indefinite length not allowed for major type %d", h.Major)
+		}
+	default:
+		return Head{}, syntaxError(off, "reserved additional information %d", h.Info)
+	}
+	if h.Major == MajOther && h.Info == 24 && h.Arg < 32 {
+		return Head{}, syntaxError(off, "invalid simple value encoding")
+	}
+	return h, nil
+}
+
+// AppendHead appends the minimal encoding of a head with major type `major` and argument `arg`.
+func AppendHead(b []byte, major byte, arg uint64) []byte {
+	switch {
+	case arg < 24:
+		return append(b, major<<5|byte(arg))
+	case arg <= 0xff:
+		return append(b, major<<5|24, byte(arg))
+	case arg <= 0xffff:
+		return append(b, major<<5|25, byte(arg>>8), byte(arg))
+	case arg <= 0xffffffff:
+		return append(b, major<<5|26, byte(arg>>24), byte(arg>>16), byte(arg>>8), byte(arg))
+	default:
+		b = append(b, major<<5|27)
+		var buf [8]byte
+		binary.BigEndian.PutUint64(buf[:], arg)
+		return append(b, buf[:]...)
+	}
+}
+
+// Skip returns the offset just after the item at offset `off` of `b`.
+func Skip(b []byte, off int) (int, error) {
+	return skip(b, off, 0)
+}
+
+func skip(b []byte, off, depth int) (int, error) {
+	if depth > MaxDepth {
+		return 0, syntaxError(off, "nesting deeper than %d", MaxDepth)
+	}
+	h, err := ReadHead(b, off)
+	if err != nil {
+		return 0, err
+	}
+	end := h.End()
+	switch h.Major {
+	case MajByteString, MajTextString:
+		if h.Indefinite() {
+			for {
+				if end >= len(b) {
+					return 0, syntaxError(end, "unexpected end of input")
+				}
+				if b[end] == Break {
+					return end + 1, nil
+				}
+				ch, err := ReadHead(b, end)
+				if err != nil {
+					return 0, err
+				}
+				if ch.Major != h.Major || ch.Indefinite() {
+					return 0, syntaxError(end, "invalid chunk in indefinite-length string")
+				}
+				if end, err = skip(b, end, depth+1); err != nil {
+					return 0, err
+				}
+			}
+		}
+		if h.Arg > uint64(len(b)-end) {
+			return 0, syntaxError(off, "unexpected end of input")
+		}
+		return end + int(h.Arg), nil
+	case MajArray, MajMap:
+		n := h.Arg
+		if h.Major == MajMap {
+			if n > uint64(len(b)) {
+				return 0, syntaxError(off, "unexpected end of input")
+			}
+			n *= 2
+		}
+		if h.Indefinite() {
+			items := 0
+			for {
+				if end >= len(b) {
+					return 0, syntaxError(end, "unexpected end of input")
+				}
+				if b[end] == Break {
+					if h.Major == MajMap && items%2 != 0 {
+						return 0, syntaxError(end, "map with a key but no value")
+					}
+					return end + 1, nil
+				}
+				if end, err = skip(b, end, depth+1); err != nil {
+					return 0, err
+				}
+				items++
+			}
+		}
+		if n > uint64(len(b)-end) {
+			// Each item takes at least one byte.
+			return 0, syntaxError(off, "unexpected end of input")
+		}
+		for i := uint64(0); i < n; i++ {
+			if end, err = skip(b, end, depth+1); err != nil {
+				return 0, err
+			}
+		}
+		return end, nil
+	case MajTag:
+		return skip(b, end, depth+1)
+	default:
+		return end, nil
+	}
+}
+
+// Links calls `f` with each IPLD link (a byte string tagged with TagLink) in the item at offset
+// `off` of `b` and returns the offset just after the item.
+func Links(b []byte, off int, f func(c cid.Cid)) (int, error) {
+	return links(b, off, 0, f)
+}
+
+func links(b []byte, off, depth int, f func(c cid.Cid)) (int, error) {
+	if depth > MaxDepth {
+		return 0, syntaxError(off, "nesting deeper than %d", MaxDepth)
+	}
+	h, err := ReadHead(b, off)
+	if err != nil {
+		return 0, err
+	}
+	switch h.Major {
+	case MajTag:
+		if h.Arg == TagLink {
+			c, end, err := ReadLink(b, h.End())
+			if err != nil {
+				return 0, err
+			}
+			f(c)
+			return end, nil
+		}
+		return links(b, h.End(), depth+1, f)
+	case MajArray, MajMap:
+		end := h.End()
+		n := h.Arg
+		if h.Major == MajMap {
+			n *= 2
+		}
+		for i := uint64(0); h.Indefinite() || i < n; i++ {
+			if h.Indefinite() {
+				if end >= len(b) {
+					return 0, syntaxError(end, "unexpected end of input")
+				}
+				if b[end] == Break {
+					return end + 1, nil
+				}
+			}
+			if end, err = links(b, end, depth+1, f); err != nil {
+				return 0, err
+			}
+		}
+		return end, nil
+	default:
+		return Skip(b, off)
+	}
+}
+
+// ReadLink reads the content of an IPLD link, a byte string holding a 0x00 multibase prefix
+// followed by the binary CID, at offset `off` of `b`.
+func ReadLink(b []byte, off int) (cid.Cid, int, error) {
+	h, err := ReadHead(b, off)
+	if err != nil {
+		return cid.Undef, 0, err
+	}
+	if h.Major != MajByteString || h.Indefinite() {
+		return cid.Undef, 0, syntaxError(off, "link is not a definite-length byte string")
+	}
+	end, err := Skip(b, off)
+	if err != nil {
+		return cid.Undef, 0, err
+	}
+	content := b[h.End():end]
+	if len(content) == 0 || content[0] != 0 {
+		return cid.Undef, 0, syntaxError(off, "link without the identity multibase prefix")
+	}
+	c, err := cid.Cast(content[1:])
+	if err != nil {
+		return cid.Undef, 0, syntaxError(off, "invalid CID in link: %v", err)
+	}
+	return c, end, nil
+}
@@ -0,0 +1,89 @@
+package cbor
+
+import (
+	"bytes"
+	"io"
+)
+
+// ReadItem reads exactly one data item from `r` and returns its bytes. Offsets in errors are
+// relative to the start of the item.
+func ReadItem(r io.Reader) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := readItem(r, &buf, 0); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func readItem(r io.Reader, buf *bytes.Buffer, depth int) error {
+	if depth > MaxDepth {
+		return syntaxError(buf.Len(), "nesting deeper than %d", MaxDepth)
+	}
+	off := buf.Len()
+	if _, err := io.CopyN(buf, r, 1); err != nil {
+		if err == io.EOF && depth > 0 {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	if info := buf.Bytes()[off] & 0x1f; info >= 24 && info <= 27 {
+		if _, err := io.CopyN(buf, r, 1<<(info-24)); err != nil {
+			return noEOF(err)
+		}
+	}
+	h, err := ReadHead(buf.Bytes(), off)
+	if err != nil {
+		return err
+	}
+	switch h.Major {
+	case MajByteString, MajTextString:
+		if h.Indefinite() {
+			return readUntilBreak(r, buf, depth)
+		}
+		// Copying grows the buffer only as data arrives, so a forged length can't exhaust memory.
+		if _, err := io.CopyN(buf, r, int64(h.Arg)); err != nil {
+			return noEOF(err)
+		}
+	case MajArray, MajMap:
+		if h.Indefinite() {
+			return readUntilBreak(r, buf, depth)
+		}
+		n := h.Arg
+		if h.Major == MajMap {
+			n *= 2
+		}
+		for i := uint64(0); i < n; i++ {
+			if err := readItem(r, buf, depth+1); err != nil {
+				return noEOF(err)
+			}
+		}
+	case MajTag:
+		return noEOF(readItem(r, buf, depth+1))
+	}
+	return nil
+}
+
+// readUntilBreak reads items of an indefinite-length item until the break byte.
+func readUntilBreak(r io.Reader, buf *bytes.Buffer, depth int) error {
+	var b [1]byte
+	for {
+		if _, err := io.ReadFull(r, b[:]); err != nil {
+			return noEOF(err)
+		}
+		if b[0] == Break {
+			buf.WriteByte(Break)
+			return nil
+		}
+		// Push the byte back by reading the rest of the item behind it.
+		if err := readItem(io.MultiReader(bytes.NewReader(b[:]), r), buf, depth+1); err != nil {
+			return noEOF(err)
+		}
+	}
+}
+
+func noEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
@@ -0,0 +1,76 @@
+package cborutil
+
+import (
+	"io"
+	"sync"
+
+	"github.com/daotl/go-marsha"
+	"github.com/daotl/go-marsha/internal/fields"
+)
+
+// maxPooled is the capacity above which buffers are not returned to the pool, so that a few large
+// messages don't keep memory alive.
+const maxPooled = 64 << 10
+
+// appendWriter is an io.Writer appending to a byte slice.
+type appendWriter struct {
+	b []byte
+}
+
+func (w *appendWriter) Write(p []byte) (int, error) {
+	w.b = append(w.b, p...)
+	return len(p), nil
+}
+
+func (w *appendWriter) WriteString(s string) (int, error) {
+	w.b = append(w.b, s...)
+	return len(s), nil
+}
+
+var (
+	writers = sync.Pool{New: func() interface{} { return new(appendWriter) }}
+	buffers = sync.Pool{New: func() interface{} { b := make([]byte, 0, 512); return &b }}
+)
+
+// Append appends the encoding of `p`, written by `write` to the writer passed to it, to `dst`
+// after applying Output, and returns the extended slice, or `dst` and the error if it fails. The
+// writer is pooled, so `write` must not keep it.
+func Append(opts marsha.Options, dst []byte, p interface{}, s fields.Schema,
+	write func(w io.Writer) error) ([]byte, error) {
+	w := writers.Get().(*appendWriter)
+	w.b = dst
+	err := write(w)
+	b := w.b
+	w.b = nil
+	writers.Put(w)
+	if err != nil {
+		return dst, err
+	}
+	if !Buffered(opts) {
+		return b, nil
+	}
+	bin := b[len(dst):]
+	out, err := Output(opts, bin, p, s)
+	if err != nil {
+		return dst, err
+	}
+	if len(out) == len(bin) && (len(out) == 0 || &out[0] == &bin[0]) {
+		return b, nil
+	}
+	return append(b[:len(dst)], out...), nil
+}
+
+// WriteAppended writes to `w` the bytes `write` appends to an empty pooled buffer.
+func WriteAppended(w io.Writer, write func(dst []byte) ([]byte, error)) (int, error) {
+	bp := buffers.Get().(*[]byte)
+	b, err := write((*bp)[:0])
+	n := 0
+	if err == nil {
+		n, err = w.Write(b)
+	}
+	if cap(b) <= maxPooled {
+		*bp = b[:0]
+		buffers.Put(bp)
+	}
+	return n, err
+}
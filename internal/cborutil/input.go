@@ -0,0 +1,104 @@
+package cborutil
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+
+	"github.com/daotl/go-marsha"
+	"github.com/daotl/go-marsha/internal/cbor"
+	"github.com/daotl/go-marsha/internal/fields"
+)
+
+// Input prepares `bin` to be unmarshaled into `p` according to `opts`, resolving the fields of
+// types with `s`:
+//   - if `opts.Strict` is set, `bin` must be exactly one data item in core deterministic encoding
+//     without unknown fields,
+//   - otherwise unknown fields are handled according to `opts.UnknownPolicy`.
+//
+// It returns the bytes to unmarshal into `p` and a function to call with the count of bytes read
+// from them once unmarshaled, which keeps the unknown fields to preserve in `p` and returns the
+// count of bytes read from `bin`.
+func Input(opts marsha.Options, bin []byte, p interface{}, s fields.Schema) (
+	[]byte, func(read int) int, error) {
+	identity := func(read int) int { return read }
+	policy := opts.UnknownPolicy
+	if opts.Strict {
+		end, err := cbor.CheckCanonical(bin, 0)
+		if err != nil {
+			return nil, nil, err
+		}
+		if end != len(bin) {
+			return nil, nil, &marsha.StrictError{Offset: end, Err: marsha.ErrTrailingData}
+		}
+		policy = marsha.UnknownError
+	}
+	if policy == marsha.UnknownDefault {
+		return bin, identity, nil
+	}
+
+	off, t := 0, reflect.TypeOf(p)
+	if _, ok := p.(*marsha.Union); ok && opts.Registry != nil {
+		ti, n, err := ReadUnionHeader(bytes.NewReader(bin), opts.Registry)
+		if err != nil {
+			return nil, nil, err
+		}
+		off, t = n, ti.Type
+	}
+	var us []fields.Unknown
+	end, err := fields.Walk(bin, off, t, s, func(u fields.Unknown) error {
+		if policy == marsha.UnknownError {
+			return &marsha.StrictError{Offset: u.Offset, Path: u.Path, Err: marsha.ErrUnknownField}
+		}
+		us = append(us, u)
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	in, err := fields.Strip(bin[:end], us)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(us) == 0 {
+		in = bin
+	}
+	return in, func(read int) int {
+		if policy == marsha.UnknownPreserve {
+			v := reflect.ValueOf(p)
+			if u, ok := p.(*marsha.Union); ok {
+				v = reflect.ValueOf(u.Value)
+			}
+			if !v.IsValid() {
+				return read
+			}
+			// Drop the unknown fields kept by a previous unmarshaling into the same value.
+			if uk, ok := v.Interface().(marsha.UnknownKeeper); ok {
+				uk.UnknownFields().Set(nil, 0)
+			}
+			fields.Keep(v, bin, us)
+		}
+		if read < 0 || len(us) == 0 {
+			return read
+		}
+		return end - len(in) + read
+	}, nil
+}
+
+// Reader returns `r` itself if Input would return its input unchanged, or otherwise a reader
+// over the next data item read from `r` after applying Input, and the function returned by Input.
+func Reader(opts marsha.Options, r io.Reader, p interface{}, s fields.Schema) (
+	io.Reader, func(read int) int, error) {
+	if !opts.Strict && opts.UnknownPolicy == marsha.UnknownDefault {
+		return r, func(read int) int { return read }, nil
+	}
+	item, err := cbor.ReadItem(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	in, done, err := Input(opts, item, p, s)
+	if err != nil {
+		return nil, nil, err
+	}
+	return bytes.NewReader(in), done, nil
+}
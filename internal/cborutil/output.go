@@ -0,0 +1,60 @@
+package cborutil
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+
+	"github.com/daotl/go-marsha"
+	"github.com/daotl/go-marsha/internal/fields"
+)
+
+// Output returns `bin`, the encoding of `p`, with the unknown fields kept in `p` restored if
+// `opts.UnknownPolicy` is marsha.UnknownPreserve, after applying Deterministic.
+func Output(opts marsha.Options, bin []byte, p interface{}, s fields.Schema) ([]byte, error) {
+	if opts.UnknownPolicy == marsha.UnknownPreserve {
+		off, v := 0, reflect.ValueOf(p)
+		if u, ok := p.(*marsha.Union); ok {
+			_, n, err := ReadUnionHeader(bytes.NewReader(bin), opts.Registry)
+			if err != nil {
+				return nil, err
+			}
+			off, v = n, reflect.ValueOf(u.Value)
+		}
+		var err error
+		if bin, err = fields.Restore(bin, off, v, s); err != nil {
+			return nil, err
+		}
+	}
+	return Deterministic(opts, bin)
+}
+
+// Buffered returns whether Output may change the encoding of values, so encoders must buffer it.
+func Buffered(opts marsha.Options) bool {
+	return deterministic(opts) || opts.UnknownPolicy == marsha.UnknownPreserve
+}
+
+// deterministic returns whether output must be in core deterministic encoding, which is also the
+// case if `opts.Strict` is set so that Marshas can unmarshal their own output.
+func deterministic(opts marsha.Options) bool {
+	return opts.Deterministic || opts.Strict
+}
+
+// Deterministic returns `bin` re-encoded in core deterministic encoding if `opts.Deterministic` or
+// `opts.Strict` is set, or `bin` itself if neither is set or `bin` is already canonical.
+func Deterministic(opts marsha.Options, bin []byte) ([]byte, error) {
+	if !deterministic(opts) || marsha.IsCanonical(bin) {
+		return bin, nil
+	}
+	return marsha.Canonicalize(bin)
+}
+
+// WriteOutput writes `bin` to `w` after applying Output.
+func WriteOutput(w io.Writer, opts marsha.Options, bin []byte, p interface{}, s fields.Schema) (
+	int, error) {
+	bin, err := Output(opts, bin, p, s)
+	if err != nil {
+		return 0, err
+	}
+	return w.Write(bin)
+}
@@ -0,0 +1,75 @@
+// Package cborutil provides low-level CBOR helpers shared by the CBOR Marsha implementations.
+package cborutil
+
+import (
+	"fmt"
+	"io"
+
+	cbg "github.com/daotl/cbor-gen"
+
+	"github.com/daotl/go-marsha"
+)
+
+// UnionHeader returns the bytes preceding the value of a marsha.Union holding a value of the type
+// described by `ti`: a CBOR tag header if `ti.CBORTag` is set, otherwise the header of a 2-element
+// array followed by the `ti.Name` text string, in which case the value is the second element.
+func UnionHeader(ti *marsha.TypeInfo) ([]byte, error) {
+	if ti.CBORTag != 0 {
+		return cbg.CborEncodeMajorType(cbg.MajTag, ti.CBORTag), nil
+	}
+	if ti.Name == "" {
+		return nil, fmt.Errorf("%w: %s has neither a CBOR tag nor a name", marsha.ErrNotRegistered, ti.Type)
+	}
+	bin := cbg.CborEncodeMajorType(cbg.MajArray, 2)
+	bin = append(bin, cbg.CborEncodeMajorType(cbg.MajTextString, uint64(len(ti.Name)))...)
+	return append(bin, ti.Name...), nil
+}
+
+// UnionValue returns the header for the value `u` holds together with its TypeInfo.
+func UnionValue(reg *marsha.Registry, u *marsha.Union) ([]byte, *marsha.TypeInfo, error) {
+	if reg == nil {
+		return nil, nil, marsha.ErrNoRegistry
+	}
+	if u.Value == nil {
+		return nil, nil, marsha.ErrEmptyUnion
+	}
+	ti, ok := reg.Lookup(u.Value)
+	if !ok {
+		return nil, nil, fmt.Errorf("%w: %T", marsha.ErrNotRegistered, u.Value)
+	}
+	h, err := UnionHeader(ti)
+	return h, ti, err
+}
+
+// ReadUnionHeader reads a header written by UnionHeader from `r` and returns the TypeInfo it
+// refers to and the count of bytes read.
+func ReadUnionHeader(r io.Reader, reg *marsha.Registry) (*marsha.TypeInfo, int, error) {
+	if reg == nil {
+		return nil, 0, marsha.ErrNoRegistry
+	}
+	maj, extra, read, err := cbg.CborReadHeader(r)
+	if err != nil {
+		return nil, read, err
+	}
+	switch {
+	case maj == cbg.MajTag:
+		ti, ok := reg.ByCBORTag(extra)
+		if !ok {
+			return nil, read, fmt.Errorf("%w: CBOR tag %d", marsha.ErrNotRegistered, extra)
+		}
+		return ti, read, nil
+	case maj == cbg.MajArray && extra == 2:
+		name, n, err := cbg.ReadString(r)
+		read += n
+		if err != nil {
+			return nil, read, err
+		}
+		ti, ok := reg.ByName(name)
+		if !ok {
+			return nil, read, fmt.Errorf("%w: name %q", marsha.ErrNotRegistered, name)
+		}
+		return ti, read, nil
+	default:
+		return nil, read, marsha.ErrNotUnionHeader
+	}
+}
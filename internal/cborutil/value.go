@@ -0,0 +1,42 @@
+package cborutil
+
+import (
+	"github.com/daotl/go-marsha"
+	"github.com/daotl/go-marsha/internal/cbor"
+)
+
+// DecodeValue decodes the data item at the start of `bin` into a Value tree. If `opts.Strict` is
+// set, `bin` must be exactly one data item in core deterministic encoding.
+func DecodeValue(opts marsha.Options, bin []byte) (marsha.Value, int, error) {
+	if opts.Strict {
+		end, err := cbor.CheckCanonical(bin, 0)
+		if err != nil {
+			return marsha.Value{}, 0, err
+		}
+		if end != len(bin) {
+			return marsha.Value{}, 0, &marsha.StrictError{Offset: end, Err: marsha.ErrTrailingData}
+		}
+	}
+	return marsha.DecodeCBORValue(bin)
+}
+
+// StructToValue marshals the struct `p` points to with `m` and decodes it into a Value tree.
+func StructToValue(m marsha.Marsha, p marsha.StructPtr) (marsha.Value, error) {
+	bin, err := m.MarshalStruct(p)
+	if err != nil {
+		return marsha.Value{}, err
+	}
+	v, _, err := marsha.DecodeCBORValue(bin)
+	return v, err
+}
+
+// ValueToStruct encodes the Value tree `v` and unmarshals it with `m` into the struct `p` points
+// to.
+func ValueToStruct(m marsha.Marsha, v marsha.Value, p marsha.StructPtr) error {
+	bin, err := marsha.EncodeCBORValue(v)
+	if err != nil {
+		return err
+	}
+	_, err = m.UnmarshalStruct(bin, p)
+	return err
+}
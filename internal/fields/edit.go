@@ -0,0 +1,299 @@
+package fields
+
+import (
+	"bytes"
+	"reflect"
+	"sort"
+
+	"github.com/daotl/go-marsha"
+	"github.com/daotl/go-marsha/internal/cbor"
+)
+
+// edit replaces b[off:end] with repl.
+type edit struct {
+	off, end int
+	repl     []byte
+	// container is the offset of the head of the map or tuple edited.
+	container int
+}
+
+// apply returns `b` with `edits` applied. Insertions at the same offset are applied innermost
+// container first, otherwise in order.
+func apply(b []byte, edits []edit) []byte {
+	sort.SliceStable(edits, func(i, j int) bool {
+		ei, ej := edits[i], edits[j]
+		if ei.off != ej.off {
+			return ei.off < ej.off
+		}
+		if ii, ij := ei.end == ei.off, ej.end == ej.off; ii != ij {
+			return ii
+		}
+		return ei.container > ej.container
+	})
+	out := make([]byte, 0, len(b))
+	pos := 0
+	for _, e := range edits {
+		out = append(out, b[pos:e.off]...)
+		out = append(out, e.repl...)
+		pos = e.end
+	}
+	return append(out, b[pos:]...)
+}
+
+// headEdit returns the edit changing the argument of the head at `off` of `b` by `delta`.
+func headEdit(b []byte, off, delta int) (edit, error) {
+	h, err := cbor.ReadHead(b, off)
+	if err != nil {
+		return edit{}, err
+	}
+	repl := cbor.AppendHead(nil, h.Major, uint64(int64(h.Arg)+int64(delta)))
+	return edit{off: off, end: h.End(), repl: repl, container: off}, nil
+}
+
+// Strip returns `b` without `us`, which were reported by Walk over `b`.
+func Strip(b []byte, us []Unknown) ([]byte, error) {
+	if len(us) == 0 {
+		return b, nil
+	}
+	counts := map[int]int{}
+	edits := make([]edit, 0, len(us)+1)
+	for _, u := range us {
+		counts[u.Container]++
+		edits = append(edits, edit{off: u.Offset, end: u.End, container: u.Container})
+	}
+	for c, n := range counts {
+		e, err := headEdit(b, c, -n)
+		if err != nil {
+			return nil, err
+		}
+		edits = append(edits, e)
+	}
+	return apply(b, edits), nil
+}
+
+// Keep keeps `us`, which were reported by Walk over `b`, in the Unknown of the struct value each
+// of them belongs to within `v`.
+func Keep(v reflect.Value, b []byte, us []Unknown) {
+	type kept struct {
+		target []Step
+		raw    []byte
+		n      int
+	}
+	var ks []*kept
+	byContainer := map[int]*kept{}
+	for _, u := range us {
+		if u.Target == nil {
+			continue
+		}
+		k, ok := byContainer[u.Container]
+		if !ok {
+			k = &kept{target: u.Target}
+			byContainer[u.Container] = k
+			ks = append(ks, k)
+		}
+		k.raw = append(k.raw, b[u.Offset:u.End]...)
+		k.n++
+	}
+	for _, k := range ks {
+		if sv, ok := locate(v, k.target); ok {
+			if uk, ok := keeper(sv); ok {
+				uk.UnknownFields().Set(k.raw, k.n)
+			}
+		}
+	}
+}
+
+// locate returns the value `target` locates within `v`.
+func locate(v reflect.Value, target []Step) (reflect.Value, bool) {
+	for _, s := range target {
+		if s.Field != nil {
+			for _, i := range s.Field {
+				if v = deref(v); !v.IsValid() || v.Kind() != reflect.Struct {
+					return reflect.Value{}, false
+				}
+				v = v.Field(i)
+			}
+			continue
+		}
+		if v = deref(v); !v.IsValid() ||
+			v.Kind() != reflect.Slice && v.Kind() != reflect.Array || s.Elem >= v.Len() {
+			return reflect.Value{}, false
+		}
+		v = v.Index(s.Elem)
+	}
+	return v, true
+}
+
+// deref follows pointers from `v`, returning the zero Value at a nil pointer.
+func deref(v reflect.Value) reflect.Value {
+	for v.IsValid() && (v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface) {
+		if v.IsNil() {
+			return reflect.Value{}
+		}
+		v = v.Elem()
+	}
+	return v
+}
+
+// keeper returns the UnknownKeeper of struct value `v`, which must be addressable.
+func keeper(v reflect.Value) (marsha.UnknownKeeper, bool) {
+	if v = deref(v); !v.IsValid() || v.Kind() != reflect.Struct || !v.CanAddr() {
+		return nil, false
+	}
+	uk, ok := v.Addr().Interface().(marsha.UnknownKeeper)
+	return uk, ok
+}
+
+// Restore returns `b`, the encoding of `v` starting at offset `off`, with the unknown fields kept
+// in the Unknowns of the struct values within `v` restored: appended to tuples, or inserted into
+// maps in bytewise order of the encoded keys, which preserves core deterministic encoding.
+func Restore(b []byte, off int, v reflect.Value, s Schema) ([]byte, error) {
+	r := restorer{b: b, s: s}
+	if _, err := r.restore(off, v, 0); err != nil {
+		return nil, err
+	}
+	if len(r.edits) == 0 {
+		return b, nil
+	}
+	return apply(b, r.edits), nil
+}
+
+type restorer struct {
+	b     []byte
+	s     Schema
+	edits []edit
+}
+
+func (r *restorer) restore(off int, v reflect.Value, depth int) (int, error) {
+	b := r.b
+	v = deref(v)
+	h, err := cbor.ReadHead(b, off)
+	if err != nil {
+		return 0, err
+	}
+	if depth > cbor.MaxDepth || !v.IsValid() || h.Indefinite() {
+		return cbor.Skip(b, off)
+	}
+	end := h.End()
+	switch {
+	case v.Kind() == reflect.Struct && (h.Major == cbor.MajArray || h.Major == cbor.MajMap):
+		fs, ok := r.s.Fields(v.Type())
+		if !ok {
+			return cbor.Skip(b, off)
+		}
+		var u *marsha.Unknown
+		if uk, ok := keeper(v); ok && uk.UnknownFields().Len() > 0 {
+			u = uk.UnknownFields()
+		}
+		if h.Major == cbor.MajArray {
+			for i := uint64(0); i < h.Arg; i++ {
+				if i < uint64(len(fs)) {
+					end, err = r.restore(end, field(v, fs[i].Index), depth+1)
+				} else {
+					end, err = cbor.Skip(b, end)
+				}
+				if err != nil {
+					return 0, err
+				}
+			}
+			if u != nil {
+				if err = r.insert(off, u.Len(), edit{off: end, end: end, repl: u.Raw()}); err != nil {
+					return 0, err
+				}
+			}
+			return end, nil
+		}
+		var keys []int // offsets of the keys of the entries
+		for i := uint64(0); i < h.Arg; i++ {
+			keys = append(keys, end)
+			key, vOff, err := mapKey(b, end)
+			if err != nil {
+				return 0, err
+			}
+			if fd := lookup(fs, key); fd != nil {
+				end, err = r.restore(vOff, field(v, fd.Index), depth+1)
+			} else {
+				end, err = cbor.Skip(b, vOff)
+			}
+			if err != nil {
+				return 0, err
+			}
+		}
+		if u != nil {
+			if err = r.insertEntries(off, end, keys, u); err != nil {
+				return 0, err
+			}
+		}
+		return end, nil
+	case (v.Kind() == reflect.Slice || v.Kind() == reflect.Array) && h.Major == cbor.MajArray:
+		for i := uint64(0); i < h.Arg; i++ {
+			var ev reflect.Value
+			if i < uint64(v.Len()) {
+				ev = v.Index(int(i))
+			}
+			if end, err = r.restore(end, ev, depth+1); err != nil {
+				return 0, err
+			}
+		}
+		return end, nil
+	default:
+		return cbor.Skip(b, off)
+	}
+}
+
+// insert records `e` inserting `n` items into the map or tuple whose head is at `container`.
+func (r *restorer) insert(container, n int, es ...edit) error {
+	he, err := headEdit(r.b, container, n)
+	if err != nil {
+		return err
+	}
+	for i := range es {
+		es[i].container = container
+	}
+	r.edits = append(append(r.edits, he), es...)
+	return nil
+}
+
+// insertEntries records the insertion of the entries kept in `u` into the map whose head is at
+// `container`, which ends at `end` and has entries with keys at `keys`.
+func (r *restorer) insertEntries(container, end int, keys []int, u *marsha.Unknown) error {
+	raw := u.Raw()
+	es := make([]edit, 0, u.Len())
+	for off := 0; off < len(raw); {
+		vOff, err := cbor.Skip(raw, off)
+		if err != nil {
+			return err
+		}
+		eEnd, err := cbor.Skip(raw, vOff)
+		if err != nil {
+			return err
+		}
+		key := raw[off:vOff]
+		at := end
+		for _, k := range keys {
+			kEnd, err := cbor.Skip(r.b, k)
+			if err != nil {
+				return err
+			}
+			if bytes.Compare(r.b[k:kEnd], key) > 0 {
+				at = k
+				break
+			}
+		}
+		es = append(es, edit{off: at, end: at, repl: raw[off:eEnd]})
+		off = eEnd
+	}
+	return r.insert(container, u.Len(), es...)
+}
+
+// field returns the field of struct value `v` at `index`, or the zero Value if it is behind a nil
+// pointer.
+func field(v reflect.Value, index []int) reflect.Value {
+	for _, i := range index {
+		if v = deref(v); !v.IsValid() {
+			return v
+		}
+		v = v.Field(i)
+	}
+	return v
+}
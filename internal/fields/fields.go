@@ -0,0 +1,240 @@
+// Package fields finds fields of CBOR-encoded structs which don't exist in their Go types, and
+// strips, keeps or restores them.
+package fields
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+
+	cbg "github.com/daotl/cbor-gen"
+
+	"github.com/daotl/go-marsha/internal/cbor"
+)
+
+// Field is a field of a struct as encoded.
+type Field struct {
+	// Key is the key of the field when the struct is encoded as a map.
+	Key  string
+	Type reflect.Type
+	// Index is the index sequence of the Go struct field as for reflect.Value.FieldByIndex.
+	Index []int
+}
+
+// Schema resolves the encoded fields of struct types.
+type Schema interface {
+	// Fields returns the encoded fields of struct type `t` in tuple order, or false if the encoding
+	// of `t` is unknown, in which case its items are not inspected.
+	Fields(t reflect.Type) ([]Field, bool)
+}
+
+// Unknown is an item of the input which doesn't correspond to a field of a struct: a map entry
+// with an unknown key, or an element of a tuple beyond the last field.
+type Unknown struct {
+	// Path is the path of the item from the top-level item, like a JSON Pointer.
+	Path string
+	// Offset is the offset of the map key or tuple element.
+	Offset int
+	// End is the offset just after the map value or tuple element.
+	End int
+	// Container is the offset of the head of the map or tuple.
+	Container int
+	// Target locates the struct value of the map or tuple from the top-level value, unless nil.
+	Target []Step
+}
+
+// Step is a step from a value to a struct field, or to an element if Field is nil.
+type Step struct {
+	Field []int
+	Elem  int
+}
+
+// Walk calls `f` with each Unknown in the item at offset `off` of `b`, which is decoded into a
+// value of type `t`, stopping at the first error `f` returns. It returns the offset just after the
+// item.
+func Walk(b []byte, off int, t reflect.Type, s Schema, f func(Unknown) error) (int, error) {
+	w := walker{b: b, s: s, f: f}
+	return w.walk(off, t, "", []Step{}, 0)
+}
+
+type walker struct {
+	b []byte
+	s Schema
+	f func(Unknown) error
+}
+
+// walk walks the item at `off` of type `t` at `path`, located by `target` unless nil.
+func (w *walker) walk(off int, t reflect.Type, path string, target []Step, depth int) (int, error) {
+	b := w.b
+	if depth > cbor.MaxDepth {
+		return cbor.Skip(b, off)
+	}
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	h, err := cbor.ReadHead(b, off)
+	if err != nil {
+		return 0, err
+	}
+	if t == nil || h.Indefinite() {
+		return cbor.Skip(b, off)
+	}
+	end := h.End()
+	switch {
+	case t.Kind() == reflect.Struct && (h.Major == cbor.MajArray || h.Major == cbor.MajMap):
+		fs, ok := w.s.Fields(t)
+		if !ok {
+			return cbor.Skip(b, off)
+		}
+		u := Unknown{Container: off, Target: target}
+		if h.Major == cbor.MajArray {
+			for i := uint64(0); i < h.Arg; i++ {
+				elPath := path + "/" + strconv.FormatUint(i, 10)
+				if i < uint64(len(fs)) {
+					end, err = w.walk(end, fs[i].Type, elPath, step(target, Step{Field: fs[i].Index}),
+						depth+1)
+				} else {
+					u.Path, u.Offset = elPath, end
+					end, err = w.unknown(u, end)
+				}
+				if err != nil {
+					return 0, err
+				}
+			}
+			return end, nil
+		}
+		for i := uint64(0); i < h.Arg; i++ {
+			key, vOff, err := mapKey(b, end)
+			if err != nil {
+				return 0, err
+			}
+			kPath := path + "/" + escape(key)
+			if fd := lookup(fs, key); fd != nil {
+				end, err = w.walk(vOff, fd.Type, kPath, step(target, Step{Field: fd.Index}), depth+1)
+			} else {
+				u.Path, u.Offset = kPath, end
+				end, err = w.unknown(u, vOff)
+			}
+			if err != nil {
+				return 0, err
+			}
+		}
+		return end, nil
+	case (t.Kind() == reflect.Slice || t.Kind() == reflect.Array) && h.Major == cbor.MajArray:
+		for i := uint64(0); i < h.Arg; i++ {
+			elPath := path + "/" + strconv.FormatUint(i, 10)
+			if end, err = w.walk(end, t.Elem(), elPath, step(target, Step{Elem: int(i)}),
+				depth+1); err != nil {
+				return 0, err
+			}
+		}
+		return end, nil
+	case t.Kind() == reflect.Map && h.Major == cbor.MajMap:
+		for i := uint64(0); i < h.Arg; i++ {
+			key, vOff, err := mapKey(b, end)
+			if err != nil {
+				return 0, err
+			}
+			// Map values are not addressable, so nothing in them can be located.
+			if end, err = w.walk(vOff, t.Elem(), path+"/"+escape(key), nil, depth+1); err != nil {
+				return 0, err
+			}
+		}
+		return end, nil
+	default:
+		return cbor.Skip(b, off)
+	}
+}
+
+// unknown calls `f` with `u` whose value starts at `vOff`.
+func (w *walker) unknown(u Unknown, vOff int) (int, error) {
+	end, err := cbor.Skip(w.b, vOff)
+	if err != nil {
+		return 0, err
+	}
+	u.End = end
+	if err = w.f(u); err != nil {
+		return 0, err
+	}
+	return end, nil
+}
+
+// step returns `target` followed by `s`, or nil if `target` is nil.
+func step(target []Step, s Step) []Step {
+	if target == nil {
+		return nil
+	}
+	return append(target[:len(target):len(target)], s)
+}
+
+// mapKey returns the map key at `off` of `b` rendered as a string, and the offset of its value.
+func mapKey(b []byte, off int) (string, int, error) {
+	h, err := cbor.ReadHead(b, off)
+	if err != nil {
+		return "", 0, err
+	}
+	end, err := cbor.Skip(b, off)
+	if err != nil {
+		return "", 0, err
+	}
+	switch {
+	case h.Major == cbor.MajTextString && !h.Indefinite():
+		return string(b[h.End():end]), end, nil
+	case h.Major == cbor.MajUnsignedInt:
+		return strconv.FormatUint(h.Arg, 10), end, nil
+	case h.Major == cbor.MajNegativeInt:
+		return "-" + strconv.FormatUint(h.Arg+1, 10), end, nil
+	default:
+		// Not a valid struct field key, which is unknown to every struct.
+		return "\x00", end, nil
+	}
+}
+
+func lookup(fs []Field, key string) *Field {
+	for i := range fs {
+		if fs[i].Key == key {
+			return &fs[i]
+		}
+	}
+	return nil
+}
+
+// escape escapes a path segment like a JSON Pointer reference token.
+func escape(s string) string {
+	return strings.NewReplacer("~", "~0", "/", "~1").Replace(s)
+}
+
+// CBORGen is the Schema of structs with code generated by `github.com/daotl/cbor-gen` with
+// embedded structs flattened, as `test/cborgen/gen.go` does.
+var CBORGen Schema = &cborGen{}
+
+type cborGen struct {
+	cache sync.Map // reflect.Type -> cached
+}
+
+type cached struct {
+	fields []Field
+	ok     bool
+}
+
+var cborUnmarshaler = reflect.TypeOf((*cbg.CBORUnmarshaler)(nil)).Elem()
+
+func (s *cborGen) Fields(t reflect.Type) ([]Field, bool) {
+	if c, ok := s.cache.Load(t); ok {
+		return c.(cached).fields, c.(cached).ok
+	}
+	var c cached
+	if reflect.PtrTo(t).Implements(cborUnmarshaler) {
+		if gti, _, err := cbg.ParseTypeInfo(reflect.Zero(t).Interface(), true); err == nil {
+			c.fields = make([]Field, len(gti.Fields))
+			for i, f := range gti.Fields {
+				sf, _ := t.FieldByName(f.Name)
+				c.fields[i] = Field{Key: f.MapKey, Type: f.Type, Index: sf.Index}
+			}
+			c.ok = true
+		}
+	}
+	s.cache.Store(t, c)
+	return c.fields, c.ok
+}
@@ -0,0 +1,73 @@
+// Package frame provides helpers for Encoder/Decoder middlewares which transmit each item as a
+// frame holding a uvarint length followed by that many bytes.
+package frame
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+)
+
+// Write writes `prefix`, the uvarint length of `body` and `body` to `w` and returns the count of
+// bytes written.
+func Write(w io.Writer, prefix, body []byte) (int, error) {
+	h := make([]byte, len(prefix), len(prefix)+binary.MaxVarintLen64)
+	copy(h, prefix)
+	h = h[:len(prefix)+binary.PutUvarint(h[len(prefix):cap(h)], uint64(len(body)))]
+	n, err := w.Write(h)
+	if err != nil {
+		return n, err
+	}
+	n_, err := w.Write(body)
+	return n + n_, err
+}
+
+// Reader wraps an io.Reader to count the bytes read from it without reading ahead.
+type Reader struct {
+	R io.Reader
+	// N is the count of bytes read.
+	N int
+}
+
+var _ io.ByteReader = (*Reader)(nil)
+
+func (r *Reader) Read(p []byte) (int, error) {
+	n, err := r.R.Read(p)
+	r.N += n
+	return n, err
+}
+
+func (r *Reader) ReadByte() (byte, error) {
+	var b [1]byte
+	if _, err := io.ReadFull(r, b[:]); err != nil {
+		return 0, err
+	}
+	return b[0], nil
+}
+
+// ReadBody reads a uvarint length and that many bytes. It returns io.EOF only if no byte was read
+// and io.ErrUnexpectedEOF if the input ends in the middle of the frame.
+func (r *Reader) ReadBody() ([]byte, error) {
+	start := r.N
+	l, err := binary.ReadUvarint(r)
+	if err != nil {
+		if err == io.EOF && r.N > start {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	// Grow the buffer as bytes arrive rather than trusting the length up front.
+	var body bytes.Buffer
+	if _, err = io.CopyN(&body, r, int64(l)); err != nil {
+		return nil, NoEOF(err)
+	}
+	return body.Bytes(), nil
+}
+
+// NoEOF converts io.EOF in the middle of a frame to io.ErrUnexpectedEOF.
+func NoEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
@@ -0,0 +1,101 @@
+// Package model resolves Go models to their struct types and names these types for the
+// generators of schemas and code, so that the generators resolve and name models alike.
+package model
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/ipfs/go-cid"
+)
+
+var (
+	ErrNotStruct   = errors.New("not a struct type")
+	ErrUnsupported = errors.New("unsupported type")
+)
+
+var CidType = reflect.TypeOf(cid.Cid{})
+
+// Struct returns the struct type of the model `m`, which is a struct value or a pointer to one.
+func Struct(m interface{}) (reflect.Type, error) {
+	t := reflect.TypeOf(m)
+	if t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == nil || t.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("%w: %T", ErrNotStruct, m)
+	}
+	return t, nil
+}
+
+// Package returns the name of the package of the named type `t`, the last element of its import
+// path.
+func Package(t reflect.Type) string {
+	pkg := t.PkgPath()
+	return pkg[strings.LastIndexByte(pkg, '/')+1:]
+}
+
+// Qualify returns the name of the named type `t` qualified by its package, such as "test.Order".
+func Qualify(t reflect.Type) string {
+	return Package(t) + "." + t.Name()
+}
+
+// Namer names struct types after themselves, qualifying types of the same name by their package.
+type Namer struct {
+	qualify func(t reflect.Type) string
+	names   map[reflect.Type]string
+	types   map[string]reflect.Type
+}
+
+// NewNamer creates a Namer qualifying the names of types by `qualify`, or refusing to name types
+// of the same name if it is nil. Types whose qualified names are taken too are refused as well.
+func NewNamer(qualify func(t reflect.Type) string) *Namer {
+	return &Namer{
+		qualify: qualify,
+		names:   map[reflect.Type]string{},
+		types:   map[string]reflect.Type{},
+	}
+}
+
+// Lookup returns the name of the struct type `t`, or false if it isn't named yet.
+func (n *Namer) Lookup(t reflect.Type) (string, bool) {
+	name, ok := n.names[t]
+	return name, ok
+}
+
+// Type returns the type named `name`, which is nil for reserved names, or false if no type is.
+func (n *Namer) Type(name string) (reflect.Type, bool) {
+	t, ok := n.types[name]
+	return t, ok
+}
+
+// Reserve reserves `name` for something other than a struct type.
+func (n *Namer) Reserve(name string) {
+	n.types[name] = nil
+}
+
+// Name names the struct type `t`, which must not be anonymous, and returns its name.
+func (n *Namer) Name(t reflect.Type) (string, error) {
+	if name, ok := n.names[t]; ok {
+		return name, nil
+	}
+	if t.Name() == "" {
+		return "", fmt.Errorf("%w: anonymous struct %s", ErrUnsupported, t)
+	}
+	name := t.Name()
+	if _, taken := n.types[name]; taken && n.qualify != nil {
+		// Qualify types of the same name by their package.
+		name = n.qualify(t)
+	}
+	if other, taken := n.types[name]; taken {
+		if other == nil {
+			return "", fmt.Errorf("%w: %s is named %s, which is reserved", ErrUnsupported, t, name)
+		}
+		return "", fmt.Errorf("%w: %s and %s are both named %s", ErrUnsupported, other, t, name)
+	}
+	n.names[t] = name
+	n.types[name] = t
+	return name, nil
+}
@@ -0,0 +1,69 @@
+// Package pointer parses paths to data items, which are written like JSON Pointers (RFC 6901).
+package pointer
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrSyntax   = errors.New("invalid path")
+	ErrNotFound = errors.New("path not found")
+)
+
+// Split returns the reference tokens of `path`, which is empty for the top-level item or a
+// sequence of tokens each preceded by "/", with "~1" and "~0" unescaped to "/" and "~".
+func Split(path string) ([]string, error) {
+	if path == "" {
+		return nil, nil
+	}
+	if path[0] != '/' {
+		return nil, fmt.Errorf("%w %q: must start with /", ErrSyntax, path)
+	}
+	toks := strings.Split(path[1:], "/")
+	for i, tok := range toks {
+		for j := 0; j < len(tok); j++ {
+			if tok[j] != '~' {
+				continue
+			}
+			if j+1 == len(tok) || tok[j+1] != '0' && tok[j+1] != '1' {
+				return nil, fmt.Errorf("%w %q: invalid escape in %q", ErrSyntax, path, tok)
+			}
+			j++
+		}
+		toks[i] = strings.NewReplacer("~1", "/", "~0", "~").Replace(tok)
+	}
+	return toks, nil
+}
+
+// Index returns the array index written in the token `tok`, in decimal without leading zeros.
+func Index(tok string) (int, bool) {
+	if tok == "" || len(tok) > 1 && tok[0] == '0' {
+		return 0, false
+	}
+	for i := 0; i < len(tok); i++ {
+		if tok[i] < '0' || tok[i] > '9' {
+			return 0, false
+		}
+	}
+	i, err := strconv.Atoi(tok)
+	return i, err == nil
+}
+
+// NotFound returns an error wrapping ErrNotFound for the first `n` tokens of `toks`, the last of
+// which isn't found in the item at offset `off`, for the reason `format`.
+func NotFound(toks []string, n, off int, format string, args ...interface{}) error {
+	return fmt.Errorf("%w: %s at offset %d: %s", ErrNotFound, Join(toks[:n]), off, fmt.Sprintf(format, args...))
+}
+
+// Join returns the path of the tokens `toks`.
+func Join(toks []string) string {
+	var sb strings.Builder
+	for _, tok := range toks {
+		sb.WriteByte('/')
+		sb.WriteString(strings.NewReplacer("~", "~0", "/", "~1").Replace(tok))
+	}
+	return sb.String()
+}
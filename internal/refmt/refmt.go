@@ -3,10 +3,14 @@ package refmt
 // Adapted from: https://github.com/ipfs/go-ipld-cbor/blob/821d2db12599a4c79963e2c7988f2d77c8e19c7e/refmt.go
 
 import (
+	"reflect"
+
 	"github.com/ipfs/go-cid"
 	cbornode "github.com/ipfs/go-ipld-cbor"
 	"github.com/ipfs/go-ipld-cbor/encoding"
 	"github.com/polydawn/refmt/obj/atlas"
+
+	"github.com/daotl/go-marsha/internal/fields"
 )
 
 // This atlas describes the CBOR Tag (42) for IPLD links, such that refmt can marshal and unmarshal them
@@ -31,6 +35,7 @@ func New() *Refmt {
 
 type Refmt struct {
 	atlasEntries []*atlas.AtlasEntry
+	atlas        atlas.Atlas
 	Marshaller   encoding.PooledMarshaller
 	Unmarshaller encoding.PooledUnmarshaller
 }
@@ -38,6 +43,7 @@ type Refmt struct {
 func (r *Refmt) RebuildAlts() {
 	cborAtlas := atlas.MustBuild(r.atlasEntries...).
 		WithMapMorphism(atlas.MapMorphism{KeySortMode: atlas.KeySortMode_RFC7049})
+	r.atlas = cborAtlas
 	r.Marshaller = encoding.NewPooledMarshaller(cborAtlas)
 	r.Unmarshaller = encoding.NewPooledUnmarshaller(cborAtlas)
 }
@@ -54,6 +60,21 @@ func (r *Refmt) RegisterCborType(i interface{}) {
 	r.RebuildAlts()
 }
 
+var _ fields.Schema = (*Refmt)(nil)
+
+// Fields implements fields.Schema for the struct types registered with a struct map.
+func (r *Refmt) Fields(t reflect.Type) ([]fields.Field, bool) {
+	entry, ok := r.atlas.Get(reflect.ValueOf(t).Pointer())
+	if !ok || entry.StructMap == nil {
+		return nil, false
+	}
+	fs := make([]fields.Field, 0, len(entry.StructMap.Fields))
+	for _, f := range entry.StructMap.Fields {
+		fs = append(fs, fields.Field{Key: f.SerialName, Type: f.Type, Index: f.ReflectRoute})
+	}
+	return fs, true
+}
+
 // From: https://github.com/ipfs/go-ipld-cbor/blob/821d2db12599a4c79963e2c7988f2d77c8e19c7e/node.go
 
 func castBytesToCid(x []byte) (cid.Cid, error) {
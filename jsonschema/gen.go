@@ -0,0 +1,360 @@
+// Package jsonschema generates JSON Schema (draft 2020-12) documents describing how
+// `encoding/json` encodes Go models, so that the models exposed over JSON APIs are documented and
+// validated from the same types as their CBOR and Protocol Buffers encodings.
+package jsonschema
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/daotl/go-marsha/internal/model"
+)
+
+var (
+	ErrNotStruct   = model.ErrNotStruct
+	ErrUnsupported = model.ErrUnsupported
+)
+
+// Draft is the JSON Schema dialect of generated documents.
+const Draft = "https://json-schema.org/draft/2020-12/schema"
+
+var (
+	bigIntType        = reflect.TypeOf(big.Int{})
+	timeType          = reflect.TypeOf(time.Time{})
+	jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+// object is a JSON object, which `encoding/json` marshals with its keys sorted.
+type object = map[string]interface{}
+
+// Generator generates the definitions of Go models and of the struct types they refer to.
+//
+// A struct type becomes an object schema in `$defs` named after the type, with the properties
+// `encoding/json` marshals: exported fields named after them or their `json` struct tags, and the
+// fields of embedded structs. Properties not tagged `omitempty` are required. Pointers, slices
+// and maps may be null, `[]byte` is a base64 string, CIDs are DAG-JSON links such as
+// `{"/": "bafy..."}` and `time.Time` is a date-time string. Other types implementing
+// `json.Marshaler` are unconstrained, and those implementing `encoding.TextMarshaler` strings.
+type Generator struct {
+	defs  object
+	root  string
+	names *model.Namer
+}
+
+// NewGenerator creates a Generator.
+func NewGenerator() *Generator {
+	return &Generator{
+		defs:  object{},
+		names: model.NewNamer(model.Qualify),
+	}
+}
+
+// Add generates the definitions of the models, which are struct values or pointers to them. The
+// first model added is the root of the document.
+func (g *Generator) Add(models ...interface{}) error {
+	for _, m := range models {
+		t, err := model.Struct(m)
+		if err != nil {
+			return err
+		}
+		name, err := g.ref(t)
+		if err != nil {
+			return err
+		}
+		if g.root == "" {
+			g.root = name
+		}
+	}
+	return nil
+}
+
+// Document returns the document of the definitions generated so far, which can be marshaled by
+// `encoding/json`.
+func (g *Generator) Document() map[string]interface{} {
+	doc := object{"$schema": Draft, "$defs": g.defs}
+	if g.root != "" {
+		doc["$ref"] = "#/$defs/" + g.root
+	}
+	return doc
+}
+
+// WriteTo writes the document of the definitions generated so far to `w` as indented JSON.
+func (g *Generator) WriteTo(w io.Writer) (int64, error) {
+	return writeDocument(w, g.Document())
+}
+
+func writeDocument(w io.Writer, doc object) (int64, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		return 0, err
+	}
+	return buf.WriteTo(w)
+}
+
+// String returns the document of the definitions generated so far as indented JSON.
+func (g *Generator) String() string {
+	var sb strings.Builder
+	g.WriteTo(&sb)
+	return sb.String()
+}
+
+// WriteFile writes the document of the definitions of `models` to the file `path`.
+func WriteFile(path string, models ...interface{}) error {
+	g := NewGenerator()
+	if err := g.Add(models...); err != nil {
+		return err
+	}
+	doc := g.Document()
+	doc["$comment"] = "Code generated by github.com/daotl/go-marsha/jsonschema. DO NOT EDIT."
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if _, err := writeDocument(f, doc); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// ref returns a reference to the definition of the struct type `t`, generating it the first
+// time.
+func (g *Generator) ref(t reflect.Type) (string, error) {
+	if name, ok := g.names.Lookup(t); ok {
+		return name, nil
+	}
+	name, err := g.names.Name(t)
+	if err != nil {
+		return "", err
+	}
+	def, err := g.def(t)
+	if err != nil {
+		return "", err
+	}
+	g.defs[name] = def
+	return name, nil
+}
+
+// property is a property of the object schema of a struct.
+type property struct {
+	name     string
+	schema   object
+	optional bool
+	depth    int
+	tagged   bool
+}
+
+// def returns the definition of the struct type `t`.
+func (g *Generator) def(t reflect.Type) (object, error) {
+	props, err := g.properties(t, 0)
+	if err != nil {
+		return nil, err
+	}
+	properties := object{}
+	required := []string{}
+	for _, p := range props {
+		properties[p.name] = p.schema
+		if !p.optional {
+			required = append(required, p.name)
+		}
+	}
+	def := object{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		def["required"] = required
+	}
+	return def, nil
+}
+
+// properties returns the properties of the struct type `t` embedded `depth` levels deep,
+// resolving the conflicts between their names like `encoding/json`.
+func (g *Generator) properties(t reflect.Type, depth int) ([]property, error) {
+	var props []property
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		tag := sf.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts := tag, ""
+		if j := strings.IndexByte(tag, ','); j >= 0 {
+			name, opts = tag[:j], tag[j:]
+		}
+		ft := sf.Type
+		if sf.Anonymous {
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if name == "" && ft.Kind() == reflect.Struct {
+				embedded, err := g.properties(ft, depth+1)
+				if err != nil {
+					return nil, err
+				}
+				props = append(props, embedded...)
+				continue
+			}
+		}
+		if sf.PkgPath != "" {
+			continue
+		}
+		p := property{name: name, depth: depth, tagged: name != ""}
+		p.optional = strings.Contains(opts, ",omitempty")
+		if p.name == "" {
+			p.name = sf.Name
+		}
+		var err error
+		if strings.Contains(opts, ",string") && isScalar(sf.Type) {
+			p.schema = object{"type": "string"}
+		} else if p.schema, err = g.typ(sf.Type); err != nil {
+			return nil, fmt.Errorf("%s.%s: %w", t.Name(), sf.Name, err)
+		}
+		props = append(props, p)
+	}
+	if depth > 0 {
+		return props, nil
+	}
+
+	// The shallowest property of a name wins, then the tagged one. Others are dropped.
+	var kept []property
+	for i, p := range props {
+		dominant, ambiguous := true, false
+		for j, q := range props {
+			if q.name != p.name || i == j {
+				continue
+			}
+			if q.depth < p.depth || q.depth == p.depth && q.tagged && !p.tagged {
+				dominant = false
+			} else if q.depth == p.depth && q.tagged == p.tagged {
+				ambiguous = true
+			}
+		}
+		if dominant && !ambiguous {
+			kept = append(kept, p)
+		}
+	}
+	return kept, nil
+}
+
+func isScalar(t reflect.Type) bool {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Bool, reflect.String, reflect.Float32, reflect.Float64,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return true
+	}
+	return false
+}
+
+// nullable returns a schema matching `s` or null.
+func nullable(s object) object {
+	switch typ := s["type"].(type) {
+	case string:
+		s["type"] = []string{typ, "null"}
+		return s
+	case []string:
+		return s
+	}
+	return object{"anyOf": []object{s, {"type": "null"}}}
+}
+
+// typ returns the schema of values of `t`.
+func (g *Generator) typ(t reflect.Type) (object, error) {
+	switch t {
+	case model.CidType:
+		// Undefined CIDs are null.
+		return object{
+			"type":                 []string{"object", "null"},
+			"properties":           object{"/": object{"type": "string"}},
+			"required":             []string{"/"},
+			"additionalProperties": false,
+		}, nil
+	case bigIntType:
+		return object{"type": "integer"}, nil
+	case timeType:
+		return object{"type": "string", "format": "date-time"}, nil
+	}
+	if t.Kind() != reflect.Ptr && t.Kind() != reflect.Interface {
+		switch {
+		case t.Implements(jsonMarshalerType) || reflect.PtrTo(t).Implements(jsonMarshalerType):
+			return object{}, nil
+		case t.Implements(textMarshalerType) || reflect.PtrTo(t).Implements(textMarshalerType):
+			return object{"type": "string"}, nil
+		}
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		elem, err := g.typ(t.Elem())
+		if err != nil {
+			return nil, err
+		}
+		return nullable(elem), nil
+	case reflect.Bool:
+		return object{"type": "boolean"}, nil
+	case reflect.String:
+		return object{"type": "string"}, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return object{"type": "integer"}, nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return object{"type": "integer", "minimum": 0}, nil
+	case reflect.Float32, reflect.Float64:
+		return object{"type": "number"}, nil
+	case reflect.Interface:
+		return object{}, nil
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.Uint8 && !reflect.PtrTo(t.Elem()).Implements(jsonMarshalerType) &&
+			!reflect.PtrTo(t.Elem()).Implements(textMarshalerType) {
+			return object{"type": []string{"string", "null"}, "contentEncoding": "base64"}, nil
+		}
+		elem, err := g.typ(t.Elem())
+		if err != nil {
+			return nil, err
+		}
+		return object{"type": []string{"array", "null"}, "items": elem}, nil
+	case reflect.Array:
+		elem, err := g.typ(t.Elem())
+		if err != nil {
+			return nil, err
+		}
+		return object{"type": "array", "items": elem, "minItems": t.Len(), "maxItems": t.Len()}, nil
+	case reflect.Map:
+		s := object{"type": []string{"object", "null"}}
+		switch k := t.Key(); {
+		case k.Kind() == reflect.String:
+		case k.Implements(textMarshalerType):
+		case k.Kind() >= reflect.Int && k.Kind() <= reflect.Int64:
+			s["propertyNames"] = object{"pattern": "^-?[0-9]+$"}
+		case k.Kind() >= reflect.Uint && k.Kind() <= reflect.Uintptr:
+			s["propertyNames"] = object{"pattern": "^[0-9]+$"}
+		default:
+			return nil, fmt.Errorf("%w: map key %s", ErrUnsupported, k)
+		}
+		elem, err := g.typ(t.Elem())
+		if err != nil {
+			return nil, err
+		}
+		s["additionalProperties"] = elem
+		return s, nil
+	case reflect.Struct:
+		name, err := g.ref(t)
+		if err != nil {
+			return nil, err
+		}
+		return object{"$ref": "#/$defs/" + name}, nil
+	}
+	return nil, fmt.Errorf("%w: %s", ErrUnsupported, t)
+}
@@ -0,0 +1,134 @@
+package jsonschema_test
+
+import (
+	"encoding/json"
+	"errors"
+	"math/big"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/daotl/go-marsha/jsonschema"
+	"github.com/daotl/go-marsha/test"
+)
+
+func TestModels(t *testing.T) {
+	want, err := os.ReadFile("../test/models.schema.json")
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	require.NoError(t, jsonschema.WriteFile(dir+"/models.schema.json",
+		test.TestStruct{}, test.TestStruct2{}, test.TestLinked{},
+		test.TestStructV2{}, test.TestChildV2{}, test.TestStructV1{}, test.TestChildV1{},
+		test.TestPersonV1{}, test.TestPersonV2{}, test.TestPerson{}))
+	got, err := os.ReadFile(dir + "/models.schema.json")
+	require.NoError(t, err)
+	assert.Equal(t, string(want), string(got), "test/models.schema.json is outdated, run go generate")
+}
+
+type Base struct {
+	ID      uint64 `json:"id"`
+	Created time.Time
+	Name    string
+}
+
+type Item struct {
+	SKU string `json:"sku"`
+	Qty int    `json:"qty,omitempty"`
+}
+
+type Order struct {
+	Base
+	Name     string            `json:"name"`
+	Items    []Item            `json:"items"`
+	Tags     map[string]string `json:"tags,omitempty"`
+	Counts   map[uint16]int
+	Link     cid.Cid
+	Parent   *Item
+	Total    *big.Int
+	Amount   int64 `json:",string"`
+	Data     []byte
+	Hash     [2]byte
+	Extra    interface{}
+	Internal string `json:"-"`
+	private  bool
+}
+
+func TestTypes(t *testing.T) {
+	g := jsonschema.NewGenerator()
+	require.NoError(t, g.Add(&Order{}))
+	assert.JSONEq(t, `{
+  "$schema": "https://json-schema.org/draft/2020-12/schema",
+  "$ref": "#/$defs/Order",
+  "$defs": {
+    "Order": {
+      "type": "object",
+      "properties": {
+        "id": {"type": "integer", "minimum": 0},
+        "Created": {"type": "string", "format": "date-time"},
+        "Name": {"type": "string"},
+        "name": {"type": "string"},
+        "items": {"type": ["array", "null"], "items": {"$ref": "#/$defs/Item"}},
+        "tags": {"type": ["object", "null"], "additionalProperties": {"type": "string"}},
+        "Counts": {
+          "type": ["object", "null"],
+          "propertyNames": {"pattern": "^[0-9]+$"},
+          "additionalProperties": {"type": "integer"}
+        },
+        "Link": {
+          "type": ["object", "null"],
+          "properties": {"/": {"type": "string"}},
+          "required": ["/"],
+          "additionalProperties": false
+        },
+        "Parent": {"anyOf": [{"$ref": "#/$defs/Item"}, {"type": "null"}]},
+        "Total": {"type": ["integer", "null"]},
+        "Amount": {"type": "string"},
+        "Data": {"type": ["string", "null"], "contentEncoding": "base64"},
+        "Hash": {"type": "array", "items": {"type": "integer", "minimum": 0}, "minItems": 2, "maxItems": 2},
+        "Extra": {}
+      },
+      "required": ["id", "Created", "Name", "name", "items", "Counts", "Link", "Parent", "Total", "Amount",
+        "Data", "Hash", "Extra"]
+    },
+    "Item": {
+      "type": "object",
+      "properties": {
+        "sku": {"type": "string"},
+        "qty": {"type": "integer"}
+      },
+      "required": ["sku"]
+    }
+  }
+}`, g.String())
+
+	// The properties are those marshaled, "tags" being omitted when empty.
+	bin, err := json.Marshal(Order{})
+	require.NoError(t, err)
+	var got map[string]interface{}
+	require.NoError(t, json.Unmarshal(bin, &got))
+	props := g.Document()["$defs"].(map[string]interface{})["Order"].(map[string]interface{})["properties"]
+	for k := range got {
+		assert.Contains(t, props, k)
+	}
+	assert.Len(t, props, len(got)+1)
+}
+
+type Chan struct {
+	C chan int
+}
+
+type FloatKeys struct {
+	M map[float64]string
+}
+
+func TestErrors(t *testing.T) {
+	g := jsonschema.NewGenerator()
+	assert.True(t, errors.Is(g.Add(42), jsonschema.ErrNotStruct))
+	assert.True(t, errors.Is(g.Add(Chan{}), jsonschema.ErrUnsupported))
+	assert.True(t, errors.Is(g.Add(FloatKeys{}), jsonschema.ErrUnsupported))
+}
@@ -60,6 +60,49 @@ type Marsha interface {
 	NewDecoder(r io.Reader) Decoder
 }
 
+// AppendMarsha is implemented by Marshas which marshal into buffers provided by the caller, such
+// as the `cborgen`, `cbor_refmt` and `protobuf` implementations. Marshaling repeatedly into the
+// same buffer, such as `buf, err = m.MarshalStructAppend(buf[:0], p)`, saves allocating a slice
+// per message.
+type AppendMarsha interface {
+	Marsha
+
+	// MarshalStructAppend appends the encoding of the struct `p` points to to `dst` and returns the
+	// extended slice, or `dst` and the error if it fails, in which case the bytes after `dst` in its
+	// capacity may have been overwritten.
+	MarshalStructAppend(dst []byte, p StructPtr) ([]byte, error)
+
+	// MarshalStructSliceAppend appends the encoding of the struct slice `p` points to to `dst` as
+	// MarshalStructAppend does.
+	MarshalStructSliceAppend(dst []byte, p StructSlicePtr) ([]byte, error)
+}
+
+// MarshalStructAppend appends the encoding of the struct `p` points to by `m` to `dst`, without an
+// intermediate slice if `m` is an AppendMarsha.
+func MarshalStructAppend(m Marsha, dst []byte, p StructPtr) ([]byte, error) {
+	if am, ok := m.(AppendMarsha); ok {
+		return am.MarshalStructAppend(dst, p)
+	}
+	bin, err := m.MarshalStruct(p)
+	if err != nil {
+		return dst, err
+	}
+	return append(dst, bin...), nil
+}
+
+// MarshalStructSliceAppend appends the encoding of the struct slice `p` points to by `m` to `dst`,
+// without an intermediate slice if `m` is an AppendMarsha.
+func MarshalStructSliceAppend(m Marsha, dst []byte, p StructSlicePtr) ([]byte, error) {
+	if am, ok := m.(AppendMarsha); ok {
+		return am.MarshalStructSliceAppend(dst, p)
+	}
+	bin, err := m.MarshalStructSlice(p)
+	if err != nil {
+		return dst, err
+	}
+	return append(dst, bin...), nil
+}
+
 // An Encoder manages the transmission of type and data information to the other side of a connection.
 // It is safe for concurrent use by multiple goroutines.
 type Encoder interface {
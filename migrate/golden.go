@@ -0,0 +1,72 @@
+package migrate
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/daotl/go-marsha"
+)
+
+// Golden files hold structs marshaled by each version of a model, so backward-compatibility tests
+// can replay them through the current code after the model has changed. A test writes the golden
+// file of a version once, when the version is introduced, and commits it.
+
+// GoldenPath returns the path of the golden file of the version of `p` of model `name` in `dir`.
+func GoldenPath(dir, name string, p Versioned) string {
+	return filepath.Join(dir, fmt.Sprintf("%s.v%d.golden", name, p.ModelVersion()))
+}
+
+// WriteGolden marshals `p` with `m` and writes it to the golden file of its version of model
+// `name` in `dir`.
+func WriteGolden(m marsha.Marsha, dir, name string, p Versioned) error {
+	bin, err := m.MarshalStruct(p.Ptr())
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(GoldenPath(dir, name, p), bin, 0644)
+}
+
+// ReplayGolden unmarshals each golden file of model `name` in `dir` with `m`, from the oldest
+// version, into a value created by `newPtr` and calls `check` with the version of the file and
+// the value. It fails if there is no golden file.
+func ReplayGolden(m marsha.Marsha, dir, name string, newPtr func() marsha.StructPtr,
+	check func(version uint64, p marsha.StructPtr) error) error {
+	paths, err := filepath.Glob(filepath.Join(dir, name+".v*.golden"))
+	if err != nil {
+		return err
+	}
+	if len(paths) == 0 {
+		return fmt.Errorf("no golden file of %s in %s", name, dir)
+	}
+	versions := make(map[string]uint64, len(paths))
+	for _, path := range paths {
+		v := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(path), name+".v"), ".golden")
+		if versions[path], err = strconv.ParseUint(v, 10, 64); err != nil {
+			return fmt.Errorf("golden file %s: %w", path, err)
+		}
+	}
+	sort.Slice(paths, func(i, j int) bool { return versions[paths[i]] < versions[paths[j]] })
+
+	for _, path := range paths {
+		bin, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		p := newPtr()
+		if _, err = m.UnmarshalStruct(bin, p); err != nil {
+			return fmt.Errorf("golden file %s: %w", path, err)
+		}
+		if err = check(versions[path], p); err != nil {
+			return fmt.Errorf("golden file %s: %w", path, err)
+		}
+	}
+	return nil
+}
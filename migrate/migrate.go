@@ -0,0 +1,346 @@
+// Package migrate provides a Marsha middleware which upgrades structs stored by older versions of
+// their models to the current version when unmarshaling them.
+package migrate
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"sync"
+
+	"github.com/daotl/go-marsha"
+	"github.com/daotl/go-marsha/internal/cbor"
+	"github.com/daotl/go-marsha/internal/frame"
+)
+
+var (
+	ErrNotVersioned      = errors.New("model is not versioned")
+	ErrUnknownVersion    = errors.New("unknown model version")
+	ErrVersionConflict   = errors.New("model version already registered")
+	ErrInvalidUpgrade    = errors.New("upgrade did not produce a newer version")
+	ErrMalformedEnvelope = errors.New("malformed version envelope")
+	ErrNoVersionField    = errors.New("no leading version field")
+)
+
+// Versioned is implemented by models declaring their version, which must be at least 1.
+type Versioned interface {
+	marsha.Struct
+
+	// ModelVersion returns the version of the model, the same for all values of its type.
+	ModelVersion() uint64
+}
+
+// UpgradeFunc converts a value of an older version of a model into a value of a newer version.
+type UpgradeFunc func(old marsha.StructPtr) (marsha.StructPtr, error)
+
+// Upgrade is an older version of a model and the function upgrading its values.
+type Upgrade struct {
+	// From is an empty value of the model of the older version.
+	From Versioned
+	// Func converts a value of From's type into a value of a newer version, which is upgraded
+	// further until it reaches the current version.
+	Func UpgradeFunc
+}
+
+// From returns an Upgrade from the version of `old` with `f`.
+func From(old Versioned, f UpgradeFunc) Upgrade {
+	return Upgrade{From: old, Func: f}
+}
+
+// Mode is where the version of a stored struct is read from.
+type Mode int
+
+const (
+	// Envelope prepends the uvarint version to the bytes produced by the wrapped Marsha, or 0 if
+	// the struct is not versioned. It works with any Marsha.
+	Envelope Mode = iota
+	// LeadingField reads the version from the first element of the CBOR array a struct is encoded
+	// as, like a `Version uint64` first field of a model with `cborgen` tuple encoding, and leaves
+	// the bytes produced by the wrapped Marsha unchanged. The models must maintain the field.
+	LeadingField
+)
+
+type options struct {
+	mode Mode
+}
+
+// Option configures a Marsha.
+type Option func(*options)
+
+// WithMode sets where the version of a stored struct is read from, Envelope by default.
+func WithMode(mode Mode) Option {
+	return func(o *options) {
+		o.mode = mode
+	}
+}
+
+// history is the registered versions of a model.
+type history struct {
+	current  uint64
+	upgrades map[uint64]Upgrade
+}
+
+// Marsha is a marsha.Marsha middleware which upgrades structs to the current version of their
+// models when unmarshaling them.
+//
+// The current version of each model and upgrades from its older versions are registered with
+// Register. UnmarshalStruct and Decoder.DecodeStruct read the stored version, unmarshal the bytes
+// into the model of that version and apply the upgrades up to the current version. Primitives and
+// struct slices are passed through unchanged.
+//
+// Encoder transmits each item as a frame holding a uvarint length followed by the bytes produced
+// by the corresponding Marshal* method.
+type Marsha struct {
+	inner marsha.Marsha
+	opts  options
+
+	lk        sync.RWMutex
+	histories map[reflect.Type]*history
+}
+
+var _ marsha.Marsha = (*Marsha)(nil)
+
+// New creates a Marsha wrapping `m`.
+func New(m marsha.Marsha, opts ...Option) *Marsha {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return &Marsha{inner: m, opts: o, histories: map[reflect.Type]*history{}}
+}
+
+// Register registers `current`, an empty value of the current version of a model, along with
+// `upgrades` from its older versions.
+func (m *Marsha) Register(current Versioned, upgrades ...Upgrade) error {
+	h := &history{current: current.ModelVersion(), upgrades: map[uint64]Upgrade{}}
+	if h.current == 0 {
+		return fmt.Errorf("%w: version 0 of %T", ErrUnknownVersion, current)
+	}
+	for _, u := range upgrades {
+		v := u.From.ModelVersion()
+		if _, ok := h.upgrades[v]; ok || v >= h.current || v == 0 {
+			return fmt.Errorf("%w: %T version %d", ErrVersionConflict, u.From, v)
+		}
+		h.upgrades[v] = u
+	}
+	t := reflect.TypeOf(current)
+	m.lk.Lock()
+	defer m.lk.Unlock()
+	if _, ok := m.histories[t]; ok {
+		return fmt.Errorf("%w: %s", ErrVersionConflict, t)
+	}
+	m.histories[t] = h
+	return nil
+}
+
+// MustRegister is like Register but panics on error.
+func (m *Marsha) MustRegister(current Versioned, upgrades ...Upgrade) {
+	if err := m.Register(current, upgrades...); err != nil {
+		panic(err)
+	}
+}
+
+func (m *Marsha) MarshalPrimitive(p interface{}) ([]byte, error) {
+	return m.inner.MarshalPrimitive(p)
+}
+
+func (m *Marsha) UnmarshalPrimitive(bin []byte, p interface{}) (int, error) {
+	return m.inner.UnmarshalPrimitive(bin, p)
+}
+
+func (m *Marsha) MarshalStruct(p marsha.StructPtr) ([]byte, error) {
+	bin, err := m.inner.MarshalStruct(p)
+	if err != nil || m.opts.mode != Envelope {
+		return bin, err
+	}
+	var version uint64
+	if v, ok := p.Val().(Versioned); ok {
+		version = v.ModelVersion()
+	}
+	out := make([]byte, binary.MaxVarintLen64, binary.MaxVarintLen64+len(bin))
+	out = append(out[:binary.PutUvarint(out, version)], bin...)
+	return out, nil
+}
+
+// UnmarshalStruct unmarshals `bin` into `p`, upgrading it if it was stored by an older version of
+// the model of `p` registered with Register. It returns the count of bytes read as returned by
+// the wrapped Marsha for the stored version.
+func (m *Marsha) UnmarshalStruct(bin []byte, p marsha.StructPtr) (int, error) {
+	h := m.history(p)
+	if h == nil && m.opts.mode == LeadingField {
+		return m.inner.UnmarshalStruct(bin, p)
+	}
+	version, n, err := m.version(bin)
+	if err != nil {
+		return 0, err
+	}
+	body := bin[n:]
+	if h == nil || version == h.current {
+		read, err := m.inner.UnmarshalStruct(body, p)
+		return offset(n, read), err
+	}
+	if version == 0 {
+		return n, fmt.Errorf("%w: stored %T", ErrNotVersioned, p.Val())
+	}
+
+	u, ok := h.upgrades[version]
+	if !ok {
+		return n, fmt.Errorf("%w: %T version %d", ErrUnknownVersion, p.Val(), version)
+	}
+	old := u.From.Ptr()
+	read, err := m.inner.UnmarshalStruct(body, old)
+	if read = offset(n, read); err != nil {
+		return read, err
+	}
+	upgraded, err := h.upgrade(old)
+	if err != nil {
+		return read, err
+	}
+	pv, uv := reflect.ValueOf(p), reflect.ValueOf(upgraded)
+	if uv.Type() != pv.Type() {
+		return read, fmt.Errorf("%w: got %s, want %s", ErrInvalidUpgrade, uv.Type(), pv.Type())
+	}
+	pv.Elem().Set(uv.Elem())
+	return read, nil
+}
+
+func (m *Marsha) MarshalStructSlice(p marsha.StructSlicePtr) ([]byte, error) {
+	return m.inner.MarshalStructSlice(p)
+}
+
+func (m *Marsha) UnmarshalStructSlice(bin []byte, p marsha.StructSlicePtr) (int, error) {
+	return m.inner.UnmarshalStructSlice(bin, p)
+}
+
+func (m *Marsha) NewEncoder(w io.Writer) marsha.Encoder {
+	return &encoder{m: m, w: w}
+}
+
+func (m *Marsha) NewDecoder(r io.Reader) marsha.Decoder {
+	return &decoder{m: m, r: r}
+}
+
+// history returns the history of the model of `p`, or nil if it is not registered.
+func (m *Marsha) history(p marsha.StructPtr) *history {
+	m.lk.RLock()
+	defer m.lk.RUnlock()
+	return m.histories[reflect.TypeOf(p.Val())]
+}
+
+// version reads the stored version from `bin` and returns it along with the size of the envelope.
+func (m *Marsha) version(bin []byte) (uint64, int, error) {
+	if m.opts.mode == Envelope {
+		version, n := binary.Uvarint(bin)
+		if n <= 0 {
+			return 0, 0, ErrMalformedEnvelope
+		}
+		return version, n, nil
+	}
+	h, err := cbor.ReadHead(bin, 0)
+	if err != nil {
+		return 0, 0, err
+	}
+	if h.Major != cbor.MajArray || h.Arg == 0 && !h.Indefinite() {
+		return 0, 0, ErrNoVersionField
+	}
+	fh, err := cbor.ReadHead(bin, h.End())
+	if err != nil {
+		return 0, 0, err
+	}
+	if fh.Major != cbor.MajUnsignedInt {
+		return 0, 0, ErrNoVersionField
+	}
+	return fh.Arg, 0, nil
+}
+
+// upgrade applies upgrades to `p` until it reaches the current version.
+func (h *history) upgrade(p marsha.StructPtr) (marsha.StructPtr, error) {
+	version := p.Val().(Versioned).ModelVersion()
+	for version != h.current {
+		u, ok := h.upgrades[version]
+		if !ok {
+			return nil, fmt.Errorf("%w: version %d", ErrUnknownVersion, version)
+		}
+		next, err := u.Func(p)
+		if err != nil {
+			return nil, err
+		}
+		nv, ok := next.Val().(Versioned)
+		if !ok || nv.ModelVersion() <= version {
+			return nil, fmt.Errorf("%w: from version %d", ErrInvalidUpgrade, version)
+		}
+		p, version = next, nv.ModelVersion()
+	}
+	return p, nil
+}
+
+// offset adds the size of the envelope `n` to the count of bytes read unless it is -1.
+func offset(n, read int) int {
+	if read == -1 {
+		return -1
+	}
+	return n + read
+}
+
+type encoder struct {
+	sync.Mutex // each item must be sent atomically
+	m          *Marsha
+	w          io.Writer
+}
+
+func (e *encoder) EncodePrimitive(p interface{}) (int, error) {
+	return e.encode(e.m.MarshalPrimitive(p))
+}
+
+func (e *encoder) EncodeStruct(p marsha.StructPtr) (int, error) {
+	return e.encode(e.m.MarshalStruct(p))
+}
+
+func (e *encoder) EncodeStructSlice(p marsha.StructSlicePtr) (int, error) {
+	return e.encode(e.m.MarshalStructSlice(p))
+}
+
+func (e *encoder) encode(bin []byte, err error) (int, error) {
+	if err != nil {
+		return 0, err
+	}
+	e.Lock()
+	defer e.Unlock()
+	return frame.Write(e.w, nil, bin)
+}
+
+type decoder struct {
+	sync.Mutex // each item must be sent atomically
+	m          *Marsha
+	r          io.Reader
+}
+
+func (d *decoder) DecodePrimitive(p interface{}) (int, error) {
+	return d.decode(func(bin []byte) (int, error) { return d.m.UnmarshalPrimitive(bin, p) })
+}
+
+func (d *decoder) DecodeStruct(p marsha.StructPtr) (int, error) {
+	return d.decode(func(bin []byte) (int, error) { return d.m.UnmarshalStruct(bin, p) })
+}
+
+func (d *decoder) DecodeStructSlice(p marsha.StructSlicePtr) (int, error) {
+	return d.decode(func(bin []byte) (int, error) { return d.m.UnmarshalStructSlice(bin, p) })
+}
+
+// decode reads a frame and passes its bytes to `unmarshal`. It returns the count of bytes read
+// from the stream.
+func (d *decoder) decode(unmarshal func([]byte) (int, error)) (int, error) {
+	d.Lock()
+	defer d.Unlock()
+	fr := &frame.Reader{R: d.r}
+	bin, err := fr.ReadBody()
+	if err != nil {
+		return fr.N, err
+	}
+	if _, err = unmarshal(bin); err != nil {
+		return fr.N, err
+	}
+	return fr.N, nil
+}
@@ -0,0 +1,149 @@
+package migrate_test
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/daotl/go-marsha"
+	"github.com/daotl/go-marsha/cborgen"
+	"github.com/daotl/go-marsha/migrate"
+	"github.com/daotl/go-marsha/test"
+)
+
+var update = flag.Bool("update", false, "write the golden files of the current versions")
+
+func newMarsha(mode migrate.Mode) *migrate.Marsha {
+	mrsh := migrate.New(cborgen.New(), migrate.WithMode(mode))
+	mrsh.MustRegister(test.TestPerson{},
+		migrate.From(test.TestPersonV1{}, func(old marsha.StructPtr) (marsha.StructPtr, error) {
+			v1 := old.(*test.TestPersonV1)
+			first, last := v1.Name, ""
+			if i := strings.IndexByte(v1.Name, ' '); i >= 0 {
+				first, last = v1.Name[:i], v1.Name[i+1:]
+			}
+			return &test.TestPersonV2{Version: 2, First: first, Last: last}, nil
+		}),
+		migrate.From(test.TestPersonV2{}, func(old marsha.StructPtr) (marsha.StructPtr, error) {
+			v2 := old.(*test.TestPersonV2)
+			return &test.TestPerson{Version: 3, First: v2.First, Last: v2.Last, Age: -1}, nil
+		}),
+	)
+	return mrsh
+}
+
+var modes = []struct {
+	name string
+	mode migrate.Mode
+}{
+	{"Envelope", migrate.Envelope},
+	{"LeadingField", migrate.LeadingField},
+}
+
+func TestSuite(t *testing.T) {
+	for _, c := range modes {
+		t.Run(c.name, func(t *testing.T) {
+			test.SubTestAll(t, newMarsha(c.mode))
+		})
+	}
+}
+
+func TestUpgrade(t *testing.T) {
+	for _, c := range modes {
+		t.Run(c.name, func(t *testing.T) {
+			req := require.New(t)
+			asrt := assert.New(t)
+			mrsh := newMarsha(c.mode)
+
+			bin, err := mrsh.MarshalStruct(&test.TestPersonV1{Version: 1, Name: "Ada Lovelace"})
+			req.NoError(err)
+			p := &test.TestPerson{}
+			read, err := mrsh.UnmarshalStruct(bin, p)
+			req.NoError(err)
+			asrt.Equal(len(bin), read)
+			asrt.Equal(&test.TestPerson{Version: 3, First: "Ada", Last: "Lovelace", Age: -1}, p)
+
+			current := &test.TestPerson{Version: 3, First: "Alan", Last: "Turing", Age: 41}
+			bin, err = mrsh.MarshalStruct(current)
+			req.NoError(err)
+			p = &test.TestPerson{}
+			_, err = mrsh.UnmarshalStruct(bin, p)
+			req.NoError(err)
+			asrt.Equal(current, p)
+
+			var buf bytes.Buffer
+			_, err = mrsh.NewEncoder(&buf).EncodeStruct(&test.TestPersonV2{Version: 2, First: "Grace"})
+			req.NoError(err)
+			p = &test.TestPerson{}
+			_, err = mrsh.NewDecoder(&buf).DecodeStruct(p)
+			req.NoError(err)
+			asrt.Equal(&test.TestPerson{Version: 3, First: "Grace", Age: -1}, p)
+		})
+	}
+}
+
+func TestErrors(t *testing.T) {
+	asrt := assert.New(t)
+	mrsh := newMarsha(migrate.Envelope)
+
+	t.Run("Register error: version conflict", func(t *testing.T) {
+		err := mrsh.Register(test.TestPerson{})
+		asrt.True(errors.Is(err, migrate.ErrVersionConflict))
+	})
+
+	t.Run("UnmarshalStruct error: unknown version", func(t *testing.T) {
+		bin, err := mrsh.MarshalStruct(&test.TestPerson{Version: 3})
+		asrt.NoError(err)
+		bin[0] = 9
+		_, err = mrsh.UnmarshalStruct(bin, &test.TestPerson{})
+		asrt.True(errors.Is(err, migrate.ErrUnknownVersion))
+	})
+
+	t.Run("UnmarshalStruct error: not versioned", func(t *testing.T) {
+		bin, err := mrsh.MarshalStruct(&test.TestStruct{Data: "test"})
+		asrt.NoError(err)
+		_, err = mrsh.UnmarshalStruct(bin, &test.TestPerson{})
+		asrt.True(errors.Is(err, migrate.ErrNotVersioned))
+	})
+}
+
+// TestGolden replays the golden files of every version of TestPerson through the current code.
+// Run with -update to write the golden files of the versions whose models are in `versions`.
+func TestGolden(t *testing.T) {
+	versions := []migrate.Versioned{
+		test.TestPersonV1{Version: 1, Name: "Ada Lovelace"},
+		test.TestPersonV2{Version: 2, First: "Ada", Last: "Lovelace"},
+		test.TestPerson{Version: 3, First: "Ada", Last: "Lovelace", Age: 36},
+	}
+	for _, c := range modes {
+		t.Run(c.name, func(t *testing.T) {
+			mrsh := newMarsha(c.mode)
+			dir := filepath.Join("testdata", strings.ToLower(c.name))
+			if *update {
+				for _, v := range versions {
+					require.NoError(t, migrate.WriteGolden(mrsh, dir, "person", v))
+				}
+			}
+			err := migrate.ReplayGolden(mrsh, dir, "person",
+				func() marsha.StructPtr { return &test.TestPerson{} },
+				func(version uint64, p marsha.StructPtr) error {
+					want := &test.TestPerson{Version: 3, First: "Ada", Last: "Lovelace", Age: -1}
+					if version == 3 {
+						want.Age = 36
+					}
+					if got := p.(*test.TestPerson); *got != *want {
+						return fmt.Errorf("got %+v, want %+v", got, want)
+					}
+					return nil
+				})
+			assert.NoError(t, err)
+		})
+	}
+}
@@ -0,0 +1,83 @@
+package marsha
+
+// Options holds the configuration shared by Marsha implementations.
+// Implementations accept it as a variadic list of Option in their constructors:
+//
+//	mrsh := cborgen.New(marsha.WithRegistry(reg))
+type Options struct {
+	// Registry resolves the concrete types of Union values. Marshaling or unmarshaling a Union
+	// fails with ErrNoRegistry if it is nil.
+	Registry *Registry
+
+	// Deterministic makes marshaling produce byte-identical output for equal values, suitable for
+	// hashing and signing. CBOR implementations produce core deterministic encoding as defined by
+	// RFC 8949 section 4.2.1.
+	Deterministic bool
+
+	// Strict makes unmarshaling reject malleable input, failing with an error which reports the
+	// offset of the first offending part of the input and wraps:
+	//   - ErrNotCanonical if the input is not in the form produced with Deterministic, which rules
+	//     out duplicate map keys and integers not encoded in the fewest bytes possible,
+	//   - ErrTrailingData if there are bytes after the data item,
+	//   - ErrUnknownField if a field doesn't exist in the type unmarshaled into.
+	// CBOR implementations also marshal as with Deterministic, so they accept their own output.
+	Strict bool
+
+	// UnknownPolicy is how unmarshaling handles unknown fields if not Strict.
+	UnknownPolicy UnknownPolicy
+
+	// ZeroCopy lets unmarshaling from byte slices decode byte slices and strings without copying
+	// them: the values unmarshaled share memory with the input, so the caller must not modify the
+	// input for as long as they are used, and keeps all of it alive by keeping any of them. Byte
+	// slices unmarshaled have their capacity limited to their length, so appending to them copies
+	// them rather than overwriting the input. Implementations which always copy ignore it.
+	ZeroCopy bool
+}
+
+// Option configures Options.
+type Option func(*Options)
+
+// NewOptions returns Options with all `opts` applied.
+func NewOptions(opts ...Option) Options {
+	var o Options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// WithRegistry sets the Registry used to resolve the concrete types of Union values.
+func WithRegistry(r *Registry) Option {
+	return func(o *Options) {
+		o.Registry = r
+	}
+}
+
+// WithDeterministic makes marshaling deterministic.
+func WithDeterministic() Option {
+	return func(o *Options) {
+		o.Deterministic = true
+	}
+}
+
+// WithStrict makes unmarshaling strict.
+func WithStrict() Option {
+	return func(o *Options) {
+		o.Strict = true
+	}
+}
+
+// WithUnknownPolicy sets how unmarshaling handles unknown fields.
+func WithUnknownPolicy(p UnknownPolicy) Option {
+	return func(o *Options) {
+		o.UnknownPolicy = p
+	}
+}
+
+// WithZeroCopy makes unmarshaling from byte slices share memory with the input rather than copy
+// byte slices and strings. See Options.ZeroCopy for the guarantees the caller must give.
+func WithZeroCopy() Option {
+	return func(o *Options) {
+		o.ZeroCopy = true
+	}
+}
@@ -0,0 +1,198 @@
+package marsha
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/daotl/go-marsha/internal/cbor"
+	"github.com/daotl/go-marsha/internal/pointer"
+)
+
+// Op is the operation of a PatchOp.
+type Op int
+
+const (
+	// OpAdd inserts an element into an array before the element at the index, or at its end for
+	// the index "-" or the count of elements, or sets the value of an entry of a map.
+	OpAdd Op = iota
+	// OpRemove removes an element of an array or an entry of a map.
+	OpRemove
+	// OpReplace replaces an existing item.
+	OpReplace
+)
+
+var opNames = [...]string{"add", "remove", "replace"}
+
+func (o Op) String() string {
+	if o < 0 || int(o) >= len(opNames) {
+		return "invalid"
+	}
+	return opNames[o]
+}
+
+// PatchOp is an operation of Patch, like an operation of a JSON Patch (RFC 6902).
+type PatchOp struct {
+	Op Op
+	// Path is the path of the item, written as for GetRaw.
+	Path string
+	// Value is the item added or replacing, unless Raw is set.
+	Value Value
+	// Raw, if not nil, is the CBOR data item added or replacing, such as a marshaled struct.
+	Raw []byte
+}
+
+// Patch applies the operations `ops` in order to the CBOR data item `bin` and returns the patched
+// copy. Only the affected items and the heads of their arrays or maps are rewritten, so the rest
+// of the data is kept byte for byte without being decoded.
+//
+// Values are written in CBOR core deterministic encoding and entries added to maps are inserted
+// in the order of their keys, so canonical data stays canonical. New keys are text strings, or
+// integers if the map starts with an integer key and the token is one. Errors of operations report
+// offsets in the data patched by the operations before them.
+func Patch(bin []byte, ops ...PatchOp) ([]byte, error) {
+	for i, op := range ops {
+		var err error
+		if bin, err = patch(bin, op); err != nil {
+			return nil, fmt.Errorf("op %d (%s %s): %w", i, op.Op, op.Path, err)
+		}
+	}
+	return bin, nil
+}
+
+func patch(b []byte, op PatchOp) ([]byte, error) {
+	toks, err := pointer.Split(op.Path)
+	if err != nil {
+		return nil, err
+	}
+	var item []byte
+	switch op.Op {
+	case OpAdd, OpReplace:
+		if item, err = patchItem(op); err != nil {
+			return nil, err
+		}
+	case OpRemove:
+		if len(toks) == 0 {
+			return nil, fmt.Errorf("%w: the top-level item can't be removed", ErrInvalidPath)
+		}
+	default:
+		return nil, fmt.Errorf("invalid op %d", op.Op)
+	}
+	if len(toks) == 0 {
+		if _, err := cbor.Skip(b, 0); err != nil {
+			return nil, err
+		}
+		return item, nil
+	}
+
+	last := len(toks) - 1
+	off, err := walk(b, toks[:last])
+	if err != nil {
+		return nil, err
+	}
+	h, err := container(b, off)
+	if err != nil {
+		return nil, err
+	}
+	switch h.Major {
+	case cbor.MajArray:
+		off, err := element(b, h, toks, last, op.Op == OpAdd)
+		if err != nil {
+			return nil, err
+		}
+		if op.Op == OpAdd {
+			return resize(splice(b, off, off, item), h, 1), nil
+		}
+		end, err := cbor.Skip(b, off)
+		if err != nil {
+			return nil, err
+		}
+		if op.Op == OpRemove {
+			return resize(splice(b, off, end, nil), h, -1), nil
+		}
+		return splice(b, off, end, item), nil
+	case cbor.MajMap:
+		kOff, vOff, err := entry(b, h, toks, last)
+		if errors.Is(err, ErrPathNotFound) && op.Op == OpAdd {
+			return addEntry(b, h, toks[last], item)
+		}
+		if err != nil {
+			return nil, err
+		}
+		end, err := cbor.Skip(b, vOff)
+		if err != nil {
+			return nil, err
+		}
+		if op.Op == OpRemove {
+			return resize(splice(b, kOff, end, nil), h, -1), nil
+		}
+		return splice(b, vOff, end, item), nil
+	}
+	return nil, pointer.NotFound(toks, len(toks), h.Offset, "not an array or a map")
+}
+
+// patchItem returns the item written by `op`.
+func patchItem(op PatchOp) ([]byte, error) {
+	if op.Raw == nil {
+		return EncodeCBORValue(op.Value)
+	}
+	end, err := cbor.Skip(op.Raw, 0)
+	if err != nil {
+		return nil, err
+	}
+	if end != len(op.Raw) {
+		return nil, fmt.Errorf("%w at offset %d", ErrTrailingData, end)
+	}
+	return op.Raw, nil
+}
+
+// addEntry inserts the entry of key `tok` and value `item` into the map of head `h` before the
+// first entry with a greater key.
+func addEntry(b []byte, h cbor.Head, tok string, item []byte) ([]byte, error) {
+	key := NewString(tok)
+	if first, err := cbor.ReadHead(b, h.End()); err == nil &&
+		(first.Major == cbor.MajUnsignedInt || first.Major == cbor.MajNegativeInt) {
+		if n, ok := new(big.Int).SetString(tok, 10); ok {
+			key = Value{Kind: KindInt, Int: n}
+		}
+	}
+	k, err := EncodeCBORValue(key)
+	if err != nil {
+		return nil, err
+	}
+	off := h.End()
+	for j := uint64(0); h.Indefinite() || j < h.Arg; j++ {
+		if h.Indefinite() && off < len(b) && b[off] == cbor.Break {
+			break
+		}
+		vOff, err := cbor.Skip(b, off)
+		if err != nil {
+			return nil, err
+		}
+		if bytes.Compare(b[off:vOff], k) > 0 {
+			break
+		}
+		if off, err = cbor.Skip(b, vOff); err != nil {
+			return nil, err
+		}
+	}
+	return resize(splice(b, off, off, append(k, item...)), h, 1), nil
+}
+
+// splice returns a copy of `b` with the bytes from `from` to `to` replaced by `repl`.
+func splice(b []byte, from, to int, repl []byte) []byte {
+	out := make([]byte, 0, len(b)-(to-from)+len(repl))
+	out = append(out, b[:from]...)
+	out = append(out, repl...)
+	return append(out, b[to:]...)
+}
+
+// resize rewrites the head `h` of an array or a map in `b` to count `delta` more items, unless its
+// length is indefinite.
+func resize(b []byte, h cbor.Head, delta int) []byte {
+	if h.Indefinite() {
+		return b
+	}
+	return splice(b, h.Offset, h.End(), cbor.AppendHead(nil, h.Major, h.Arg+uint64(delta)))
+}
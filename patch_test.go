@@ -0,0 +1,89 @@
+package marsha_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/daotl/go-marsha"
+	"github.com/daotl/go-marsha/cbordiag"
+)
+
+func TestPatch(t *testing.T) {
+	bin := cbordiag.MustParse(`{"a": 1, "items": [{"name": "x"}, {"name": "y"}], "zz": 1.0}`)
+
+	for _, tc := range []struct {
+		name string
+		op   marsha.PatchOp
+		want string
+	}{
+		{"replace", marsha.PatchOp{Op: marsha.OpReplace, Path: "/items/1/name", Value: marsha.NewString("yy")},
+			`{"a": 1, "items": [{"name": "x"}, {"name": "yy"}], "zz": 1.0}`},
+		{"replace top-level", marsha.PatchOp{Op: marsha.OpReplace, Value: marsha.NewArray()}, `[]`},
+		{"replace raw", marsha.PatchOp{Op: marsha.OpReplace, Path: "/a", Raw: cbordiag.MustParse(`[1_0]`)},
+			`{"a": [1_0], "items": [{"name": "x"}, {"name": "y"}], "zz": 1.0}`},
+		{"add element", marsha.PatchOp{Op: marsha.OpAdd, Path: "/items/0", Value: marsha.Value{}},
+			`{"a": 1, "items": [null, {"name": "x"}, {"name": "y"}], "zz": 1.0}`},
+		{"append element", marsha.PatchOp{Op: marsha.OpAdd, Path: "/items/-", Value: marsha.NewInt(1)},
+			`{"a": 1, "items": [{"name": "x"}, {"name": "y"}, 1], "zz": 1.0}`},
+		{"append element by count", marsha.PatchOp{Op: marsha.OpAdd, Path: "/items/2", Value: marsha.NewInt(1)},
+			`{"a": 1, "items": [{"name": "x"}, {"name": "y"}, 1], "zz": 1.0}`},
+		{"add entry in order", marsha.PatchOp{Op: marsha.OpAdd, Path: "/b", Value: marsha.NewInt(2)},
+			`{"a": 1, "b": 2, "items": [{"name": "x"}, {"name": "y"}], "zz": 1.0}`},
+		{"add existing entry", marsha.PatchOp{Op: marsha.OpAdd, Path: "/a", Value: marsha.NewInt(2)},
+			`{"a": 2, "items": [{"name": "x"}, {"name": "y"}], "zz": 1.0}`},
+		{"remove element", marsha.PatchOp{Op: marsha.OpRemove, Path: "/items/0"},
+			`{"a": 1, "items": [{"name": "y"}], "zz": 1.0}`},
+		{"remove entry", marsha.PatchOp{Op: marsha.OpRemove, Path: "/items"},
+			`{"a": 1, "zz": 1.0}`},
+	} {
+		out, err := marsha.Patch(bin, tc.op)
+		require.NoError(t, err, tc.name)
+		assert.Equal(t, cbordiag.MustParse(tc.want), out, tc.name)
+	}
+
+	// Heads grow and shrink with their counts, and indefinite lengths are kept.
+	out, err := marsha.Patch(cbordiag.MustParse(`[0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18, 19, 20, 21, 22]`),
+		marsha.PatchOp{Op: marsha.OpAdd, Path: "/-", Value: marsha.NewInt(23)})
+	require.NoError(t, err)
+	assert.Equal(t, cbordiag.MustParse(`[0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18, 19, 20, 21, 22, 23]`), out)
+	out, err = marsha.Patch(out, marsha.PatchOp{Op: marsha.OpRemove, Path: "/0"})
+	require.NoError(t, err)
+	assert.Equal(t, cbordiag.MustParse(`[1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18, 19, 20, 21, 22, 23]`), out)
+	out, err = marsha.Patch(cbordiag.MustParse(`{_ 1: [_ ], 3: 0}`),
+		marsha.PatchOp{Op: marsha.OpAdd, Path: "/2", Value: marsha.NewInt(0)},
+		marsha.PatchOp{Op: marsha.OpAdd, Path: "/1/-", Value: marsha.NewInt(0)})
+	require.NoError(t, err)
+	assert.Equal(t, cbordiag.MustParse(`{_ 1: [_ 0], 2: 0, 3: 0}`), out)
+
+	// Canonical data stays canonical.
+	out, err = marsha.Patch(bin,
+		marsha.PatchOp{Op: marsha.OpAdd, Path: "/c", Value: marsha.NewFloat(1.5)},
+		marsha.PatchOp{Op: marsha.OpAdd, Path: "/items/0/id", Value: marsha.NewInt(7)},
+		marsha.PatchOp{Op: marsha.OpRemove, Path: "/zz"})
+	require.NoError(t, err)
+	assert.NoError(t, marsha.CheckCanonical(out))
+	assert.Equal(t, cbordiag.MustParse(`{"a": 1, "c": 1.5, "items": [{"id": 7, "name": "x"}, {"name": "y"}]}`), out)
+
+	for _, tc := range []struct {
+		op   marsha.PatchOp
+		want error
+	}{
+		{marsha.PatchOp{Op: marsha.OpReplace, Path: "/b"}, marsha.ErrPathNotFound},
+		{marsha.PatchOp{Op: marsha.OpRemove, Path: "/items/2"}, marsha.ErrPathNotFound},
+		{marsha.PatchOp{Op: marsha.OpReplace, Path: "/items/-"}, marsha.ErrPathNotFound},
+		{marsha.PatchOp{Op: marsha.OpAdd, Path: "/items/3"}, marsha.ErrPathNotFound},
+		{marsha.PatchOp{Op: marsha.OpAdd, Path: "/a/b"}, marsha.ErrPathNotFound},
+		{marsha.PatchOp{Op: marsha.OpRemove}, marsha.ErrInvalidPath},
+		{marsha.PatchOp{Op: marsha.OpAdd, Path: "/x", Raw: []byte{1, 2}}, marsha.ErrTrailingData},
+		{marsha.PatchOp{Op: marsha.OpAdd, Path: "/x", Value: marsha.Value{Kind: marsha.KindInt}}, marsha.ErrValueKind},
+	} {
+		_, err := marsha.Patch(bin, tc.op)
+		assert.True(t, errors.Is(err, tc.want), "%s %s: %v", tc.op.Op, tc.op.Path, err)
+	}
+	_, err = marsha.Patch(bin, marsha.PatchOp{Op: marsha.OpRemove, Path: "/items/0"},
+		marsha.PatchOp{Op: marsha.OpRemove, Path: "/items/1"})
+	assert.EqualError(t, err, "op 1 (remove /items/1): path not found: /items/1 at offset 10: index 1 out of range")
+}
@@ -3,11 +3,17 @@
 package protobuf
 
 import (
+	"bytes"
 	"errors"
+	"fmt"
 	"io"
 	"runtime"
+	"strconv"
 
+	"google.golang.org/protobuf/encoding/protowire"
 	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/known/anypb"
 
 	"github.com/daotl/go-marsha"
 )
@@ -39,13 +45,30 @@ type StructPtr interface {
 // pre-generated by `protoc`.
 // Only `MarshalStruct` and `UnmarshalStruct` are supported by this implementation because of the
 // limitation of Protocol Buffers.
-type Marsha struct{}
+//
+// A marsha.Union is marshaled as a `google.protobuf.Any` whose type URL is the one registered for
+// the value's type in the marsha.Registry passed by marsha.WithRegistry, or derived from the full
+// name of its `proto.Message` if none is registered.
+//
+// With marsha.WithDeterministic, messages are marshaled with `proto.MarshalOptions.Deterministic`,
+// which orders map entries by key. Protocol Buffers has no canonical encoding, so with
+// marsha.WithStrict, UnmarshalStruct instead rejects input which differs from the deterministic
+// re-marshaling of the message it decodes to, as well as input with unknown fields, and messages
+// are marshaled deterministically too so that they can be unmarshaled again.
+//
+// With marsha.UnknownDefault and marsha.UnknownPreserve, unknown fields are preserved in the
+// `proto.Message` loaded by LoadPB, so they are restored if PB returns it again.
+type Marsha struct {
+	opts marsha.Options
+}
 
-var _ marsha.Marsha = (*Marsha)(nil)
+var _ marsha.AppendMarsha = (*Marsha)(nil)
 
 // New creates a Marsha.
-func New() *Marsha {
-	return &Marsha{}
+func New(opts ...marsha.Option) *Marsha {
+	return &Marsha{
+		opts: marsha.NewOptions(opts...),
+	}
 }
 
 // Not implemented
@@ -59,11 +82,35 @@ func (m *Marsha) UnmarshalPrimitive(_ []byte, _ interface{}) (int, error) {
 }
 
 func (m *Marsha) MarshalStruct(p marsha.StructPtr) ([]byte, error) {
+	if u, ok := p.(*marsha.Union); ok {
+		return m.marshalUnion(u)
+	}
 	pbp, ok := p.(StructPtr)
 	if !ok {
 		return nil, ErrNotPBStructPtr
 	}
-	return proto.Marshal(pbp.PB())
+	return m.marshalOptions().Marshal(pbp.PB())
+}
+
+// MarshalStructAppend appends the encoding of the struct `p` points to to `dst` with
+// `proto.MarshalOptions.MarshalAppend`.
+func (m *Marsha) MarshalStructAppend(dst []byte, p marsha.StructPtr) ([]byte, error) {
+	if u, ok := p.(*marsha.Union); ok {
+		bin, err := m.marshalUnion(u)
+		if err != nil {
+			return dst, err
+		}
+		return append(dst, bin...), nil
+	}
+	pbp, ok := p.(StructPtr)
+	if !ok {
+		return dst, ErrNotPBStructPtr
+	}
+	b, err := m.marshalOptions().MarshalAppend(dst, pbp.PB())
+	if err != nil {
+		return dst, err
+	}
+	return b, nil
 }
 
 func (m *Marsha) UnmarshalStruct(bin []byte, p marsha.StructPtr) (read int, err error) {
@@ -83,16 +130,18 @@ func (m *Marsha) UnmarshalStruct(bin []byte, p marsha.StructPtr) (read int, err
 		}
 	}()
 
+	if u, ok := p.(*marsha.Union); ok {
+		return -1, m.unmarshalUnion(bin, u)
+	}
 	pbp, ok := p.(StructPtr)
 	if !ok {
 		return -1, ErrNotPBStructPtr
 	}
 	pb := pbp.EmptyPB()
-	err = proto.Unmarshal(bin, pb)
-	if err == nil {
+	if err = m.unmarshal(bin, pb); err == nil {
 		err = pbp.LoadPB(pb)
 	}
-	return -1, nil
+	return -1, err
 }
 
 // Not implemented
@@ -100,6 +149,11 @@ func (m *Marsha) MarshalStructSlice(_ marsha.StructSlicePtr) ([]byte, error) {
 	return nil, marsha.ErrUnimplemented
 }
 
+// Not implemented
+func (m *Marsha) MarshalStructSliceAppend(dst []byte, _ marsha.StructSlicePtr) ([]byte, error) {
+	return dst, marsha.ErrUnimplemented
+}
+
 // Not implemented
 func (m *Marsha) UnmarshalStructSlice(_ []byte, _ marsha.StructSlicePtr) (int, error) {
 	return -1, marsha.ErrUnimplemented
@@ -114,3 +168,134 @@ func (m *Marsha) NewEncoder(_ io.Writer) marsha.Encoder {
 func (m *Marsha) NewDecoder(_ io.Reader) marsha.Decoder {
 	panic(marsha.ErrUnimplemented)
 }
+
+func (m *Marsha) marshalUnion(u *marsha.Union) ([]byte, error) {
+	if m.opts.Registry == nil {
+		return nil, marsha.ErrNoRegistry
+	}
+	if u.Value == nil {
+		return nil, marsha.ErrEmptyUnion
+	}
+	ti, ok := m.opts.Registry.Lookup(u.Value)
+	if !ok {
+		return nil, fmt.Errorf("%w: %T", marsha.ErrNotRegistered, u.Value)
+	}
+	pbp, ok := u.Value.(StructPtr)
+	if !ok {
+		return nil, ErrNotPBStructPtr
+	}
+	pb := pbp.PB()
+	mo := m.marshalOptions()
+	bin, err := mo.Marshal(pb)
+	if err != nil {
+		return nil, err
+	}
+	return mo.Marshal(&anypb.Any{TypeUrl: typeURL(ti, pb), Value: bin})
+}
+
+func (m *Marsha) unmarshalUnion(bin []byte, u *marsha.Union) error {
+	if m.opts.Registry == nil {
+		return marsha.ErrNoRegistry
+	}
+	a := &anypb.Any{}
+	if err := m.unmarshal(bin, a); err != nil {
+		return err
+	}
+	ti, ok := m.opts.Registry.ByTypeURL(a.TypeUrl)
+	if !ok {
+		for _, t := range m.opts.Registry.Types() {
+			if pbp, ok := t.New().(StructPtr); ok && typeURL(t, pbp.EmptyPB()) == a.TypeUrl {
+				ti = t
+				break
+			}
+		}
+		if ti == nil {
+			return fmt.Errorf("%w: type URL %q", marsha.ErrNotRegistered, a.TypeUrl)
+		}
+	}
+	v := ti.New()
+	if _, err := m.UnmarshalStruct(a.Value, v); err != nil {
+		return err
+	}
+	u.Value = v
+	return nil
+}
+
+func (m *Marsha) marshalOptions() proto.MarshalOptions {
+	return proto.MarshalOptions{Deterministic: m.opts.Deterministic || m.opts.Strict}
+}
+
+// unmarshal unmarshals `bin` into `pb` according to the unknown-field policy, checking it against
+// the deterministic re-marshaling of `pb` if unmarshaling is strict.
+func (m *Marsha) unmarshal(bin []byte, pb proto.Message) error {
+	policy := m.opts.UnknownPolicy
+	if m.opts.Strict {
+		policy = marsha.UnknownError
+	}
+	uo := proto.UnmarshalOptions{DiscardUnknown: policy == marsha.UnknownIgnore}
+	if err := uo.Unmarshal(bin, pb); err != nil {
+		return err
+	}
+	if policy == marsha.UnknownError {
+		if err := checkFields(bin, pb.ProtoReflect().Descriptor(), 0, ""); err != nil {
+			return err
+		}
+	}
+	if !m.opts.Strict {
+		return nil
+	}
+	re, err := proto.MarshalOptions{Deterministic: true}.Marshal(pb)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(re, bin) {
+		return fmt.Errorf("%w: protobuf message differs from its deterministic encoding",
+			marsha.ErrNotCanonical)
+	}
+	return nil
+}
+
+// checkFields returns a *marsha.StrictError for the first field encoded in `b` which is not
+// declared by `md`, recursing into message fields. `off` and `path` are the offset and path of `b`.
+func checkFields(b []byte, md protoreflect.MessageDescriptor, off int, path string) error {
+	counts := map[protowire.Number]int{}
+	for i := 0; i < len(b); {
+		num, typ, n := protowire.ConsumeTag(b[i:])
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		fd := md.Fields().ByNumber(num)
+		if fd == nil {
+			return &marsha.StrictError{
+				Offset: off + i,
+				Path:   path + "/" + strconv.Itoa(int(num)),
+				Err:    marsha.ErrUnknownField,
+			}
+		}
+		l := protowire.ConsumeFieldValue(num, typ, b[i+n:])
+		if l < 0 {
+			return protowire.ParseError(l)
+		}
+		if fd.Message() != nil && typ == protowire.BytesType {
+			fPath := path + "/" + string(fd.Name())
+			if fd.IsList() || fd.IsMap() {
+				fPath += "/" + strconv.Itoa(counts[num])
+				counts[num]++
+			}
+			v, _ := protowire.ConsumeBytes(b[i+n:])
+			if err := checkFields(v, fd.Message(), off+i+n+l-len(v), fPath); err != nil {
+				return err
+			}
+		}
+		i += n + l
+	}
+	return nil
+}
+
+// typeURL returns the type URL registered in `ti` or the default one derived from `pb`.
+func typeURL(ti *marsha.TypeInfo, pb proto.Message) string {
+	if ti.TypeURL != "" {
+		return ti.TypeURL
+	}
+	return "type.googleapis.com/" + string(pb.ProtoReflect().Descriptor().FullName())
+}
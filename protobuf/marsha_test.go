@@ -1,10 +1,13 @@
 package protobuf_test
 
 import (
+	"errors"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/structpb"
 
 	"github.com/daotl/go-marsha"
 	"github.com/daotl/go-marsha/protobuf"
@@ -65,6 +68,34 @@ func (s *TestStruct2) PB() proto.Message {
 	return s.Test2
 }
 
+// TestStruct3 is like TestStruct2 but returns the right type from EmptyPB.
+type TestStruct3 struct {
+	*protobuf.Test2
+	Data2 int32
+}
+
+func (s TestStruct3) Ptr() marsha.StructPtr { return &s }
+func (s *TestStruct3) Val() marsha.Struct   { return *s }
+
+func (s *TestStruct3) EmptyPB() proto.Message {
+	return &protobuf.Test2{}
+}
+
+func (s *TestStruct3) LoadPB(pb proto.Message) error {
+	tpb := pb.(*protobuf.Test2)
+	s.Test2 = tpb
+	s.Data2 = tpb.Data2
+	return nil
+}
+
+func (s *TestStruct3) PB() proto.Message {
+	if s.Test2 == nil {
+		s.Test2 = &protobuf.Test2{}
+	}
+	s.Test2.Data2 = s.Data2
+	return s.Test2
+}
+
 func TestPBMarshaler(t *testing.T) {
 	asrt := assert.New(t)
 	mrsh := protobuf.New()
@@ -89,3 +120,164 @@ func TestPBMarshaler(t *testing.T) {
 		asrt.Equal(0, read)
 	})
 }
+
+func TestAppend(t *testing.T) {
+	asrt := assert.New(t)
+	mrsh := protobuf.New()
+	s := &TestStruct{&protobuf.Test{}, "test"}
+	want, err := mrsh.MarshalStruct(s)
+	asrt.NoError(err)
+
+	bin, err := mrsh.MarshalStructAppend([]byte{0xca, 0xfe}, s)
+	asrt.NoError(err)
+	asrt.Equal(append([]byte{0xca, 0xfe}, want...), bin)
+
+	buf := make([]byte, 0, 64)
+	allocs := testing.AllocsPerRun(100, func() {
+		buf, err = mrsh.MarshalStructAppend(buf[:0], s)
+	})
+	asrt.NoError(err)
+	asrt.Equal(want, buf)
+	asrt.Zero(allocs)
+
+	_, err = mrsh.MarshalStructSliceAppend(nil, nil)
+	asrt.Equal(marsha.ErrUnimplemented, err)
+}
+
+func TestUnion(t *testing.T) {
+	asrt := assert.New(t)
+	reg := marsha.NewRegistry()
+	reg.MustRegister(TestStruct{}, marsha.Tag{TypeURL: "type.example.com/test"})
+	reg.MustRegister(TestStruct3{}, marsha.Tag{Name: "test3"})
+	mrsh := protobuf.New(marsha.WithRegistry(reg))
+
+	for _, u := range []*marsha.Union{
+		{Value: &TestStruct{&protobuf.Test{}, "test"}},
+		{Value: &TestStruct3{&protobuf.Test2{}, 42}},
+	} {
+		bin, err := mrsh.MarshalStruct(u)
+		asrt.NoError(err)
+		u2 := &marsha.Union{}
+		_, err = mrsh.UnmarshalStruct(bin, u2)
+		asrt.NoError(err)
+		asrt.IsType(u.Value, u2.Value)
+		asrt.True(proto.Equal(u.Value.(protobuf.StructPtr).PB(), u2.Value.(protobuf.StructPtr).PB()))
+	}
+
+	t.Run("Error: type not registered", func(t *testing.T) {
+		_, err := mrsh.MarshalStruct(&marsha.Union{Value: &TestStruct2{}})
+		asrt.True(errors.Is(err, marsha.ErrNotRegistered))
+	})
+}
+
+func TestDeterministic(t *testing.T) {
+	asrt := assert.New(t)
+	mrsh := protobuf.New(marsha.WithDeterministic(), marsha.WithStrict())
+	s := &TestStruct{&protobuf.Test{}, "test"}
+
+	bin, err := mrsh.MarshalStruct(s)
+	asrt.NoError(err)
+	n := &TestStruct{}
+	_, err = mrsh.UnmarshalStruct(bin, n)
+	asrt.NoError(err)
+	asrt.Equal(s.Data, n.Data)
+
+	t.Run("Error: not canonical", func(t *testing.T) {
+		// A repeated singular field is valid protobuf, the last one wins.
+		_, err := mrsh.UnmarshalStruct(append(append([]byte{}, bin...), bin...), &TestStruct{})
+		asrt.True(errors.Is(err, marsha.ErrNotCanonical))
+	})
+}
+
+func TestStrict(t *testing.T) {
+	asrt := assert.New(t)
+	mrsh := protobuf.New(marsha.WithStrict())
+	bin, err := mrsh.MarshalStruct(&TestStruct{&protobuf.Test{}, "test"})
+	asrt.NoError(err)
+
+	t.Run("Error: unknown field", func(t *testing.T) {
+		// Field 15 as varint 1
+		_, err := mrsh.UnmarshalStruct(append(append([]byte{}, bin...), 0x78, 0x01), &TestStruct{})
+		var serr *marsha.StrictError
+		asrt.True(errors.As(err, &serr))
+		asrt.Equal(marsha.ErrUnknownField, serr.Err)
+		asrt.Equal(len(bin), serr.Offset)
+		asrt.Equal("/15", serr.Path)
+	})
+}
+
+func TestUnknown(t *testing.T) {
+	asrt := assert.New(t)
+	bin, err := protobuf.New().MarshalStruct(&TestStruct{&protobuf.Test{}, "test"})
+	asrt.NoError(err)
+	// Field 15 as varint 1
+	bin = append(bin, 0x78, 0x01)
+
+	for _, c := range []struct {
+		policy   marsha.UnknownPolicy
+		restored bool
+	}{
+		{marsha.UnknownDefault, true},
+		{marsha.UnknownPreserve, true},
+		{marsha.UnknownIgnore, false},
+	} {
+		mrsh := protobuf.New(marsha.WithUnknownPolicy(c.policy))
+		s := &TestStruct{}
+		_, err := mrsh.UnmarshalStruct(bin, s)
+		asrt.NoError(err)
+		bin2, err := mrsh.MarshalStruct(s)
+		asrt.NoError(err)
+		asrt.Equal(c.restored, len(bin2) == len(bin))
+	}
+
+	t.Run("Error: unknown field", func(t *testing.T) {
+		mrsh := protobuf.New(marsha.WithUnknownPolicy(marsha.UnknownError))
+		_, err := mrsh.UnmarshalStruct(bin, &TestStruct{})
+		asrt.True(errors.Is(err, marsha.ErrUnknownField))
+	})
+}
+
+// MapStruct is marshaled as a `google.protobuf.Struct`, whose fields are a map.
+type MapStruct struct {
+	Fields map[string]string
+}
+
+func (s MapStruct) Ptr() marsha.StructPtr { return &s }
+func (s *MapStruct) Val() marsha.Struct   { return *s }
+
+func (s *MapStruct) EmptyPB() proto.Message {
+	return &structpb.Struct{}
+}
+
+func (s *MapStruct) LoadPB(pb proto.Message) error {
+	s.Fields = map[string]string{}
+	for k, v := range pb.(*structpb.Struct).Fields {
+		s.Fields[k] = v.GetStringValue()
+	}
+	return nil
+}
+
+func (s *MapStruct) PB() proto.Message {
+	pb := &structpb.Struct{Fields: map[string]*structpb.Value{}}
+	for k, v := range s.Fields {
+		pb.Fields[k] = structpb.NewStringValue(v)
+	}
+	return pb
+}
+
+func TestStrictMap(t *testing.T) {
+	// Map entries are marshaled in random order unless marshaling is deterministic.
+	mrsh := protobuf.New(marsha.WithStrict())
+	s := &MapStruct{Fields: map[string]string{}}
+	for _, k := range []string{"a", "b", "c", "d", "e", "f", "g", "h"} {
+		s.Fields[k] = k + k
+	}
+	for i := 0; i < 50; i++ {
+		bin, err := mrsh.MarshalStruct(s)
+		require.NoError(t, err)
+		n := &MapStruct{}
+		_, err = mrsh.UnmarshalStruct(bin, n)
+		require.NoError(t, err)
+		assert.Equal(t, s.Fields, n.Fields)
+	}
+}
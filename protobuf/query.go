@@ -0,0 +1,208 @@
+package protobuf
+
+import (
+	"fmt"
+	"strconv"
+
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+
+	"github.com/daotl/go-marsha"
+	"github.com/daotl/go-marsha/internal/pointer"
+)
+
+// segment is a part of the encoding of a message starting at offset `off` of the input. A message
+// may be split into several segments, which are merged when decoded.
+type segment struct {
+	b   []byte
+	off int
+}
+
+// record is an occurrence of a field in the encoding of a message.
+type record struct {
+	raw []byte  // Tag and value
+	val segment // Content of length-delimited values
+}
+
+// Get returns the Value of the field at `path` in `bin`, a message described by `md`, as
+// DecodeValue would decode it, without decoding the fields it isn't in: fields before it are
+// skipped by their lengths.
+//
+// Paths are written like JSON Pointers, such as "/items/3/name": each token is the name, JSON name
+// or number of a field of a message, followed by an index for repeated fields or a key for map
+// fields, which can be omitted at the end of the path to get the whole field. Fields which aren't
+// set are not found.
+func (m *Marsha) Get(bin []byte, path string, md protoreflect.MessageDescriptor) (marsha.Value, error) {
+	toks, err := pointer.Split(path)
+	if err != nil {
+		return marsha.Value{}, err
+	}
+	if len(toks) == 0 {
+		return m.DecodeValue(bin, md)
+	}
+	segs := []segment{{b: bin}}
+	for i := 0; ; i++ {
+		off := segs[0].off
+		fd := fieldByToken(md, toks[i])
+		if fd == nil {
+			return marsha.Value{}, pointer.NotFound(toks, i+1, off, "no field %q in %s", toks[i], md.FullName())
+		}
+		recs, err := findField(segs, fd.Number())
+		if err != nil {
+			return marsha.Value{}, err
+		}
+		if len(recs) == 0 {
+			return marsha.Value{}, pointer.NotFound(toks, i+1, off, "field %s not set", fd.Name())
+		}
+		if i+1 == len(toks) {
+			msg, err := m.decodeRecords(md, recs)
+			if err != nil {
+				return marsha.Value{}, err
+			}
+			return fieldValue(fd, msg.Get(fd)), nil
+		}
+		switch {
+		case fd.IsList():
+			i++
+			idx, ok := pointer.Index(toks[i])
+			if !ok {
+				return marsha.Value{}, pointer.NotFound(toks, i+1, off, "%q is not an index", toks[i])
+			}
+			if fd.Message() == nil {
+				// Scalars may be packed, so the field is decoded to count them.
+				msg, err := m.decodeRecords(md, recs)
+				if err != nil {
+					return marsha.Value{}, err
+				}
+				list := msg.Get(fd).List()
+				if idx >= list.Len() {
+					return marsha.Value{}, pointer.NotFound(toks, i+1, off, "index %d out of range", idx)
+				}
+				if i+1 < len(toks) {
+					return marsha.Value{}, pointer.NotFound(toks, i+2, off, "not a message")
+				}
+				return scalarValue(fd, list.Get(idx)), nil
+			}
+			if idx >= len(recs) {
+				return marsha.Value{}, pointer.NotFound(toks, i+1, off, "index %d out of range", idx)
+			}
+			if i+1 == len(toks) {
+				return m.DecodeValue(recs[idx].val.b, fd.Message())
+			}
+			segs, md = []segment{recs[idx].val}, fd.Message()
+		case fd.IsMap():
+			i++
+			var entry *record
+			for j := range recs {
+				k, err := m.mapKey(fd, recs[j].val)
+				if err != nil {
+					return marsha.Value{}, err
+				}
+				if k == toks[i] {
+					entry = &recs[j]
+				}
+			}
+			if entry == nil {
+				return marsha.Value{}, pointer.NotFound(toks, i+1, off, "no key %q", toks[i])
+			}
+			vfd := fd.MapValue()
+			if i+1 == len(toks) {
+				e := dynamicpb.NewMessage(fd.Message())
+				if err := m.unmarshal(entry.val.b, e); err != nil {
+					return marsha.Value{}, err
+				}
+				return scalarValue(vfd, e.Get(vfd)), nil
+			}
+			if vfd.Message() == nil {
+				return marsha.Value{}, pointer.NotFound(toks, i+2, entry.val.off, "not a message")
+			}
+			vrecs, err := findField([]segment{entry.val}, vfd.Number())
+			if err != nil {
+				return marsha.Value{}, err
+			}
+			segs, md = []segment{{off: entry.val.off}}, vfd.Message()
+			for _, r := range vrecs {
+				segs = append(segs, r.val)
+			}
+		case fd.Message() != nil:
+			// Occurrences of a message field are merged.
+			segs, md = nil, fd.Message()
+			for _, r := range recs {
+				segs = append(segs, r.val)
+			}
+		default:
+			return marsha.Value{}, pointer.NotFound(toks, i+2, off, "not a message")
+		}
+	}
+}
+
+// fieldByToken returns the field of `md` named `tok` or with the JSON name or number `tok`.
+func fieldByToken(md protoreflect.MessageDescriptor, tok string) protoreflect.FieldDescriptor {
+	fields := md.Fields()
+	if fd := fields.ByName(protoreflect.Name(tok)); fd != nil {
+		return fd
+	}
+	if fd := fields.ByJSONName(tok); fd != nil {
+		return fd
+	}
+	if n, err := strconv.ParseInt(tok, 10, 32); err == nil {
+		return fields.ByNumber(protoreflect.FieldNumber(n))
+	}
+	return nil
+}
+
+// findField returns the occurrences of field `num` in the message of segments `segs`, skipping
+// other fields.
+func findField(segs []segment, num protowire.Number) ([]record, error) {
+	var recs []record
+	for _, seg := range segs {
+		for i := 0; i < len(seg.b); {
+			n, typ, tl := protowire.ConsumeTag(seg.b[i:])
+			if tl < 0 {
+				return nil, fmt.Errorf("%w at offset %d", protowire.ParseError(tl), seg.off+i)
+			}
+			vl := protowire.ConsumeFieldValue(n, typ, seg.b[i+tl:])
+			if vl < 0 {
+				return nil, fmt.Errorf("%w at offset %d", protowire.ParseError(vl), seg.off+i)
+			}
+			if n == num {
+				r := record{raw: seg.b[i : i+tl+vl]}
+				if typ == protowire.BytesType {
+					v, _ := protowire.ConsumeBytes(seg.b[i+tl:])
+					r.val = segment{b: v, off: seg.off + i + tl + vl - len(v)}
+				}
+				recs = append(recs, r)
+			}
+			i += tl + vl
+		}
+	}
+	return recs, nil
+}
+
+// decodeRecords decodes the occurrences `recs` of a field into a message described by `md`.
+func (m *Marsha) decodeRecords(md protoreflect.MessageDescriptor, recs []record) (protoreflect.Message, error) {
+	var b []byte
+	for _, r := range recs {
+		b = append(b, r.raw...)
+	}
+	msg := dynamicpb.NewMessage(md)
+	if err := m.unmarshal(b, msg); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// mapKey returns the key of the entry `entry` of the map field `fd` as written in paths.
+func (m *Marsha) mapKey(fd protoreflect.FieldDescriptor, entry segment) (string, error) {
+	kfd := fd.MapKey()
+	recs, err := findField([]segment{entry}, kfd.Number())
+	if err != nil {
+		return "", err
+	}
+	msg, err := m.decodeRecords(fd.Message(), recs)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprint(msg.Get(kfd).Interface()), nil
+}
@@ -0,0 +1,99 @@
+package protobuf_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	"github.com/daotl/go-marsha"
+	"github.com/daotl/go-marsha/protobuf"
+)
+
+func TestGet(t *testing.T) {
+	mrsh := protobuf.New()
+	pb := &descriptorpb.FileDescriptorProto{
+		Name: proto.String("models.proto"),
+		MessageType: []*descriptorpb.DescriptorProto{
+			{Name: proto.String("Order")},
+			{Name: proto.String("Item"), Field: []*descriptorpb.FieldDescriptorProto{
+				{Name: proto.String("sku"), Number: proto.Int32(1)},
+				{Name: proto.String("name"), Number: proto.Int32(2)},
+			}},
+		},
+		SourceCodeInfo: &descriptorpb.SourceCodeInfo{Location: []*descriptorpb.SourceCodeInfo_Location{
+			{Path: []int32{4, 1, 2, 1}}, // Packed
+		}},
+	}
+	bin, err := proto.Marshal(pb)
+	require.NoError(t, err)
+	// Occurrences of a message field are merged.
+	bin = append(bin, 0x42, 0x02, 0x50, 0x01)       // options {java_multiple_files: true}
+	bin = append(bin, 0x42, 0x03, 0xb8, 0x01, 0x01) // options {deprecated: true}
+	md := pb.ProtoReflect().Descriptor()
+
+	for _, tc := range []struct {
+		path string
+		want marsha.Value
+	}{
+		{"/name", marsha.NewString("models.proto")},
+		{"/message_type/1/field/1/name", marsha.NewString("name")},
+		{"/messageType/1/name", marsha.NewString("Item")}, // JSON name
+		{"/4/0", marsha.NewMap(marsha.MapEntry{Key: marsha.NewString("name"), Value: marsha.NewString("Order")})},
+		{"/source_code_info/location/0/path/3", marsha.NewInt(1)},
+		{"/source_code_info/location/0/path", marsha.NewArray(
+			marsha.NewInt(4), marsha.NewInt(1), marsha.NewInt(2), marsha.NewInt(1))},
+		{"/options/java_multiple_files", marsha.NewBool(true)},
+		{"/options", marsha.NewMap(
+			marsha.MapEntry{Key: marsha.NewString("java_multiple_files"), Value: marsha.NewBool(true)},
+			marsha.MapEntry{Key: marsha.NewString("deprecated"), Value: marsha.NewBool(true)},
+		)},
+	} {
+		v, err := mrsh.Get(bin, tc.path, md)
+		require.NoError(t, err, tc.path)
+		assert.True(t, tc.want.Equal(v), "%s: %+v", tc.path, v)
+	}
+
+	for _, tc := range []struct {
+		path, want string
+	}{
+		{"/nope", `path not found: /nope at offset 0: no field "nope" in google.protobuf.FileDescriptorProto`},
+		{"/package", `path not found: /package at offset 0: field package not set`},
+		{"/message_type/2", `path not found: /message_type/2 at offset 0: index 2 out of range`},
+		{"/message_type/x", `path not found: /message_type/x at offset 0: "x" is not an index`},
+		{"/name/0", `path not found: /name/0 at offset 0: not a message`},
+		{"/message_type/1/field/0/number/x", `path not found: /message_type/1/field/0/number/x at offset 33: not a message`},
+	} {
+		_, err := mrsh.Get(bin, tc.path, md)
+		assert.True(t, errors.Is(err, marsha.ErrPathNotFound), tc.path)
+		assert.EqualError(t, err, tc.want, tc.path)
+	}
+
+	_, err = mrsh.Get(bin, "name", md)
+	assert.True(t, errors.Is(err, marsha.ErrInvalidPath))
+
+	t.Run("map fields", func(t *testing.T) {
+		pb, err := structpb.NewStruct(map[string]interface{}{
+			"a": "x", "b": map[string]interface{}{"c": 1.5},
+		})
+		require.NoError(t, err)
+		bin, err := proto.Marshal(pb)
+		require.NoError(t, err)
+		md := pb.ProtoReflect().Descriptor()
+
+		v, err := mrsh.Get(bin, "/fields/b/struct_value/fields/c/number_value", md)
+		require.NoError(t, err)
+		assert.True(t, marsha.NewFloat(1.5).Equal(v))
+		v, err = mrsh.Get(bin, "/fields/a", md)
+		require.NoError(t, err)
+		assert.True(t, marsha.NewMap(marsha.MapEntry{
+			Key: marsha.NewString("string_value"), Value: marsha.NewString("x"),
+		}).Equal(v))
+		_, err = mrsh.Get(bin, "/fields/z", md)
+		assert.True(t, errors.Is(err, marsha.ErrPathNotFound))
+	})
+}
@@ -0,0 +1,294 @@
+package protobuf
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+
+	"github.com/daotl/go-marsha"
+)
+
+var _ marsha.ValueMarsha = (*Marsha)(nil)
+
+// DecodeValue decodes `bin` into a Value tree without a Go type, as a message described by `md`,
+// such as a descriptor of a FileDescriptorSet loaded with `protodesc`. Unknown fields are handled
+// like by UnmarshalStruct.
+//
+// A message becomes a map from the names of its populated fields to their values, in the order
+// they are declared. Repeated fields become arrays, map fields maps sorted by key and enums
+// integers.
+func (m *Marsha) DecodeValue(bin []byte, md protoreflect.MessageDescriptor) (marsha.Value, error) {
+	msg := dynamicpb.NewMessage(md)
+	if err := m.unmarshal(bin, msg); err != nil {
+		return marsha.Value{}, err
+	}
+	return messageValue(msg), nil
+}
+
+// EncodeValue encodes the Value tree `v` as a message described by `md`. `v` must be a map from
+// names of fields to values of their types, like the trees returned by DecodeValue, and null
+// values leave fields unset.
+func (m *Marsha) EncodeValue(v marsha.Value, md protoreflect.MessageDescriptor) ([]byte, error) {
+	msg := dynamicpb.NewMessage(md)
+	if err := loadMessage(msg, v, ""); err != nil {
+		return nil, err
+	}
+	return m.marshalOptions().Marshal(msg)
+}
+
+func (m *Marsha) StructToValue(p marsha.StructPtr) (marsha.Value, error) {
+	pbp, ok := p.(StructPtr)
+	if !ok {
+		return marsha.Value{}, ErrNotPBStructPtr
+	}
+	return messageValue(pbp.PB().ProtoReflect()), nil
+}
+
+func (m *Marsha) ValueToStruct(v marsha.Value, p marsha.StructPtr) error {
+	pbp, ok := p.(StructPtr)
+	if !ok {
+		return ErrNotPBStructPtr
+	}
+	pb := pbp.EmptyPB()
+	if err := loadMessage(pb.ProtoReflect(), v, ""); err != nil {
+		return err
+	}
+	return pbp.LoadPB(pb)
+}
+
+// messageValue returns the Value tree of `msg`.
+func messageValue(msg protoreflect.Message) marsha.Value {
+	v := marsha.NewMap()
+	fields := msg.Descriptor().Fields()
+	for i := 0; i < fields.Len(); i++ {
+		fd := fields.Get(i)
+		if !msg.Has(fd) {
+			continue
+		}
+		v.Map = append(v.Map, marsha.MapEntry{
+			Key:   marsha.NewString(string(fd.Name())),
+			Value: fieldValue(fd, msg.Get(fd)),
+		})
+	}
+	return v
+}
+
+// fieldValue returns the Value of field `fd` holding `pv`.
+func fieldValue(fd protoreflect.FieldDescriptor, pv protoreflect.Value) marsha.Value {
+	switch {
+	case fd.IsList():
+		list := pv.List()
+		v := marsha.NewArray()
+		for i := 0; i < list.Len(); i++ {
+			v.Array = append(v.Array, scalarValue(fd, list.Get(i)))
+		}
+		return v
+	case fd.IsMap():
+		v := marsha.NewMap()
+		pv.Map().Range(func(k protoreflect.MapKey, ev protoreflect.Value) bool {
+			v.Map = append(v.Map, marsha.MapEntry{
+				Key:   scalarValue(fd.MapKey(), k.Value()),
+				Value: scalarValue(fd.MapValue(), ev),
+			})
+			return true
+		})
+		sort.Slice(v.Map, func(i, j int) bool { return lessKey(v.Map[i].Key, v.Map[j].Key) })
+		return v
+	}
+	return scalarValue(fd, pv)
+}
+
+func lessKey(a, b marsha.Value) bool {
+	switch a.Kind {
+	case marsha.KindString:
+		return a.Text < b.Text
+	case marsha.KindInt:
+		return a.Int.Cmp(b.Int) < 0
+	}
+	return !a.Bool && b.Bool
+}
+
+// scalarValue returns the Value of a single value `pv` of field `fd`.
+func scalarValue(fd protoreflect.FieldDescriptor, pv protoreflect.Value) marsha.Value {
+	switch fd.Kind() {
+	case protoreflect.BoolKind:
+		return marsha.NewBool(pv.Bool())
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind,
+		protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		return marsha.NewInt(pv.Int())
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind, protoreflect.Uint64Kind,
+		protoreflect.Fixed64Kind:
+		return marsha.NewUint(pv.Uint())
+	case protoreflect.EnumKind:
+		return marsha.NewInt(int64(pv.Enum()))
+	case protoreflect.FloatKind, protoreflect.DoubleKind:
+		return marsha.NewFloat(pv.Float())
+	case protoreflect.StringKind:
+		return marsha.NewString(pv.String())
+	case protoreflect.BytesKind:
+		return marsha.NewBytes(pv.Bytes())
+	}
+	return messageValue(pv.Message())
+}
+
+// loadMessage sets the fields of `msg` from the Value tree `v` found at `path`.
+func loadMessage(msg protoreflect.Message, v marsha.Value, path string) error {
+	if v.Kind != marsha.KindMap {
+		return kindError(path, "map", v)
+	}
+	fields := msg.Descriptor().Fields()
+	for _, e := range v.Map {
+		if e.Key.Kind != marsha.KindString {
+			return kindError(path, "string key", e.Key)
+		}
+		fPath := path + "/" + e.Key.Text
+		fd := fields.ByName(protoreflect.Name(e.Key.Text))
+		if fd == nil {
+			fd = fields.ByJSONName(e.Key.Text)
+		}
+		if fd == nil {
+			return fmt.Errorf("%w at %q", marsha.ErrUnknownField, fPath)
+		}
+		if e.Value.Kind == marsha.KindNull {
+			continue
+		}
+		if err := loadField(msg, fd, e.Value, fPath); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadField sets field `fd` of `msg` from `v` found at `path`.
+func loadField(msg protoreflect.Message, fd protoreflect.FieldDescriptor, v marsha.Value, path string) error {
+	switch {
+	case fd.IsList():
+		if v.Kind != marsha.KindArray {
+			return kindError(path, "array", v)
+		}
+		list := msg.Mutable(fd).List()
+		for i, elem := range v.Array {
+			ePath := fmt.Sprintf("%s/%d", path, i)
+			if fd.Message() != nil {
+				pv := list.NewElement()
+				if err := loadMessage(pv.Message(), elem, ePath); err != nil {
+					return err
+				}
+				list.Append(pv)
+				continue
+			}
+			pv, err := scalar(fd, elem, ePath)
+			if err != nil {
+				return err
+			}
+			list.Append(pv)
+		}
+	case fd.IsMap():
+		if v.Kind != marsha.KindMap {
+			return kindError(path, "map", v)
+		}
+		mp := msg.Mutable(fd).Map()
+		for _, e := range v.Map {
+			k, err := scalar(fd.MapKey(), e.Key, path)
+			if err != nil {
+				return err
+			}
+			ePath := fmt.Sprintf("%s/%v", path, k.Interface())
+			if fd.MapValue().Message() != nil {
+				if err := loadMessage(mp.Mutable(k.MapKey()).Message(), e.Value, ePath); err != nil {
+					return err
+				}
+				continue
+			}
+			pv, err := scalar(fd.MapValue(), e.Value, ePath)
+			if err != nil {
+				return err
+			}
+			mp.Set(k.MapKey(), pv)
+		}
+	case fd.Message() != nil:
+		return loadMessage(msg.Mutable(fd).Message(), v, path)
+	default:
+		pv, err := scalar(fd, v, path)
+		if err != nil {
+			return err
+		}
+		msg.Set(fd, pv)
+	}
+	return nil
+}
+
+// scalar returns the single value of field `fd` of the scalar `v` found at `path`.
+func scalar(fd protoreflect.FieldDescriptor, v marsha.Value, path string) (protoreflect.Value, error) {
+	switch fd.Kind() {
+	case protoreflect.BoolKind:
+		if v.Kind == marsha.KindBool {
+			return protoreflect.ValueOfBool(v.Bool), nil
+		}
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind:
+		if i, ok := intIn(v, math.MinInt32, math.MaxInt32); ok {
+			return protoreflect.ValueOfInt32(int32(i)), nil
+		}
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		if i, ok := intIn(v, math.MinInt64, math.MaxInt64); ok {
+			return protoreflect.ValueOfInt64(i), nil
+		}
+	case protoreflect.EnumKind:
+		if i, ok := intIn(v, math.MinInt32, math.MaxInt32); ok {
+			return protoreflect.ValueOfEnum(protoreflect.EnumNumber(i)), nil
+		}
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+		if u, ok := uintIn(v, math.MaxUint32); ok {
+			return protoreflect.ValueOfUint32(uint32(u)), nil
+		}
+	case protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		if u, ok := uintIn(v, math.MaxUint64); ok {
+			return protoreflect.ValueOfUint64(u), nil
+		}
+	case protoreflect.FloatKind:
+		if v.Kind == marsha.KindFloat {
+			return protoreflect.ValueOfFloat32(float32(v.Float)), nil
+		}
+	case protoreflect.DoubleKind:
+		if v.Kind == marsha.KindFloat {
+			return protoreflect.ValueOfFloat64(v.Float), nil
+		}
+	case protoreflect.StringKind:
+		if v.Kind == marsha.KindString {
+			return protoreflect.ValueOfString(v.Text), nil
+		}
+	case protoreflect.BytesKind:
+		if v.Kind == marsha.KindBytes {
+			return protoreflect.ValueOfBytes(v.Bytes), nil
+		}
+	}
+	return protoreflect.Value{}, kindError(path, fd.Kind().String(), v)
+}
+
+// intIn returns the integer of `v` if it is in [min, max].
+func intIn(v marsha.Value, min, max int64) (int64, bool) {
+	if v.Kind != marsha.KindInt || v.Int == nil || !v.Int.IsInt64() {
+		return 0, false
+	}
+	i := v.Int.Int64()
+	return i, i >= min && i <= max
+}
+
+// uintIn returns the integer of `v` if it is in [0, max].
+func uintIn(v marsha.Value, max uint64) (uint64, bool) {
+	if v.Kind != marsha.KindInt || v.Int == nil || !v.Int.IsUint64() {
+		return 0, false
+	}
+	u := v.Int.Uint64()
+	return u, u <= max
+}
+
+func kindError(path, want string, v marsha.Value) error {
+	if path == "" {
+		path = "/"
+	}
+	return fmt.Errorf("%w at %q: expected %s, got %s", marsha.ErrValueKind, path, want, v.Kind)
+}
@@ -0,0 +1,124 @@
+package protobuf_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	"github.com/daotl/go-marsha"
+	"github.com/daotl/go-marsha/protobuf"
+)
+
+func TestValue(t *testing.T) {
+	mrsh := protobuf.New(marsha.WithDeterministic())
+
+	t.Run("StructToValue/ValueToStruct", func(t *testing.T) {
+		v, err := mrsh.StructToValue(&TestStruct{Data: "test"})
+		require.NoError(t, err)
+		assert.True(t, marsha.NewMap(marsha.MapEntry{
+			Key: marsha.NewString("data"), Value: marsha.NewString("test"),
+		}).Equal(v))
+
+		s := &TestStruct{}
+		require.NoError(t, mrsh.ValueToStruct(v, s))
+		assert.Equal(t, "test", s.Data)
+	})
+
+	t.Run("DecodeValue/EncodeValue", func(t *testing.T) {
+		// A message with nested, repeated and enum fields.
+		pb := &descriptorpb.DescriptorProto{
+			Name: proto.String("Item"),
+			Field: []*descriptorpb.FieldDescriptorProto{{
+				Name:   proto.String("sku"),
+				Number: proto.Int32(1),
+				Type:   descriptorpb.FieldDescriptorProto_TYPE_STRING.Enum(),
+			}},
+			ReservedName: []string{"old"},
+		}
+		bin, err := proto.Marshal(pb)
+		require.NoError(t, err)
+		md := pb.ProtoReflect().Descriptor()
+		v, err := mrsh.DecodeValue(bin, md)
+		require.NoError(t, err)
+		want := marsha.NewMap(
+			marsha.MapEntry{Key: marsha.NewString("name"), Value: marsha.NewString("Item")},
+			marsha.MapEntry{Key: marsha.NewString("field"), Value: marsha.NewArray(marsha.NewMap(
+				marsha.MapEntry{Key: marsha.NewString("name"), Value: marsha.NewString("sku")},
+				marsha.MapEntry{Key: marsha.NewString("number"), Value: marsha.NewInt(1)},
+				marsha.MapEntry{Key: marsha.NewString("type"), Value: marsha.NewInt(9)},
+			))},
+			marsha.MapEntry{Key: marsha.NewString("reserved_name"), Value: marsha.NewArray(marsha.NewString("old"))},
+		)
+		assert.True(t, want.Equal(v), "%+v", v)
+
+		re, err := mrsh.EncodeValue(v, md)
+		require.NoError(t, err)
+		assert.Equal(t, bin, re)
+	})
+
+	t.Run("DecodeValue/EncodeValue: map fields", func(t *testing.T) {
+		pb, err := structpb.NewStruct(map[string]interface{}{"b": 1.5, "a": "x"})
+		require.NoError(t, err)
+		bin, err := proto.MarshalOptions{Deterministic: true}.Marshal(pb)
+		require.NoError(t, err)
+		md := pb.ProtoReflect().Descriptor()
+		v, err := mrsh.DecodeValue(bin, md)
+		require.NoError(t, err)
+		fields, ok := v.Get("fields")
+		require.True(t, ok)
+		require.Len(t, fields.Map, 2)
+		assert.Equal(t, "a", fields.Map[0].Key.Text)
+		b, _ := fields.Map[1].Value.Get("number_value")
+		assert.Equal(t, 1.5, b.Float)
+
+		re, err := mrsh.EncodeValue(v, md)
+		require.NoError(t, err)
+		assert.Equal(t, bin, re)
+	})
+
+	t.Run("EncodeValue error", func(t *testing.T) {
+		md := (&descriptorpb.FieldDescriptorProto{}).ProtoReflect().Descriptor()
+		for _, c := range []struct {
+			v    marsha.Value
+			want string
+		}{
+			{marsha.NewArray(), `wrong kind of value at "/": expected map, got array`},
+			{marsha.NewMap(marsha.MapEntry{Key: marsha.NewString("number"), Value: marsha.NewInt(1 << 40)}),
+				`wrong kind of value at "/number": expected int32, got int`},
+			{marsha.NewMap(marsha.MapEntry{Key: marsha.NewString("number"),
+				Value: marsha.Value{Kind: marsha.KindInt}}),
+				`wrong kind of value at "/number": expected int32, got int`},
+			{marsha.NewMap(marsha.MapEntry{Key: marsha.NewString("options"), Value: marsha.NewMap(
+				marsha.MapEntry{Key: marsha.NewString("uninterpreted_option"), Value: marsha.NewArray(
+					marsha.NewMap(marsha.MapEntry{Key: marsha.NewString("positive_int_value"),
+						Value: marsha.Value{Kind: marsha.KindInt}}))})}),
+				`wrong kind of value at "/options/uninterpreted_option/0/positive_int_value": ` +
+					`expected uint64, got int`},
+			{marsha.NewMap(marsha.MapEntry{Key: marsha.NewString("options"), Value: marsha.NewMap(
+				marsha.MapEntry{Key: marsha.NewString("packed"), Value: marsha.NewString("yes")})}),
+				`wrong kind of value at "/options/packed": expected bool, got string`},
+		} {
+			_, err := mrsh.EncodeValue(c.v, md)
+			if assert.Error(t, err) {
+				assert.True(t, errors.Is(err, marsha.ErrValueKind))
+				assert.Equal(t, c.want, err.Error())
+			}
+		}
+
+		_, err := mrsh.EncodeValue(marsha.NewMap(marsha.MapEntry{Key: marsha.NewString("nope")}), md)
+		assert.True(t, errors.Is(err, marsha.ErrUnknownField))
+		assert.EqualError(t, err, `unknown field at "/nope"`)
+	})
+
+	t.Run("DecodeValue error: unknown field", func(t *testing.T) {
+		strict := protobuf.New(marsha.WithStrict())
+		bin := []byte{0x78, 0x01} // Field 15 as varint 1
+		_, err := strict.DecodeValue(bin, (&protobuf.Test{}).ProtoReflect().Descriptor())
+		assert.True(t, errors.Is(err, marsha.ErrUnknownField))
+	})
+}
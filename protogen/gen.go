@@ -0,0 +1,379 @@
+// Package protogen generates Protocol Buffers (proto3) message definitions from Go models, so that
+// the messages used with the `protobuf` Marsha implementation are derived from the same models as
+// the other implementations rather than maintained separately. Field numbers are kept stable
+// across generations by a Lock.
+package protogen
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	cbg "github.com/daotl/cbor-gen"
+
+	"github.com/daotl/go-marsha/internal/model"
+)
+
+var (
+	ErrNotStruct   = model.ErrNotStruct
+	ErrUnsupported = model.ErrUnsupported
+)
+
+var (
+	bigIntType   = reflect.TypeOf(big.Int{})
+	deferredType = reflect.TypeOf(cbg.Deferred{})
+)
+
+// Generator generates the messages of Go models and of the struct types they refer to.
+//
+// A struct type becomes a message of the same name, and its exported fields become fields named
+// in snake_case, or as given by a `proto` struct tag, `proto:"-"` skipping the field. Fields of
+// embedded structs are promoted. Go types map to scalar types of the same size and signedness,
+// with `[]byte`, CIDs and big integers as `bytes`, slices as `repeated` fields and maps with
+// integer, string or boolean keys as `map` fields. Pointers to structs are message fields and
+// pointers to other types `optional` fields.
+type Generator struct {
+	pkg, goPkg string
+	lock       *Lock
+	msgs       []message
+	names      *model.Namer
+}
+
+type message struct {
+	name     string
+	fields   []field
+	reserved []Reserved
+}
+
+type field struct {
+	label, typ, name string
+	number           int32
+}
+
+// NewGenerator creates a Generator of messages in the protobuf package `pkg`, with the Go
+// package `goPkg` as the `go_package` option if it is not empty. `goPkg` must not be the package
+// of the models, whose types `protoc` would redeclare, but a package of its own such as
+// "example.com/models/pb". The numbers of the fields are taken from `lock`, which is updated with
+// the numbers of new fields and removed fields.
+func NewGenerator(pkg, goPkg string, lock *Lock) *Generator {
+	return &Generator{
+		pkg:   pkg,
+		goPkg: goPkg,
+		lock:  lock,
+		names: model.NewNamer(qualify),
+	}
+}
+
+// Add generates the messages of the models, which are struct values or pointers to them.
+func (g *Generator) Add(models ...interface{}) error {
+	for _, m := range models {
+		t, err := model.Struct(m)
+		if err != nil {
+			return err
+		}
+		if _, err := g.ref(t); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteTo writes the `.proto` source of the messages generated so far to `w`.
+func (g *Generator) WriteTo(w io.Writer) (int64, error) {
+	var buf bytes.Buffer
+	buf.WriteString("syntax = \"proto3\";\n\n")
+	fmt.Fprintf(&buf, "package %s;\n", g.pkg)
+	if g.goPkg != "" {
+		fmt.Fprintf(&buf, "\noption go_package = %q;\n", g.goPkg)
+	}
+	for i := range g.msgs {
+		m := &g.msgs[i]
+		fmt.Fprintf(&buf, "\nmessage %s {\n", m.name)
+		if len(m.reserved) > 0 {
+			fmt.Fprintf(&buf, "  reserved %s;\n", m.reservedNumbers())
+			if names := m.reservedNames(); names != "" {
+				fmt.Fprintf(&buf, "  reserved %s;\n", names)
+			}
+			if len(m.fields) > 0 {
+				buf.WriteByte('\n')
+			}
+		}
+		for _, f := range m.fields {
+			buf.WriteString("  ")
+			if f.label != "" {
+				buf.WriteString(f.label + " ")
+			}
+			fmt.Fprintf(&buf, "%s %s = %d;\n", f.typ, f.name, f.number)
+		}
+		buf.WriteString("}\n")
+	}
+	return buf.WriteTo(w)
+}
+
+// reservedNumbers returns the numbers of the removed fields of `m` in order.
+func (m *message) reservedNumbers() string {
+	nums := make([]int, 0, len(m.reserved))
+	for _, r := range m.reserved {
+		nums = append(nums, int(r.Number))
+	}
+	sort.Ints(nums)
+	strs := make([]string, len(nums))
+	for i, n := range nums {
+		strs[i] = strconv.Itoa(n)
+	}
+	return strings.Join(strs, ", ")
+}
+
+// reservedNames returns the quoted names of the removed fields of `m` which weren't added back,
+// in order.
+func (m *message) reservedNames() string {
+	seen := map[string]bool{}
+	for _, f := range m.fields {
+		seen[f.name] = true
+	}
+	var names []string
+	for _, r := range m.reserved {
+		if !seen[r.Name] {
+			seen[r.Name] = true
+			names = append(names, strconv.Quote(r.Name))
+		}
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// String returns the `.proto` source of the messages generated so far.
+func (g *Generator) String() string {
+	var sb strings.Builder
+	g.WriteTo(&sb)
+	return sb.String()
+}
+
+// WriteFile writes the messages of `models` in the protobuf package `pkg` and Go package `goPkg`
+// to the file `path`, numbering their fields by the Lock in the file `lockPath`, which is
+// created or updated.
+func WriteFile(path, lockPath, pkg, goPkg string, models ...interface{}) error {
+	lock, err := ReadLock(lockPath)
+	if err != nil {
+		return err
+	}
+	g := NewGenerator(pkg, goPkg, lock)
+	if err := g.Add(models...); err != nil {
+		return err
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprint(f, "// Code generated by github.com/daotl/go-marsha/protogen. DO NOT EDIT.\n\n"); err != nil {
+		f.Close()
+		return err
+	}
+	if _, err := g.WriteTo(f); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return lock.WriteFile(lockPath)
+}
+
+// ref returns the name of the message of the struct type `t`, generating it the first time.
+func (g *Generator) ref(t reflect.Type) (string, error) {
+	if name, ok := g.names.Lookup(t); ok {
+		return name, nil
+	}
+	name, err := g.names.Name(t)
+	if err != nil {
+		return "", err
+	}
+	i := len(g.msgs)
+	g.msgs = append(g.msgs, message{name: name})
+	fields, err := g.fields(t)
+	if err != nil {
+		return "", err
+	}
+
+	ml, ok := g.lock.Messages[name]
+	if !ok {
+		ml = &MessageLock{}
+		g.lock.Messages[name] = ml
+	}
+	if ml.Fields == nil {
+		ml.Fields = map[string]int32{}
+	}
+	names := map[string]bool{}
+	for j := range fields {
+		names[fields[j].name] = true
+	}
+	// Reserve the numbers of removed fields before numbering new ones.
+	ml.retain(names)
+	for j := range fields {
+		fields[j].number = ml.number(fields[j].name)
+	}
+	g.msgs[i].fields = fields
+	g.msgs[i].reserved = ml.Reserved
+	return name, nil
+}
+
+// fields returns the fields of the struct type `t` without their numbers.
+func (g *Generator) fields(t reflect.Type) ([]field, error) {
+	var fields []field
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		tag, tagged := sf.Tag.Lookup("proto")
+		if tag == "-" {
+			continue
+		}
+		if sf.Anonymous && !tagged && sf.Type.Kind() == reflect.Struct {
+			embedded, err := g.fields(sf.Type)
+			if err != nil {
+				return nil, err
+			}
+			fields = append(fields, embedded...)
+			continue
+		}
+		if sf.PkgPath != "" {
+			continue
+		}
+		f := field{name: tag}
+		if f.name == "" {
+			f.name = snakeCase(sf.Name)
+		}
+		var err error
+		if f.label, f.typ, err = g.typ(sf.Type); err != nil {
+			return nil, fmt.Errorf("%s.%s: %w", t.Name(), sf.Name, err)
+		}
+		fields = append(fields, f)
+	}
+	for i := range fields {
+		for j := 0; j < i; j++ {
+			if fields[i].name == fields[j].name {
+				return nil, fmt.Errorf("%s: duplicate field %s", t.Name(), fields[i].name)
+			}
+		}
+	}
+	return fields, nil
+}
+
+// typ returns the label and type of fields of `t`.
+func (g *Generator) typ(t reflect.Type) (label, typ string, err error) {
+	switch {
+	case t.Kind() == reflect.Ptr && t.Elem().Kind() == reflect.Struct && t.Elem() != model.CidType &&
+		t.Elem() != bigIntType:
+		// Message fields have presence.
+		typ, err = g.elem(t.Elem())
+		return "", typ, err
+	case t.Kind() == reflect.Ptr:
+		typ, err = g.elem(t.Elem())
+		return "optional", typ, err
+	case t.Kind() == reflect.Slice && t.Elem().Kind() != reflect.Uint8:
+		typ, err = g.elem(t.Elem())
+		return "repeated", typ, err
+	case t.Kind() == reflect.Map:
+		switch t.Key().Kind() {
+		case reflect.Float32, reflect.Float64, reflect.Slice, reflect.Array, reflect.Struct,
+			reflect.Ptr, reflect.Map, reflect.Interface:
+			return "", "", fmt.Errorf("%w: map key %s", ErrUnsupported, t.Key())
+		}
+		key, err := g.elem(t.Key())
+		if err != nil {
+			return "", "", err
+		}
+		value, err := g.elem(t.Elem())
+		if err != nil {
+			return "", "", err
+		}
+		return "", "map<" + key + ", " + value + ">", nil
+	}
+	typ, err = g.elem(t)
+	return "", typ, err
+}
+
+// elem returns the type of values of `t` which are not fields by themselves, such as elements of
+// repeated fields.
+func (g *Generator) elem(t reflect.Type) (string, error) {
+	switch t {
+	case model.CidType, bigIntType, deferredType:
+		return "bytes", nil
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return "bool", nil
+	case reflect.String:
+		return "string", nil
+	case reflect.Int, reflect.Int64:
+		return "int64", nil
+	case reflect.Int8, reflect.Int16, reflect.Int32:
+		return "int32", nil
+	case reflect.Uint, reflect.Uint64, reflect.Uintptr:
+		return "uint64", nil
+	case reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return "uint32", nil
+	case reflect.Float32:
+		return "float", nil
+	case reflect.Float64:
+		return "double", nil
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return "bytes", nil
+		}
+	case reflect.Ptr:
+		if t.Elem().Kind() == reflect.Struct {
+			return g.elem(t.Elem())
+		}
+	case reflect.Struct:
+		return g.ref(t)
+	}
+	return "", fmt.Errorf("%w: %s", ErrUnsupported, t)
+}
+
+// snakeCase converts the Go identifier `s` to snake_case, keeping initialisms together, such as
+// "HTTPServer" to "http_server".
+func snakeCase(s string) string {
+	rs := []rune(s)
+	var sb strings.Builder
+	for i, r := range rs {
+		if unicode.IsUpper(r) {
+			if i > 0 && (unicode.IsLower(rs[i-1]) || unicode.IsDigit(rs[i-1]) ||
+				i+1 < len(rs) && unicode.IsLower(rs[i+1])) {
+				sb.WriteByte('_')
+			}
+			r = unicode.ToLower(r)
+		}
+		sb.WriteRune(r)
+	}
+	return sb.String()
+}
+
+// qualify prefixes the name of the struct type `t` with its package, as messages can't be
+// qualified by a dot.
+func qualify(t reflect.Type) string {
+	return camelCase(model.Package(t)) + t.Name()
+}
+
+// camelCase converts the package name `s` to CamelCase, such as "go_marsha" to "GoMarsha".
+func camelCase(s string) string {
+	var sb strings.Builder
+	upper := true
+	for _, r := range s {
+		if r == '_' || r == '-' || r == '.' {
+			upper = true
+			continue
+		}
+		if upper {
+			r = unicode.ToUpper(r)
+			upper = false
+		}
+		sb.WriteRune(r)
+	}
+	return sb.String()
+}
@@ -0,0 +1,194 @@
+package protogen_test
+
+import (
+	"errors"
+	"math/big"
+	"os"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/daotl/go-marsha/compat"
+	"github.com/daotl/go-marsha/protogen"
+	"github.com/daotl/go-marsha/test"
+)
+
+func TestModels(t *testing.T) {
+	want, err := os.ReadFile("../test/models.proto")
+	require.NoError(t, err)
+	wantLock, err := os.ReadFile("../test/models.proto.lock")
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(dir+"/models.proto.lock", wantLock, 0o644))
+	require.NoError(t, protogen.WriteFile(dir+"/models.proto", dir+"/models.proto.lock", "test",
+		"github.com/daotl/go-marsha/test/pb",
+		test.TestStruct{}, test.TestStruct2{}, test.TestLinked{},
+		test.TestStructV2{}, test.TestChildV2{}, test.TestStructV1{}, test.TestChildV1{},
+		test.TestPersonV1{}, test.TestPersonV2{}, test.TestPerson{}))
+	got, err := os.ReadFile(dir + "/models.proto")
+	require.NoError(t, err)
+	assert.Equal(t, string(want), string(got), "test/models.proto is outdated, run go generate")
+	gotLock, err := os.ReadFile(dir + "/models.proto.lock")
+	require.NoError(t, err)
+	assert.Equal(t, string(wantLock), string(gotLock))
+
+	f, err := compat.ParseProto(string(got))
+	require.NoError(t, err)
+	assert.Len(t, f.Messages, 10)
+}
+
+type Item struct {
+	SKU string
+	Qty uint32
+}
+
+type Order struct {
+	ID        uint64 `proto:"order_id"`
+	Items     []*Item
+	Labels    map[string]*Item
+	Counts    map[int32]int64
+	Link      cid.Cid
+	Parent    *cid.Cid
+	Note      *string
+	Total     *big.Int
+	Hash      [32]byte
+	Blobs     [][]byte
+	Ratio     float32
+	Internal  string `proto:"-"`
+	unexposed bool
+}
+
+func TestTypes(t *testing.T) {
+	g := protogen.NewGenerator("shop", "", protogen.NewLock())
+	require.NoError(t, g.Add(&Order{}))
+	assert.Equal(t, `syntax = "proto3";
+
+package shop;
+
+message Order {
+  uint64 order_id = 1;
+  repeated Item items = 2;
+  map<string, Item> labels = 3;
+  map<int32, int64> counts = 4;
+  bytes link = 5;
+  optional bytes parent = 6;
+  optional string note = 7;
+  optional bytes total = 8;
+  bytes hash = 9;
+  repeated bytes blobs = 10;
+  float ratio = 11;
+}
+
+message Item {
+  string sku = 1;
+  uint32 qty = 2;
+}
+`, g.String())
+	_, err := compat.ParseProto(g.String())
+	assert.NoError(t, err)
+}
+
+func v1() interface{} {
+	type Order struct {
+		ID       uint64
+		Customer string
+		Note     string
+	}
+	return Order{}
+}
+
+func v2() interface{} {
+	type Order struct {
+		Customer string
+		ID       uint64
+		Total    int64
+	}
+	return Order{}
+}
+
+func v3() interface{} {
+	type Order struct {
+		Customer string
+		ID       uint64
+		Total    int64
+		Note     []string
+	}
+	return Order{}
+}
+
+func TestLock(t *testing.T) {
+	dir := t.TempDir()
+	path, lockPath := dir+"/shop.proto", dir+"/shop.proto.lock"
+	generate := func(model interface{}) *compat.ProtoFile {
+		require.NoError(t, protogen.WriteFile(path, lockPath, "shop", "", model))
+		f, err := compat.ParseProtoFile(path)
+		require.NoError(t, err)
+		return f
+	}
+
+	f1 := generate(v1())
+	f2 := generate(v2())
+	src, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Contains(t, string(src), `message Order {
+  reserved 3;
+  reserved "note";
+
+  string customer = 2;
+  uint64 id = 1;
+  int64 total = 4;
+}`)
+	assert.NotEqual(t, compat.Breaking, compat.CompareProto(f1, f2).Max())
+
+	// Fields added back get new numbers, as their types may have changed.
+	f3 := generate(v3())
+	src, err = os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Contains(t, string(src), `message Order {
+  reserved 3;
+
+  string customer = 2;
+  uint64 id = 1;
+  int64 total = 4;
+  repeated string note = 5;
+}`)
+	assert.NotEqual(t, compat.Breaking, compat.CompareProto(f2, f3).Max())
+
+	// Removing them again reserves both numbers.
+	generate(v2())
+	lock, err := protogen.ReadLock(lockPath)
+	require.NoError(t, err)
+	assert.Equal(t, &protogen.MessageLock{
+		Fields:   map[string]int32{"id": 1, "customer": 2, "total": 4},
+		Reserved: []protogen.Reserved{{Name: "note", Number: 3}, {Name: "note", Number: 5}},
+	}, lock.Messages["Order"])
+}
+
+type Chan struct {
+	C chan int
+}
+
+type Nested struct {
+	S [][]string
+}
+
+// TestStruct shares its name with test.TestStruct, and ProtogenTestTestStruct the name it is
+// prefixed to.
+type (
+	TestStruct             struct{ A int }
+	ProtogenTestTestStruct struct{ B int }
+)
+
+func TestErrors(t *testing.T) {
+	g := protogen.NewGenerator("shop", "", protogen.NewLock())
+	assert.True(t, errors.Is(g.Add(42), protogen.ErrNotStruct))
+	assert.True(t, errors.Is(g.Add(Chan{}), protogen.ErrUnsupported))
+	assert.True(t, errors.Is(g.Add(Nested{}), protogen.ErrUnsupported))
+
+	g = protogen.NewGenerator("shop", "", protogen.NewLock())
+	require.NoError(t, g.Add(test.TestStruct{}, ProtogenTestTestStruct{}))
+	assert.True(t, errors.Is(g.Add(TestStruct{}), protogen.ErrUnsupported))
+}
@@ -0,0 +1,106 @@
+package protogen
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"sort"
+)
+
+// Lock persists the field numbers assigned to the fields of each message, so that generating
+// again after fields are added, removed or reordered keeps the wire format compatible. It is
+// meant to be committed along with the generated `.proto` file.
+type Lock struct {
+	Messages map[string]*MessageLock `json:"messages"`
+}
+
+// MessageLock is the field numbers of a message in a Lock.
+type MessageLock struct {
+	// Fields maps the names of the fields generated to their numbers.
+	Fields map[string]int32 `json:"fields"`
+	// Reserved is the removed fields, whose numbers are never reused.
+	Reserved []Reserved `json:"reserved,omitempty"`
+}
+
+// Reserved is a removed field in a MessageLock.
+type Reserved struct {
+	Name   string `json:"name"`
+	Number int32  `json:"number"`
+}
+
+// NewLock creates an empty Lock.
+func NewLock() *Lock {
+	return &Lock{Messages: map[string]*MessageLock{}}
+}
+
+// ReadLock reads the Lock in the file `path`, or returns an empty Lock if there is none.
+func ReadLock(path string) (*Lock, error) {
+	bin, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return NewLock(), nil
+	} else if err != nil {
+		return nil, err
+	}
+	l := NewLock()
+	if err := json.Unmarshal(bin, l); err != nil {
+		return nil, err
+	}
+	if l.Messages == nil {
+		l.Messages = map[string]*MessageLock{}
+	}
+	return l, nil
+}
+
+// WriteFile writes the Lock to the file `path`.
+func (l *Lock) WriteFile(path string) error {
+	bin, err := json.MarshalIndent(l, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(bin, '\n'), 0o644)
+}
+
+// Field numbers 19000 to 19999 are reserved for the implementation of Protocol Buffers.
+const (
+	firstReservedNumber = 19000
+	lastReservedNumber  = 19999
+)
+
+// number returns the number of the field `name` of the message, assigning the next unused
+// number to new fields.
+func (ml *MessageLock) number(name string) int32 {
+	if n, ok := ml.Fields[name]; ok {
+		return n
+	}
+	var max int32
+	for _, n := range ml.Fields {
+		if n > max {
+			max = n
+		}
+	}
+	for _, r := range ml.Reserved {
+		if r.Number > max {
+			max = r.Number
+		}
+	}
+	n := max + 1
+	if n >= firstReservedNumber && n <= lastReservedNumber {
+		n = lastReservedNumber + 1
+	}
+	ml.Fields[name] = n
+	return n
+}
+
+// retain reserves the numbers of the fields not in `names`.
+func (ml *MessageLock) retain(names map[string]bool) {
+	var removed []Reserved
+	for name, n := range ml.Fields {
+		if !names[name] {
+			removed = append(removed, Reserved{Name: name, Number: n})
+			delete(ml.Fields, name)
+		}
+	}
+	sort.Slice(removed, func(i, j int) bool { return removed[i].Number < removed[j].Number })
+	ml.Reserved = append(ml.Reserved, removed...)
+}
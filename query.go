@@ -0,0 +1,171 @@
+package marsha
+
+import (
+	"bytes"
+	"math/big"
+
+	"github.com/daotl/go-marsha/internal/cbor"
+	"github.com/daotl/go-marsha/internal/pointer"
+)
+
+var (
+	// ErrInvalidPath is wrapped by errors reporting malformed paths.
+	ErrInvalidPath = pointer.ErrSyntax
+	// ErrPathNotFound is wrapped by errors reporting paths which lead to no item.
+	ErrPathNotFound = pointer.ErrNotFound
+)
+
+// Get returns the Value of the item at `path` in the CBOR data item `bin`, decoding only that
+// item. See GetRaw for the paths.
+func Get(bin []byte, path string) (Value, error) {
+	raw, err := GetRaw(bin, path)
+	if err != nil {
+		return Value{}, err
+	}
+	v, _, err := DecodeCBORValue(raw)
+	return v, err
+}
+
+// GetRaw returns the bytes of the item at `path` in the CBOR data item `bin`, which can be
+// unmarshaled on their own. Items before it are skipped by their lengths without being decoded.
+//
+// Paths are written like JSON Pointers, such as "/items/3/name": each token is the index of an
+// element of an array, such as a field of a struct in the tuple layout of `cborgen`, or the key
+// of an entry of a map, such as a field of a struct in the map layout of `cbor_refmt`, matching
+// text keys or else integer keys. Tags other than links are stepped through. The empty path is
+// the top-level item.
+func GetRaw(bin []byte, path string) ([]byte, error) {
+	toks, err := pointer.Split(path)
+	if err != nil {
+		return nil, err
+	}
+	off, err := walk(bin, toks)
+	if err != nil {
+		return nil, err
+	}
+	end, err := cbor.Skip(bin, off)
+	if err != nil {
+		return nil, err
+	}
+	return bin[off:end], nil
+}
+
+// walk returns the offset of the item at the path of tokens `toks` in `b`.
+func walk(b []byte, toks []string) (int, error) {
+	off := 0
+	for i := range toks {
+		h, err := container(b, off)
+		if err != nil {
+			return 0, err
+		}
+		switch h.Major {
+		case cbor.MajArray:
+			off, err = element(b, h, toks, i, false)
+		case cbor.MajMap:
+			_, off, err = entry(b, h, toks, i)
+		default:
+			err = pointer.NotFound(toks, i+1, h.Offset, "not an array or a map")
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+	return off, nil
+}
+
+// container returns the head of the item at `off` of `b`, stepping through tags other than links.
+func container(b []byte, off int) (cbor.Head, error) {
+	h, err := cbor.ReadHead(b, off)
+	for err == nil && h.Major == cbor.MajTag && h.Arg != cbor.TagLink {
+		h, err = cbor.ReadHead(b, h.End())
+	}
+	return h, err
+}
+
+// element returns the offset of the element of the array of head `h` indexed by token `i` of
+// `toks`. If `end` is set, the count of elements or "-" index the end of the array.
+func element(b []byte, h cbor.Head, toks []string, i int, end bool) (int, error) {
+	idx, ok := pointer.Index(toks[i])
+	if end && toks[i] == "-" {
+		idx, ok = -1, true
+	}
+	if !ok {
+		return 0, pointer.NotFound(toks, i+1, h.Offset, "%q is not an array index", toks[i])
+	}
+	off, n := h.End(), 0
+	for ; ; n++ {
+		if h.Indefinite() {
+			if off >= len(b) {
+				return 0, &cbor.SyntaxError{Offset: off, Msg: "unexpected end of input"}
+			}
+			if b[off] == cbor.Break {
+				break
+			}
+		} else if uint64(n) >= h.Arg {
+			break
+		}
+		if n == idx {
+			return off, nil
+		}
+		var err error
+		if off, err = cbor.Skip(b, off); err != nil {
+			return 0, err
+		}
+	}
+	if end && (idx < 0 || idx == n) {
+		return off, nil
+	}
+	return 0, pointer.NotFound(toks, i+1, h.Offset, "index %d out of range", idx)
+}
+
+// entry returns the offsets of the key and the value of the entry of the map of head `h` keyed by
+// token `i` of `toks`, preferring text keys to integer keys.
+func entry(b []byte, h cbor.Head, toks []string, i int) (int, int, error) {
+	key := []byte(toks[i])
+	n, isInt := new(big.Int).SetString(toks[i], 10)
+	intKey, intOff := -1, -1
+	off := h.End()
+	for j := uint64(0); h.Indefinite() || j < h.Arg; j++ {
+		if h.Indefinite() {
+			if off >= len(b) {
+				return 0, 0, &cbor.SyntaxError{Offset: off, Msg: "unexpected end of input"}
+			}
+			if b[off] == cbor.Break {
+				break
+			}
+		}
+		k, err := cbor.ReadHead(b, off)
+		if err != nil {
+			return 0, 0, err
+		}
+		vOff, err := cbor.Skip(b, off)
+		if err != nil {
+			return 0, 0, err
+		}
+		switch {
+		case k.Major == cbor.MajTextString:
+			s, _, err := readString(b, k)
+			if err != nil {
+				return 0, 0, err
+			}
+			if bytes.Equal(s, key) {
+				return off, vOff, nil
+			}
+		case isInt && intOff < 0 && (k.Major == cbor.MajUnsignedInt || k.Major == cbor.MajNegativeInt):
+			kn := new(big.Int).SetUint64(k.Arg)
+			if k.Major == cbor.MajNegativeInt {
+				kn.Neg(kn.Add(kn, big.NewInt(1)))
+			}
+			if kn.Cmp(n) == 0 {
+				intKey, intOff = off, vOff
+			}
+		}
+		if off, err = cbor.Skip(b, vOff); err != nil {
+			return 0, 0, err
+		}
+	}
+	if intOff >= 0 {
+		return intKey, intOff, nil
+	}
+	return 0, 0, pointer.NotFound(toks, i+1, h.Offset, "no key %q", toks[i])
+}
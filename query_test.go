@@ -0,0 +1,58 @@
+package marsha_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/daotl/go-marsha"
+	"github.com/daotl/go-marsha/cbordiag"
+)
+
+func TestGet(t *testing.T) {
+	bin := cbordiag.MustParse(`{"items": [_ {"name": "a"}, 1(["b", h'00']), [], {"name": "d", "a/b~": 4}],
+		-2: "neg", "2": "text", 2: "int", "": null}`)
+
+	for _, tc := range []struct {
+		path, want string
+	}{
+		{``, `{"items": [_ {"name": "a"}, 1(["b", h'00']), [], {"name": "d", "a/b~": 4}], -2: "neg", "2": "text", 2: "int", "": null}`},
+		{`/items/0`, `{"name": "a"}`},
+		{`/items/1/0`, `"b"`},
+		{`/items/3/name`, `"d"`},
+		{`/items/3/a~1b~0`, `4`},
+		{`/-2`, `"neg"`},
+		{`/2`, `"text"`},
+		{`/`, `null`},
+	} {
+		raw, err := marsha.GetRaw(bin, tc.path)
+		require.NoError(t, err, tc.path)
+		assert.Equal(t, cbordiag.MustParse(tc.want), raw, tc.path)
+	}
+
+	v, err := marsha.Get(bin, "/items/1")
+	require.NoError(t, err)
+	assert.True(t, marsha.NewTag(1, marsha.NewArray(marsha.NewString("b"), marsha.NewBytes([]byte{0}))).Equal(v))
+
+	for _, tc := range []struct {
+		path, want string
+	}{
+		{`/items/4`, `path not found: /items/4 at offset 7: index 4 out of range`},
+		{`/items/01`, `path not found: /items/01 at offset 7: "01" is not an array index`},
+		{`/items/0/nope`, `path not found: /items/0/nope at offset 8: no key "nope"`},
+		{`/items/3/name/0`, `path not found: /items/3/name/0 at offset 29: not an array or a map`},
+	} {
+		_, err := marsha.GetRaw(bin, tc.path)
+		assert.True(t, errors.Is(err, marsha.ErrPathNotFound), tc.path)
+		assert.EqualError(t, err, tc.want, tc.path)
+	}
+
+	_, err = marsha.GetRaw(bin, "items")
+	assert.True(t, errors.Is(err, marsha.ErrInvalidPath))
+	_, err = marsha.GetRaw(bin, "/items/~2")
+	assert.True(t, errors.Is(err, marsha.ErrInvalidPath))
+	_, err = marsha.GetRaw(bin[:20], "/2")
+	assert.True(t, errors.Is(err, marsha.ErrInvalidCBOR))
+}
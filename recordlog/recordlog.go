@@ -0,0 +1,432 @@
+// Package recordlog provides a segment-based append-only log of marsha.StructPtr records encoded
+// by the Encoder of any Marsha, with O(1) random access by record number.
+//
+// A log is a directory of segments. Each segment is a pair of files named after the number of its
+// first record: `<first>.log` holds the encoded records back to back and `<first>.idx` holds the
+// big-endian uint64 end offset of each record in `<first>.log`. A record is committed once its
+// index entry is written, so on opening, index entries pointing beyond the end of the log file and
+// log bytes after the last committed record, both left by a crash in the middle of an append, are
+// truncated.
+package recordlog
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/daotl/go-marsha"
+)
+
+var (
+	ErrClosed     = errors.New("record log closed")
+	ErrOutOfRange = errors.New("record number out of range")
+	ErrCorrupt    = errors.New("record log corrupted")
+)
+
+const (
+	logExt    = ".log"
+	idxExt    = ".idx"
+	entrySize = 8
+
+	// DefaultSegmentSize is the default size in bytes after which a new segment is started.
+	DefaultSegmentSize = 64 << 20
+)
+
+type options struct {
+	segmentSize int64
+	sync        bool
+}
+
+// Option configures a Log.
+type Option func(*options)
+
+// WithSegmentSize sets the size in bytes after which a new segment is started,
+// DefaultSegmentSize by default.
+func WithSegmentSize(n int64) Option {
+	return func(o *options) {
+		o.segmentSize = n
+	}
+}
+
+// WithSync makes Append sync the log file before writing the index entry and the index file after,
+// so that committed records survive power loss, at the cost of throughput.
+func WithSync(sync bool) Option {
+	return func(o *options) {
+		o.sync = sync
+	}
+}
+
+type segment struct {
+	first uint64
+	log   *os.File
+	idx   *os.File
+	ends  []int64 // end offset of each record in log
+}
+
+func (s *segment) bounds(i int) (int64, int64) {
+	start := int64(0)
+	if i > 0 {
+		start = s.ends[i-1]
+	}
+	return start, s.ends[i]
+}
+
+func (s *segment) size() int64 {
+	if len(s.ends) == 0 {
+		return 0
+	}
+	return s.ends[len(s.ends)-1]
+}
+
+func (s *segment) close() error {
+	err := s.log.Close()
+	if err2 := s.idx.Close(); err == nil {
+		err = err2
+	}
+	return err
+}
+
+// Log is a segment-based append-only log of records.
+// It is safe for concurrent use by multiple goroutines.
+type Log struct {
+	mu       sync.RWMutex
+	dir      string
+	m        marsha.Marsha
+	opts     options
+	segments []*segment
+	enc      marsha.Encoder // encoder writing to the last segment
+	w        *countingWriter
+	closed   bool
+}
+
+// Open opens the log in directory `dir`, creating it if needed, whose records are encoded and
+// decoded with `m`.
+func Open(dir string, m marsha.Marsha, opts ...Option) (*Log, error) {
+	o := options{segmentSize: DefaultSegmentSize}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	l := &Log{dir: dir, m: m, opts: o}
+	if err := l.load(); err != nil {
+		_ = l.closeSegments()
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *Log) load() error {
+	entries, err := os.ReadDir(l.dir)
+	if err != nil {
+		return err
+	}
+	var firsts []uint64
+	for _, e := range entries {
+		name := e.Name()
+		if !strings.HasSuffix(name, logExt) {
+			continue
+		}
+		first, err := strconv.ParseUint(strings.TrimSuffix(name, logExt), 10, 64)
+		if err != nil {
+			continue
+		}
+		firsts = append(firsts, first)
+	}
+	sort.Slice(firsts, func(i, j int) bool { return firsts[i] < firsts[j] })
+
+	next := uint64(0)
+	for i, first := range firsts {
+		if first != next {
+			return fmt.Errorf("%w: segment %d does not follow record %d", ErrCorrupt, first, next)
+		}
+		s, err := l.openSegment(first, i == len(firsts)-1)
+		if err != nil {
+			return err
+		}
+		l.segments = append(l.segments, s)
+		next = first + uint64(len(s.ends))
+	}
+	if len(l.segments) == 0 {
+		s, err := l.openSegment(0, true)
+		if err != nil {
+			return err
+		}
+		l.segments = append(l.segments, s)
+	}
+	l.resetEncoder()
+	return nil
+}
+
+// openSegment opens the segment starting at record `first`, truncating its uncommitted tail if it
+// is the last one.
+func (l *Log) openSegment(first uint64, last bool) (*segment, error) {
+	flag := os.O_RDONLY
+	if last {
+		flag = os.O_RDWR | os.O_CREATE
+	}
+	base := filepath.Join(l.dir, fmt.Sprintf("%020d", first))
+	s := &segment{first: first}
+	var err error
+	if s.log, err = os.OpenFile(base+logExt, flag, 0o644); err != nil {
+		return nil, err
+	}
+	if s.idx, err = os.OpenFile(base+idxExt, flag, 0o644); err != nil {
+		_ = s.log.Close()
+		return nil, err
+	}
+	if err = l.loadIndex(s, last); err != nil {
+		_ = s.close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (l *Log) loadIndex(s *segment, last bool) error {
+	idx, err := io.ReadAll(s.idx)
+	if err != nil {
+		return err
+	}
+	fi, err := s.log.Stat()
+	if err != nil {
+		return err
+	}
+	logSize := fi.Size()
+
+	prev := int64(0)
+	for i := 0; i+entrySize <= len(idx); i += entrySize {
+		end := int64(binary.BigEndian.Uint64(idx[i:]))
+		if end <= prev || end > logSize {
+			break
+		}
+		s.ends = append(s.ends, end)
+		prev = end
+	}
+	committed := int64(len(s.ends) * entrySize)
+	if committed == int64(len(idx)) && s.size() == logSize {
+		return nil
+	}
+	if !last {
+		return fmt.Errorf("%w: segment %d has an uncommitted tail", ErrCorrupt, s.first)
+	}
+	if err = s.idx.Truncate(committed); err != nil {
+		return err
+	}
+	if err = s.log.Truncate(s.size()); err != nil {
+		return err
+	}
+	return nil
+}
+
+// resetEncoder creates the encoder appending to the last segment.
+func (l *Log) resetEncoder() {
+	s := l.segments[len(l.segments)-1]
+	l.w = &countingWriter{f: s.log, off: s.size()}
+	l.enc = l.m.NewEncoder(l.w)
+}
+
+// Len returns the count of records in the log.
+func (l *Log) Len() uint64 {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.len()
+}
+
+func (l *Log) len() uint64 {
+	s := l.segments[len(l.segments)-1]
+	return s.first + uint64(len(s.ends))
+}
+
+// Append appends the record `p` points to and returns its record number.
+func (l *Log) Append(p marsha.StructPtr) (uint64, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return 0, ErrClosed
+	}
+
+	s := l.segments[len(l.segments)-1]
+	if s.size() >= l.opts.segmentSize && len(s.ends) > 0 {
+		if err := l.rotate(); err != nil {
+			return 0, err
+		}
+		s = l.segments[len(l.segments)-1]
+	}
+
+	start := l.w.off
+	if _, err := l.enc.EncodeStruct(p); err != nil {
+		// Drop whatever was partially written so the next record starts at a record boundary.
+		l.w.off = start
+		_ = s.log.Truncate(start)
+		return 0, err
+	}
+	if l.opts.sync {
+		if err := s.log.Sync(); err != nil {
+			return 0, err
+		}
+	}
+	var entry [entrySize]byte
+	binary.BigEndian.PutUint64(entry[:], uint64(l.w.off))
+	if _, err := s.idx.WriteAt(entry[:], int64(len(s.ends)*entrySize)); err != nil {
+		return 0, err
+	}
+	if l.opts.sync {
+		if err := s.idx.Sync(); err != nil {
+			return 0, err
+		}
+	}
+	s.ends = append(s.ends, l.w.off)
+	return s.first + uint64(len(s.ends)-1), nil
+}
+
+// rotate starts a new segment.
+func (l *Log) rotate() error {
+	s := l.segments[len(l.segments)-1]
+	if err := s.log.Sync(); err != nil {
+		return err
+	}
+	if err := s.idx.Sync(); err != nil {
+		return err
+	}
+	ns, err := l.openSegment(s.first+uint64(len(s.ends)), true)
+	if err != nil {
+		return err
+	}
+	l.segments = append(l.segments, ns)
+	l.resetEncoder()
+	return nil
+}
+
+// Read decodes record number `n` into the struct `p` points to.
+func (l *Log) Read(n uint64, p marsha.StructPtr) error {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if l.closed {
+		return ErrClosed
+	}
+	if n >= l.len() {
+		return fmt.Errorf("%w: %d", ErrOutOfRange, n)
+	}
+	i := sort.Search(len(l.segments), func(i int) bool { return l.segments[i].first > n }) - 1
+	s := l.segments[i]
+	start, end := s.bounds(int(n - s.first))
+	_, err := l.m.NewDecoder(io.NewSectionReader(s.log, start, end-start)).DecodeStruct(p)
+	return err
+}
+
+// Sync commits the log and index files of the last segment to stable storage.
+func (l *Log) Sync() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return ErrClosed
+	}
+	s := l.segments[len(l.segments)-1]
+	if err := s.log.Sync(); err != nil {
+		return err
+	}
+	return s.idx.Sync()
+}
+
+// Close syncs and closes the log.
+func (l *Log) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return ErrClosed
+	}
+	l.closed = true
+	s := l.segments[len(l.segments)-1]
+	err := s.log.Sync()
+	if err2 := s.idx.Sync(); err == nil {
+		err = err2
+	}
+	if err2 := l.closeSegments(); err == nil {
+		err = err2
+	}
+	return err
+}
+
+func (l *Log) closeSegments() (err error) {
+	for _, s := range l.segments {
+		if err2 := s.close(); err == nil {
+			err = err2
+		}
+	}
+	return err
+}
+
+// Iterator iterates over the records of a Log:
+//
+//   it := l.Reverse()
+//   for it.Next() {
+//   	if err := it.Decode(p); err != nil {
+//   		...
+//   	}
+//   }
+//
+type Iterator struct {
+	l       *Log
+	next    uint64
+	cur     uint64
+	end     uint64 // exclusive in iteration direction
+	reverse bool
+}
+
+// Iter returns an Iterator over records [`from`, Len()) in ascending order, not including records
+// appended after it is created.
+func (l *Log) Iter(from uint64) *Iterator {
+	return &Iterator{l: l, next: from, end: l.Len()}
+}
+
+// Reverse returns an Iterator over all records in descending order, starting with the last record
+// at the time it is created.
+func (l *Log) Reverse() *Iterator {
+	return &Iterator{l: l, next: l.Len(), reverse: true}
+}
+
+// Next advances the Iterator to the next record and returns false when there is none.
+func (it *Iterator) Next() bool {
+	if it.reverse {
+		if it.next == 0 {
+			return false
+		}
+		it.next--
+		it.cur = it.next
+		return true
+	}
+	if it.next >= it.end {
+		return false
+	}
+	it.cur = it.next
+	it.next++
+	return true
+}
+
+// N returns the number of the current record.
+func (it *Iterator) N() uint64 { return it.cur }
+
+// Decode decodes the current record into the struct `p` points to.
+func (it *Iterator) Decode(p marsha.StructPtr) error {
+	return it.l.Read(it.cur, p)
+}
+
+// countingWriter writes to a file at an offset it keeps track of.
+type countingWriter struct {
+	f   *os.File
+	off int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.f.WriteAt(p, w.off)
+	w.off += int64(n)
+	return n, err
+}
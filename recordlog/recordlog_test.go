@@ -0,0 +1,126 @@
+package recordlog_test
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/daotl/go-marsha"
+	cbor_refmt "github.com/daotl/go-marsha/cbor-refmt"
+	"github.com/daotl/go-marsha/cborgen"
+	"github.com/daotl/go-marsha/recordlog"
+	"github.com/daotl/go-marsha/test"
+)
+
+func record(i int) string { return fmt.Sprintf("record-%d", i) }
+
+func TestLog(t *testing.T) {
+	refmt := cbor_refmt.New()
+	refmt.Register(test.TestStruct{})
+
+	for name, mrsh := range map[string]marsha.Marsha{"cborgen": cborgen.New(), "cbor_refmt": refmt} {
+		t.Run(name, func(t *testing.T) {
+			req := require.New(t)
+			asrt := assert.New(t)
+			dir := t.TempDir()
+			const count = 50
+
+			l, err := recordlog.Open(dir, mrsh, recordlog.WithSegmentSize(64))
+			req.NoError(err)
+			for i := 0; i < count; i++ {
+				n, err := l.Append(&test.TestStruct{Data: record(i)})
+				req.NoError(err)
+				asrt.Equal(uint64(i), n)
+			}
+			asrt.Equal(uint64(count), l.Len())
+			logs, err := filepath.Glob(filepath.Join(dir, "*.log"))
+			req.NoError(err)
+			asrt.Greater(len(logs), 1)
+
+			s := &test.TestStruct{}
+			for _, i := range []int{0, 17, 33, count - 1} {
+				req.NoError(l.Read(uint64(i), s))
+				asrt.Equal(record(i), s.Data)
+			}
+			asrt.True(errors.Is(l.Read(count, s), recordlog.ErrOutOfRange))
+
+			want := count - 1
+			for it := l.Reverse(); it.Next(); want-- {
+				req.NoError(it.Decode(s))
+				asrt.Equal(uint64(want), it.N())
+				asrt.Equal(record(want), s.Data)
+			}
+			asrt.Equal(-1, want)
+			req.NoError(l.Close())
+
+			// Reopen and keep appending.
+			l, err = recordlog.Open(dir, mrsh, recordlog.WithSegmentSize(64))
+			req.NoError(err)
+			asrt.Equal(uint64(count), l.Len())
+			n, err := l.Append(&test.TestStruct{Data: record(count)})
+			req.NoError(err)
+			asrt.Equal(uint64(count), n)
+			want = 40
+			for it := l.Iter(40); it.Next(); want++ {
+				req.NoError(it.Decode(s))
+				asrt.Equal(record(want), s.Data)
+			}
+			asrt.Equal(count+1, want)
+			req.NoError(l.Close())
+		})
+	}
+}
+
+func TestTailTruncation(t *testing.T) {
+	req := require.New(t)
+	asrt := assert.New(t)
+	dir := t.TempDir()
+	mrsh := cborgen.New()
+
+	l, err := recordlog.Open(dir, mrsh)
+	req.NoError(err)
+	for i := 0; i < 3; i++ {
+		_, err = l.Append(&test.TestStruct{Data: record(i)})
+		req.NoError(err)
+	}
+	req.NoError(l.Close())
+
+	// Simulate a crash in the middle of appending two records: the first one was fully written but
+	// its index entry only partially, the second one was partially written.
+	base := filepath.Join(dir, fmt.Sprintf("%020d", 0))
+	logInfo, err := os.Stat(base + ".log")
+	req.NoError(err)
+	f, err := os.OpenFile(base+".log", os.O_APPEND|os.O_WRONLY, 0)
+	req.NoError(err)
+	_, err = f.Write([]byte{0x81, 0x68, 'r', 'e', 'c', 'o', 'r', 'd', '-', '3', 0x81, 0x68, 'r'})
+	req.NoError(err)
+	req.NoError(f.Close())
+	f, err = os.OpenFile(base+".idx", os.O_APPEND|os.O_WRONLY, 0)
+	req.NoError(err)
+	_, err = f.Write([]byte{0, 0, 0})
+	req.NoError(err)
+	req.NoError(f.Close())
+
+	l, err = recordlog.Open(dir, mrsh)
+	req.NoError(err)
+	asrt.Equal(uint64(3), l.Len())
+	fi, err := os.Stat(base + ".log")
+	req.NoError(err)
+	asrt.Equal(logInfo.Size(), fi.Size())
+	fi, err = os.Stat(base + ".idx")
+	req.NoError(err)
+	asrt.Equal(int64(3*8), fi.Size())
+
+	n, err := l.Append(&test.TestStruct{Data: record(3)})
+	req.NoError(err)
+	asrt.Equal(uint64(3), n)
+	s := &test.TestStruct{}
+	req.NoError(l.Read(3, s))
+	asrt.Equal(record(3), s.Data)
+	req.NoError(l.Close())
+}
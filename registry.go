@@ -0,0 +1,195 @@
+package marsha
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+var (
+	ErrNoRegistry     = errors.New("no type registry configured")
+	ErrNotRegistered  = errors.New("type not registered")
+	ErrTagConflict    = errors.New("type tag already registered")
+	ErrEmptyTag       = errors.New("type tag is empty")
+	ErrNotStructPtr   = errors.New("pointer to type does not implement marsha.StructPtr")
+	ErrEmptyUnion     = errors.New("union holds no value")
+	ErrNotUnionHeader = errors.New("bytes do not start with a union header")
+)
+
+// Tag identifies a concrete type registered in a Registry on the wire.
+// Each Marsha implementation uses the form that fits its encoding, so a type used with several
+// implementations should set all of the forms they need:
+//
+//   - CBOR implementations prefer CBORTag and fall back to Name if CBORTag is 0.
+//   - The Protocol Buffers implementation uses TypeURL in `google.protobuf.Any`.
+type Tag struct {
+	// CBORTag is the CBOR tag number (RFC 8949 section 3.4) wrapping values of the type.
+	// 0 means not set. Numbers already assigned by IANA, such as 42 for IPLD links, should be avoided.
+	CBORTag uint64
+
+	// Name is the string discriminator written before values of the type.
+	Name string
+
+	// TypeURL is the `google.protobuf.Any` type URL of the corresponding `proto.Message`.
+	// If empty, the Protocol Buffers implementation derives it from the message's full name.
+	TypeURL string
+}
+
+// TypeInfo describes a concrete type registered in a Registry.
+type TypeInfo struct {
+	Tag
+
+	// Type is the struct type, which implements Struct while its pointer implements StructPtr.
+	Type reflect.Type
+}
+
+// New returns a StructPtr pointing to a new zero value of the type.
+func (ti *TypeInfo) New() StructPtr {
+	return reflect.New(ti.Type).Interface().(StructPtr)
+}
+
+// Registry assigns concrete types stable tags, so that values of different types can be
+// marshaled behind a Union and unmarshaled back to their concrete types.
+// It is safe for concurrent use by multiple goroutines.
+type Registry struct {
+	mu        sync.RWMutex
+	types     []*TypeInfo
+	byType    map[reflect.Type]*TypeInfo
+	byCBORTag map[uint64]*TypeInfo
+	byName    map[string]*TypeInfo
+	byTypeURL map[string]*TypeInfo
+}
+
+// NewRegistry creates an empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{
+		byType:    make(map[reflect.Type]*TypeInfo),
+		byCBORTag: make(map[uint64]*TypeInfo),
+		byName:    make(map[string]*TypeInfo),
+		byTypeURL: make(map[string]*TypeInfo),
+	}
+}
+
+// Register a Struct type by passing an empty Struct along with its Tag.
+func (r *Registry) Register(s Struct, tag Tag) error {
+	if tag == (Tag{}) {
+		return ErrEmptyTag
+	}
+	typ := reflect.TypeOf(s)
+	if typ == nil {
+		return fmt.Errorf("%w: nil", ErrNotStructPtr)
+	}
+	if typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	if !reflect.PtrTo(typ).Implements(reflect.TypeOf((*StructPtr)(nil)).Elem()) {
+		return fmt.Errorf("%w: %s", ErrNotStructPtr, typ)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.byType[typ]; ok {
+		return fmt.Errorf("%w: %s", ErrTagConflict, typ)
+	}
+	if _, ok := r.byCBORTag[tag.CBORTag]; ok && tag.CBORTag != 0 {
+		return fmt.Errorf("%w: CBOR tag %d", ErrTagConflict, tag.CBORTag)
+	}
+	if _, ok := r.byName[tag.Name]; ok && tag.Name != "" {
+		return fmt.Errorf("%w: name %q", ErrTagConflict, tag.Name)
+	}
+	if _, ok := r.byTypeURL[tag.TypeURL]; ok && tag.TypeURL != "" {
+		return fmt.Errorf("%w: type URL %q", ErrTagConflict, tag.TypeURL)
+	}
+
+	ti := &TypeInfo{Tag: tag, Type: typ}
+	r.types = append(r.types, ti)
+	r.byType[typ] = ti
+	if tag.CBORTag != 0 {
+		r.byCBORTag[tag.CBORTag] = ti
+	}
+	if tag.Name != "" {
+		r.byName[tag.Name] = ti
+	}
+	if tag.TypeURL != "" {
+		r.byTypeURL[tag.TypeURL] = ti
+	}
+	return nil
+}
+
+// MustRegister is like Register but panics if registration fails.
+func (r *Registry) MustRegister(s Struct, tag Tag) {
+	if err := r.Register(s, tag); err != nil {
+		panic(err)
+	}
+}
+
+// Lookup returns the TypeInfo of the concrete type `p` points to.
+func (r *Registry) Lookup(p StructPtr) (*TypeInfo, bool) {
+	typ := reflect.TypeOf(p)
+	if typ == nil || typ.Kind() != reflect.Ptr {
+		return nil, false
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	ti, ok := r.byType[typ.Elem()]
+	return ti, ok
+}
+
+// ByCBORTag returns the TypeInfo registered with CBOR tag number `tag`.
+func (r *Registry) ByCBORTag(tag uint64) (*TypeInfo, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	ti, ok := r.byCBORTag[tag]
+	return ti, ok
+}
+
+// ByName returns the TypeInfo registered with string discriminator `name`.
+func (r *Registry) ByName(name string) (*TypeInfo, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	ti, ok := r.byName[name]
+	return ti, ok
+}
+
+// ByTypeURL returns the TypeInfo registered with `google.protobuf.Any` type URL `url`.
+func (r *Registry) ByTypeURL(url string) (*TypeInfo, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	ti, ok := r.byTypeURL[url]
+	return ti, ok
+}
+
+// Types returns all registered types in registration order.
+func (r *Registry) Types() []*TypeInfo {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return append([]*TypeInfo(nil), r.types...)
+}
+
+// Union holds a value of any concrete type registered in the Registry of the Marsha it is
+// marshaled with. Unmarshaling a Union sets Value to a pointer to a new value of the concrete
+// type identified by the encoded tag.
+type Union struct {
+	Value StructPtr
+}
+
+func (u Union) Ptr() StructPtr { return &u }
+func (u *Union) Val() Struct   { return *u }
+
+// Unions is a heterogeneous struct slice whose elements are marshaled as Unions.
+type Unions []Union
+
+func (s *Unions) Val() []StructPtr {
+	ptrs := make([]StructPtr, 0, len(*s))
+	for i := range *s {
+		ptrs = append(ptrs, &(*s)[i])
+	}
+	return ptrs
+}
+
+// NewStructPtr returns a pointer to an empty Union.
+func (*Unions) NewStructPtr() StructPtr { return &Union{} }
+
+// Append appends the Union `u` points to.
+func (s *Unions) Append(u *Union) { *s = append(*s, *u) }
@@ -0,0 +1,19 @@
+package marsha_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/daotl/go-marsha"
+	"github.com/daotl/go-marsha/test"
+)
+
+func TestRegister(t *testing.T) {
+	reg := marsha.NewRegistry()
+	assert.NoError(t, reg.Register(test.TestStruct{}, marsha.Tag{Name: "test"}))
+	assert.True(t, errors.Is(reg.Register(test.TestStruct2{}, marsha.Tag{Name: "test"}), marsha.ErrTagConflict))
+	assert.True(t, errors.Is(reg.Register(test.TestStruct2{}, marsha.Tag{}), marsha.ErrEmptyTag))
+	assert.True(t, errors.Is(reg.Register(nil, marsha.Tag{Name: "nil"}), marsha.ErrNotStructPtr))
+}
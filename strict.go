@@ -0,0 +1,32 @@
+package marsha
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrTrailingData = errors.New("trailing data after data item")
+	ErrUnknownField = errors.New("unknown field")
+)
+
+// StrictError reports input rejected by strict unmarshaling (see WithStrict) or UnknownError though
+// it could be unmarshaled otherwise. Errors reporting malformed or non-canonical CBOR wrap ErrInvalidCBOR or
+// ErrNotCanonical instead, and also report offsets.
+type StrictError struct {
+	// Offset is the offset of the offending part of the input.
+	Offset int
+	// Path is the path of the offending item from the top-level item, like a JSON Pointer, if any.
+	Path string
+	// Err is ErrTrailingData or ErrUnknownField.
+	Err error
+}
+
+func (e *StrictError) Error() string {
+	if e.Path != "" {
+		return fmt.Sprintf("%s %q at offset %d", e.Err, e.Path, e.Offset)
+	}
+	return fmt.Sprintf("%s at offset %d", e.Err, e.Offset)
+}
+
+func (e *StrictError) Unwrap() error { return e.Err }
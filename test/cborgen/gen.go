@@ -3,12 +3,34 @@ package main
 import (
 	cbg "github.com/daotl/cbor-gen"
 
+	"github.com/daotl/go-marsha/cborgen/lazygen"
+	"github.com/daotl/go-marsha/cddl"
+	"github.com/daotl/go-marsha/jsonschema"
+	"github.com/daotl/go-marsha/protogen"
 	"github.com/daotl/go-marsha/test"
 )
 
 func main() {
+	models := []interface{}{test.TestStruct{}, test.TestStruct2{}, test.TestLinked{},
+		test.TestStructV2{}, test.TestChildV2{}, test.TestStructV1{}, test.TestChildV1{},
+		test.TestPersonV1{}, test.TestPersonV2{}, test.TestPerson{}}
 	if err := cbg.WriteTupleEncodersToFile("test/models_cbor.go",
-		"test", true, nil, test.TestStruct{}, test.TestStruct2{}); err != nil {
+		"test", true, nil, append(models, test.TestInts{})...); err != nil {
+		panic(err)
+	}
+	if err := lazygen.WriteFile("test/models_lazy.go", "test", test.TestStruct{},
+		test.TestStruct2{}, test.TestLinked{}, test.TestStructV2{}, test.TestChildV2{},
+		test.TestPerson{}); err != nil {
+		panic(err)
+	}
+	if err := cddl.WriteFile("test/models.cddl", cddl.Tuple, models...); err != nil {
+		panic(err)
+	}
+	if err := jsonschema.WriteFile("test/models.schema.json", models...); err != nil {
+		panic(err)
+	}
+	if err := protogen.WriteFile("test/models.proto", "test/models.proto.lock", "test",
+		"github.com/daotl/go-marsha/test/pb", models...); err != nil {
 		panic(err)
 	}
 }
@@ -1,6 +1,8 @@
 package test
 
 import (
+	"github.com/ipfs/go-cid"
+
 	"github.com/daotl/go-marsha"
 	"github.com/daotl/go-marsha/cborgen"
 )
@@ -31,3 +33,97 @@ type TestStruct2 struct {
 
 func (s TestStruct2) Ptr() marsha.StructPtr { return &s }
 func (s *TestStruct2) Val() marsha.Struct   { return *s }
+
+// TestLinked links to up to two other models by CID.
+type TestLinked struct {
+	Name  string
+	Left  *cid.Cid
+	Right *cid.Cid
+}
+
+func (s TestLinked) Ptr() marsha.StructPtr { return &s }
+func (s *TestLinked) Val() marsha.Struct   { return *s }
+
+// NewRegistry returns a marsha.Registry with TestStruct registered by CBOR tag and TestStruct2
+// registered by name.
+func NewRegistry() *marsha.Registry {
+	reg := marsha.NewRegistry()
+	reg.MustRegister(TestStruct{}, marsha.Tag{CBORTag: 80001})
+	reg.MustRegister(TestStruct2{}, marsha.Tag{Name: "test2"})
+	return reg
+}
+
+// TestStructV2 is a newer version of TestStructV1 with more fields.
+type TestStructV2 struct {
+	Name  string
+	Child *TestChildV2
+	Age   int64
+}
+
+func (s TestStructV2) Ptr() marsha.StructPtr { return &s }
+func (s *TestStructV2) Val() marsha.Struct   { return *s }
+
+type TestChildV2 struct {
+	ID   int64
+	Note string
+}
+
+// TestStructV1 is an older version of TestStructV2 which keeps the fields it doesn't know.
+type TestStructV1 struct {
+	marsha.Unknown
+	Name  string
+	Child *TestChildV1
+}
+
+func (s TestStructV1) Ptr() marsha.StructPtr { return &s }
+func (s *TestStructV1) Val() marsha.Struct   { return *s }
+
+type TestChildV1 struct {
+	marsha.Unknown
+	ID int64
+}
+
+// TestPersonV1 is the first version of TestPerson.
+type TestPersonV1 struct {
+	Version uint64
+	Name    string
+}
+
+func (s TestPersonV1) Ptr() marsha.StructPtr { return &s }
+func (s *TestPersonV1) Val() marsha.Struct   { return *s }
+func (TestPersonV1) ModelVersion() uint64    { return 1 }
+
+// TestPersonV2 is the second version of TestPerson, with the name split.
+type TestPersonV2 struct {
+	Version uint64
+	First   string
+	Last    string
+}
+
+func (s TestPersonV2) Ptr() marsha.StructPtr { return &s }
+func (s *TestPersonV2) Val() marsha.Struct   { return *s }
+func (TestPersonV2) ModelVersion() uint64    { return 2 }
+
+// TestPerson is the current version of a model with a leading version field.
+type TestPerson struct {
+	Version uint64
+	First   string
+	Last    string
+	Age     int64
+}
+
+func (s TestPerson) Ptr() marsha.StructPtr { return &s }
+func (s *TestPerson) Val() marsha.Struct   { return *s }
+func (TestPerson) ModelVersion() uint64    { return 3 }
+
+// TestInts has integers narrower than 64 bits, to test that decoders check their ranges. The code
+// generated by cbor-gen decodes the integers of a struct other than uint64 at the width of the
+// first one, so they are of the same width.
+type TestInts struct {
+	I16 int16
+	U16 uint16
+	U64 uint64
+}
+
+func (s TestInts) Ptr() marsha.StructPtr { return &s }
+func (s *TestInts) Val() marsha.Struct   { return *s }
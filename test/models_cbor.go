@@ -177,3 +177,1122 @@ func (t *TestStruct2) UnmarshalCBOR(r io.Reader) (int, error) {
 	}
 	return bytesRead, nil
 }
+
+func (t *TestLinked) InitNilEmbeddedStruct() {
+	if t != nil {
+	}
+}
+
+var lengthBufTestLinked = []byte{131}
+
+func (t *TestLinked) MarshalCBOR(w io.Writer) (n int, err error) {
+	if t == nil {
+		return w.Write(cbg.CborNull)
+	}
+	t.InitNilEmbeddedStruct()
+	if n_, err := w.Write(lengthBufTestLinked); err != nil {
+		return n_, err
+	} else {
+		n += n_
+	}
+
+	scratch := make([]byte, 9)
+
+	// t.Name (string) (string)
+	if len(t.Name) > cbg.MaxLength {
+		return n, xerrors.Errorf("Value in field t.Name was too long")
+	}
+
+	if n_, err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len(t.Name))); err != nil {
+		return n + n_, err
+	} else {
+		n += n_
+	}
+	if n_, err := io.WriteString(w, string(t.Name)); err != nil {
+		return n + n_, err
+	} else {
+		n += n_
+	}
+
+	// t.Left (cid.Cid) (struct)
+
+	if t.Left == nil {
+		if n_, err := w.Write(cbg.CborNull); err != nil {
+			return n + n_, err
+		} else {
+			n += n_
+		}
+	} else {
+		if n_, err := cbg.WriteCidBuf(scratch, w, *t.Left); err != nil {
+			return n + n_, xerrors.Errorf("failed to write cid field t.Left: %w", err)
+		} else {
+			n += n_
+		}
+	}
+
+	// t.Right (cid.Cid) (struct)
+
+	if t.Right == nil {
+		if n_, err := w.Write(cbg.CborNull); err != nil {
+			return n + n_, err
+		} else {
+			n += n_
+		}
+	} else {
+		if n_, err := cbg.WriteCidBuf(scratch, w, *t.Right); err != nil {
+			return n + n_, xerrors.Errorf("failed to write cid field t.Right: %w", err)
+		} else {
+			n += n_
+		}
+	}
+
+	return n, nil
+}
+
+func (t *TestLinked) UnmarshalCBOR(r io.Reader) (int, error) {
+	bytesRead := 0
+	*t = TestLinked{}
+	t.InitNilEmbeddedStruct()
+
+	br := cbg.GetPeeker(r)
+	scratch := make([]byte, 8)
+
+	maj, extra, read, err := cbg.CborReadHeaderBuf(br, scratch)
+	if err != nil {
+		return bytesRead, err
+	}
+	bytesRead += read
+	if maj != cbg.MajArray {
+		return bytesRead, fmt.Errorf("cbor input should be of type array")
+	}
+
+	if extra != 3 {
+		return bytesRead, fmt.Errorf("cbor input had wrong number of fields")
+	}
+
+	// t.Name (string) (string)
+
+	{
+		sval, read, err := cbg.ReadStringBuf(br, scratch)
+		if err != nil {
+			return bytesRead, err
+		}
+		bytesRead += read
+
+		t.Name = string(sval)
+	}
+	// t.Left (cid.Cid) (struct)
+
+	{
+
+		b, err := br.ReadByte()
+		if err != nil {
+			return bytesRead, err
+		}
+		bytesRead++
+		if b != cbg.CborNull[0] {
+			if err := br.UnreadByte(); err != nil {
+				return bytesRead, err
+			}
+			bytesRead--
+
+			c, read, err := cbg.ReadCid(br)
+			if err != nil {
+				return bytesRead, xerrors.Errorf("failed to read cid field t.Left: %w", err)
+			}
+			bytesRead += read
+
+			t.Left = &c
+		}
+
+	}
+	// t.Right (cid.Cid) (struct)
+
+	{
+
+		b, err := br.ReadByte()
+		if err != nil {
+			return bytesRead, err
+		}
+		bytesRead++
+		if b != cbg.CborNull[0] {
+			if err := br.UnreadByte(); err != nil {
+				return bytesRead, err
+			}
+			bytesRead--
+
+			c, read, err := cbg.ReadCid(br)
+			if err != nil {
+				return bytesRead, xerrors.Errorf("failed to read cid field t.Right: %w", err)
+			}
+			bytesRead += read
+
+			t.Right = &c
+		}
+
+	}
+	return bytesRead, nil
+}
+
+func (t *TestStructV2) InitNilEmbeddedStruct() {
+	if t != nil {
+	}
+}
+
+var lengthBufTestStructV2 = []byte{131}
+
+func (t *TestStructV2) MarshalCBOR(w io.Writer) (n int, err error) {
+	if t == nil {
+		return w.Write(cbg.CborNull)
+	}
+	t.InitNilEmbeddedStruct()
+	if n_, err := w.Write(lengthBufTestStructV2); err != nil {
+		return n_, err
+	} else {
+		n += n_
+	}
+
+	scratch := make([]byte, 9)
+
+	// t.Name (string) (string)
+	if len(t.Name) > cbg.MaxLength {
+		return n, xerrors.Errorf("Value in field t.Name was too long")
+	}
+
+	if n_, err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len(t.Name))); err != nil {
+		return n + n_, err
+	} else {
+		n += n_
+	}
+	if n_, err := io.WriteString(w, string(t.Name)); err != nil {
+		return n + n_, err
+	} else {
+		n += n_
+	}
+
+	// t.Child (test.TestChildV2) (struct)
+	if n_, err := t.Child.MarshalCBOR(w); err != nil {
+		return n + n_, err
+	} else {
+		n += n_
+	}
+
+	// t.Age (int64) (int64)
+	if t.Age >= 0 {
+		if n_, err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajUnsignedInt, uint64(t.Age)); err != nil {
+			return n + n_, err
+		} else {
+			n += n_
+		}
+	} else {
+		if n_, err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajNegativeInt, uint64(-t.Age-1)); err != nil {
+			return n + n_, err
+		} else {
+			n += n_
+		}
+	}
+	return n, nil
+}
+
+func (t *TestStructV2) UnmarshalCBOR(r io.Reader) (int, error) {
+	bytesRead := 0
+	*t = TestStructV2{}
+	t.InitNilEmbeddedStruct()
+
+	br := cbg.GetPeeker(r)
+	scratch := make([]byte, 8)
+
+	maj, extra, read, err := cbg.CborReadHeaderBuf(br, scratch)
+	if err != nil {
+		return bytesRead, err
+	}
+	bytesRead += read
+	if maj != cbg.MajArray {
+		return bytesRead, fmt.Errorf("cbor input should be of type array")
+	}
+
+	if extra != 3 {
+		return bytesRead, fmt.Errorf("cbor input had wrong number of fields")
+	}
+
+	// t.Name (string) (string)
+
+	{
+		sval, read, err := cbg.ReadStringBuf(br, scratch)
+		if err != nil {
+			return bytesRead, err
+		}
+		bytesRead += read
+
+		t.Name = string(sval)
+	}
+	// t.Child (test.TestChildV2) (struct)
+
+	{
+
+		b, err := br.ReadByte()
+		if err != nil {
+			return bytesRead, err
+		}
+		bytesRead++
+		if b != cbg.CborNull[0] {
+			if err := br.UnreadByte(); err != nil {
+				return bytesRead, err
+			}
+			bytesRead--
+			t.Child = new(TestChildV2)
+			if read, err := t.Child.UnmarshalCBOR(br); err != nil {
+				return bytesRead, xerrors.Errorf("unmarshaling t.Child pointer: %w", err)
+			} else {
+				bytesRead += read
+			}
+		}
+
+	}
+	// t.Age (int64) (int64)
+	{
+		maj, extra, read, err := cbg.CborReadHeaderBuf(br, scratch)
+		var extraI int64
+		if err != nil {
+			return bytesRead, err
+		}
+		bytesRead += read
+		switch maj {
+		case cbg.MajUnsignedInt:
+			extraI = int64(extra)
+			if extraI < 0 {
+				return bytesRead, fmt.Errorf("int64 positive overflow")
+			}
+		case cbg.MajNegativeInt:
+			extraI = int64(extra)
+			if extraI < 0 {
+				return bytesRead, fmt.Errorf("int64 negative oveflow")
+			}
+			extraI = -1 - extraI
+		default:
+			return bytesRead, fmt.Errorf("wrong type for int64 field: %d", maj)
+		}
+
+		t.Age = int64(extraI)
+	}
+	return bytesRead, nil
+}
+
+func (t *TestChildV2) InitNilEmbeddedStruct() {
+	if t != nil {
+	}
+}
+
+var lengthBufTestChildV2 = []byte{130}
+
+func (t *TestChildV2) MarshalCBOR(w io.Writer) (n int, err error) {
+	if t == nil {
+		return w.Write(cbg.CborNull)
+	}
+	t.InitNilEmbeddedStruct()
+	if n_, err := w.Write(lengthBufTestChildV2); err != nil {
+		return n_, err
+	} else {
+		n += n_
+	}
+
+	scratch := make([]byte, 9)
+
+	// t.ID (int64) (int64)
+	if t.ID >= 0 {
+		if n_, err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajUnsignedInt, uint64(t.ID)); err != nil {
+			return n + n_, err
+		} else {
+			n += n_
+		}
+	} else {
+		if n_, err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajNegativeInt, uint64(-t.ID-1)); err != nil {
+			return n + n_, err
+		} else {
+			n += n_
+		}
+	}
+
+	// t.Note (string) (string)
+	if len(t.Note) > cbg.MaxLength {
+		return n, xerrors.Errorf("Value in field t.Note was too long")
+	}
+
+	if n_, err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len(t.Note))); err != nil {
+		return n + n_, err
+	} else {
+		n += n_
+	}
+	if n_, err := io.WriteString(w, string(t.Note)); err != nil {
+		return n + n_, err
+	} else {
+		n += n_
+	}
+	return n, nil
+}
+
+func (t *TestChildV2) UnmarshalCBOR(r io.Reader) (int, error) {
+	bytesRead := 0
+	*t = TestChildV2{}
+	t.InitNilEmbeddedStruct()
+
+	br := cbg.GetPeeker(r)
+	scratch := make([]byte, 8)
+
+	maj, extra, read, err := cbg.CborReadHeaderBuf(br, scratch)
+	if err != nil {
+		return bytesRead, err
+	}
+	bytesRead += read
+	if maj != cbg.MajArray {
+		return bytesRead, fmt.Errorf("cbor input should be of type array")
+	}
+
+	if extra != 2 {
+		return bytesRead, fmt.Errorf("cbor input had wrong number of fields")
+	}
+
+	// t.ID (int64) (int64)
+	{
+		maj, extra, read, err := cbg.CborReadHeaderBuf(br, scratch)
+		var extraI int64
+		if err != nil {
+			return bytesRead, err
+		}
+		bytesRead += read
+		switch maj {
+		case cbg.MajUnsignedInt:
+			extraI = int64(extra)
+			if extraI < 0 {
+				return bytesRead, fmt.Errorf("int64 positive overflow")
+			}
+		case cbg.MajNegativeInt:
+			extraI = int64(extra)
+			if extraI < 0 {
+				return bytesRead, fmt.Errorf("int64 negative oveflow")
+			}
+			extraI = -1 - extraI
+		default:
+			return bytesRead, fmt.Errorf("wrong type for int64 field: %d", maj)
+		}
+
+		t.ID = int64(extraI)
+	}
+	// t.Note (string) (string)
+
+	{
+		sval, read, err := cbg.ReadStringBuf(br, scratch)
+		if err != nil {
+			return bytesRead, err
+		}
+		bytesRead += read
+
+		t.Note = string(sval)
+	}
+	return bytesRead, nil
+}
+
+func (t *TestStructV1) InitNilEmbeddedStruct() {
+	if t != nil {
+	}
+}
+
+var lengthBufTestStructV1 = []byte{130}
+
+func (t *TestStructV1) MarshalCBOR(w io.Writer) (n int, err error) {
+	if t == nil {
+		return w.Write(cbg.CborNull)
+	}
+	t.InitNilEmbeddedStruct()
+	if n_, err := w.Write(lengthBufTestStructV1); err != nil {
+		return n_, err
+	} else {
+		n += n_
+	}
+
+	scratch := make([]byte, 9)
+
+	// t.Name (string) (string)
+	if len(t.Name) > cbg.MaxLength {
+		return n, xerrors.Errorf("Value in field t.Name was too long")
+	}
+
+	if n_, err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len(t.Name))); err != nil {
+		return n + n_, err
+	} else {
+		n += n_
+	}
+	if n_, err := io.WriteString(w, string(t.Name)); err != nil {
+		return n + n_, err
+	} else {
+		n += n_
+	}
+
+	// t.Child (test.TestChildV1) (struct)
+	if n_, err := t.Child.MarshalCBOR(w); err != nil {
+		return n + n_, err
+	} else {
+		n += n_
+	}
+	return n, nil
+}
+
+func (t *TestStructV1) UnmarshalCBOR(r io.Reader) (int, error) {
+	bytesRead := 0
+	*t = TestStructV1{}
+	t.InitNilEmbeddedStruct()
+
+	br := cbg.GetPeeker(r)
+	scratch := make([]byte, 8)
+
+	maj, extra, read, err := cbg.CborReadHeaderBuf(br, scratch)
+	if err != nil {
+		return bytesRead, err
+	}
+	bytesRead += read
+	if maj != cbg.MajArray {
+		return bytesRead, fmt.Errorf("cbor input should be of type array")
+	}
+
+	if extra != 2 {
+		return bytesRead, fmt.Errorf("cbor input had wrong number of fields")
+	}
+
+	// t.Name (string) (string)
+
+	{
+		sval, read, err := cbg.ReadStringBuf(br, scratch)
+		if err != nil {
+			return bytesRead, err
+		}
+		bytesRead += read
+
+		t.Name = string(sval)
+	}
+	// t.Child (test.TestChildV1) (struct)
+
+	{
+
+		b, err := br.ReadByte()
+		if err != nil {
+			return bytesRead, err
+		}
+		bytesRead++
+		if b != cbg.CborNull[0] {
+			if err := br.UnreadByte(); err != nil {
+				return bytesRead, err
+			}
+			bytesRead--
+			t.Child = new(TestChildV1)
+			if read, err := t.Child.UnmarshalCBOR(br); err != nil {
+				return bytesRead, xerrors.Errorf("unmarshaling t.Child pointer: %w", err)
+			} else {
+				bytesRead += read
+			}
+		}
+
+	}
+	return bytesRead, nil
+}
+
+func (t *TestChildV1) InitNilEmbeddedStruct() {
+	if t != nil {
+	}
+}
+
+var lengthBufTestChildV1 = []byte{129}
+
+func (t *TestChildV1) MarshalCBOR(w io.Writer) (n int, err error) {
+	if t == nil {
+		return w.Write(cbg.CborNull)
+	}
+	t.InitNilEmbeddedStruct()
+	if n_, err := w.Write(lengthBufTestChildV1); err != nil {
+		return n_, err
+	} else {
+		n += n_
+	}
+
+	scratch := make([]byte, 9)
+
+	// t.ID (int64) (int64)
+	if t.ID >= 0 {
+		if n_, err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajUnsignedInt, uint64(t.ID)); err != nil {
+			return n + n_, err
+		} else {
+			n += n_
+		}
+	} else {
+		if n_, err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajNegativeInt, uint64(-t.ID-1)); err != nil {
+			return n + n_, err
+		} else {
+			n += n_
+		}
+	}
+	return n, nil
+}
+
+func (t *TestChildV1) UnmarshalCBOR(r io.Reader) (int, error) {
+	bytesRead := 0
+	*t = TestChildV1{}
+	t.InitNilEmbeddedStruct()
+
+	br := cbg.GetPeeker(r)
+	scratch := make([]byte, 8)
+
+	maj, extra, read, err := cbg.CborReadHeaderBuf(br, scratch)
+	if err != nil {
+		return bytesRead, err
+	}
+	bytesRead += read
+	if maj != cbg.MajArray {
+		return bytesRead, fmt.Errorf("cbor input should be of type array")
+	}
+
+	if extra != 1 {
+		return bytesRead, fmt.Errorf("cbor input had wrong number of fields")
+	}
+
+	// t.ID (int64) (int64)
+	{
+		maj, extra, read, err := cbg.CborReadHeaderBuf(br, scratch)
+		var extraI int64
+		if err != nil {
+			return bytesRead, err
+		}
+		bytesRead += read
+		switch maj {
+		case cbg.MajUnsignedInt:
+			extraI = int64(extra)
+			if extraI < 0 {
+				return bytesRead, fmt.Errorf("int64 positive overflow")
+			}
+		case cbg.MajNegativeInt:
+			extraI = int64(extra)
+			if extraI < 0 {
+				return bytesRead, fmt.Errorf("int64 negative oveflow")
+			}
+			extraI = -1 - extraI
+		default:
+			return bytesRead, fmt.Errorf("wrong type for int64 field: %d", maj)
+		}
+
+		t.ID = int64(extraI)
+	}
+	return bytesRead, nil
+}
+
+func (t *TestPersonV1) InitNilEmbeddedStruct() {
+	if t != nil {
+	}
+}
+
+var lengthBufTestPersonV1 = []byte{130}
+
+func (t *TestPersonV1) MarshalCBOR(w io.Writer) (n int, err error) {
+	if t == nil {
+		return w.Write(cbg.CborNull)
+	}
+	t.InitNilEmbeddedStruct()
+	if n_, err := w.Write(lengthBufTestPersonV1); err != nil {
+		return n_, err
+	} else {
+		n += n_
+	}
+
+	scratch := make([]byte, 9)
+
+	// t.Version (uint64) (uint64)
+
+	if n_, err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajUnsignedInt, uint64(t.Version)); err != nil {
+		return n + n_, err
+	} else {
+		n += n_
+	}
+
+	// t.Name (string) (string)
+	if len(t.Name) > cbg.MaxLength {
+		return n, xerrors.Errorf("Value in field t.Name was too long")
+	}
+
+	if n_, err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len(t.Name))); err != nil {
+		return n + n_, err
+	} else {
+		n += n_
+	}
+	if n_, err := io.WriteString(w, string(t.Name)); err != nil {
+		return n + n_, err
+	} else {
+		n += n_
+	}
+	return n, nil
+}
+
+func (t *TestPersonV1) UnmarshalCBOR(r io.Reader) (int, error) {
+	bytesRead := 0
+	*t = TestPersonV1{}
+	t.InitNilEmbeddedStruct()
+
+	br := cbg.GetPeeker(r)
+	scratch := make([]byte, 8)
+
+	maj, extra, read, err := cbg.CborReadHeaderBuf(br, scratch)
+	if err != nil {
+		return bytesRead, err
+	}
+	bytesRead += read
+	if maj != cbg.MajArray {
+		return bytesRead, fmt.Errorf("cbor input should be of type array")
+	}
+
+	if extra != 2 {
+		return bytesRead, fmt.Errorf("cbor input had wrong number of fields")
+	}
+
+	// t.Version (uint64) (uint64)
+
+	{
+
+		maj, extra, read, err = cbg.CborReadHeaderBuf(br, scratch)
+		if err != nil {
+			return bytesRead, err
+		}
+		bytesRead += read
+		if maj != cbg.MajUnsignedInt {
+			return bytesRead, fmt.Errorf("wrong type for uint64 field")
+		}
+		t.Version = uint64(extra)
+
+	}
+	// t.Name (string) (string)
+
+	{
+		sval, read, err := cbg.ReadStringBuf(br, scratch)
+		if err != nil {
+			return bytesRead, err
+		}
+		bytesRead += read
+
+		t.Name = string(sval)
+	}
+	return bytesRead, nil
+}
+
+func (t *TestPersonV2) InitNilEmbeddedStruct() {
+	if t != nil {
+	}
+}
+
+var lengthBufTestPersonV2 = []byte{131}
+
+func (t *TestPersonV2) MarshalCBOR(w io.Writer) (n int, err error) {
+	if t == nil {
+		return w.Write(cbg.CborNull)
+	}
+	t.InitNilEmbeddedStruct()
+	if n_, err := w.Write(lengthBufTestPersonV2); err != nil {
+		return n_, err
+	} else {
+		n += n_
+	}
+
+	scratch := make([]byte, 9)
+
+	// t.Version (uint64) (uint64)
+
+	if n_, err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajUnsignedInt, uint64(t.Version)); err != nil {
+		return n + n_, err
+	} else {
+		n += n_
+	}
+
+	// t.First (string) (string)
+	if len(t.First) > cbg.MaxLength {
+		return n, xerrors.Errorf("Value in field t.First was too long")
+	}
+
+	if n_, err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len(t.First))); err != nil {
+		return n + n_, err
+	} else {
+		n += n_
+	}
+	if n_, err := io.WriteString(w, string(t.First)); err != nil {
+		return n + n_, err
+	} else {
+		n += n_
+	}
+
+	// t.Last (string) (string)
+	if len(t.Last) > cbg.MaxLength {
+		return n, xerrors.Errorf("Value in field t.Last was too long")
+	}
+
+	if n_, err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len(t.Last))); err != nil {
+		return n + n_, err
+	} else {
+		n += n_
+	}
+	if n_, err := io.WriteString(w, string(t.Last)); err != nil {
+		return n + n_, err
+	} else {
+		n += n_
+	}
+	return n, nil
+}
+
+func (t *TestPersonV2) UnmarshalCBOR(r io.Reader) (int, error) {
+	bytesRead := 0
+	*t = TestPersonV2{}
+	t.InitNilEmbeddedStruct()
+
+	br := cbg.GetPeeker(r)
+	scratch := make([]byte, 8)
+
+	maj, extra, read, err := cbg.CborReadHeaderBuf(br, scratch)
+	if err != nil {
+		return bytesRead, err
+	}
+	bytesRead += read
+	if maj != cbg.MajArray {
+		return bytesRead, fmt.Errorf("cbor input should be of type array")
+	}
+
+	if extra != 3 {
+		return bytesRead, fmt.Errorf("cbor input had wrong number of fields")
+	}
+
+	// t.Version (uint64) (uint64)
+
+	{
+
+		maj, extra, read, err = cbg.CborReadHeaderBuf(br, scratch)
+		if err != nil {
+			return bytesRead, err
+		}
+		bytesRead += read
+		if maj != cbg.MajUnsignedInt {
+			return bytesRead, fmt.Errorf("wrong type for uint64 field")
+		}
+		t.Version = uint64(extra)
+
+	}
+	// t.First (string) (string)
+
+	{
+		sval, read, err := cbg.ReadStringBuf(br, scratch)
+		if err != nil {
+			return bytesRead, err
+		}
+		bytesRead += read
+
+		t.First = string(sval)
+	}
+	// t.Last (string) (string)
+
+	{
+		sval, read, err := cbg.ReadStringBuf(br, scratch)
+		if err != nil {
+			return bytesRead, err
+		}
+		bytesRead += read
+
+		t.Last = string(sval)
+	}
+	return bytesRead, nil
+}
+
+func (t *TestPerson) InitNilEmbeddedStruct() {
+	if t != nil {
+	}
+}
+
+var lengthBufTestPerson = []byte{132}
+
+func (t *TestPerson) MarshalCBOR(w io.Writer) (n int, err error) {
+	if t == nil {
+		return w.Write(cbg.CborNull)
+	}
+	t.InitNilEmbeddedStruct()
+	if n_, err := w.Write(lengthBufTestPerson); err != nil {
+		return n_, err
+	} else {
+		n += n_
+	}
+
+	scratch := make([]byte, 9)
+
+	// t.Version (uint64) (uint64)
+
+	if n_, err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajUnsignedInt, uint64(t.Version)); err != nil {
+		return n + n_, err
+	} else {
+		n += n_
+	}
+
+	// t.First (string) (string)
+	if len(t.First) > cbg.MaxLength {
+		return n, xerrors.Errorf("Value in field t.First was too long")
+	}
+
+	if n_, err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len(t.First))); err != nil {
+		return n + n_, err
+	} else {
+		n += n_
+	}
+	if n_, err := io.WriteString(w, string(t.First)); err != nil {
+		return n + n_, err
+	} else {
+		n += n_
+	}
+
+	// t.Last (string) (string)
+	if len(t.Last) > cbg.MaxLength {
+		return n, xerrors.Errorf("Value in field t.Last was too long")
+	}
+
+	if n_, err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len(t.Last))); err != nil {
+		return n + n_, err
+	} else {
+		n += n_
+	}
+	if n_, err := io.WriteString(w, string(t.Last)); err != nil {
+		return n + n_, err
+	} else {
+		n += n_
+	}
+
+	// t.Age (int64) (int64)
+	if t.Age >= 0 {
+		if n_, err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajUnsignedInt, uint64(t.Age)); err != nil {
+			return n + n_, err
+		} else {
+			n += n_
+		}
+	} else {
+		if n_, err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajNegativeInt, uint64(-t.Age-1)); err != nil {
+			return n + n_, err
+		} else {
+			n += n_
+		}
+	}
+	return n, nil
+}
+
+func (t *TestPerson) UnmarshalCBOR(r io.Reader) (int, error) {
+	bytesRead := 0
+	*t = TestPerson{}
+	t.InitNilEmbeddedStruct()
+
+	br := cbg.GetPeeker(r)
+	scratch := make([]byte, 8)
+
+	maj, extra, read, err := cbg.CborReadHeaderBuf(br, scratch)
+	if err != nil {
+		return bytesRead, err
+	}
+	bytesRead += read
+	if maj != cbg.MajArray {
+		return bytesRead, fmt.Errorf("cbor input should be of type array")
+	}
+
+	if extra != 4 {
+		return bytesRead, fmt.Errorf("cbor input had wrong number of fields")
+	}
+
+	// t.Version (uint64) (uint64)
+
+	{
+
+		maj, extra, read, err = cbg.CborReadHeaderBuf(br, scratch)
+		if err != nil {
+			return bytesRead, err
+		}
+		bytesRead += read
+		if maj != cbg.MajUnsignedInt {
+			return bytesRead, fmt.Errorf("wrong type for uint64 field")
+		}
+		t.Version = uint64(extra)
+
+	}
+	// t.First (string) (string)
+
+	{
+		sval, read, err := cbg.ReadStringBuf(br, scratch)
+		if err != nil {
+			return bytesRead, err
+		}
+		bytesRead += read
+
+		t.First = string(sval)
+	}
+	// t.Last (string) (string)
+
+	{
+		sval, read, err := cbg.ReadStringBuf(br, scratch)
+		if err != nil {
+			return bytesRead, err
+		}
+		bytesRead += read
+
+		t.Last = string(sval)
+	}
+	// t.Age (int64) (int64)
+	{
+		maj, extra, read, err := cbg.CborReadHeaderBuf(br, scratch)
+		var extraI int64
+		if err != nil {
+			return bytesRead, err
+		}
+		bytesRead += read
+		switch maj {
+		case cbg.MajUnsignedInt:
+			extraI = int64(extra)
+			if extraI < 0 {
+				return bytesRead, fmt.Errorf("int64 positive overflow")
+			}
+		case cbg.MajNegativeInt:
+			extraI = int64(extra)
+			if extraI < 0 {
+				return bytesRead, fmt.Errorf("int64 negative oveflow")
+			}
+			extraI = -1 - extraI
+		default:
+			return bytesRead, fmt.Errorf("wrong type for int64 field: %d", maj)
+		}
+
+		t.Age = int64(extraI)
+	}
+	return bytesRead, nil
+}
+
+func (t *TestInts) InitNilEmbeddedStruct() {
+	if t != nil {
+	}
+}
+
+var lengthBufTestInts = []byte{131}
+
+func (t *TestInts) MarshalCBOR(w io.Writer) (n int, err error) {
+	if t == nil {
+		return w.Write(cbg.CborNull)
+	}
+	t.InitNilEmbeddedStruct()
+	if n_, err := w.Write(lengthBufTestInts); err != nil {
+		return n_, err
+	} else {
+		n += n_
+	}
+
+	scratch := make([]byte, 9)
+
+	// t.I16 (int16) (int16)
+	if t.I16 >= 0 {
+		if n_, err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajUnsignedInt, uint64(t.I16)); err != nil {
+			return n + n_, err
+		} else {
+			n += n_
+		}
+	} else {
+		if n_, err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajNegativeInt, uint64(-t.I16-1)); err != nil {
+			return n + n_, err
+		} else {
+			n += n_
+		}
+	}
+
+	// t.U16 (uint16) (uint16)
+	if n_, err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajUnsignedInt, uint64(t.U16)); err != nil {
+		return n + n_, err
+	} else {
+		n += n_
+	}
+
+	// t.U64 (uint64) (uint64)
+
+	if n_, err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajUnsignedInt, uint64(t.U64)); err != nil {
+		return n + n_, err
+	} else {
+		n += n_
+	}
+
+	return n, nil
+}
+
+func (t *TestInts) UnmarshalCBOR(r io.Reader) (int, error) {
+	bytesRead := 0
+	*t = TestInts{}
+	t.InitNilEmbeddedStruct()
+
+	br := cbg.GetPeeker(r)
+	scratch := make([]byte, 8)
+
+	maj, extra, read, err := cbg.CborReadHeaderBuf(br, scratch)
+	if err != nil {
+		return bytesRead, err
+	}
+	bytesRead += read
+	if maj != cbg.MajArray {
+		return bytesRead, fmt.Errorf("cbor input should be of type array")
+	}
+
+	if extra != 3 {
+		return bytesRead, fmt.Errorf("cbor input had wrong number of fields")
+	}
+
+	// t.I16 (int16) (int16)
+	{
+		maj, extra, read, err := cbg.CborReadHeaderBuf(br, scratch)
+		var extraI int16
+		if err != nil {
+			return bytesRead, err
+		}
+		bytesRead += read
+		switch maj {
+		case cbg.MajUnsignedInt:
+			extraI = int16(extra)
+			if extraI < 0 {
+				return bytesRead, fmt.Errorf("int16 positive overflow")
+			}
+		case cbg.MajNegativeInt:
+			extraI = int16(extra)
+			if extraI < 0 {
+				return bytesRead, fmt.Errorf("int16 negative oveflow")
+			}
+			extraI = -1 - extraI
+		default:
+			return bytesRead, fmt.Errorf("wrong type for int16 field: %d", maj)
+		}
+
+		t.I16 = int16(extraI)
+	}
+	// t.U16 (uint16) (uint16)
+
+	maj, extra, read, err = cbg.CborReadHeaderBuf(br, scratch)
+	if err != nil {
+		return bytesRead, err
+	}
+	bytesRead += read
+	if maj != cbg.MajUnsignedInt {
+		return bytesRead, fmt.Errorf("wrong type for uint16 field")
+	}
+	if extra > math.MaxUint16 {
+		return bytesRead, fmt.Errorf("integer in input was too large for uint16 field")
+	}
+	t.U16 = uint16(extra)
+	// t.U64 (uint64) (uint64)
+
+	{
+
+		maj, extra, read, err = cbg.CborReadHeaderBuf(br, scratch)
+		if err != nil {
+			return bytesRead, err
+		}
+		bytesRead += read
+		if maj != cbg.MajUnsignedInt {
+			return bytesRead, fmt.Errorf("wrong type for uint64 field")
+		}
+		t.U64 = uint64(extra)
+
+	}
+	return bytesRead, nil
+}
@@ -0,0 +1,470 @@
+// Code generated by github.com/daotl/go-marsha/cborgen/lazygen. DO NOT EDIT.
+
+package test
+
+import (
+	"bytes"
+	"io"
+
+	"github.com/ipfs/go-cid"
+
+	"github.com/daotl/go-marsha"
+	"github.com/daotl/go-marsha/cborgen"
+)
+
+// LazyTestStruct is a TestStruct unmarshaled by indexing its fields, decoded on first access.
+type LazyTestStruct struct {
+	cborgen.Lazy
+}
+
+func (t LazyTestStruct) Ptr() marsha.StructPtr { return &t }
+func (t *LazyTestStruct) Val() marsha.Struct   { return *t }
+
+// IndexCBOR implements cborgen.LazyStructPtr.
+func (t *LazyTestStruct) IndexCBOR(bin []byte) (int, error) {
+	*t = LazyTestStruct{}
+	return t.Index(bin, 1)
+}
+
+// Model implements cborgen.LazyStructPtr.
+func (t *LazyTestStruct) Model() interface{} { return &TestStruct{} }
+
+// Struct decodes all the fields into a TestStruct.
+func (t *LazyTestStruct) Struct() (*TestStruct, error) {
+	s := &TestStruct{}
+	if t.Raw() == nil {
+		return s, nil
+	}
+	if _, err := s.UnmarshalCBOR(bytes.NewReader(t.Raw())); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// MarshalCBOR writes the encoding the struct was unmarshaled from.
+func (t *LazyTestStruct) MarshalCBOR(w io.Writer) (int, error) {
+	if t.Raw() == nil {
+		return (&TestStruct{}).MarshalCBOR(w)
+	}
+	return w.Write(t.Raw())
+}
+
+// UnmarshalCBOR reads the struct from r and indexes it.
+func (t *LazyTestStruct) UnmarshalCBOR(r io.Reader) (int, error) {
+	*t = LazyTestStruct{}
+	return t.ReadCBOR(r, 1)
+}
+
+// Data returns field Data, decoding it on first access.
+func (t *LazyTestStruct) Data() (string, error) {
+	f, err := t.Field(0, func(b []byte) (interface{}, error) {
+		v, err := cborgen.DecodeString(b)
+		if err != nil {
+			return nil, err
+		}
+		return v, nil
+	})
+	v, _ := f.(string)
+	return v, err
+}
+
+// LazyTestStruct2 is a TestStruct2 unmarshaled by indexing its fields, decoded on first access.
+type LazyTestStruct2 struct {
+	cborgen.Lazy
+}
+
+func (t LazyTestStruct2) Ptr() marsha.StructPtr { return &t }
+func (t *LazyTestStruct2) Val() marsha.Struct   { return *t }
+
+// IndexCBOR implements cborgen.LazyStructPtr.
+func (t *LazyTestStruct2) IndexCBOR(bin []byte) (int, error) {
+	*t = LazyTestStruct2{}
+	return t.Index(bin, 1)
+}
+
+// Model implements cborgen.LazyStructPtr.
+func (t *LazyTestStruct2) Model() interface{} { return &TestStruct2{} }
+
+// Struct decodes all the fields into a TestStruct2.
+func (t *LazyTestStruct2) Struct() (*TestStruct2, error) {
+	s := &TestStruct2{}
+	if t.Raw() == nil {
+		return s, nil
+	}
+	if _, err := s.UnmarshalCBOR(bytes.NewReader(t.Raw())); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// MarshalCBOR writes the encoding the struct was unmarshaled from.
+func (t *LazyTestStruct2) MarshalCBOR(w io.Writer) (int, error) {
+	if t.Raw() == nil {
+		return (&TestStruct2{}).MarshalCBOR(w)
+	}
+	return w.Write(t.Raw())
+}
+
+// UnmarshalCBOR reads the struct from r and indexes it.
+func (t *LazyTestStruct2) UnmarshalCBOR(r io.Reader) (int, error) {
+	*t = LazyTestStruct2{}
+	return t.ReadCBOR(r, 1)
+}
+
+// Data2 returns field Data2, decoding it on first access.
+func (t *LazyTestStruct2) Data2() (int64, error) {
+	f, err := t.Field(0, func(b []byte) (interface{}, error) {
+		v, err := cborgen.DecodeInt64(b)
+		if err != nil {
+			return nil, err
+		}
+		return v, nil
+	})
+	v, _ := f.(int64)
+	return v, err
+}
+
+// LazyTestLinked is a TestLinked unmarshaled by indexing its fields, decoded on first access.
+type LazyTestLinked struct {
+	cborgen.Lazy
+}
+
+func (t LazyTestLinked) Ptr() marsha.StructPtr { return &t }
+func (t *LazyTestLinked) Val() marsha.Struct   { return *t }
+
+// IndexCBOR implements cborgen.LazyStructPtr.
+func (t *LazyTestLinked) IndexCBOR(bin []byte) (int, error) {
+	*t = LazyTestLinked{}
+	return t.Index(bin, 3)
+}
+
+// Model implements cborgen.LazyStructPtr.
+func (t *LazyTestLinked) Model() interface{} { return &TestLinked{} }
+
+// Struct decodes all the fields into a TestLinked.
+func (t *LazyTestLinked) Struct() (*TestLinked, error) {
+	s := &TestLinked{}
+	if t.Raw() == nil {
+		return s, nil
+	}
+	if _, err := s.UnmarshalCBOR(bytes.NewReader(t.Raw())); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// MarshalCBOR writes the encoding the struct was unmarshaled from.
+func (t *LazyTestLinked) MarshalCBOR(w io.Writer) (int, error) {
+	if t.Raw() == nil {
+		return (&TestLinked{}).MarshalCBOR(w)
+	}
+	return w.Write(t.Raw())
+}
+
+// UnmarshalCBOR reads the struct from r and indexes it.
+func (t *LazyTestLinked) UnmarshalCBOR(r io.Reader) (int, error) {
+	*t = LazyTestLinked{}
+	return t.ReadCBOR(r, 3)
+}
+
+// Name returns field Name, decoding it on first access.
+func (t *LazyTestLinked) Name() (string, error) {
+	f, err := t.Field(0, func(b []byte) (interface{}, error) {
+		v, err := cborgen.DecodeString(b)
+		if err != nil {
+			return nil, err
+		}
+		return v, nil
+	})
+	v, _ := f.(string)
+	return v, err
+}
+
+// Left returns field Left, decoding it on first access.
+func (t *LazyTestLinked) Left() (*cid.Cid, error) {
+	f, err := t.Field(1, func(b []byte) (interface{}, error) {
+		var v *cid.Cid
+		var err error
+		if !cborgen.IsNull(b) {
+			var c cid.Cid
+			c, err = cborgen.DecodeCid(b)
+			v = &c
+		}
+		if err != nil {
+			return nil, err
+		}
+		return v, nil
+	})
+	v, _ := f.(*cid.Cid)
+	return v, err
+}
+
+// Right returns field Right, decoding it on first access.
+func (t *LazyTestLinked) Right() (*cid.Cid, error) {
+	f, err := t.Field(2, func(b []byte) (interface{}, error) {
+		var v *cid.Cid
+		var err error
+		if !cborgen.IsNull(b) {
+			var c cid.Cid
+			c, err = cborgen.DecodeCid(b)
+			v = &c
+		}
+		if err != nil {
+			return nil, err
+		}
+		return v, nil
+	})
+	v, _ := f.(*cid.Cid)
+	return v, err
+}
+
+// LazyTestStructV2 is a TestStructV2 unmarshaled by indexing its fields, decoded on first access.
+type LazyTestStructV2 struct {
+	cborgen.Lazy
+}
+
+func (t LazyTestStructV2) Ptr() marsha.StructPtr { return &t }
+func (t *LazyTestStructV2) Val() marsha.Struct   { return *t }
+
+// IndexCBOR implements cborgen.LazyStructPtr.
+func (t *LazyTestStructV2) IndexCBOR(bin []byte) (int, error) {
+	*t = LazyTestStructV2{}
+	return t.Index(bin, 3)
+}
+
+// Model implements cborgen.LazyStructPtr.
+func (t *LazyTestStructV2) Model() interface{} { return &TestStructV2{} }
+
+// Struct decodes all the fields into a TestStructV2.
+func (t *LazyTestStructV2) Struct() (*TestStructV2, error) {
+	s := &TestStructV2{}
+	if t.Raw() == nil {
+		return s, nil
+	}
+	if _, err := s.UnmarshalCBOR(bytes.NewReader(t.Raw())); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// MarshalCBOR writes the encoding the struct was unmarshaled from.
+func (t *LazyTestStructV2) MarshalCBOR(w io.Writer) (int, error) {
+	if t.Raw() == nil {
+		return (&TestStructV2{}).MarshalCBOR(w)
+	}
+	return w.Write(t.Raw())
+}
+
+// UnmarshalCBOR reads the struct from r and indexes it.
+func (t *LazyTestStructV2) UnmarshalCBOR(r io.Reader) (int, error) {
+	*t = LazyTestStructV2{}
+	return t.ReadCBOR(r, 3)
+}
+
+// Name returns field Name, decoding it on first access.
+func (t *LazyTestStructV2) Name() (string, error) {
+	f, err := t.Field(0, func(b []byte) (interface{}, error) {
+		v, err := cborgen.DecodeString(b)
+		if err != nil {
+			return nil, err
+		}
+		return v, nil
+	})
+	v, _ := f.(string)
+	return v, err
+}
+
+// Child returns field Child, decoding it on first access.
+func (t *LazyTestStructV2) Child() (*TestChildV2, error) {
+	f, err := t.Field(1, func(b []byte) (interface{}, error) {
+		var v *TestChildV2
+		var err error
+		if !cborgen.IsNull(b) {
+			v = new(TestChildV2)
+			err = cborgen.DecodeStruct(b, v)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return v, nil
+	})
+	v, _ := f.(*TestChildV2)
+	return v, err
+}
+
+// Age returns field Age, decoding it on first access.
+func (t *LazyTestStructV2) Age() (int64, error) {
+	f, err := t.Field(2, func(b []byte) (interface{}, error) {
+		v, err := cborgen.DecodeInt64(b)
+		if err != nil {
+			return nil, err
+		}
+		return v, nil
+	})
+	v, _ := f.(int64)
+	return v, err
+}
+
+// LazyTestChildV2 is a TestChildV2 unmarshaled by indexing its fields, decoded on first access.
+type LazyTestChildV2 struct {
+	cborgen.Lazy
+}
+
+func (t LazyTestChildV2) Ptr() marsha.StructPtr { return &t }
+func (t *LazyTestChildV2) Val() marsha.Struct   { return *t }
+
+// IndexCBOR implements cborgen.LazyStructPtr.
+func (t *LazyTestChildV2) IndexCBOR(bin []byte) (int, error) {
+	*t = LazyTestChildV2{}
+	return t.Index(bin, 2)
+}
+
+// Model implements cborgen.LazyStructPtr.
+func (t *LazyTestChildV2) Model() interface{} { return &TestChildV2{} }
+
+// Struct decodes all the fields into a TestChildV2.
+func (t *LazyTestChildV2) Struct() (*TestChildV2, error) {
+	s := &TestChildV2{}
+	if t.Raw() == nil {
+		return s, nil
+	}
+	if _, err := s.UnmarshalCBOR(bytes.NewReader(t.Raw())); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// MarshalCBOR writes the encoding the struct was unmarshaled from.
+func (t *LazyTestChildV2) MarshalCBOR(w io.Writer) (int, error) {
+	if t.Raw() == nil {
+		return (&TestChildV2{}).MarshalCBOR(w)
+	}
+	return w.Write(t.Raw())
+}
+
+// UnmarshalCBOR reads the struct from r and indexes it.
+func (t *LazyTestChildV2) UnmarshalCBOR(r io.Reader) (int, error) {
+	*t = LazyTestChildV2{}
+	return t.ReadCBOR(r, 2)
+}
+
+// ID returns field ID, decoding it on first access.
+func (t *LazyTestChildV2) ID() (int64, error) {
+	f, err := t.Field(0, func(b []byte) (interface{}, error) {
+		v, err := cborgen.DecodeInt64(b)
+		if err != nil {
+			return nil, err
+		}
+		return v, nil
+	})
+	v, _ := f.(int64)
+	return v, err
+}
+
+// Note returns field Note, decoding it on first access.
+func (t *LazyTestChildV2) Note() (string, error) {
+	f, err := t.Field(1, func(b []byte) (interface{}, error) {
+		v, err := cborgen.DecodeString(b)
+		if err != nil {
+			return nil, err
+		}
+		return v, nil
+	})
+	v, _ := f.(string)
+	return v, err
+}
+
+// LazyTestPerson is a TestPerson unmarshaled by indexing its fields, decoded on first access.
+type LazyTestPerson struct {
+	cborgen.Lazy
+}
+
+func (t LazyTestPerson) Ptr() marsha.StructPtr { return &t }
+func (t *LazyTestPerson) Val() marsha.Struct   { return *t }
+
+// IndexCBOR implements cborgen.LazyStructPtr.
+func (t *LazyTestPerson) IndexCBOR(bin []byte) (int, error) {
+	*t = LazyTestPerson{}
+	return t.Index(bin, 4)
+}
+
+// Model implements cborgen.LazyStructPtr.
+func (t *LazyTestPerson) Model() interface{} { return &TestPerson{} }
+
+// Struct decodes all the fields into a TestPerson.
+func (t *LazyTestPerson) Struct() (*TestPerson, error) {
+	s := &TestPerson{}
+	if t.Raw() == nil {
+		return s, nil
+	}
+	if _, err := s.UnmarshalCBOR(bytes.NewReader(t.Raw())); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// MarshalCBOR writes the encoding the struct was unmarshaled from.
+func (t *LazyTestPerson) MarshalCBOR(w io.Writer) (int, error) {
+	if t.Raw() == nil {
+		return (&TestPerson{}).MarshalCBOR(w)
+	}
+	return w.Write(t.Raw())
+}
+
+// UnmarshalCBOR reads the struct from r and indexes it.
+func (t *LazyTestPerson) UnmarshalCBOR(r io.Reader) (int, error) {
+	*t = LazyTestPerson{}
+	return t.ReadCBOR(r, 4)
+}
+
+// Version returns field Version, decoding it on first access.
+func (t *LazyTestPerson) Version() (uint64, error) {
+	f, err := t.Field(0, func(b []byte) (interface{}, error) {
+		v, err := cborgen.DecodeUint64(b)
+		if err != nil {
+			return nil, err
+		}
+		return v, nil
+	})
+	v, _ := f.(uint64)
+	return v, err
+}
+
+// First returns field First, decoding it on first access.
+func (t *LazyTestPerson) First() (string, error) {
+	f, err := t.Field(1, func(b []byte) (interface{}, error) {
+		v, err := cborgen.DecodeString(b)
+		if err != nil {
+			return nil, err
+		}
+		return v, nil
+	})
+	v, _ := f.(string)
+	return v, err
+}
+
+// Last returns field Last, decoding it on first access.
+func (t *LazyTestPerson) Last() (string, error) {
+	f, err := t.Field(2, func(b []byte) (interface{}, error) {
+		v, err := cborgen.DecodeString(b)
+		if err != nil {
+			return nil, err
+		}
+		return v, nil
+	})
+	v, _ := f.(string)
+	return v, err
+}
+
+// Age returns field Age, decoding it on first access.
+func (t *LazyTestPerson) Age() (int64, error) {
+	f, err := t.Field(3, func(b []byte) (interface{}, error) {
+		v, err := cborgen.DecodeInt64(b)
+		if err != nil {
+			return nil, err
+		}
+		return v, nil
+	})
+	v, _ := f.(int64)
+	return v, err
+}
@@ -0,0 +1,6 @@
+//go:generate protoc -I.. --go_out=paths=source_relative:. ../models.proto
+
+// Package pb holds the messages compiled from test/models.proto, which protogen generates from
+// the models of package test. Compiling them into package test would collide with the models of
+// the same names.
+package pb
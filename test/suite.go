@@ -2,6 +2,7 @@ package test
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"reflect"
 	"runtime"
@@ -11,6 +12,7 @@ import (
 	"github.com/stretchr/testify/require"
 
 	"github.com/daotl/go-marsha"
+	"github.com/daotl/go-marsha/cbordiag"
 )
 
 var Subtests = []func(t *testing.T, mer marsha.Marsha){
@@ -180,3 +182,323 @@ func SubTestEncoderDecoder(t *testing.T, m marsha.Marsha) {
 		}
 	})
 }
+
+// SubTestUnion tests marshaling/unmarshaling marsha.Union and marsha.Unions with `m`, which must be
+// created with the marsha.Registry returned by NewRegistry.
+func SubTestUnion(t *testing.T, m marsha.Marsha) {
+	req := require.New(t)
+	asrt := assert.New(t)
+	us := &marsha.Unions{{Value: &TestStruct{"test"}}, {Value: &TestStruct2{42}}}
+
+	t.Run("MarshalStruct/UnmarshalStruct", func(t *testing.T) {
+		for _, u := range *us {
+			bin, err := m.MarshalStruct(&u)
+			req.NoError(err)
+			u2 := &marsha.Union{}
+			read, err := m.UnmarshalStruct(bin, u2)
+			req.NoError(err)
+			asrt.Equal(u.Value, u2.Value)
+			if read != -1 {
+				asrt.Equal(len(bin), read)
+			}
+		}
+	})
+
+	t.Run("MarshalStructSlice/UnmarshalStructSlice", func(t *testing.T) {
+		bin, err := m.MarshalStructSlice(us)
+		req.NoError(err)
+		us2 := &marsha.Unions{}
+		read, err := m.UnmarshalStructSlice(bin, us2)
+		req.NoError(err)
+		asrt.Equal(us, us2)
+		if read != -1 {
+			asrt.Equal(len(bin), read)
+		}
+	})
+
+	t.Run("EncodeStructSlice/DecodeStructSlice", func(t *testing.T) {
+		var buf bytes.Buffer
+		enc := m.NewEncoder(&buf)
+		dec := m.NewDecoder(&buf)
+
+		_, err := enc.EncodeStruct(&(*us)[1])
+		req.NoError(err)
+		u2 := &marsha.Union{}
+		_, err = dec.DecodeStruct(u2)
+		req.NoError(err)
+		asrt.Equal((*us)[1].Value, u2.Value)
+
+		_, err = enc.EncodeStructSlice(us)
+		req.NoError(err)
+		us2 := &marsha.Unions{}
+		_, err = dec.DecodeStructSlice(us2)
+		req.NoError(err)
+		asrt.Equal(us, us2)
+	})
+
+	t.Run("UnmarshalStruct error: type not registered", func(t *testing.T) {
+		bin, err := m.MarshalStruct(&marsha.Union{Value: &TestStruct{"test"}})
+		req.NoError(err)
+		bin[2] ^= 0xff // corrupt the CBOR tag number
+		_, err = m.UnmarshalStruct(bin, &marsha.Union{})
+		asrt.True(errors.Is(err, marsha.ErrNotRegistered))
+	})
+}
+
+// SubTestValue tests converting between structs and marsha.Value trees with `m`.
+func SubTestValue(t *testing.T, m marsha.ValueMarsha) {
+	req := require.New(t)
+	asrt := assert.New(t)
+	s := &TestStructV2{Name: "a", Child: &TestChildV2{ID: 1, Note: "n"}, Age: 30}
+
+	t.Run("StructToValue/ValueToStruct", func(t *testing.T) {
+		v, err := m.StructToValue(s)
+		req.NoError(err)
+		// Rename wherever the layout puts the name.
+		req.True(replaceValue(&v, marsha.NewString("a"), marsha.NewString("b")))
+		s2 := &TestStructV2{}
+		req.NoError(m.ValueToStruct(v, s2))
+		asrt.Equal(&TestStructV2{Name: "b", Child: s.Child, Age: 30}, s2)
+	})
+
+	t.Run("ValueToStruct error: wrong kind", func(t *testing.T) {
+		asrt.Error(m.ValueToStruct(marsha.NewString("a"), &TestStructV2{}))
+	})
+}
+
+// replaceValue replaces the first node of the tree `v` equal to `old` with `new`.
+func replaceValue(v *marsha.Value, old, new marsha.Value) bool {
+	if v.Equal(old) {
+		*v = new
+		return true
+	}
+	for i := range v.Array {
+		if replaceValue(&v.Array[i], old, new) {
+			return true
+		}
+	}
+	for i := range v.Map {
+		if replaceValue(&v.Map[i].Value, old, new) {
+			return true
+		}
+	}
+	return false
+}
+
+// SubTestDeterministic tests CBOR Marsha `m`, which must be created with marsha.WithDeterministic
+// and marsha.WithStrict.
+func SubTestDeterministic(t *testing.T, m marsha.Marsha) {
+	req := require.New(t)
+	asrt := assert.New(t)
+
+	SubTestAll(t, m)
+
+	t.Run("MarshalPrimitive: shortest floats and sorted map keys", func(t *testing.T) {
+		f := 1.5
+		bin, err := m.MarshalPrimitive(&f)
+		req.NoError(err)
+		asrt.Equal(cbordiag.MustParse(`1.5`), bin)
+
+		mp := map[string]int{"bb": 1, "a": 2, "c": 3}
+		bin, err = m.MarshalPrimitive(&mp)
+		req.NoError(err)
+		asrt.True(marsha.IsCanonical(bin))
+		asrt.Equal(cbordiag.MustParse(`{"a": 2, "c": 3, "bb": 1}`), bin)
+	})
+
+	// Encode the length of the outermost item in a needlessly long head.
+	nonCanonical := func() []byte {
+		bin, err := m.MarshalStruct(&TestStruct{"test"})
+		req.NoError(err)
+		return append([]byte{bin[0]&0xe0 | 24, bin[0] & 0x1f}, bin[1:]...)
+	}
+
+	t.Run("UnmarshalStruct error: not canonical", func(t *testing.T) {
+		_, err := m.UnmarshalStruct(nonCanonical(), &TestStruct{})
+		asrt.True(errors.Is(err, marsha.ErrNotCanonical))
+	})
+
+	t.Run("DecodeStruct error: not canonical", func(t *testing.T) {
+		_, err := m.NewDecoder(bytes.NewReader(nonCanonical())).DecodeStruct(&TestStruct{})
+		asrt.True(errors.Is(err, marsha.ErrNotCanonical))
+	})
+}
+
+// SubTestStrict tests CBOR Marsha `m`, which must be created with marsha.WithStrict.
+func SubTestStrict(t *testing.T, m marsha.Marsha) {
+	req := require.New(t)
+	asrt := assert.New(t)
+
+	SubTestAll(t, m)
+
+	bin, err := m.MarshalStruct(&TestStruct{"test"})
+	req.NoError(err)
+
+	t.Run("UnmarshalStruct error: trailing data", func(t *testing.T) {
+		_, err := m.UnmarshalStruct(append(append([]byte{}, bin...), 0x00), &TestStruct{})
+		var serr *marsha.StrictError
+		req.True(errors.As(err, &serr))
+		asrt.Equal(marsha.ErrTrailingData, serr.Err)
+		asrt.Equal(len(bin), serr.Offset)
+	})
+
+	t.Run("UnmarshalStruct error: unknown field", func(t *testing.T) {
+		// Add an element to the tuple or an entry sorted last to the map.
+		unknown, path := []byte{0x00}, "/1"
+		if bin[0]>>5 == 5 {
+			unknown, path = []byte{0x65, 'z', 'z', 'z', 'z', 'z', 0x00}, "/zzzzz"
+		}
+		bin2 := append(append([]byte{bin[0] + 1}, bin[1:]...), unknown...)
+		for _, f := range []func() error{
+			func() error { _, err := m.UnmarshalStruct(bin2, &TestStruct{}); return err },
+			func() error {
+				_, err := m.NewDecoder(bytes.NewReader(bin2)).DecodeStruct(&TestStruct{})
+				return err
+			},
+		} {
+			var serr *marsha.StrictError
+			req.True(errors.As(f(), &serr))
+			asrt.Equal(marsha.ErrUnknownField, serr.Err)
+			asrt.Equal(len(bin), serr.Offset)
+			asrt.Equal(path, serr.Path)
+		}
+	})
+
+	t.Run("UnmarshalPrimitive error: duplicate map key", func(t *testing.T) {
+		var mp map[string]int
+		_, err := m.UnmarshalPrimitive(cbordiag.MustParse(`{"a": 1, "a": 2}`), &mp)
+		asrt.True(errors.Is(err, marsha.ErrNotCanonical))
+		asrt.Contains(err.Error(), "offset 4")
+	})
+
+	t.Run("UnmarshalPrimitive error: non-minimal integer", func(t *testing.T) {
+		var i int
+		_, err := m.UnmarshalPrimitive(cbordiag.MustParse(`1_0`), &i)
+		asrt.True(errors.Is(err, marsha.ErrNotCanonical))
+	})
+
+	t.Run("Round-trip: floats", func(t *testing.T) {
+		// Floats representable in fewer bits must be marshaled in them to be unmarshaled again.
+		mp := map[string]float64{"x": 1.5, "y": 0.1}
+		bin, err := m.MarshalPrimitive(&mp)
+		req.NoError(err)
+		var mp2 map[string]float64
+		_, err = m.UnmarshalPrimitive(bin, &mp2)
+		req.NoError(err)
+		asrt.Equal(mp, mp2)
+
+		var buf bytes.Buffer
+		_, err = m.NewEncoder(&buf).EncodePrimitive(&mp)
+		req.NoError(err)
+		mp2 = nil
+		_, err = m.NewDecoder(&buf).DecodePrimitive(&mp2)
+		req.NoError(err)
+		asrt.Equal(mp, mp2)
+	})
+}
+
+// SubTestUnknown tests the unknown-field policies with CBOR Marsha returned by `newMarsha`.
+func SubTestUnknown(t *testing.T, newMarsha func(p marsha.UnknownPolicy) marsha.Marsha) {
+	req := require.New(t)
+	asrt := assert.New(t)
+	v2 := &TestStructV2{Name: "test", Child: &TestChildV2{ID: 1, Note: "note"}, Age: 30}
+
+	t.Run("UnknownPreserve", func(t *testing.T) {
+		m := newMarsha(marsha.UnknownPreserve)
+		bin, err := m.MarshalStruct(v2)
+		req.NoError(err)
+		v1 := &TestStructV1{}
+		read, err := m.UnmarshalStruct(bin, v1)
+		req.NoError(err)
+		if read != -1 {
+			asrt.Equal(len(bin), read)
+		}
+		asrt.Equal(v2.Name, v1.Name)
+		asrt.Equal(v2.Child.ID, v1.Child.ID)
+		asrt.Equal(1, v1.Len())
+		asrt.Equal(1, v1.Child.Len())
+
+		bin1, err := m.MarshalStruct(v1)
+		req.NoError(err)
+		asrt.Equal(bin, bin1)
+
+		var buf bytes.Buffer
+		_, err = m.NewEncoder(&buf).EncodeStruct(v1)
+		req.NoError(err)
+		v1 = &TestStructV1{}
+		_, err = m.NewDecoder(&buf).DecodeStruct(v1)
+		req.NoError(err)
+		bin1, err = m.MarshalStruct(v1)
+		req.NoError(err)
+		asrt.Equal(bin, bin1)
+	})
+
+	t.Run("UnknownIgnore", func(t *testing.T) {
+		m := newMarsha(marsha.UnknownIgnore)
+		bin, err := m.MarshalStruct(v2)
+		req.NoError(err)
+		v1 := &TestStructV1{}
+		_, err = m.UnmarshalStruct(bin, v1)
+		req.NoError(err)
+		asrt.Equal(v2.Name, v1.Name)
+		asrt.Equal(0, v1.Len())
+
+		bin1, err := m.MarshalStruct(v1)
+		req.NoError(err)
+		asrt.Less(len(bin1), len(bin))
+	})
+
+	t.Run("UnknownError", func(t *testing.T) {
+		m := newMarsha(marsha.UnknownError)
+		bin, err := m.MarshalStruct(v2)
+		req.NoError(err)
+		_, err = m.UnmarshalStruct(bin, &TestStructV1{})
+		var serr *marsha.StrictError
+		req.True(errors.As(err, &serr))
+		asrt.Equal(marsha.ErrUnknownField, serr.Err)
+	})
+}
+
+// SubTestAppend tests CBOR Marsha `m`, whose append methods must produce the same encodings as
+// its other methods.
+func SubTestAppend(t *testing.T, m marsha.AppendMarsha) {
+	req := require.New(t)
+	asrt := assert.New(t)
+	prefix := []byte{0xca, 0xfe}
+
+	t.Run("MarshalStructAppend", func(t *testing.T) {
+		s := &TestStruct{"test"}
+		want, err := m.MarshalStruct(s)
+		req.NoError(err)
+		bin, err := m.MarshalStructAppend(prefix[:len(prefix):len(prefix)], s)
+		req.NoError(err)
+		asrt.Equal(append(prefix[:len(prefix):len(prefix)], want...), bin)
+
+		// Reusing the buffer doesn't allocate it again.
+		buf := make([]byte, 0, 64)
+		out, err := m.MarshalStructAppend(buf, s)
+		req.NoError(err)
+		asrt.Equal(want, out)
+		asrt.Equal(&buf[:1][0], &out[0])
+	})
+
+	t.Run("MarshalStructSliceAppend", func(t *testing.T) {
+		ss := &TestStructs{TestStruct{"test"}, TestStruct{"test2"}}
+		want, err := m.MarshalStructSlice(ss)
+		req.NoError(err)
+		bin, err := m.MarshalStructSliceAppend(prefix[:len(prefix):len(prefix)], ss)
+		req.NoError(err)
+		asrt.Equal(append(prefix[:len(prefix):len(prefix)], want...), bin)
+
+		got := &TestStructs{}
+		_, err = m.UnmarshalStructSlice(bin[len(prefix):], got)
+		req.NoError(err)
+		asrt.Equal(ss, got)
+	})
+
+	t.Run("MarshalStructAppend error", func(t *testing.T) {
+		bin, err := m.MarshalStructAppend(prefix, &marsha.Union{})
+		asrt.Error(err)
+		asrt.Equal(prefix, bin)
+	})
+}
@@ -0,0 +1,56 @@
+package marsha
+
+// UnknownPolicy is how unmarshaling handles fields of the input which don't exist in the type
+// unmarshaled into: entries of maps encoding structs with unknown keys, and elements of tuples
+// encoding structs beyond the last field.
+type UnknownPolicy int
+
+const (
+	// UnknownDefault keeps the behavior of the implementation: CBOR implementations fail with
+	// their own errors, and the Protocol Buffers implementation preserves unknown fields in the
+	// `proto.Message`.
+	UnknownDefault UnknownPolicy = iota
+	// UnknownError fails with a *StrictError wrapping ErrUnknownField.
+	UnknownError
+	// UnknownIgnore drops unknown fields.
+	UnknownIgnore
+	// UnknownPreserve keeps unknown fields in the Unknown embedded in the struct they belong to,
+	// from which marshaling restores them, or drops them if there is none.
+	UnknownPreserve
+)
+
+// Unknown keeps the fields of the input unknown to the struct it is embedded in by value when
+// unmarshaling with UnknownPreserve, so that marshaling the struct again restores them:
+//
+//	type Model struct {
+//		marsha.Unknown
+//		Name string
+//	}
+//
+// Unknown has no exported fields, so it doesn't add any field to the encoding of the struct.
+// Within map values it is neither filled nor restored.
+type Unknown struct {
+	raw []byte
+	n   int
+}
+
+// UnknownKeeper is implemented by pointers to structs embedding Unknown.
+type UnknownKeeper interface {
+	UnknownFields() *Unknown
+}
+
+// UnknownFields implements UnknownKeeper.
+func (u *Unknown) UnknownFields() *Unknown { return u }
+
+// Len returns the number of unknown fields kept.
+func (u *Unknown) Len() int { return u.n }
+
+// Raw returns the unknown fields kept as they were encoded, concatenated: the key and value of
+// each map entry, or each tuple element.
+func (u *Unknown) Raw() []byte { return u.raw }
+
+// Set keeps `n` unknown fields encoded in `raw` as returned by Raw, replacing those kept before.
+// It is meant to be called by Marsha implementations.
+func (u *Unknown) Set(raw []byte, n int) {
+	u.raw, u.n = raw, n
+}
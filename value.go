@@ -0,0 +1,197 @@
+package marsha
+
+import (
+	"bytes"
+	"errors"
+	"math"
+	"math/big"
+
+	"github.com/ipfs/go-cid"
+)
+
+var (
+	// ErrValueKind is wrapped by errors reporting a Value of a kind which doesn't fit where it is.
+	ErrValueKind = errors.New("wrong kind of value")
+)
+
+// Kind is the kind of a Value.
+type Kind int
+
+const (
+	KindNull Kind = iota
+	KindBool
+	KindInt
+	KindFloat
+	KindString
+	KindBytes
+	KindArray
+	KindMap
+	KindTag
+	KindLink
+)
+
+var kindNames = [...]string{"null", "bool", "int", "float", "string", "bytes", "array", "map", "tag", "link"}
+
+func (k Kind) String() string {
+	if k < 0 || int(k) >= len(kindNames) {
+		return "invalid"
+	}
+	return kindNames[k]
+}
+
+// Value is a node of a dynamically typed tree of data, decoded without a Go type by the
+// `DecodeValue` methods of the implementations, to inspect data of unknown or unregistered types.
+// The zero Value is null.
+//
+// Only the field matching Kind is set. Integers of any size are held by Int, so bignums (CBOR tags
+// 2 and 3) decode to KindInt rather than KindTag.
+type Value struct {
+	Kind Kind
+
+	Bool  bool     // KindBool
+	Int   *big.Int // KindInt
+	Float float64  // KindFloat
+	Text  string   // KindString
+	Bytes []byte   // KindBytes
+
+	// Array holds the elements of KindArray.
+	Array []Value
+	// Map holds the entries of KindMap, in the order they were decoded.
+	Map []MapEntry
+
+	// Tag is the tag number of KindTag and Content the tagged value.
+	Tag     uint64
+	Content *Value
+
+	Link cid.Cid // KindLink
+}
+
+// MapEntry is an entry of a Value of KindMap.
+type MapEntry struct {
+	Key   Value
+	Value Value
+}
+
+// NewBool returns a Value of KindBool.
+func NewBool(b bool) Value { return Value{Kind: KindBool, Bool: b} }
+
+// NewInt returns a Value of KindInt.
+func NewInt(i int64) Value { return Value{Kind: KindInt, Int: big.NewInt(i)} }
+
+// NewUint returns a Value of KindInt.
+func NewUint(u uint64) Value { return Value{Kind: KindInt, Int: new(big.Int).SetUint64(u)} }
+
+// NewFloat returns a Value of KindFloat.
+func NewFloat(f float64) Value { return Value{Kind: KindFloat, Float: f} }
+
+// NewString returns a Value of KindString.
+func NewString(s string) Value { return Value{Kind: KindString, Text: s} }
+
+// NewBytes returns a Value of KindBytes.
+func NewBytes(b []byte) Value { return Value{Kind: KindBytes, Bytes: b} }
+
+// NewArray returns a Value of KindArray.
+func NewArray(elems ...Value) Value { return Value{Kind: KindArray, Array: elems} }
+
+// NewMap returns a Value of KindMap.
+func NewMap(entries ...MapEntry) Value { return Value{Kind: KindMap, Map: entries} }
+
+// NewTag returns a Value of KindTag.
+func NewTag(tag uint64, content Value) Value {
+	return Value{Kind: KindTag, Tag: tag, Content: &content}
+}
+
+// NewLink returns a Value of KindLink.
+func NewLink(c cid.Cid) Value { return Value{Kind: KindLink, Link: c} }
+
+// Get returns the value of the entry with the string key `key` of a Value of KindMap.
+func (v Value) Get(key string) (Value, bool) {
+	for _, e := range v.Map {
+		if e.Key.Kind == KindString && e.Key.Text == key {
+			return e.Value, true
+		}
+	}
+	return Value{}, false
+}
+
+// Equal returns whether `v` and `w` are the same tree. Floats are compared by value except that
+// NaNs are equal, and entries of maps must be in the same order. A Value of KindInt without Int or
+// of KindTag without Content, which can't be encoded, is only equal to another one missing it too.
+func (v Value) Equal(w Value) bool {
+	if v.Kind != w.Kind {
+		return false
+	}
+	switch v.Kind {
+	case KindNull:
+		return true
+	case KindBool:
+		return v.Bool == w.Bool
+	case KindInt:
+		if v.Int == nil || w.Int == nil {
+			return v.Int == nil && w.Int == nil
+		}
+		return v.Int.Cmp(w.Int) == 0
+	case KindFloat:
+		return v.Float == w.Float || math.IsNaN(v.Float) && math.IsNaN(w.Float)
+	case KindString:
+		return v.Text == w.Text
+	case KindBytes:
+		return bytes.Equal(v.Bytes, w.Bytes)
+	case KindArray:
+		if len(v.Array) != len(w.Array) {
+			return false
+		}
+		for i := range v.Array {
+			if !v.Array[i].Equal(w.Array[i]) {
+				return false
+			}
+		}
+		return true
+	case KindMap:
+		if len(v.Map) != len(w.Map) {
+			return false
+		}
+		for i := range v.Map {
+			if !v.Map[i].Key.Equal(w.Map[i].Key) || !v.Map[i].Value.Equal(w.Map[i].Value) {
+				return false
+			}
+		}
+		return true
+	case KindTag:
+		if v.Tag != w.Tag {
+			return false
+		}
+		if v.Content == nil || w.Content == nil {
+			return v.Content == nil && w.Content == nil
+		}
+		return v.Content.Equal(*w.Content)
+	case KindLink:
+		return v.Link.Equals(w.Link)
+	}
+	return false
+}
+
+// ValueMarsha is implemented by Marshas which convert between structs and Value trees, such as
+// the `cborgen`, `cbor_refmt` and `protobuf` implementations.
+//
+// Decoding bytes into a Value tree without a Go type is not part of it, as Protocol Buffers
+// messages aren't self-describing: the `protobuf` implementation's DecodeValue takes the message
+// descriptor for each call. The CBOR implementations are ValueDecoders.
+type ValueMarsha interface {
+	Marsha
+
+	// StructToValue returns the Value tree of the struct `p` points to, as it is marshaled.
+	StructToValue(p StructPtr) (Value, error)
+
+	// ValueToStruct loads the Value tree `v` into the struct `p` points to, as if it were
+	// unmarshaled from the encoding of `v`.
+	ValueToStruct(v Value, p StructPtr) error
+}
+
+// ValueDecoder is implemented by Marshas of self-describing formats which decode bytes into Value
+// trees without a Go type, such as the `cborgen` and `cbor_refmt` implementations.
+type ValueDecoder interface {
+	// DecodeValue decodes the data item at the start of `bin` into a Value tree, and returns it
+	// along with the count of bytes read.
+	DecodeValue(bin []byte) (Value, int, error)
+}
@@ -0,0 +1,216 @@
+package marsha
+
+import (
+	"fmt"
+	"math/big"
+
+	"github.com/daotl/go-marsha/internal/cbor"
+)
+
+// Tags of bignums (RFC 8949 section 3.4.3).
+const (
+	tagPosBignum = 2
+	tagNegBignum = 3
+)
+
+// DecodeCBORValue decodes the CBOR data item at the start of `bin` into a Value tree and returns
+// it along with the count of bytes read. Indefinite-length strings are concatenated, and
+// `undefined` and other simple values which have no Value fail.
+func DecodeCBORValue(bin []byte) (Value, int, error) {
+	return decodeCBORValue(bin, 0, 0)
+}
+
+func decodeCBORValue(b []byte, off, depth int) (Value, int, error) {
+	if depth > cbor.MaxDepth {
+		return Value{}, 0, &cbor.SyntaxError{Offset: off, Msg: fmt.Sprintf("nesting deeper than %d", cbor.MaxDepth)}
+	}
+	h, err := cbor.ReadHead(b, off)
+	if err != nil {
+		return Value{}, 0, err
+	}
+	end := h.End()
+	switch h.Major {
+	case cbor.MajUnsignedInt:
+		return NewUint(h.Arg), end, nil
+	case cbor.MajNegativeInt:
+		n := new(big.Int).SetUint64(h.Arg)
+		return Value{Kind: KindInt, Int: n.Neg(n.Add(n, big.NewInt(1)))}, end, nil
+	case cbor.MajByteString, cbor.MajTextString:
+		s, end, err := readString(b, h)
+		if err != nil {
+			return Value{}, 0, err
+		}
+		if h.Major == cbor.MajByteString {
+			return NewBytes(s), end, nil
+		}
+		return NewString(string(s)), end, nil
+	case cbor.MajArray:
+		v := Value{Kind: KindArray, Array: []Value{}}
+		for i := uint64(0); h.Indefinite() || i < h.Arg; i++ {
+			if h.Indefinite() && end < len(b) && b[end] == cbor.Break {
+				end++
+				break
+			}
+			var elem Value
+			if elem, end, err = decodeCBORValue(b, end, depth+1); err != nil {
+				return Value{}, 0, err
+			}
+			v.Array = append(v.Array, elem)
+		}
+		return v, end, nil
+	case cbor.MajMap:
+		v := Value{Kind: KindMap, Map: []MapEntry{}}
+		for i := uint64(0); h.Indefinite() || i < h.Arg; i++ {
+			if h.Indefinite() && end < len(b) && b[end] == cbor.Break {
+				end++
+				break
+			}
+			var e MapEntry
+			if e.Key, end, err = decodeCBORValue(b, end, depth+1); err != nil {
+				return Value{}, 0, err
+			}
+			if e.Value, end, err = decodeCBORValue(b, end, depth+1); err != nil {
+				return Value{}, 0, err
+			}
+			v.Map = append(v.Map, e)
+		}
+		return v, end, nil
+	case cbor.MajTag:
+		switch h.Arg {
+		case cbor.TagLink:
+			c, end, err := cbor.ReadLink(b, end)
+			if err != nil {
+				return Value{}, 0, err
+			}
+			return NewLink(c), end, nil
+		case tagPosBignum, tagNegBignum:
+			if s, err := cbor.ReadHead(b, end); err == nil && s.Major == cbor.MajByteString {
+				digits, end, err := readString(b, s)
+				if err != nil {
+					return Value{}, 0, err
+				}
+				n := new(big.Int).SetBytes(digits)
+				if h.Arg == tagNegBignum {
+					n.Neg(n.Add(n, big.NewInt(1)))
+				}
+				return Value{Kind: KindInt, Int: n}, end, nil
+			}
+		}
+		content, end, err := decodeCBORValue(b, end, depth+1)
+		if err != nil {
+			return Value{}, 0, err
+		}
+		return NewTag(h.Arg, content), end, nil
+	}
+	if h.IsFloat() {
+		return NewFloat(h.Float()), end, nil
+	}
+	switch h.Arg {
+	case 20, 21:
+		return NewBool(h.Arg == 21), end, nil
+	case 22:
+		return Value{}, end, nil
+	}
+	return Value{}, 0, fmt.Errorf("%w: simple value %d at offset %d", ErrValueKind, h.Arg, off)
+}
+
+// readString returns the content of the string of head `h`, concatenating the chunks of
+// indefinite-length strings, and the offset after it.
+func readString(b []byte, h cbor.Head) ([]byte, int, error) {
+	end, err := cbor.Skip(b, h.Offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	if !h.Indefinite() {
+		return b[h.End():end], end, nil
+	}
+	var s []byte
+	for off := h.End(); off < end-1; {
+		c, _ := cbor.ReadHead(b, off)
+		off = c.End() + int(c.Arg)
+		s = append(s, b[c.End():off]...)
+	}
+	return s, end, nil
+}
+
+// EncodeCBORValue encodes the Value tree `v` in CBOR core deterministic encoding, so map entries
+// are sorted by key. Integers beyond 64 bits are encoded as bignums.
+func EncodeCBORValue(v Value) ([]byte, error) {
+	b, err := appendCBORValue(nil, v, 0)
+	if err != nil {
+		return nil, err
+	}
+	return Canonicalize(b)
+}
+
+func appendCBORValue(dst []byte, v Value, depth int) ([]byte, error) {
+	if depth > cbor.MaxDepth {
+		return nil, fmt.Errorf("value nested deeper than %d", cbor.MaxDepth)
+	}
+	var err error
+	switch v.Kind {
+	case KindNull:
+		return append(dst, 0xf6), nil
+	case KindBool:
+		if v.Bool {
+			return append(dst, 0xf5), nil
+		}
+		return append(dst, 0xf4), nil
+	case KindInt:
+		if v.Int == nil {
+			return nil, fmt.Errorf("%w: int without Int", ErrValueKind)
+		}
+		n, major, tag := new(big.Int).Set(v.Int), byte(cbor.MajUnsignedInt), uint64(tagPosBignum)
+		if n.Sign() < 0 {
+			n.Neg(n.Add(n, big.NewInt(1)))
+			major, tag = cbor.MajNegativeInt, tagNegBignum
+		}
+		if n.IsUint64() {
+			return cbor.AppendHead(dst, major, n.Uint64()), nil
+		}
+		dst = cbor.AppendHead(dst, cbor.MajTag, tag)
+		dst = cbor.AppendHead(dst, cbor.MajByteString, uint64(len(n.Bytes())))
+		return append(dst, n.Bytes()...), nil
+	case KindFloat:
+		return cbor.AppendFloat(dst, v.Float), nil
+	case KindString:
+		dst = cbor.AppendHead(dst, cbor.MajTextString, uint64(len(v.Text)))
+		return append(dst, v.Text...), nil
+	case KindBytes:
+		dst = cbor.AppendHead(dst, cbor.MajByteString, uint64(len(v.Bytes)))
+		return append(dst, v.Bytes...), nil
+	case KindArray:
+		dst = cbor.AppendHead(dst, cbor.MajArray, uint64(len(v.Array)))
+		for _, elem := range v.Array {
+			if dst, err = appendCBORValue(dst, elem, depth+1); err != nil {
+				return nil, err
+			}
+		}
+		return dst, nil
+	case KindMap:
+		dst = cbor.AppendHead(dst, cbor.MajMap, uint64(len(v.Map)))
+		for _, e := range v.Map {
+			if dst, err = appendCBORValue(dst, e.Key, depth+1); err != nil {
+				return nil, err
+			}
+			if dst, err = appendCBORValue(dst, e.Value, depth+1); err != nil {
+				return nil, err
+			}
+		}
+		return dst, nil
+	case KindTag:
+		if v.Content == nil {
+			return nil, fmt.Errorf("%w: tag %d without Content", ErrValueKind, v.Tag)
+		}
+		dst = cbor.AppendHead(dst, cbor.MajTag, v.Tag)
+		return appendCBORValue(dst, *v.Content, depth+1)
+	case KindLink:
+		if !v.Link.Defined() {
+			return nil, fmt.Errorf("%w: undefined link", ErrValueKind)
+		}
+		dst = cbor.AppendHead(dst, cbor.MajTag, cbor.TagLink)
+		dst = cbor.AppendHead(dst, cbor.MajByteString, uint64(v.Link.ByteLen()+1))
+		return append(append(dst, 0), v.Link.Bytes()...), nil
+	}
+	return nil, fmt.Errorf("%w: %s", ErrValueKind, v.Kind)
+}
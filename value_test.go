@@ -0,0 +1,87 @@
+package marsha_test
+
+import (
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/daotl/go-marsha"
+	"github.com/daotl/go-marsha/cbordiag"
+)
+
+func TestCBORValue(t *testing.T) {
+	c, err := cid.Decode("bafyreidykglsfhoixmivffc5uwhcgshx4j465xwqntbmu43nb2dzqwfvae")
+	require.NoError(t, err)
+	big := new(big.Int).Lsh(big.NewInt(1), 64)
+
+	for _, tc := range []struct {
+		diag string
+		want marsha.Value
+	}{
+		{`null`, marsha.Value{}},
+		{`true`, marsha.NewBool(true)},
+		{`-1`, marsha.NewInt(-1)},
+		{`18446744073709551615`, marsha.NewUint(1<<64 - 1)},
+		{`2(h'010000000000000000')`, marsha.Value{Kind: marsha.KindInt, Int: big}},
+		{`1.5`, marsha.NewFloat(1.5)},
+		{`"a"`, marsha.NewString("a")},
+		{`h'01'`, marsha.NewBytes([]byte{1})},
+		{`[]`, marsha.NewArray()},
+		{`[1, [2]]`, marsha.NewArray(marsha.NewInt(1), marsha.NewArray(marsha.NewInt(2)))},
+		{`{1: "a", "b": null}`, marsha.NewMap(
+			marsha.MapEntry{Key: marsha.NewInt(1), Value: marsha.NewString("a")},
+			marsha.MapEntry{Key: marsha.NewString("b")},
+		)},
+		{`1(0)`, marsha.NewTag(1, marsha.NewInt(0))},
+		{`42("` + c.String() + `")`, marsha.NewLink(c)},
+	} {
+		bin := cbordiag.MustParse(tc.diag)
+		v, read, err := marsha.DecodeCBORValue(bin)
+		require.NoError(t, err, tc.diag)
+		assert.Equal(t, len(bin), read, tc.diag)
+		assert.True(t, tc.want.Equal(v), tc.diag)
+
+		re, err := marsha.EncodeCBORValue(v)
+		require.NoError(t, err, tc.diag)
+		assert.Equal(t, bin, re, tc.diag)
+	}
+
+	// Indefinite lengths are lost, and maps are sorted when encoded.
+	v, _, err := marsha.DecodeCBORValue(cbordiag.MustParse(`{_ "bb": (_ "a", "b"), "c": [_ 1]}`))
+	require.NoError(t, err)
+	assert.True(t, marsha.NewMap(
+		marsha.MapEntry{Key: marsha.NewString("bb"), Value: marsha.NewString("ab")},
+		marsha.MapEntry{Key: marsha.NewString("c"), Value: marsha.NewArray(marsha.NewInt(1))},
+	).Equal(v))
+	ab, ok := v.Get("bb")
+	assert.True(t, ok)
+	assert.Equal(t, "ab", ab.Text)
+	re, err := marsha.EncodeCBORValue(v)
+	require.NoError(t, err)
+	assert.Equal(t, cbordiag.MustParse(`{"c": [1], "bb": "ab"}`), re)
+
+	_, _, err = marsha.DecodeCBORValue(cbordiag.MustParse(`undefined`))
+	assert.True(t, errors.Is(err, marsha.ErrValueKind))
+	_, _, err = marsha.DecodeCBORValue(cbordiag.MustParse(`[1, 2]`)[:2])
+	assert.True(t, errors.Is(err, marsha.ErrInvalidCBOR))
+	_, err = marsha.EncodeCBORValue(marsha.Value{Kind: marsha.KindTag, Tag: 1})
+	assert.True(t, errors.Is(err, marsha.ErrValueKind))
+	_, err = marsha.EncodeCBORValue(marsha.NewMap(
+		marsha.MapEntry{Key: marsha.NewString("a")}, marsha.MapEntry{Key: marsha.NewString("a")}))
+	assert.True(t, errors.Is(err, marsha.ErrInvalidCBOR))
+}
+
+func TestValueEqualMissing(t *testing.T) {
+	noInt := marsha.Value{Kind: marsha.KindInt}
+	noContent := marsha.Value{Kind: marsha.KindTag, Tag: 1}
+	assert.True(t, noInt.Equal(marsha.Value{Kind: marsha.KindInt}))
+	assert.False(t, noInt.Equal(marsha.NewInt(0)))
+	assert.False(t, marsha.NewInt(0).Equal(noInt))
+	assert.True(t, noContent.Equal(marsha.Value{Kind: marsha.KindTag, Tag: 1}))
+	assert.False(t, noContent.Equal(marsha.NewTag(1, marsha.Value{})))
+	assert.False(t, marsha.NewTag(1, marsha.Value{}).Equal(noContent))
+}